@@ -4,39 +4,208 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/shailendra-k-singh/example.messaging.service/message"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	defaultBitsize = 64
+	maxAuthorLen   = 64
+	maxTags        = 16
+	maxTagLen      = 64
+
+	// maxScheduleDelay is how far in the future a message may be scheduled.
+	maxScheduleDelay = 365 * 24 * time.Hour
+	// maxTTL is the longest time a message may live once delivered.
+	maxTTL = 365 * 24 * time.Hour
+	// maxMessageBytes bounds the body of a request creating or updating a
+	// single message.
+	maxMessageBytes = 1 << 20
 )
 
+// MsgRequestBody is the request body of the message endpoints. A message
+// is scheduled for delivery at DeliverAt or after DelaySeconds, at most one
+// of which may be set, and expires TTL seconds after its delivery. A reply
+// names the message it replies to in ParentId. These are ignored by updates.
 type MsgRequestBody struct {
-	Text string `json:"text"`
+	Text         string     `json:"text" xml:"text"`
+	Author       string     `json:"author,omitempty" xml:"author,omitempty"`
+	Tags         []string   `json:"tags,omitempty" xml:"tag,omitempty"`
+	Queue        string     `json:"queue,omitempty" xml:"queue,omitempty"`
+	DeliverAt    *time.Time `json:"deliver_at,omitempty" xml:"deliver_at,omitempty"`
+	DelaySeconds int64      `json:"delay_seconds,omitempty" xml:"delay_seconds,omitempty"`
+	TTL          int64      `json:"ttl,omitempty" xml:"ttl,omitempty"`
+	ParentId     int64      `json:"parent_id,omitempty" xml:"parent_id,omitempty"`
 }
 
-type ErrMsg struct {
-	Error string `json:"error"`
+func (b MsgRequestBody) message() message.MessageObj {
+	msg := message.MessageObj{Text: b.Text, Author: b.Author, Tags: b.Tags, Queue: b.Queue, DeliverAt: b.DeliverAt, ParentId: b.ParentId}
+	now := time.Now()
+	if b.DelaySeconds > 0 {
+		at := now.Add(time.Duration(b.DelaySeconds) * time.Second).UTC()
+		msg.DeliverAt = &at
+	}
+	if b.TTL > 0 {
+		if msg.DeliverAt != nil && msg.DeliverAt.After(now) {
+			now = *msg.DeliverAt
+		}
+		at := now.Add(time.Duration(b.TTL) * time.Second).UTC()
+		msg.ExpiresAt = &at
+	}
+	return msg
+}
+
+// validateTiming checks the scheduling and expiry fields of a new message;
+// the resulting delivery time is checked by validateMessage.
+func (b MsgRequestBody) validateTiming() error {
+	if b.DeliverAt != nil && b.DelaySeconds != 0 {
+		return message.Errorf(message.ErrInvalid, "Only one of deliver_at and delay_seconds may be set")
+	}
+	if max := int64(maxScheduleDelay / time.Second); b.DelaySeconds < 0 || b.DelaySeconds > max {
+		return message.Errorf(message.ErrInvalid, "Delay %d must be in range 0-%d seconds", b.DelaySeconds, max)
+	}
+	if max := int64(maxTTL / time.Second); b.TTL < 0 || b.TTL > max {
+		return message.Errorf(message.ErrInvalid, "TTL %d must be in range 0-%d seconds", b.TTL, max)
+	}
+	return nil
+}
+
+// WithDefaultTTL makes new messages created without a ttl expire d after
+// their delivery. Zero, the default, keeps them until they are deleted.
+func WithDefaultTTL(d time.Duration) Option {
+	return func(r *appRouter) {
+		r.ttl = d
+	}
 }
 
 type appRouter struct {
-	router *mux.Router
-	m      *message.MessageServer
-	limit  int
-	t      *tracerObj
+	router  *mux.Router
+	m       *message.MessageServer
+	limit   int
+	t       *tracerObj
+	idem    *idempotencyStore
+	backlog int
+	users   map[string]string
+	hooks   *webhookStore
+	ttl     time.Duration
+
+	channels       *channelStore
+	started        time.Time
+	deletePolicy   message.DeletePolicy
+	trashRetention time.Duration
+	admins         map[string]bool
+	revisionLimit  int
+
+	hookAttempts int
+	hookBackoff  time.Duration
+	hookNetworks []*net.IPNet
+
+	// the channels and webhooks above belong to the default tenant
+	defaultTenant *tenant
+	tenants       *tenantStore
+	userTenants   map[string]string
+	tenantIDs     map[string]bool
+
+	gqlSchema        graphql.Schema
+	gqlMaxDepth      int
+	gqlMaxComplexity int
+
+	spec        *openapi3.T
+	validateAPI bool
+
+	// cachePolicies are the Cache-Control headers by operation ID
+	cachePolicies map[string]string
+}
+
+// Option configures optional behaviour of the appRouter.
+type Option func(*appRouter)
+
+// WithIdempotencyWindow sets how long responses to requests carrying an
+// Idempotency-Key header are remembered.
+func WithIdempotencyWindow(d time.Duration) Option {
+	return func(r *appRouter) {
+		r.idem = newIdempotencyStore(d)
+	}
+}
+
+// WithMessageServer makes the appRouter serve messages from m instead of a
+// new in-memory MessageServer. The appRouter configures and starts m.
+func WithMessageServer(m *message.MessageServer) Option {
+	return func(r *appRouter) {
+		r.m = m
+	}
+}
+
+// WithEventBacklog sets how many recent message events are kept for event
+// stream clients resuming with Last-Event-ID.
+func WithEventBacklog(n int) Option {
+	return func(r *appRouter) {
+		r.backlog = n
+	}
+}
+
+func NewAppRouter(limit int, opts ...Option) *appRouter {
+	r := &appRouter{
+		router: mux.NewRouter(),
+		m:      message.NewMessageServer(),
+		limit:  limit,
+		t:      &tracerObj{},
+		idem:   newIdempotencyStore(defaultIdempotentWindow),
+
+		channels:       newChannelStore(),
+		started:        time.Now().UTC(),
+		deletePolicy:   message.DeleteOrphan,
+		revisionLimit:  defaultRevisionLimit,
+		trashRetention: defaultTrashRetention,
+
+		hookAttempts: defaultWebhookAttempts,
+		hookBackoff:  defaultWebhookBackoff,
+
+		tenants:   newTenantStore(),
+		tenantIDs: make(map[string]bool),
+
+		gqlMaxDepth:      defaultGraphQLMaxDepth,
+		gqlMaxComplexity: defaultGraphQLMaxComplexity,
+
+		cachePolicies: make(map[string]string),
+	}
+	for op, p := range defaultCachePolicies {
+		r.cachePolicies[op] = p
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.defaultTenant = &tenant{id: defaultTenantID, m: r.m, channels: r.channels}
+	r.setupTenant(r.defaultTenant)
+	r.hooks = r.defaultTenant.hooks
+	schema, err := r.newGraphQLSchema()
+	if err != nil {
+		panic("invalid GraphQL schema: " + err.Error())
+	}
+	r.gqlSchema = schema
+	r.spec = newOpenAPISpec()
+	r.checkCachePolicies()
+	return r
 }
 
-func NewAppRouter(limit int) *appRouter {
-	return &appRouter{router: mux.NewRouter(), m: message.NewMessageServer(), limit: limit, t: &tracerObj{}}
+// configureStore applies the settings of the appRouter to the message store
+// of a channel.
+func (r *appRouter) configureStore(m *message.MessageServer) {
+	m.SetDeletePolicy(r.deletePolicy)
+	m.SetTrashRetention(r.trashRetention)
+	m.SetRevisionLimit(r.revisionLimit)
 }
 
 func (r *appRouter) GetRouter() *mux.Router {
@@ -44,19 +213,85 @@ func (r *appRouter) GetRouter() *mux.Router {
 }
 
 func (r *appRouter) SetRoutes() {
+	r.router.Use(requestID)
 	r.router.Use(r.t.startTracing)
+	r.router.Use(discardHeadBody)
+	r.router.Use(r.authenticate)
+	r.router.Use(r.resolveTenant)
+	r.router.Use(r.cacheControl)
+	if r.validateAPI {
+		r.router.Use(r.validateOpenAPI)
+	}
 
-	r.router.Methods("GET").Path("/v1/messages").HandlerFunc(r.getAllMessages)
-	r.router.Methods("POST").Path("/v1/messages").HandlerFunc(r.createMessage)
-	r.router.Methods("GET").Path("/v1/messages/{id}").HandlerFunc(r.getMessage)
+	r.router.Methods("GET", "HEAD").Path("/v1/messages").HandlerFunc(r.getAllMessages)
+	r.router.Methods("POST").Path("/v1/messages").HandlerFunc(r.idempotent(maxMessageBytes, r.createMessage))
+	r.router.Methods("POST").Path("/v1/messages:batch").HandlerFunc(r.idempotent(maxBatchBytes, r.batchCreateMessages))
+	r.router.Methods("POST").Path("/v1/messages:batchDelete").HandlerFunc(r.batchDeleteMessages)
+	r.router.Methods("GET").Path("/v1/messages:export").HandlerFunc(r.exportMessages)
+	r.router.Methods("POST").Path("/v1/messages:import").HandlerFunc(r.importMessages)
+	r.router.Methods("GET").Path("/v1/messages/events").HandlerFunc(r.streamEvents)
+	r.router.Methods("GET").Path("/v1/ws").HandlerFunc(r.serveWebSocket)
+	r.router.Methods("GET", "HEAD").Path("/v1/queues/{name}").HandlerFunc(r.getQueue)
+	r.router.Methods("PUT").Path("/v1/queues/{name}").HandlerFunc(r.putQueue)
+	r.router.Methods("GET", "HEAD").Path("/v1/queues/{name}/messages").HandlerFunc(r.listQueueMessages)
+	r.router.Methods("POST").Path("/v1/queues/{name}/receive").HandlerFunc(r.receiveMessages)
+	r.router.Methods("POST").Path("/v1/queues/{name}/ack").HandlerFunc(r.ackMessages)
+	r.router.Methods("POST").Path("/v1/queues/{name}/nack").HandlerFunc(r.nackMessages)
+	r.router.Methods("POST").Path("/v1/queues/{name}/redrive").HandlerFunc(r.redriveMessages)
+	r.router.Methods("POST").Path("/v1/queues/{name}/purge").HandlerFunc(r.purgeQueue)
+	r.router.Methods("GET").Path("/v1/webhooks").HandlerFunc(r.listWebhooks)
+	r.router.Methods("POST").Path("/v1/webhooks").HandlerFunc(r.createWebhook)
+	r.router.Methods("GET").Path("/v1/webhooks/{id}").HandlerFunc(r.getWebhook)
+	r.router.Methods("DELETE").Path("/v1/webhooks/{id}").HandlerFunc(r.deleteWebhook)
+	r.router.Methods("GET").Path("/v1/webhooks/{id}/deliveries").HandlerFunc(r.listDeliveries)
+	r.router.Methods("POST").Path("/v1/messages/{id}:undelete").HandlerFunc(r.undeleteMessage)
+	r.router.Methods("GET", "HEAD").Path("/v1/trash").HandlerFunc(r.listTrash)
+	r.router.Methods("GET", "HEAD").Path("/v1/messages/{id}").HandlerFunc(r.getMessage)
+	r.router.Methods("GET", "HEAD").Path("/v1/messages/{id}/replies").HandlerFunc(r.getReplies)
+	r.router.Methods("GET", "HEAD").Path("/v1/messages/{id}/thread").HandlerFunc(r.getThread)
+	r.router.Methods("GET", "HEAD").Path("/v1/messages/{id}/revisions").HandlerFunc(r.getRevisions)
+	r.router.Methods("GET", "HEAD").Path("/v1/messages/{id}/revisions/{rev}").HandlerFunc(r.getRevision)
+	r.router.Methods("PUT").Path("/v1/messages/{id}").HandlerFunc(r.updateMessage)
 	r.router.Methods("DELETE").Path("/v1/messages/{id}").HandlerFunc(r.deleteMessage)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels").HandlerFunc(r.listChannels)
+	r.router.Methods("POST").Path("/v1/channels").HandlerFunc(r.createChannel)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels/{channel}").HandlerFunc(r.getChannel)
+	r.router.Methods("PUT").Path("/v1/channels/{channel}").HandlerFunc(r.updateChannel)
+	r.router.Methods("DELETE").Path("/v1/channels/{channel}").HandlerFunc(r.deleteChannel)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels/{channel}/messages").HandlerFunc(r.getAllMessages)
+	r.router.Methods("GET").Path("/v1/channels/{channel}/messages/events").HandlerFunc(r.streamEvents)
+	r.router.Methods("POST").Path("/v1/channels/{channel}/messages").HandlerFunc(r.idempotent(maxMessageBytes, r.createMessage))
+	r.router.Methods("POST").Path("/v1/channels/{channel}/messages/{id}:undelete").HandlerFunc(r.undeleteMessage)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels/{channel}/trash").HandlerFunc(r.listTrash)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels/{channel}/messages/{id}").HandlerFunc(r.getMessage)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels/{channel}/messages/{id}/replies").HandlerFunc(r.getReplies)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels/{channel}/messages/{id}/thread").HandlerFunc(r.getThread)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels/{channel}/messages/{id}/revisions").HandlerFunc(r.getRevisions)
+	r.router.Methods("GET", "HEAD").Path("/v1/channels/{channel}/messages/{id}/revisions/{rev}").HandlerFunc(r.getRevision)
+	r.router.Methods("PUT").Path("/v1/channels/{channel}/messages/{id}").HandlerFunc(r.updateMessage)
+	r.router.Methods("DELETE").Path("/v1/channels/{channel}/messages/{id}").HandlerFunc(r.deleteMessage)
 
 	// A default root handler
-	r.router.Methods("GET").Path("/").HandlerFunc(r.root)
+	r.router.Methods("GET", "POST").Path(graphQLPath).HandlerFunc(r.serveGraphQL)
+	r.router.Methods("GET").Path(openAPIPath).HandlerFunc(r.serveOpenAPI)
+	r.router.Methods("GET").Path(apiDocsPath).HandlerFunc(r.serveAPIDocs)
+	r.router.Methods("GET").Path(apiDocsPath + "/{asset}").HandlerFunc(r.serveAPIDocsAsset)
+	r.router.Methods("GET", "HEAD").Path("/").HandlerFunc(r.root)
+	r.router.Methods("GET").Path("/metrics").Handler(promhttp.Handler())
+
+	// OPTIONS is answered for every route from the routes registered above,
+	// so it must stay last.
+	r.router.Methods("OPTIONS").HandlerFunc(r.options)
+	r.router.NotFoundHandler = r.withMiddleware(http.HandlerFunc(r.notFound))
+	r.router.MethodNotAllowedHandler = r.withMiddleware(http.HandlerFunc(r.methodNotAllowed))
 }
 
 func jsonResponse(w http.ResponseWriter, resp interface{}, code int) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writeResponse(w, "application/json; charset=utf-8", resp, code)
+}
+
+func writeResponse(w http.ResponseWriter, contentType string, resp interface{}, code int) {
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.WriteHeader(code)
 
@@ -71,137 +306,321 @@ func jsonResponse(w http.ResponseWriter, resp interface{}, code int) {
 	}
 }
 
-func respondWithError(w http.ResponseWriter, message ErrMsg, code int) {
-	jsonResponse(w, message, code)
-}
-
 func (r *appRouter) createMessage(w http.ResponseWriter, req *http.Request) {
-	body, err := ioutil.ReadAll(req.Body)
+	mediaType, err := negotiate(w, req, messageMediaTypes)
 	if err != nil {
-		log.Error("Error while reading request body: ", err)
-		r.addSpan(req.Context(), http.StatusBadRequest, req)
-		respondWithError(w, ErrMsg{"Invalid request body"}, http.StatusBadRequest)
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
 		return
 	}
-	log.Debug("POST: Received request body: ", string(body))
+	body, err := readLimitedBody(w, req, maxMessageBytes)
+	if err != nil {
+		logOf(req).Error("Error while reading request body: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	logOf(req).Debug("POST: Received request body: ", string(body))
 
 	msg := MsgRequestBody{}
-	err = json.Unmarshal(body, &msg)
+	err = decodeBody(req, body, &msg)
 	if err != nil {
-		log.Error("error while unmarshalling request body: ", err)
-		r.addSpan(req.Context(), http.StatusBadRequest, req)
-		respondWithError(w, ErrMsg{"Invalid request body"}, http.StatusBadRequest)
+		r.respondWithError(w, req, err)
 		return
 	}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	obj, err := ch.newMessage(req.Context(), msg)
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+
+	resp, err := ch.m.Add(obj)
+	if err != nil {
+		logOf(req).Error("error while adding message: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusCreated, req)
+	w.Header().Set("Location", ch.messagePath(resp.Id))
+	r.encodedResponse(w, req, mediaType, resp, http.StatusCreated)
+	logOf(req).Infof("Added message with id %v successfully", resp.Id)
+}
+
+// newMessage validates body as a new message of ch sent by the user of the
+// request with context ctx, who becomes its author, and returns the message
+// to add. Every API creates messages through it so that they follow the same
+// rules: the ttl defaults to the retention of ch and the timing, text and
+// tags are checked against the limits of ch.
+func (ch *channel) newMessage(ctx context.Context, body MsgRequestBody) (message.MessageObj, error) {
+	if user := principalFromContext(ctx); user != "" {
+		body.Author = user
+	}
+	body.TTL = ch.ttlOf(body.TTL)
+	if err := body.validateTiming(); err != nil {
+		return message.MessageObj{}, err
+	}
+	obj := body.message()
+	if err := validateMessage(obj, ch.CharLimit); err != nil {
+		return message.MessageObj{}, err
+	}
+	return obj, nil
+}
+
+// addMessage adds the new message body to ch, see newMessage.
+func (ch *channel) addMessage(ctx context.Context, body MsgRequestBody) (message.MessageObj, error) {
+	obj, err := ch.newMessage(ctx, body)
+	if err != nil {
+		return message.MessageObj{}, err
+	}
+	return ch.m.Add(obj)
+}
+
+// validateMsg performs a basic sanity check on a message of the default
+// channel.
+func (r *appRouter) validateMsg(msg message.MessageObj) error {
+	return validateMessage(msg, r.limit)
+}
 
-	// Basic length sanity check
+// validateMessage performs a basic sanity check on the message text and
+// tags, allowing texts of up to limit characters.
+func validateMessage(msg message.MessageObj, limit int) error {
 	l := len(msg.Text)
 	if l < 1 {
-		log.Error("incorrect input format or message length zero")
-		r.addSpan(req.Context(), http.StatusBadRequest, req)
-		respondWithError(w, ErrMsg{"Invalid input body, must be a non-zero length string in specified format"}, http.StatusBadRequest)
-		return
+		return message.Errorf(message.ErrInvalid, "Invalid input body, must be a non-zero length string in specified format")
 	}
-	if l > r.limit {
-		log.Errorf("message length %d greater than limit %d", l, r.limit)
-		msg := fmt.Sprintf("Input text length must be in range 1-%d", r.limit)
-		r.addSpan(req.Context(), http.StatusBadRequest, req)
-		respondWithError(w, ErrMsg{msg}, http.StatusBadRequest)
-		return
+	if l > limit {
+		return message.Errorf(message.ErrInvalid, "Input text length %d must be in range 1-%d", l, limit)
+	}
+	if len(msg.Author) > maxAuthorLen {
+		return message.Errorf(message.ErrInvalid, "Author must be at most %d characters", maxAuthorLen)
+	}
+	if len(msg.Tags) > maxTags {
+		return message.Errorf(message.ErrInvalid, "A message can have at most %d tags", maxTags)
 	}
+	for _, tag := range msg.Tags {
+		if len(tag) < 1 || len(tag) > maxTagLen || strings.Contains(tag, ";") {
+			return message.Errorf(message.ErrInvalid, "Invalid tag %q, tags must be 1-%d characters and must not contain ';'", tag, maxTagLen)
+		}
+	}
+	if msg.ParentId < 0 {
+		return message.Errorf(message.ErrInvalid, "Invalid parent id %d, should be a valid positive integer", msg.ParentId)
+	}
+	if msg.DeliverAt != nil && time.Until(*msg.DeliverAt) > maxScheduleDelay {
+		return message.Errorf(message.ErrInvalid, "Delivery time must be at most %v ahead", maxScheduleDelay)
+	}
+	if msg.Queue != "" {
+		return validateQueueName(msg.Queue)
+	}
+	return nil
+}
 
-	resp := r.m.Add(msg.Text)
-	r.addSpan(req.Context(), http.StatusOK, req)
-	jsonResponse(w, resp, http.StatusOK)
-	log.Infof("Added message with id %v successfully", resp.Id)
+// readOptions returns the read options selected by the optional include
+// query param, a comma-separated list. "scheduled" includes messages that
+// are not delivered yet.
+func readOptions(req *http.Request) ([]message.ReadOption, error) {
+	var opts []message.ReadOption
+	for _, val := range req.URL.Query()["include"] {
+		for _, item := range strings.Split(val, ",") {
+			switch strings.TrimSpace(item) {
+			case "scheduled":
+				opts = append(opts, message.IncludeScheduled())
+			default:
+				return nil, message.Errorf(message.ErrInvalid, "Invalid value %q for query param include, should be scheduled", item)
+			}
+		}
+	}
+	return opts, nil
 }
 
-func (r *appRouter) validateMsgID(w http.ResponseWriter, req *http.Request) (int64, error) {
-	var err error
+func (r *appRouter) validateMsgID(req *http.Request) (int64, error) {
 	vars := mux.Vars(req)
-	log.Infof("Received route params as: %#v", vars)
+	logOf(req).Infof("Received route params as: %#v", vars)
 	id, ok := vars["id"]
 	if !ok {
-		err = fmt.Errorf("id param not present in request path")
-		r.addSpan(req.Context(), http.StatusBadRequest, req)
-		respondWithError(w, ErrMsg{"Message id not passed in the request. Retry in the format: /v1/messages/{id} "}, http.StatusBadRequest)
-		return 0, err
+		return 0, message.Errorf(message.ErrInvalid, "Message id not passed in the request. Retry in the format: /v1/messages/{id}")
 	}
 
 	val, err := strconv.ParseInt(id, 10, defaultBitsize)
 	if err != nil || val < 1 {
-		err = fmt.Errorf("invalid message id value: %s,err:%s ", id, err)
-		r.addSpan(req.Context(), http.StatusBadRequest, req)
-		respondWithError(w, ErrMsg{"Invalid message id value, should be a valid positive integer"}, http.StatusBadRequest)
-		return 0, err
+		return 0, message.Errorf(message.ErrInvalid, "Invalid message id value %q, should be a valid positive integer", id)
 	}
-	log.Debug("Validated message ID successfully")
-	return val, err
+	logOf(req).Debug("Validated message ID successfully")
+	return val, nil
 }
 
 func (r *appRouter) getMessage(w http.ResponseWriter, req *http.Request) {
-	id, err := r.validateMsgID(w, req)
+	mediaType, err := negotiate(w, req, messageMediaTypes)
+	var id int64
+	if err == nil {
+		id, err = r.validateMsgID(req)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	opts, err := readOptions(req)
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
 	if err != nil {
-		log.Error("error validating request: ", err)
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
 		return
 	}
-	resp, err := r.m.Get(id)
+	// read before the message, a change in between leaves it older rather than newer
+	modified := ch.m.Modified(id)
+	resp, err := ch.m.Get(id, opts...)
 	if err != nil {
-		log.Error("error while retrieving message: ", err)
-		r.addSpan(req.Context(), http.StatusNotFound, req)
-		respondWithError(w, ErrMsg{err.Error()}, http.StatusNotFound)
+		logOf(req).Error("error while retrieving message: ", err)
+		r.respondWithError(w, req, err)
 		return
 	}
 	// check for optional query param "is-palindrome"
 	param := req.URL.Query()
 	if val, ok := param["is-palindrome"]; ok {
-		log.Debugf("value of query param is %#v:", val)
+		logOf(req).Debugf("value of query param is %#v:", val)
 		if len(val) > 0 && val[0] != "" {
-			log.Error("query param passed incorrectly: ", param)
-			r.addSpan(req.Context(), http.StatusBadRequest, req)
-			respondWithError(w, ErrMsg{"Incorrect URL structure, should be passed as: /v1/messages/{id}?is-palindrome "}, http.StatusBadRequest)
+			logOf(req).Error("query param passed incorrectly: ", param)
+			r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Incorrect URL structure, should be passed as: /v1/messages/{id}?is-palindrome"))
 			return
 		}
 		resp.IsPalindrome = checkIfPalindrome(resp.Text)
-		log.Info("Result of Palindrome check: ", *resp.IsPalindrome)
+		logOf(req).Info("Result of Palindrome check: ", *resp.IsPalindrome)
+	}
+	if r.notModified(w, req, messageETag(resp, mediaType), modified) {
+		logOf(req).Infof("Message %d not modified", id)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	r.encodedResponse(w, req, mediaType, resp, http.StatusOK)
+	logOf(req).Infof("Retrieved message %d successfully", id)
+}
+
+func (r *appRouter) updateMessage(w http.ResponseWriter, req *http.Request) {
+	mediaType, err := negotiate(w, req, messageMediaTypes)
+	var id int64
+	if err == nil {
+		id, err = r.validateMsgID(req)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	body, err := readLimitedBody(w, req, maxMessageBytes)
+	if err != nil {
+		logOf(req).Error("Error while reading request body: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	msg := MsgRequestBody{}
+	err = decodeBody(req, body, &msg)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	err = validateMessage(msg.message(), ch.CharLimit)
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := ch.m.Update(id, msg.message(), message.EditedBy(authorOf(req, msg.Author)))
+	if err != nil {
+		logOf(req).Error("error while updating message: ", err)
+		r.respondWithError(w, req, err)
+		return
 	}
 	r.addSpan(req.Context(), http.StatusOK, req)
-	jsonResponse(w, resp, http.StatusOK)
-	log.Infof("Retrieved message %d successfully", id)
+	r.encodedResponse(w, req, mediaType, resp, http.StatusOK)
+	logOf(req).Infof("Updated message %d successfully", id)
 }
 
 func (r *appRouter) getAllMessages(w http.ResponseWriter, req *http.Request) {
-	resp, err := r.m.GetAll()
+	mediaType, err := negotiate(w, req, listMediaTypes)
+	var opts []message.ReadOption
+	if err == nil {
+		opts, err = readOptions(req)
+	}
+	var p page
+	if err == nil {
+		p, err = readPage(req)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	// read before the messages, a change in between makes the ETag stale
+	// rather than the list
+	version, modified := ch.m.Version()
+	resp, err := ch.m.GetAll(opts...)
 	if err != nil {
-		log.Error("error while retrieving messages: ", err)
-		r.addSpan(req.Context(), http.StatusNotFound, req)
-		respondWithError(w, ErrMsg{err.Error()}, http.StatusNotFound)
+		logOf(req).Error("error while retrieving messages: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, next := p.apply(resp)
+	if next != "" {
+		setNextLink(w, req, next)
+	}
+	if r.notModified(w, req, listETag(version, req, mediaType), modified) {
+		logOf(req).Info("Messages not modified")
 		return
 	}
 	r.addSpan(req.Context(), http.StatusOK, req)
-	jsonResponse(w, resp, http.StatusOK)
-	log.Info("Retrieved all messages successfully")
+	r.encodedResponse(w, req, mediaType, resp, http.StatusOK)
+	logOf(req).Info("Retrieved all messages successfully")
 }
 
 func (r *appRouter) deleteMessage(w http.ResponseWriter, req *http.Request) {
-
-	id, err := r.validateMsgID(w, req)
+	id, err := r.validateMsgID(req)
+	var opts []message.DeleteOption
+	if err == nil {
+		opts, err = r.deleteOptions(req)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
 	if err != nil {
-		log.Error("error validating request: ", err)
-		r.addSpan(req.Context(), http.StatusBadRequest, req)
-		respondWithError(w, ErrMsg{err.Error()}, http.StatusBadRequest)
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
 		return
 	}
-	err = r.m.Delete(id)
+	err = ch.m.Delete(id, opts...)
 	if err != nil {
-		log.Error("error while deleting message: ", err)
-		r.addSpan(req.Context(), http.StatusNotFound, req)
-		respondWithError(w, ErrMsg{err.Error()}, http.StatusNotFound)
+		logOf(req).Error("error while deleting message: ", err)
+		r.respondWithError(w, req, err)
 		return
 	}
 	r.addSpan(req.Context(), http.StatusNoContent, req)
-	jsonResponse(w, struct{}{}, http.StatusNoContent)
-	log.Infof("Deleted message %d successfully", id)
+	w.WriteHeader(http.StatusNoContent)
+	logOf(req).Infof("Deleted message %d successfully", id)
 }
 
 func checkIfPalindrome(str string) *bool {
@@ -226,6 +645,9 @@ func (r *appRouter) InitTracing(lib string, host string, addr string) error {
 }
 
 func (r *appRouter) Close() {
+	r.hooks.d.close()
+	r.channels.close()
+	r.tenants.close()
 	r.t.Close()
 }
 
@@ -238,7 +660,7 @@ func (r *appRouter) addSpan(ctx context.Context, code int, req *http.Request) {
 	}
 
 	// start a new Span to wrap HTTP request
-	span := r.t.tracer.StartSpan(
+	span := r.t.get().StartSpan(
 		req.URL.Path,
 		opentracing.ChildOf(parentCtx),
 	)
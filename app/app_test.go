@@ -2,14 +2,17 @@ package app
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -63,7 +66,49 @@ func Test_appRouter_getMessage_Neg(t *testing.T) {
 
 	appRouterObj.getMessage(w, req)
 	assert.Equal(t, http.StatusNotFound, w.Code)
-	assert.Equal(t, `{"error":"input text ID 1 not found "}`, strings.TrimSuffix(string(w.Body.Bytes()), "\n"))
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+
+	var p Problem
+	err = json.Unmarshal(w.Body.Bytes(), &p)
+	assert.NoError(t, err)
+	assert.Equal(t, Problem{
+		Type:     "about:blank",
+		Title:    "Not Found",
+		Status:   http.StatusNotFound,
+		Code:     "not_found",
+		Detail:   "message 1 not found",
+		Instance: "/v1/messages/%7Bid%7D",
+	}, p)
+}
+
+func Test_appRouter_problem_requestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{"invalid id", "/v1/messages/abc", http.StatusBadRequest, "invalid_argument"},
+		{"unknown id", "/v1/messages/100", http.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("X-Request-ID", "test-"+tt.code)
+			w := httptest.NewRecorder()
+
+			appRouterObj.GetRouter().ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, "test-"+tt.code, w.Header().Get("X-Request-ID"))
+
+			var p Problem
+			err := json.Unmarshal(w.Body.Bytes(), &p)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, tt.path, p.Instance)
+			assert.Equal(t, "test-"+tt.code, p.RequestID)
+		})
+	}
 }
 
 func Test_appRouter_createMessage_getMessage(t *testing.T) {
@@ -77,7 +122,7 @@ func Test_appRouter_createMessage_getMessage(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	appRouterObj.createMessage(w, req)
-	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusCreated, w.Code)
 	assert.Equal(t, `{"id":1,"text":"sample"}`, strings.TrimSuffix(string(w.Body.Bytes()), "\n"))
 
 	// Test Get as well
@@ -111,7 +156,7 @@ func Test_appRouter_palindrome_createMessage_getMessage(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	appRouterObj.createMessage(w, req)
-	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusCreated, w.Code)
 	assert.Equal(t, `{"id":2,"text":"malayalam"}`, strings.TrimSuffix(string(w.Body.Bytes()), "\n"))
 
 	// Test Get as well
@@ -168,13 +213,60 @@ func Test_appRouter_deleteMessage(t *testing.T) {
 	assert.Equal(t, http.StatusNoContent, w.Code)
 }
 
+func Test_appRouter_ttl(t *testing.T) {
+	r := newTestRouter(WithDefaultTTL(time.Hour))
+	create := func(body string) message.MessageObj {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body)))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var msg message.MessageObj
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msg))
+		return msg
+	}
+
+	// the default applies to messages without a ttl
+	msg := create(`{"text":"default"}`)
+	if assert.NotNil(t, msg.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *msg.ExpiresAt, 5*time.Second)
+	}
+	// a ttl counts from the delivery of a scheduled message
+	msg = create(`{"text":"scheduled","ttl":60,"delay_seconds":600}`)
+	if assert.NotNil(t, msg.ExpiresAt) && assert.NotNil(t, msg.DeliverAt) {
+		assert.Equal(t, time.Minute, msg.ExpiresAt.Sub(*msg.DeliverAt))
+	}
+
+	rec := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "messages_expired_total")
+}
+
+// texts returns messages with the given texts, for the batch methods.
+func texts(t ...string) []message.MessageObj {
+	msgs := make([]message.MessageObj, len(t))
+	for i := range t {
+		msgs[i].Text = t[i]
+	}
+	return msgs
+}
+
+// newTestRouter returns a router with its own empty message store, sharing
+// the tracer initialized in TestMain.
+func newTestRouter(opts ...Option) *appRouter {
+	r := NewAppRouter(200, opts...)
+	r.t = appRouterObj.t
+	r.SetRoutes()
+	return r
+}
+
 func TestMain(m *testing.M) {
 	appRouterObj = NewAppRouter(200)
-	err := appRouterObj.InitTracing("jaeger", ":8080")
+	err := appRouterObj.InitTracing("jaeger", "localhost", ":6831")
 	if err != nil {
 		print("Initialization of tests failed with error: ", err)
 		os.Exit(-1)
 	}
+	appRouterObj.SetRoutes()
 	exitVal := m.Run()
 	os.Exit(exitVal)
 }
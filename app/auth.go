@@ -0,0 +1,106 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const authRealm = "messaging"
+
+// errUnauthenticated is returned for requests without valid credentials.
+var errUnauthenticated = errors.New("unauthenticated")
+
+// errForbidden is returned for requests the authenticated user may not make.
+var errForbidden = errors.New("forbidden")
+
+type principalKey struct{}
+
+// principalFromContext returns the name of the authenticated user of a
+// request, or "" if authentication is disabled.
+func principalFromContext(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey{}).(string)
+	return p
+}
+
+// authorOf returns the author to store for a message sent with req: the
+// authenticated user if there is one, author as sent by the client otherwise.
+func authorOf(req *http.Request, author string) string {
+	if p := principalFromContext(req.Context()); p != "" {
+		return p
+	}
+	return author
+}
+
+// ReadUsers parses user:password lines, as read by -auth-file. Blank lines
+// and lines starting with # are ignored.
+func ReadUsers(r io.Reader) (map[string]string, error) {
+	return readPairs(r, "user:password")
+}
+
+// readPairs parses key:value lines in the format of ReadUsers, format names
+// the expected line in errors.
+func readPairs(r io.Reader, format string) (map[string]string, error) {
+	users := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i < 1 || i == len(text)-1 {
+			return nil, fmt.Errorf("line %d: expected %s", line, format)
+		}
+		users[text[:i]] = text[i+1:]
+	}
+	return users, sc.Err()
+}
+
+// WithBasicAuth requires HTTP basic authentication with one of users, a map
+// of user name to password, for every /v1 route. Authentication is disabled
+// if users is empty.
+func WithBasicAuth(users map[string]string) Option {
+	return func(r *appRouter) {
+		r.users = users
+	}
+}
+
+// isAPIPath reports whether path is served by the API, the /v1 routes and
+// the GraphQL endpoint, rather than by the health and metrics routes.
+func isAPIPath(path string) bool {
+	return strings.HasPrefix(path, "/v1/") || path == graphQLPath
+}
+
+// authenticate checks the credentials of requests to the API and stores the
+// authenticated user in the request context. OPTIONS requests are let
+// through since they reveal nothing but the routes.
+func (r *appRouter) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(r.users) == 0 || req.Method == http.MethodOptions || !isAPIPath(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		user, pass, ok := req.BasicAuth()
+		if !ok || !r.validCredentials(user, pass) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+			r.respondWithError(w, req, message.Errorf(errUnauthenticated, "Valid credentials are required"))
+			return
+		}
+		ctx := context.WithValue(req.Context(), principalKey{}, user)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// validCredentials reports whether pass is the password of user.
+func (r *appRouter) validCredentials(user, pass string) bool {
+	want, known := r.users[user]
+	return known && subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+}
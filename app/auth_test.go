@@ -0,0 +1,63 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadUsers(t *testing.T) {
+	users, err := ReadUsers(strings.NewReader("# users\nann:secret\n\nbob:pa:ss\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"ann": "secret", "bob": "pa:ss"}, users)
+
+	_, err = ReadUsers(strings.NewReader("ann\n"))
+	assert.EqualError(t, err, "line 1: expected user:password")
+}
+
+func Test_appRouter_authenticate(t *testing.T) {
+	r := newTestRouter(WithBasicAuth(map[string]string{"ann": "secret"}))
+
+	tests := []struct {
+		name       string
+		user, pass string
+		wantCode   int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "ann", "guess", http.StatusUnauthorized},
+		{"unknown user", "bob", "secret", http.StatusUnauthorized},
+		{"valid", "ann", "secret", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"text":"hi","author":"eve"}`))
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			rec := httptest.NewRecorder()
+			r.GetRouter().ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode == http.StatusUnauthorized {
+				assert.Equal(t, `Basic realm="messaging"`, rec.Header().Get("WWW-Authenticate"))
+				assert.Contains(t, rec.Body.String(), `"code":"unauthenticated"`)
+				return
+			}
+			var msg message.MessageObj
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msg))
+			assert.Equal(t, "ann", msg.Author, "author must be the authenticated user")
+		})
+	}
+
+	// the root page and OPTIONS need no credentials
+	for _, req := range []*http.Request{httptest.NewRequest("GET", "/", nil), httptest.NewRequest("OPTIONS", "/v1/messages", nil)} {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, req)
+		assert.NotEqual(t, http.StatusUnauthorized, rec.Code, req.Method+" "+req.URL.Path)
+	}
+}
@@ -0,0 +1,289 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	maxBatchItems     = 10000
+	// maxBatchBytes bounds the body of a batch of messages.
+	maxBatchBytes = 16 << 20
+)
+
+// errPayloadTooLarge is the kind of errors for request bodies over their
+// limit.
+var errPayloadTooLarge = errors.New("payload too large")
+
+// limitedBody reads a request body of at most max bytes, see
+// http.MaxBytesReader, and counts the bytes read so that a failed read can
+// be told apart from a body that is too large.
+type limitedBody struct {
+	r    io.Reader
+	read int64
+	max  int64
+}
+
+func newLimitedBody(w http.ResponseWriter, req *http.Request, max int64) *limitedBody {
+	return &limitedBody{r: http.MaxBytesReader(w, req.Body, max), max: max}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// check returns err, or an error of kind errPayloadTooLarge if err was
+// caused by reading past the limit.
+func (b *limitedBody) check(err error) error {
+	if err != nil && b.read >= b.max {
+		return message.Errorf(errPayloadTooLarge, "Request body must be at most %d bytes", b.max)
+	}
+	return err
+}
+
+// readLimitedBody reads the body of req, which may be at most max bytes.
+func readLimitedBody(w http.ResponseWriter, req *http.Request, max int64) ([]byte, error) {
+	body := newLimitedBody(w, req, max)
+	b, err := ioutil.ReadAll(body)
+	if err = body.check(err); err != nil {
+		if errors.Is(err, errPayloadTooLarge) {
+			return nil, err
+		}
+		return nil, message.Errorf(message.ErrInvalid, "Invalid request body")
+	}
+	return b, nil
+}
+
+// BatchDeleteRequestBody is the request body of POST /v1/messages:batchDelete.
+type BatchDeleteRequestBody struct {
+	Ids []int64 `json:"ids"`
+}
+
+// BatchItemResult is the outcome of a single item of a batch request. Index
+// is the position of the item in the request.
+type BatchItemResult struct {
+	Index   int                 `json:"index"`
+	Status  int                 `json:"status"`
+	Id      int64               `json:"id,omitempty"`
+	Message *message.MessageObj `json:"message,omitempty"`
+	Error   *Problem            `json:"error,omitempty"`
+}
+
+// BatchResponse is returned by the batch endpoints. When the request was
+// atomic and any item failed, nothing was applied and the items that were
+// valid on their own report 424 Failed Dependency.
+type BatchResponse struct {
+	Applied bool              `json:"applied"`
+	Results []BatchItemResult `json:"results"`
+}
+
+// boolParam returns the value of the optional boolean query param name.
+func boolParam(req *http.Request, name string) (bool, error) {
+	val := req.URL.Query().Get(name)
+	if val == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, message.Errorf(message.ErrInvalid, "Invalid value %q for query param %s, should be true or false", val, name)
+	}
+	return b, nil
+}
+
+// readBatchItems splits the request body into raw items. The body is either
+// a JSON array or, with Content-Type application/x-ndjson, one JSON document
+// per line.
+func readBatchItems(w http.ResponseWriter, req *http.Request) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	body := newLimitedBody(w, req, maxBatchBytes)
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if ct == ndjsonContentType {
+		sc := bufio.NewScanner(body)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			items = append(items, json.RawMessage(append([]byte(nil), line...)))
+			if len(items) > maxBatchItems {
+				break
+			}
+		}
+		if err := body.check(sc.Err()); err != nil {
+			if errors.Is(err, errPayloadTooLarge) {
+				return nil, err
+			}
+			return nil, message.Errorf(message.ErrInvalid, "Invalid request body: %s", err)
+		}
+	} else {
+		err := body.check(json.NewDecoder(body).Decode(&items))
+		if errors.Is(err, errPayloadTooLarge) {
+			return nil, err
+		}
+		if err != nil && err != io.EOF {
+			return nil, message.Errorf(message.ErrInvalid, "Invalid request body, must be a JSON array or %s", ndjsonContentType)
+		}
+	}
+	if len(items) == 0 {
+		return nil, message.Errorf(message.ErrInvalid, "Batch must contain at least one item")
+	}
+	if len(items) > maxBatchItems {
+		return nil, message.Errorf(message.ErrInvalid, "Batch must contain at most %d items", maxBatchItems)
+	}
+	return items, nil
+}
+
+func (r *appRouter) batchCreateMessages(w http.ResponseWriter, req *http.Request) {
+	atomic, err := boolParam(req, "atomic")
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	items, err := readBatchItems(w, req)
+	if err != nil {
+		logOf(req).Error("error while reading batch: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+
+	ch := r.defaultChannel(r.tenantOf(req))
+	results := make([]BatchItemResult, len(items))
+	var msgs []message.MessageObj
+	var valid []int
+	for i, item := range items {
+		results[i].Index = i
+		msg := MsgRequestBody{}
+		var obj message.MessageObj
+		err := json.Unmarshal(item, &msg)
+		if err != nil {
+			err = message.Errorf(message.ErrInvalid, "Invalid item, must be an object in specified format")
+		} else {
+			obj, err = ch.newMessage(req.Context(), msg)
+		}
+		if err != nil {
+			p := newProblem(req, err)
+			results[i].Status, results[i].Error = p.Status, &p
+			continue
+		}
+		msgs = append(msgs, obj)
+		valid = append(valid, i)
+	}
+
+	resp := BatchResponse{Results: results}
+	if atomic && len(valid) < len(items) {
+		for _, i := range valid {
+			results[i].Status = http.StatusFailedDependency
+		}
+		r.addSpan(req.Context(), http.StatusUnprocessableEntity, req)
+		jsonResponse(w, resp, http.StatusUnprocessableEntity)
+		logOf(req).Errorf("Rejected atomic batch of %d messages, %d invalid", len(items), len(items)-len(valid))
+		return
+	}
+
+	created, err := ch.m.AddBatch(msgs)
+	if err != nil {
+		logOf(req).Error("error while adding batched messages: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	for n, obj := range created {
+		obj := obj
+		i := valid[n]
+		results[i].Status, results[i].Id, results[i].Message = http.StatusCreated, obj.Id, &obj
+	}
+	resp.Applied = len(valid) > 0
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Added %d of %d batched messages", len(valid), len(items))
+}
+
+func (r *appRouter) batchDeleteMessages(w http.ResponseWriter, req *http.Request) {
+	atomic, err := boolParam(req, "atomic")
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	body := BatchDeleteRequestBody{}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Invalid request body, must be in the format {\"ids\": [1, 2]}"))
+		return
+	}
+	if len(body.Ids) == 0 || len(body.Ids) > maxBatchItems {
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Batch must contain 1-%d ids", maxBatchItems))
+		return
+	}
+
+	results := make([]BatchItemResult, len(body.Ids))
+	var ids []int64
+	var valid []int
+	for i, id := range body.Ids {
+		results[i].Index, results[i].Id = i, id
+		if id < 1 {
+			p := newProblem(req, message.Errorf(message.ErrInvalid, "Invalid message id value %d, should be a valid positive integer", id))
+			results[i].Status, results[i].Error = p.Status, &p
+			continue
+		}
+		ids = append(ids, id)
+		valid = append(valid, i)
+	}
+	if atomic && len(valid) < len(body.Ids) {
+		for _, i := range valid {
+			results[i].Status = http.StatusFailedDependency
+		}
+		r.addSpan(req.Context(), http.StatusUnprocessableEntity, req)
+		jsonResponse(w, BatchResponse{Results: results}, http.StatusUnprocessableEntity)
+		return
+	}
+
+	errs, err := r.tenantOf(req).m.DeleteBatch(ids, atomic, message.DeletedBy(principalFromContext(req.Context())))
+	if err != nil {
+		logOf(req).Error("error while deleting batched messages: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	rejected := atomic && failed > 0
+	for n, err := range errs {
+		i := valid[n]
+		switch {
+		case err != nil:
+			p := newProblem(req, err)
+			results[i].Status, results[i].Error = p.Status, &p
+		case rejected:
+			results[i].Status = http.StatusFailedDependency
+		default:
+			results[i].Status = http.StatusNoContent
+		}
+	}
+
+	resp := BatchResponse{Applied: !rejected && failed < len(ids), Results: results}
+	if rejected {
+		r.addSpan(req.Context(), http.StatusUnprocessableEntity, req)
+		jsonResponse(w, resp, http.StatusUnprocessableEntity)
+		logOf(req).Errorf("Rejected atomic batch delete of %d messages, %d failed", len(ids), failed)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Deleted %d of %d batched messages", len(ids)-failed, len(body.Ids))
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func doBatch(r *appRouter, path, contentType, body string) (*httptest.ResponseRecorder, BatchResponse) {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(w, req)
+	var resp BatchResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func statuses(resp BatchResponse) []int {
+	var s []int
+	for _, res := range resp.Results {
+		s = append(s, res.Status)
+	}
+	return s
+}
+
+func Test_appRouter_batchCreateMessages(t *testing.T) {
+	r := newTestRouter()
+
+	w, resp := doBatch(r, "/v1/messages:batch", "", `[{"text":"one"},{"text":""},{"text":"two"}]`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, resp.Applied)
+	assert.Equal(t, []int{201, 400, 201}, statuses(resp))
+	assert.Equal(t, "invalid_argument", resp.Results[1].Error.Code)
+	assert.Equal(t, int64(2), resp.Results[2].Id)
+
+	w, resp = doBatch(r, "/v1/messages:batch", "application/x-ndjson", "{\"text\":\"three\"}\n\n{\"text\":\"four\"}\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []int{201, 201}, statuses(resp))
+
+	w, resp = doBatch(r, "/v1/messages:batch?atomic=true", "", `[{"text":"five"},{"text":5}]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.False(t, resp.Applied)
+	assert.Equal(t, []int{424, 400}, statuses(resp))
+
+	msgs, err := r.m.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+
+	w, _ = doBatch(r, "/v1/messages:batch", "", `{"text":"not an array"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a batch without valid messages creates nothing
+	w, resp = doBatch(r, "/v1/messages:batch", "", `[{"text":""}]`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []int{400}, statuses(resp))
+	msgs, _ = r.m.GetAll()
+	assert.Len(t, msgs, 4)
+
+	big := `[{"text":"` + strings.Repeat("x", maxBatchBytes) + `"}]`
+	w, _ = doBatch(r, "/v1/messages:batch", "", big)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "payload_too_large")
+	w, _ = doBatch(r, "/v1/messages:batch", "application/x-ndjson", strings.Repeat(`{"text":"`+strings.Repeat("x", 4096)+`"}`+"\n", maxBatchBytes/4096))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func Test_appRouter_batchDeleteMessages(t *testing.T) {
+	r := newTestRouter()
+	_, _ = r.m.AddBatch(texts("one", "two", "three"))
+
+	w, resp := doBatch(r, "/v1/messages:batchDelete?atomic=true", "", `{"ids":[1,7]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.False(t, resp.Applied)
+	assert.Equal(t, []int{424, 404}, statuses(resp))
+
+	w, resp = doBatch(r, "/v1/messages:batchDelete", "", `{"ids":[1,7,0,2]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, resp.Applied)
+	assert.Equal(t, []int{204, 404, 400, 204}, statuses(resp))
+
+	msgs, err := r.m.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
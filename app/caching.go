@@ -0,0 +1,159 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultCachePolicies are the Cache-Control headers of operations unless
+// configured otherwise. Messages are revalidated with their ETag on every
+// use.
+var defaultCachePolicies = map[string]string{
+	"getMessage":   "no-cache",
+	"listMessages": "no-cache",
+}
+
+// WithCacheControl sets the Cache-Control header of successful GET and HEAD
+// responses by the ID of their operation in the OpenAPI document, e.g.
+// getMessage. The channel routes of an operation follow its policy unless
+// they have their own. An empty policy drops the header of an operation.
+func WithCacheControl(policies map[string]string) Option {
+	return func(r *appRouter) {
+		for op, p := range policies {
+			r.cachePolicies[op] = p
+		}
+	}
+}
+
+// ParseCachePolicies parses operation=policy pairs separated by ';', as
+// read by -cache-control, e.g. "getMessage=private, max-age=60".
+func ParseCachePolicies(s string) (map[string]string, error) {
+	policies := make(map[string]string)
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid cache policy %q, expected operation=policy", pair)
+		}
+		policies[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return policies, nil
+}
+
+// checkCachePolicies warns about policies of operations the OpenAPI
+// document does not have, most likely typos.
+func (r *appRouter) checkCachePolicies() {
+	ops := make(map[string]bool)
+	for _, item := range r.spec.Paths {
+		for _, op := range item.Operations() {
+			ops[op.OperationID] = true
+		}
+	}
+	for op := range r.cachePolicies {
+		if !ops[op] {
+			log.Warnf("Cache-Control policy for unknown operation %s is ignored", op)
+		}
+	}
+}
+
+// cachePolicy returns the Cache-Control header of the responses of op.
+func (r *appRouter) cachePolicy(op string) string {
+	if p, ok := r.cachePolicies[op]; ok {
+		return p
+	}
+	return r.cachePolicies[strings.TrimSuffix(op, "InChannel")]
+}
+
+// cacheControl sets the Cache-Control header of GET and HEAD requests to
+// operations with a policy. respondWithError drops it again, errors are not
+// to be cached. Responses are for the tenant and user of the request, so
+// they vary with the headers naming them, whatever the policy says.
+func (r *appRouter) cacheControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" || req.Method == "HEAD" {
+			if route := r.openAPIRoute(req); route != nil {
+				w.Header().Add("Vary", tenantHeader+", Authorization")
+				if p := r.cachePolicy(route.Operation.OperationID); p != "" {
+					w.Header().Set("Cache-Control", p)
+				}
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// messageETag returns the strong ETag of msg encoded in mediaType. Encodings
+// are deterministic, so equal messages in equal media types are encoded
+// byte for byte alike.
+func messageETag(msg message.MessageObj, mediaType string) string {
+	h := sha256.New()
+	io.WriteString(h, mediaType+"\n")
+	if err := json.NewEncoder(h).Encode(msg); err != nil {
+		log.Error("Error while hashing message: ", err)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// listETag returns the ETag of the list of messages answering req in
+// mediaType, from the version of the store listed. It is weak since expired
+// messages leave the list a moment before the store reclaims them.
+func listETag(version string, req *http.Request, mediaType string) string {
+	h := sha256.New()
+	io.WriteString(h, version+"\n"+mediaType+"\n"+req.URL.RawQuery)
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified sets the ETag and Last-Modified headers of the response to
+// req and, if the client has the response already according to the
+// conditional headers of req, answers with 304 and returns true.
+func (r *appRouter) notModified(w http.ResponseWriter, req *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if !fresh(req, etag, modified) {
+		return false
+	}
+	r.addSpan(req.Context(), http.StatusNotModified, req)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// fresh reports whether the response with etag, last modified at modified,
+// is not modified according to If-None-Match or, if that is not set,
+// If-Modified-Since, as RFC 7232 section 6 evaluates them for GET and HEAD.
+func fresh(req *http.Request, etag string, modified time.Time) bool {
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		return etagMatches(match, etag)
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	// HTTP dates have a resolution of a second
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// etagMatches reports whether one of the ETags of an If-None-Match header
+// matches etag with the weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_appRouter_conditionalGet(t *testing.T) {
+	r := newTestRouter(WithOpenAPIValidation())
+	defer r.tenants.close()
+	openAPIResponseViolations.Reset()
+	serve := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, serve("POST", "/v1/messages", `{"text":"sample"}`, nil).Code)
+	w := serve("GET", "/v1/messages/1", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.NotEmpty(t, modified)
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+
+	w = serve("GET", "/v1/messages/1", "", map[string]string{"If-None-Match": `"other", ` + etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Equal(t, []string{"X-Tenant-ID, Authorization", "Accept"}, w.Header()["Vary"])
+	assert.Equal(t, http.StatusNotModified, serve("HEAD", "/v1/messages/1", "", map[string]string{"If-None-Match": "W/" + etag}).Code)
+	assert.Equal(t, http.StatusNotModified, serve("GET", "/v1/messages/1", "", map[string]string{"If-Modified-Since": modified}).Code)
+
+	// every representation has its own ETag
+	w = serve("GET", "/v1/messages/1", "", map[string]string{"If-None-Match": etag, "Accept": xmlContentType})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	w = serve("GET", "/v1/messages/1?is-palindrome", "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// If-None-Match wins over If-Modified-Since
+	w = serve("GET", "/v1/messages/1", "", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified})
+	assert.Equal(t, http.StatusOK, w.Code)
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	assert.Equal(t, http.StatusOK, serve("GET", "/v1/messages/1", "", map[string]string{"If-Modified-Since": past}).Code)
+
+	w = serve("GET", "/v1/messages", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	listTag := w.Header().Get("ETag")
+	assert.Regexp(t, `^W/"[0-9a-f]{32}"$`, listTag)
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Equal(t, http.StatusNotModified, serve("GET", "/v1/messages", "", map[string]string{"If-None-Match": listTag}).Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/v1/messages?page_size=1", "", map[string]string{"If-None-Match": listTag}).Code)
+
+	// changes make the validators stale
+	time.Sleep(time.Second)
+	assert.Equal(t, http.StatusOK, serve("PUT", "/v1/messages/1", `{"text":"edited"}`, nil).Code)
+	w = serve("GET", "/v1/messages/1", "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/v1/messages/1", "", map[string]string{"If-Modified-Since": modified}).Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/v1/messages", "", map[string]string{"If-None-Match": listTag}).Code)
+
+	// errors are not cached
+	w = serve("GET", "/v1/messages/9", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	assert.Empty(t, openAPIViolations(t), "responses not matching the OpenAPI document")
+}
+
+func Test_appRouter_cacheControl(t *testing.T) {
+	r := newTestRouter(WithCacheControl(map[string]string{
+		"getMessage":   "private, max-age=60",
+		"listMessages": "",
+		"listChannels": "max-age=5",
+	}))
+	defer r.tenants.close()
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(`{"text":"sample"}`)))
+		return w
+	}
+	assert.Equal(t, http.StatusCreated, serve("POST", "/v1/messages").Code)
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/v1/messages/1", "private, max-age=60"},
+		{"HEAD", "/v1/messages/1", "private, max-age=60"},
+		{"GET", "/v1/channels/default/messages/1", "private, max-age=60"},
+		{"GET", "/v1/messages", ""},
+		{"GET", "/v1/channels", "max-age=5"},
+		{"GET", "/v1/trash", ""},
+		{"POST", "/v1/messages", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := serve(tt.method, tt.path)
+			assert.Less(t, w.Code, 300)
+			assert.Equal(t, tt.want, w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestParseCachePolicies(t *testing.T) {
+	got, err := ParseCachePolicies(" getMessage=private, max-age=60 ;listMessages=;")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"getMessage": "private, max-age=60", "listMessages": ""}, got)
+
+	_, err = ParseCachePolicies("getMessage")
+	assert.Error(t, err)
+	_, err = ParseCachePolicies("=no-cache")
+	assert.Error(t, err)
+}
@@ -0,0 +1,422 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultChannel      = "default"
+	maxChannels         = 100
+	maxChannelNameLen   = 80
+	maxChannelCharLimit = 64 << 10
+	channelsFile        = "channels.json"
+)
+
+// ChannelRequestBody creates or updates a channel. Name is only used on
+// creation. CharLimit defaults to the -char-limit of the server. Retention,
+// in seconds, is the ttl of new messages sent without one; zero keeps them
+// until they are deleted.
+type ChannelRequestBody struct {
+	Name      string `json:"name,omitempty"`
+	CharLimit int    `json:"char_limit,omitempty"`
+	Retention int64  `json:"retention,omitempty"`
+}
+
+// Channel is a namespace of messages with its own message IDs and settings.
+type Channel struct {
+	Name      string    `json:"name"`
+	CharLimit int       `json:"char_limit"`
+	Retention int64     `json:"retention,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// channel is a Channel together with the store of its messages.
+type channel struct {
+	Channel
+	m *message.MessageServer
+}
+
+// ttlOf returns the ttl in seconds of a new message sent to c with ttl.
+func (c *channel) ttlOf(ttl int64) int64 {
+	if ttl == 0 {
+		return c.Retention
+	}
+	return ttl
+}
+
+// messagePath returns the path of message id of c.
+func (c *channel) messagePath(id int64) string {
+	if c.Name == defaultChannel {
+		return fmt.Sprintf("/v1/messages/%d", id)
+	}
+	return fmt.Sprintf("/v1/channels/%s/messages/%d", c.Name, id)
+}
+
+// channelStore holds the channels other than the default one, which is
+// served from the message store of the appRouter and configured by its
+// options. Without a directory channels live in memory only; with one,
+// their settings are saved in channels.json and the messages of every
+// channel are persisted in a subdirectory named after it.
+type channelStore struct {
+	sync.Mutex
+	dir string
+	// configure sets up the store of a channel before it is used, removed
+	// is called once the store of a removed channel is closed
+	configure func(name string, m *message.MessageServer)
+	removed   func(name string)
+	channels  map[string]*channel
+}
+
+func newChannelStore() *channelStore {
+	return &channelStore{
+		configure: func(string, *message.MessageServer) {},
+		removed:   func(string) {},
+		channels:  make(map[string]*channel),
+	}
+}
+
+// OpenChannels persists channels in dir, opening the channels saved there
+// by an earlier run. It must be called before the server starts.
+func (r *appRouter) OpenChannels(dir string) error {
+	return r.channels.open(dir)
+}
+
+func (s *channelStore) open(dir string) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, channelsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var saved []Channel
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &saved); err != nil {
+			return fmt.Errorf("channel list %s is corrupt: %v", filepath.Join(dir, channelsFile), err)
+		}
+	}
+	for _, c := range saved {
+		m, err := message.Open(filepath.Join(dir, c.Name))
+		if err != nil {
+			s.closeAll()
+			return fmt.Errorf("opening channel %s: %v", c.Name, err)
+		}
+		s.configure(c.Name, m)
+		s.channels[c.Name] = &channel{Channel: c, m: m}
+	}
+	s.dir = dir
+	return nil
+}
+
+// save writes the channel settings to the channel directory, if any.
+// Callers hold the lock.
+func (s *channelStore) save() error {
+	if s.dir == "" {
+		return nil
+	}
+	list := make([]Channel, 0, len(s.channels))
+	for _, c := range s.channels {
+		list = append(list, c.Channel)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, channelsFile)
+	if err := ioutil.WriteFile(path+".tmp", append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// newServer returns the message store of a new channel.
+func (s *channelStore) newServer(name string) (*message.MessageServer, error) {
+	m := message.NewMessageServer()
+	if s.dir != "" {
+		var err error
+		m, err = message.Open(filepath.Join(s.dir, name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.configure(name, m)
+	return m, nil
+}
+
+func (s *channelStore) add(c Channel) (Channel, error) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.channels[c.Name]; ok || c.Name == defaultChannel {
+		return Channel{}, message.Errorf(message.ErrConflict, "channel %s already exists", c.Name)
+	}
+	if len(s.channels) >= maxChannels {
+		return Channel{}, message.Errorf(message.ErrQuotaExceeded, "At most %d channels can be created", maxChannels)
+	}
+	m, err := s.newServer(c.Name)
+	if err != nil {
+		return Channel{}, err
+	}
+	c.CreatedAt = time.Now().UTC()
+	s.channels[c.Name] = &channel{Channel: c, m: m}
+	if err := s.save(); err != nil {
+		delete(s.channels, c.Name)
+		_ = m.Close()
+		return Channel{}, err
+	}
+	return c, nil
+}
+
+// get returns a copy of channel name, whose settings do not change while a
+// request is served from it.
+func (s *channelStore) get(name string) (*channel, error) {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.channels[name]
+	if !ok {
+		return nil, message.Errorf(message.ErrNotFound, "channel %s not found", name)
+	}
+	cp := *c
+	return &cp, nil
+}
+
+// list returns the channels ordered by name.
+func (s *channelStore) list() []Channel {
+	s.Lock()
+	defer s.Unlock()
+	list := make([]Channel, 0, len(s.channels))
+	for _, c := range s.channels {
+		list = append(list, c.Channel)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// update replaces the settings of channel name. They apply to messages sent
+// from then on.
+func (s *channelStore) update(name string, body ChannelRequestBody) (Channel, error) {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.channels[name]
+	if !ok {
+		return Channel{}, message.Errorf(message.ErrNotFound, "channel %s not found", name)
+	}
+	old := c.Channel
+	c.CharLimit = body.CharLimit
+	c.Retention = body.Retention
+	if err := s.save(); err != nil {
+		c.Channel = old
+		return Channel{}, err
+	}
+	return c.Channel, nil
+}
+
+// remove deletes channel name and all its messages. Requests that resolved
+// the channel before fail to change messages from then on, the store is
+// closed.
+func (s *channelStore) remove(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.channels[name]
+	if !ok {
+		return message.Errorf(message.ErrNotFound, "channel %s not found", name)
+	}
+	delete(s.channels, name)
+	if err := s.save(); err != nil {
+		s.channels[name] = c
+		return err
+	}
+	if err := c.m.Close(); err != nil {
+		log.Error("Error while closing message store of channel ", name, ": ", err)
+	}
+	s.removed(name)
+	if s.dir != "" {
+		return os.RemoveAll(filepath.Join(s.dir, name))
+	}
+	return nil
+}
+
+// close closes the message stores of all channels.
+func (s *channelStore) close() {
+	s.Lock()
+	defer s.Unlock()
+	s.closeAll()
+}
+
+func (s *channelStore) closeAll() {
+	for name, c := range s.channels {
+		if err := c.m.Close(); err != nil {
+			log.Error("Error while closing message store of channel ", name, ": ", err)
+		}
+	}
+}
+
+// defaultChannel returns the channel of tenant t served by /v1/messages.
+func (r *appRouter) defaultChannel(t *tenant) *channel {
+	return &channel{
+		Channel: Channel{
+			Name:      defaultChannel,
+			CharLimit: r.limit,
+			Retention: int64(r.ttl / time.Second),
+			CreatedAt: r.started,
+		},
+		m: t.m,
+	}
+}
+
+// channelOf returns the channel of the request path, the default channel if
+// the path has none.
+func (r *appRouter) channelOf(req *http.Request) (*channel, error) {
+	return r.channelNamed(r.tenantOf(req), mux.Vars(req)["channel"])
+}
+
+// channelNamed returns channel name of t, the default channel if name is
+// empty.
+func (r *appRouter) channelNamed(t *tenant, name string) (*channel, error) {
+	if name == "" || name == defaultChannel {
+		return r.defaultChannel(t), nil
+	}
+	if err := validateChannelName(name); err != nil {
+		return nil, err
+	}
+	return t.channels.get(name)
+}
+
+func validateChannelName(name string) error {
+	if len(name) > maxChannelNameLen || !queueNamePattern.MatchString(name) {
+		return message.Errorf(message.ErrInvalid, "Invalid channel name %q, must be 1-%d letters, digits, '-' or '_'", name, maxChannelNameLen)
+	}
+	return nil
+}
+
+func validateChannel(body ChannelRequestBody) error {
+	if body.CharLimit < 0 || body.CharLimit > maxChannelCharLimit {
+		return message.Errorf(message.ErrInvalid, "Char limit %d must be in range 0-%d, 0 for the default of the server", body.CharLimit, maxChannelCharLimit)
+	}
+	if max := int64(maxTTL / time.Second); body.Retention < 0 || body.Retention > max {
+		return message.Errorf(message.ErrInvalid, "Retention %d must be in range 0-%d seconds", body.Retention, max)
+	}
+	return nil
+}
+
+// readChannelBody decodes and validates the request body of the channel
+// endpoints, filling in the default char limit.
+func (r *appRouter) readChannelBody(req *http.Request) (ChannelRequestBody, error) {
+	body := ChannelRequestBody{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		return body, message.Errorf(message.ErrInvalid, "Invalid request body")
+	}
+	if err := validateChannel(body); err != nil {
+		return body, err
+	}
+	if body.CharLimit == 0 {
+		body.CharLimit = r.limit
+	}
+	return body, nil
+}
+
+func (r *appRouter) createChannel(w http.ResponseWriter, req *http.Request) {
+	body, err := r.readChannelBody(req)
+	if err == nil {
+		err = validateChannelName(body.Name)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := r.tenantOf(req).channels.add(Channel{Name: body.Name, CharLimit: body.CharLimit, Retention: body.Retention})
+	if err != nil {
+		logOf(req).Error("error while adding channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusCreated, req)
+	w.Header().Set("Location", "/v1/channels/"+resp.Name)
+	jsonResponse(w, resp, http.StatusCreated)
+	logOf(req).Infof("Added channel %s", resp.Name)
+}
+
+func (r *appRouter) listChannels(w http.ResponseWriter, req *http.Request) {
+	t := r.tenantOf(req)
+	resp := append([]Channel{r.defaultChannel(t).Channel}, t.channels.list()...)
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+}
+
+func (r *appRouter) getChannel(w http.ResponseWriter, req *http.Request) {
+	c, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, c.Channel, http.StatusOK)
+}
+
+// errDefaultChannel rejects changes to the default channel, whose settings
+// come from the server flags.
+var errDefaultChannel = message.Errorf(message.ErrConflict, "The default channel is configured by the server and can not be changed")
+
+func (r *appRouter) updateChannel(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["channel"]
+	err := validateChannelName(name)
+	if err == nil && name == defaultChannel {
+		err = errDefaultChannel
+	}
+	var body ChannelRequestBody
+	if err == nil {
+		body, err = r.readChannelBody(req)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := r.tenantOf(req).channels.update(name, body)
+	if err != nil {
+		logOf(req).Error("error while updating channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Updated channel %s", name)
+}
+
+func (r *appRouter) deleteChannel(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["channel"]
+	err := validateChannelName(name)
+	if err == nil && name == defaultChannel {
+		err = errDefaultChannel
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	if err := r.tenantOf(req).channels.remove(name); err != nil {
+		logOf(req).Error("error while deleting channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusNoContent, req)
+	w.WriteHeader(http.StatusNoContent)
+	logOf(req).Infof("Deleted channel %s", name)
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_appRouter_channels(t *testing.T) {
+	r := newTestRouter()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+
+	rec := serve("POST", "/v1/channels", `{"name":"ops","char_limit":10,"retention":60}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "/v1/channels/ops", rec.Header().Get("Location"))
+	rec = serve("POST", "/v1/channels", `{"name":"ops"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// every channel numbers its messages on its own
+	rec = serve("POST", "/v1/messages", `{"text":"hello world"}`)
+	assert.Equal(t, "/v1/messages/1", rec.Header().Get("Location"))
+	rec = serve("POST", "/v1/channels/ops/messages", `{"text":"deploy"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "/v1/channels/ops/messages/1", rec.Header().Get("Location"))
+	var msg message.MessageObj
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msg))
+	assert.NotNil(t, msg.ExpiresAt, "retention applies to new messages")
+
+	// the char limit is per channel
+	rec = serve("POST", "/v1/channels/ops/messages", `{"text":"hello world"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve("GET", "/v1/channels/ops/messages", "")
+	var list []message.MessageObj
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, "deploy", list[0].Text)
+	}
+	rec = serve("GET", "/v1/channels/default/messages/1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "hello world")
+
+	rec = serve("GET", "/v1/channels", "")
+	var channels []Channel
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &channels))
+	if assert.Len(t, channels, 2) {
+		assert.Equal(t, "default", channels[0].Name)
+		assert.Equal(t, 200, channels[0].CharLimit)
+	}
+
+	rec = serve("PUT", "/v1/channels/ops", `{"char_limit":20}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ops Channel
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ops))
+	assert.Equal(t, 20, ops.CharLimit)
+	rec = serve("PUT", "/v1/channels/ops", `{"char_limit":-1}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "must be in range 0-65536")
+	rec = serve("PUT", "/v1/channels/default", `{"char_limit":20}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = serve("DELETE", "/v1/channels/default", "")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serve("DELETE", "/v1/channels/ops", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve("GET", "/v1/channels/ops/messages/1", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func Test_appRouter_channelsPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newTestRouter()
+	assert.NoError(t, r.OpenChannels(dir))
+	rec := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("POST", "/v1/channels", bytes.NewBufferString(`{"name":"ops"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("POST", "/v1/channels/ops/messages", bytes.NewBufferString(`{"text":"kept"}`)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	r.channels.close()
+
+	r = newTestRouter()
+	assert.NoError(t, r.OpenChannels(dir))
+	defer r.channels.close()
+	rec = httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/channels/ops/messages/1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "kept")
+}
+
+func Test_appRouter_channelUpdatedWhileServed(t *testing.T) {
+	r := newTestRouter()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+	assert.Equal(t, http.StatusCreated, serve("POST", "/v1/channels", `{"name":"ops"}`).Code)
+
+	// run with -race: settings change under requests being served
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			serve("PUT", "/v1/channels/ops", `{"char_limit":100,"retention":60}`)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, http.StatusCreated, serve("POST", "/v1/channels/ops/messages", `{"text":"deploy"}`).Code)
+	}
+	<-done
+}
+
+func Test_channelStore_removeWhileServed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newChannelStore()
+	assert.NoError(t, s.open(dir))
+	defer s.close()
+	_, err = s.add(Channel{Name: "ops", CharLimit: 10})
+	assert.NoError(t, err)
+
+	// a request that resolved the channel before it was removed
+	c, err := s.get("ops")
+	assert.NoError(t, err)
+	assert.NoError(t, s.remove("ops"))
+	_, err = c.m.Add(message.MessageObj{Text: "lost"})
+	assert.True(t, errors.Is(err, message.ErrUnavailable), "%v", err)
+	// the change was not attempted, clients should retry elsewhere or later
+	assert.Equal(t, http.StatusServiceUnavailable, kindOf(err).status)
+	assert.Equal(t, codes.Unavailable, status.Code(grpcError(err)))
+}
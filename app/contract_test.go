@@ -0,0 +1,143 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_contract exercises every route through the real router, in order,
+// against a fresh store. Each step checks the status, the content type and
+// any headers that are part of the API contract. Requests and responses are
+// validated against the OpenAPI document too.
+func Test_contract(t *testing.T) {
+	r := newTestRouter(WithOpenAPIValidation())
+	defer r.tenants.close()
+	openAPIResponseViolations.Reset()
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		status      int
+		contentType string
+		headers     map[string]string
+		code        string // problem code for error responses
+		emptyBody   bool
+	}{
+		{name: "root", method: "GET", path: "/", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "root head", method: "HEAD", path: "/", status: http.StatusOK, emptyBody: true},
+		{name: "list empty", method: "GET", path: "/v1/messages", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "create", method: "POST", path: "/v1/messages", body: `{"text":"sample"}`, status: http.StatusCreated,
+			contentType: "application/json; charset=utf-8", headers: map[string]string{"Location": "/v1/messages/1"}},
+		{name: "create invalid", method: "POST", path: "/v1/messages", body: `{"text":""}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "create malformed", method: "POST", path: "/v1/messages", body: `{`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "list", method: "GET", path: "/v1/messages", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "list head", method: "HEAD", path: "/v1/messages", status: http.StatusOK, contentType: "application/json; charset=utf-8", emptyBody: true},
+		{name: "list options", method: "OPTIONS", path: "/v1/messages", status: http.StatusNoContent,
+			headers: map[string]string{"Allow": "GET, HEAD, POST, OPTIONS"}, emptyBody: true},
+		{name: "list not allowed", method: "PUT", path: "/v1/messages", status: http.StatusMethodNotAllowed, contentType: problemContentType,
+			headers: map[string]string{"Allow": "GET, HEAD, POST, OPTIONS"}, code: "method_not_allowed"},
+		{name: "get", method: "GET", path: "/v1/messages/1", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "get palindrome", method: "GET", path: "/v1/messages/1?is-palindrome", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "get bad palindrome param", method: "GET", path: "/v1/messages/1?is-palindrome=yes", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "get head", method: "HEAD", path: "/v1/messages/1", status: http.StatusOK, contentType: "application/json; charset=utf-8", emptyBody: true},
+		{name: "get invalid id", method: "GET", path: "/v1/messages/abc", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "get options", method: "OPTIONS", path: "/v1/messages/1", status: http.StatusNoContent,
+			headers: map[string]string{"Allow": "GET, HEAD, PUT, DELETE, OPTIONS"}, emptyBody: true},
+		{name: "get not allowed", method: "POST", path: "/v1/messages/1", status: http.StatusMethodNotAllowed, contentType: problemContentType,
+			headers: map[string]string{"Allow": "GET, HEAD, PUT, DELETE, OPTIONS"}, code: "method_not_allowed"},
+		{name: "update", method: "PUT", path: "/v1/messages/1", body: `{"text":"updated","tags":["a"]}`, status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "update unknown", method: "PUT", path: "/v1/messages/9", body: `{"text":"updated"}`, status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "update invalid tag", method: "PUT", path: "/v1/messages/1", body: `{"text":"updated","tags":[""]}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "create scheduled", method: "POST", path: "/v1/messages", body: `{"text":"later","delay_seconds":3600}`, status: http.StatusCreated,
+			contentType: "application/json; charset=utf-8", headers: map[string]string{"Location": "/v1/messages/2"}},
+		{name: "get scheduled", method: "GET", path: "/v1/messages/2", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "get scheduled included", method: "GET", path: "/v1/messages/2?include=scheduled", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "list bad include", method: "GET", path: "/v1/messages?include=everything", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "create with negative ttl", method: "POST", path: "/v1/messages", body: `{"text":"later","ttl":-1}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "create with delay and time", method: "POST", path: "/v1/messages", body: `{"text":"later","delay_seconds":1,"deliver_at":"2030-01-01T00:00:00Z"}`,
+			status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "create reply", method: "POST", path: "/v1/messages", body: `{"text":"reply","parent_id":1}`, status: http.StatusCreated,
+			contentType: "application/json; charset=utf-8", headers: map[string]string{"Location": "/v1/messages/3"}},
+		{name: "create reply unknown parent", method: "POST", path: "/v1/messages", body: `{"text":"reply","parent_id":99}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "list page", method: "GET", path: "/v1/messages?page_size=1", status: http.StatusOK, contentType: "application/json; charset=utf-8",
+			headers: map[string]string{"Link": `</v1/messages?page_size=1&page_token=1>; rel="next"`}},
+		{name: "list last page", method: "GET", path: "/v1/messages?page_size=1&page_token=1", status: http.StatusOK, contentType: "application/json; charset=utf-8",
+			headers: map[string]string{"Link": ""}},
+		{name: "list bad page size", method: "GET", path: "/v1/messages?page_size=0", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "replies", method: "GET", path: "/v1/messages/1/replies?depth=2", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "replies bad depth", method: "GET", path: "/v1/messages/1/replies?depth=0", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "replies unknown", method: "GET", path: "/v1/messages/99/replies", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "thread", method: "GET", path: "/v1/messages/3/thread", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "revisions", method: "GET", path: "/v1/messages/1/revisions", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "revision", method: "GET", path: "/v1/messages/1/revisions/2", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "revision unknown", method: "GET", path: "/v1/messages/1/revisions/9", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "revisions diff", method: "GET", path: "/v1/messages/1/revisions?diff=1..2", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "revisions bad diff", method: "GET", path: "/v1/messages/1/revisions?diff=2", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "trash", method: "GET", path: "/v1/trash", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "undelete not in trash", method: "POST", path: "/v1/messages/1:undelete", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "delete bad hard param", method: "DELETE", path: "/v1/messages/1?hard=maybe", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "create channel", method: "POST", path: "/v1/channels", body: `{"name":"ops"}`, status: http.StatusCreated,
+			contentType: "application/json; charset=utf-8", headers: map[string]string{"Location": "/v1/channels/ops"}},
+		{name: "create channel invalid name", method: "POST", path: "/v1/channels", body: `{"name":"a.b"}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "channel unknown", method: "GET", path: "/v1/channels/nope/messages", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "delete default channel", method: "DELETE", path: "/v1/channels/default", status: http.StatusConflict, contentType: problemContentType, code: "conflict"},
+		{name: "batch create", method: "POST", path: "/v1/messages:batch", body: `[{"text":"a"},{"text":"b"}]`, status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "batch delete", method: "POST", path: "/v1/messages:batchDelete", body: `{"ids":[2,3]}`, status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "events bad last event id", method: "GET", path: "/v1/messages/events?last_event_id=x", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "websocket without upgrade", method: "GET", path: "/v1/ws", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "get queue", method: "GET", path: "/v1/queues/jobs", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "put queue without dead-letter queue", method: "PUT", path: "/v1/queues/jobs", body: `{"max_receive_count":3}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "queue messages", method: "GET", path: "/v1/queues/jobs/messages", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "redrive unknown", method: "POST", path: "/v1/queues/jobs-dlq/redrive", body: `{"ids":[9]}`, status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "purge", method: "POST", path: "/v1/queues/jobs-dlq/purge", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "receive", method: "POST", path: "/v1/queues/jobs/receive", body: `{"max_messages":2}`, status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "receive invalid queue", method: "POST", path: "/v1/queues/a.b/receive", status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "receive too many", method: "POST", path: "/v1/queues/jobs/receive", body: `{"max_messages":1000}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "ack without receipts", method: "POST", path: "/v1/queues/jobs/ack", body: `{"receipts":[]}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "nack", method: "POST", path: "/v1/queues/jobs/nack", body: `{"receipts":["1-x"]}`, status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "list webhooks", method: "GET", path: "/v1/webhooks", status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "create webhook invalid url", method: "POST", path: "/v1/webhooks", body: `{"url":"ftp://example.com"}`, status: http.StatusBadRequest, contentType: problemContentType, code: "invalid_argument"},
+		{name: "webhook unknown", method: "GET", path: "/v1/webhooks/9", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "webhook deliveries unknown", method: "GET", path: "/v1/webhooks/9/deliveries", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "export", method: "GET", path: "/v1/messages:export", status: http.StatusOK, contentType: "application/x-ndjson"},
+		{name: "import", method: "POST", path: "/v1/messages:import", body: `{"text":"imported"}`, status: http.StatusOK, contentType: "application/json; charset=utf-8"},
+		{name: "delete", method: "DELETE", path: "/v1/messages/1", status: http.StatusNoContent, emptyBody: true},
+		{name: "delete again", method: "DELETE", path: "/v1/messages/1", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "get deleted", method: "GET", path: "/v1/messages/1", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "unknown route", method: "GET", path: "/v2/messages", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+		{name: "unknown route options", method: "OPTIONS", path: "/v2/messages", status: http.StatusNotFound, contentType: problemContentType, code: "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.GetRouter().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			}
+			for k, v := range tt.headers {
+				assert.Equal(t, v, w.Header().Get(k), k)
+			}
+			if tt.emptyBody {
+				assert.Empty(t, w.Body.String())
+			}
+			if tt.code != "" {
+				var p Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+				assert.Equal(t, tt.code, p.Code)
+				assert.Equal(t, tt.status, p.Status)
+			}
+		})
+	}
+	assert.Empty(t, openAPIViolations(t), "responses not matching the OpenAPI document")
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	csvContentType  = "text/csv"
+	exportFlushSize = 100
+	// maxImportBytes limits the body of an import; larger dumps can be
+	// imported offline with the server import command.
+	maxImportBytes = 64 << 20
+)
+
+var dumpContentTypes = map[string]string{
+	message.FormatNDJSON: ndjsonContentType,
+	message.FormatCSV:    csvContentType,
+}
+
+// ImportResponse summarizes the outcome of POST /v1/messages:import. Errors
+// lists at most the first 100 failed records.
+type ImportResponse struct {
+	Imported int               `json:"imported"`
+	Failed   int               `json:"failed"`
+	Errors   []ImportItemError `json:"errors,omitempty"`
+}
+
+// ImportItemError is a record of the dump that was not imported. Record is
+// the 1-based position of the record in the dump.
+type ImportItemError struct {
+	Record int     `json:"record"`
+	Error  Problem `json:"error"`
+}
+
+// dumpFormat returns the dump format of a request, from the "format" query
+// param if present or else from the given media type.
+func dumpFormat(req *http.Request, mediaType string) string {
+	if f := req.URL.Query().Get("format"); f != "" {
+		return f
+	}
+	if mediaType == csvContentType {
+		return message.FormatCSV
+	}
+	return message.FormatNDJSON
+}
+
+func (r *appRouter) exportMessages(w http.ResponseWriter, req *http.Request) {
+	accept, _, _ := mime.ParseMediaType(req.Header.Get("Accept"))
+	format := dumpFormat(req, accept)
+	dw, err := message.NewDumpWriter(w, format)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", dumpContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="messages-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), format))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	r.addSpan(req.Context(), http.StatusOK, req)
+
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	extendWriteDeadline(req)
+	err = r.tenantOf(req).m.Export(func(msg message.MessageObj) error {
+		if err := dw.Write(msg); err != nil {
+			return err
+		}
+		n++
+		if n%exportFlushSize == 0 && flusher != nil {
+			extendWriteDeadline(req)
+			if err := dw.Flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = dw.Flush()
+	}
+	if err != nil {
+		// The status is already sent, all that is left is to cut the stream short.
+		logOf(req).Error("error while exporting messages: ", err)
+		return
+	}
+	logOf(req).Infof("Exported %d messages as %s", n, format)
+}
+
+func (r *appRouter) importMessages(w http.ResponseWriter, req *http.Request) {
+	preserveIDs, err := boolParam(req, "preserve_ids")
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	format := dumpFormat(req, ct)
+	body := newLimitedBody(w, req, maxImportBytes)
+	dr, err := message.NewDumpReader(body, format)
+	if err != nil {
+		r.respondWithError(w, req, body.check(err))
+		return
+	}
+
+	// Imported messages are attributed to the authenticated user like
+	// created ones.
+	user := principalFromContext(req.Context())
+	res, err := message.ImportDump(r.tenantOf(req).m, dr, preserveIDs, func(msg *message.MessageObj) error {
+		if user != "" {
+			msg.Author = user
+		}
+		return r.validateMsg(*msg)
+	})
+	if err = body.check(err); err != nil {
+		logOf(req).Errorf("import aborted after %d messages: %v", res.Imported, err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp := ImportResponse{Imported: res.Imported, Failed: res.Failed}
+	for _, e := range res.Errors {
+		resp.Errors = append(resp.Errors, ImportItemError{Record: e.Record, Error: newProblem(req, e.Err)})
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Imported %d messages, %d failed", res.Imported, res.Failed)
+}
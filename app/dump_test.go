@@ -0,0 +1,86 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_appRouter_exportMessages_importMessages(t *testing.T) {
+	src := newTestRouter()
+	_, _ = src.m.AddBatch([]message.MessageObj{{Text: "one"}, {Text: "two", Author: "ann", Tags: []string{"a", "b"}}})
+
+	req := httptest.NewRequest("GET", "/v1/messages:export?format=csv", nil)
+	w := httptest.NewRecorder()
+	src.GetRouter().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "id,text,author,tags\n1,one,,\n2,two,ann,a;b\n", w.Body.String())
+
+	req = httptest.NewRequest("GET", "/v1/messages:export", nil)
+	w = httptest.NewRecorder()
+	src.GetRouter().ServeHTTP(w, req)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"id\":1,\"text\":\"one\"}\n{\"id\":2,\"text\":\"two\",\"author\":\"ann\",\"tags\":[\"a\",\"b\"]}\n", w.Body.String())
+	dump := w.Body.String()
+
+	dst := newTestRouter()
+	req = httptest.NewRequest("POST", "/v1/messages:import?preserve_ids=true", strings.NewReader(dump+"{\"id\":7,\"text\":\"\"}\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	dst.GetRouter().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp ImportResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Imported)
+	assert.Equal(t, 1, resp.Failed)
+	assert.Equal(t, 3, resp.Errors[0].Record)
+	assert.Equal(t, "invalid_argument", resp.Errors[0].Error.Code)
+
+	msgs, _ := dst.m.GetAll()
+	srcMsgs, _ := src.m.GetAll()
+	assert.Equal(t, srcMsgs, msgs)
+
+	req = httptest.NewRequest("GET", "/v1/messages:export?format=xml", nil)
+	w = httptest.NewRecorder()
+	src.GetRouter().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_appRouter_importMessages_checks(t *testing.T) {
+	r := newTestRouter(WithBasicAuth(map[string]string{"ann": "secret"}))
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/messages:import", strings.NewReader(body))
+		req.SetBasicAuth("ann", "secret")
+		w := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	// imported messages belong to the user, replies need a parent in the dump
+	w := post("{\"id\":1,\"text\":\"one\",\"author\":\"bob\"}\n{\"id\":2,\"text\":\"reply\",\"parent_id\":1}\n{\"id\":3,\"text\":\"dangling\",\"parent_id\":9}\n")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp ImportResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Imported)
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, 3, resp.Errors[0].Record)
+		assert.Equal(t, "invalid_argument", resp.Errors[0].Error.Code)
+	}
+	msgs, _ := r.m.GetAll()
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "ann", msgs[0].Author)
+		assert.Equal(t, msgs[0].Id, msgs[1].ParentId)
+	}
+
+	line := strings.Repeat("x", 4095) + "\n"
+	w = post(strings.Repeat(line, maxImportBytes/4096+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "payload_too_large")
+}
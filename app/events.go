@@ -0,0 +1,116 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	eventRetryMillis       = 3000
+	eventKeepAlive         = 15 * time.Second
+)
+
+// lastEventID returns the ID of the last event a reconnecting client saw,
+// from the Last-Event-ID header or the last_event_id query param.
+func lastEventID(req *http.Request) (int64, error) {
+	val := req.Header.Get("Last-Event-ID")
+	if val == "" {
+		val = req.URL.Query().Get("last_event_id")
+	}
+	if val == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(val, 10, defaultBitsize)
+	if err != nil || id < 0 {
+		return 0, message.Errorf(message.ErrInvalid, "Invalid last event id %q, should be a non-negative integer", val)
+	}
+	return id, nil
+}
+
+// streamEvents streams the message lifecycle events of the channel of the
+// request as Server-Sent Events. The stream can be narrowed with the "tag"
+// and "author" query params and is resumed from the event backlog when
+// Last-Event-ID is sent. A client that can not keep up is disconnected and
+// expected to reconnect.
+func (r *appRouter) streamEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		r.respondWithError(w, req, fmt.Errorf("streaming is not supported by the connection"))
+		return
+	}
+	after, err := lastEventID(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	filter := message.Filter{Tag: req.URL.Query().Get("tag"), Author: req.URL.Query().Get("author")}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+
+	events := ch.m.Events()
+	sub, replay, missed := events.Subscribe(after, filter)
+	defer events.Unsubscribe(sub)
+	if missed {
+		logOf(req).Warnf("Event stream resumed after %d, older events are no longer in the backlog", after)
+	}
+
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	r.addSpan(req.Context(), http.StatusOK, req)
+	logOf(req).Infof("Event stream opened, tag=%q author=%q", filter.Tag, filter.Author)
+
+	extendWriteDeadline(req)
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetryMillis)
+	for _, e := range replay {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				logOf(req).Warn("Event stream closed, subscriber fell behind")
+				return
+			}
+			extendWriteDeadline(req)
+			if err := writeEvent(w, e); err != nil {
+				logOf(req).Error("Error while writing event: ", err)
+				return
+			}
+		case <-keepAlive.C:
+			extendWriteDeadline(req)
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-req.Context().Done():
+			logOf(req).Info("Event stream closed by client")
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e message.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
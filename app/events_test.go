@@ -0,0 +1,114 @@
+package app
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+// readEvent reads the next event of an SSE stream and returns its id, type
+// and data lines, skipping comments and retry hints.
+func readEvent(t *testing.T, r *bufio.Reader) (id, typ, data string) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal("error while reading event stream: ", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			typ = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && id != "":
+			return id, typ, data
+		}
+	}
+}
+
+func Test_appRouter_streamEvents(t *testing.T) {
+	r := newTestRouter()
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+
+	_, _ = r.m.Add(message.MessageObj{Text: "before", Author: "ann"})
+
+	resp, err := http.Get(srv.URL + "/v1/messages/events?author=bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	stream := bufio.NewReader(resp.Body)
+
+	_, _ = r.m.Add(message.MessageObj{Text: "skipped", Author: "ann"})
+	_, _ = r.m.Add(message.MessageObj{Text: "hello", Author: "bob"})
+	id, typ, data := readEvent(t, stream)
+	assert.Equal(t, "3", id)
+	assert.Equal(t, message.EventCreated, typ)
+	assert.Contains(t, data, `"text":"hello"`)
+
+	_ = r.m.Delete(3)
+	id, typ, _ = readEvent(t, stream)
+	assert.Equal(t, "4", id)
+	assert.Equal(t, message.EventDeleted, typ)
+
+	// resume after the first event from the backlog
+	req, _ := http.NewRequest("GET", srv.URL+"/v1/messages/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resumed, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Body.Close()
+	stream = bufio.NewReader(resumed.Body)
+	for _, want := range []string{"2", "3", "4"} {
+		id, _, _ = readEvent(t, stream)
+		assert.Equal(t, want, id)
+	}
+}
+
+func Test_appRouter_streamEvents_channel(t *testing.T) {
+	r := newTestRouter()
+	defer r.tenants.close()
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+	_, err := r.channels.add(Channel{Name: "news", CharLimit: 100})
+	assert.NoError(t, err)
+
+	resp, err := http.Get(srv.URL + "/v1/channels/news/messages/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	stream := bufio.NewReader(resp.Body)
+
+	// events of the default channel are not part of the stream
+	_, _ = r.m.Add(message.MessageObj{Text: "elsewhere"})
+	post, err := http.Post(srv.URL+"/v1/channels/news/messages", "application/json", strings.NewReader(`{"text":"hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+	assert.Equal(t, http.StatusCreated, post.StatusCode)
+	id, typ, data := readEvent(t, stream)
+	assert.Equal(t, "1", id)
+	assert.Equal(t, message.EventCreated, typ)
+	assert.Contains(t, data, `"text":"hello"`)
+
+	missing, err := http.Get(srv.URL + "/v1/channels/gone/messages/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missing.StatusCode)
+}
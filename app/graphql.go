@@ -0,0 +1,311 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	graphQLPath = "/graphql"
+
+	defaultGraphQLPageSize      = 20
+	defaultGraphQLMaxDepth      = 10
+	defaultGraphQLMaxComplexity = 1000
+)
+
+// WithGraphQLLimits rejects GraphQL operations nesting fields deeper than
+// depth or with a complexity above complexity. Every field costs 1, the
+// fields below a paginated field cost as much times as it returns messages
+// at most. The defaults are 10 and 1000.
+func WithGraphQLLimits(depth, complexity int) Option {
+	return func(r *appRouter) {
+		r.gqlMaxDepth = depth
+		r.gqlMaxComplexity = complexity
+	}
+}
+
+// graphQLRequest is a GraphQL operation as sent in the body of a POST, the
+// query params of a GET or the payload of a WebSocket subscribe message.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// graphQLOperation is a parsed and validated GraphQL request.
+type graphQLOperation struct {
+	graphQLRequest
+	doc *ast.Document
+	op  *ast.OperationDefinition
+}
+
+// gqlError is an error of a resolver, reported with the problem code of its
+// kind in the extensions of the GraphQL error.
+type gqlError struct {
+	msg  string
+	code string
+}
+
+func (e gqlError) Error() string {
+	return e.msg
+}
+
+func (e gqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// graphQLError converts err to the error of a resolver. Like problems,
+// internal errors carry no details.
+func graphQLError(err error) error {
+	k := kindOf(err)
+	if k.status == http.StatusInternalServerError {
+		return gqlError{msg: "internal error", code: k.code}
+	}
+	return gqlError{msg: err.Error(), code: k.code}
+}
+
+// serveGraphQL executes GraphQL queries and mutations sent with POST, or
+// queries sent with GET. Subscriptions are served on WebSocket connections
+// upgraded from a GET.
+func (r *appRouter) serveGraphQL(w http.ResponseWriter, req *http.Request) {
+	if websocket.IsWebSocketUpgrade(req) {
+		r.serveGraphQLWebSocket(w, req)
+		return
+	}
+	var body graphQLRequest
+	if req.Method == http.MethodGet {
+		q := req.URL.Query()
+		body.Query = q.Get("query")
+		body.OperationName = q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &body.Variables); err != nil {
+				logOf(req).Error("error while unmarshalling variables: ", err)
+				r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Invalid value for query param variables, should be a JSON object"))
+				return
+			}
+		}
+	} else if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Invalid request body"))
+		return
+	}
+
+	op, errs := r.parseGraphQL(body)
+	if errs == nil && op.op.Operation == ast.OperationTypeSubscription {
+		errs = gqlerrors.FormatErrors(errors.New("Subscriptions are served over WebSocket only"))
+	}
+	if errs == nil && op.op.Operation == ast.OperationTypeMutation && req.Method == http.MethodGet {
+		errs = gqlerrors.FormatErrors(errors.New("Mutations must be sent with POST"))
+	}
+	var result *graphql.Result
+	if errs != nil {
+		logOf(req).Error("error validating request: ", errs[0].Message)
+		result = &graphql.Result{Errors: errs}
+	} else {
+		result = r.executeGraphQL(req.Context(), op, nil)
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, result, http.StatusOK)
+	logOf(req).Infof("Executed GraphQL %s %q with %d errors", operationType(op), body.OperationName, len(result.Errors))
+}
+
+// parseGraphQL parses and validates req and checks it against the limits.
+func (r *appRouter) parseGraphQL(req graphQLRequest) (*graphQLOperation, []gqlerrors.FormattedError) {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})})
+	if err != nil {
+		return nil, gqlerrors.FormatErrors(err)
+	}
+	if res := graphql.ValidateDocument(&r.gqlSchema, doc, nil); !res.IsValid {
+		return nil, res.Errors
+	}
+	op := &graphQLOperation{graphQLRequest: req, doc: doc}
+	for _, def := range doc.Definitions {
+		if d, ok := def.(*ast.OperationDefinition); ok && (req.OperationName == "" || d.Name != nil && d.Name.Value == req.OperationName) {
+			if op.op != nil {
+				return nil, gqlerrors.FormatErrors(errors.New("Must provide operation name if query contains multiple operations"))
+			}
+			op.op = d
+		}
+	}
+	if op.op == nil {
+		return nil, gqlerrors.FormatErrors(errors.New("Unknown operation named \"" + req.OperationName + "\""))
+	}
+	if err := r.checkGraphQLLimits(op); err != nil {
+		return nil, gqlerrors.FormatErrors(err)
+	}
+	return op, nil
+}
+
+// executeGraphQL executes op with root as the root value.
+func (r *appRouter) executeGraphQL(ctx context.Context, op *graphQLOperation, root interface{}) *graphql.Result {
+	name := op.OperationName
+	if name == "" && op.op.Name != nil {
+		name = op.op.Name.Value
+	}
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        r.gqlSchema,
+		Root:          root,
+		AST:           op.doc,
+		OperationName: name,
+		Args:          op.Variables,
+		Context:       ctx,
+	})
+}
+
+func operationType(op *graphQLOperation) string {
+	if op == nil {
+		return "request"
+	}
+	return op.op.Operation
+}
+
+// checkGraphQLLimits rejects op if it is too deep or too complex.
+func (r *appRouter) checkGraphQLLimits(op *graphQLOperation) error {
+	root := r.gqlSchema.QueryType()
+	switch op.op.Operation {
+	case ast.OperationTypeMutation:
+		root = r.gqlSchema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = r.gqlSchema.SubscriptionType()
+	}
+	c := costCounter{vars: op.Variables, fragments: make(map[string]*ast.FragmentDefinition)}
+	for _, def := range op.doc.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok {
+			c.fragments[f.Name.Value] = f
+		}
+	}
+	depth, cost := c.selections(root, op.op.SelectionSet, 1)
+	if depth > r.gqlMaxDepth {
+		return message.Errorf(message.ErrInvalid, "Query depth %d exceeds the limit of %d", depth, r.gqlMaxDepth)
+	}
+	if cost > r.gqlMaxComplexity {
+		return message.Errorf(message.ErrInvalid, "Query complexity %d exceeds the limit of %d", cost, r.gqlMaxComplexity)
+	}
+	return nil
+}
+
+// costCounter computes the depth and complexity of an operation.
+// Introspection fields are free, so that tools can always load the schema.
+type costCounter struct {
+	vars      map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+}
+
+// selections returns the depth and complexity of set, a selection on t at
+// depth.
+func (c costCounter) selections(t *graphql.Object, set *ast.SelectionSet, depth int) (int, int) {
+	maxDepth, cost := 0, 0
+	if set == nil {
+		return maxDepth, cost
+	}
+	for _, sel := range set.Selections {
+		d, n := 0, 0
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name.Value, "__") {
+				continue
+			}
+			def, ok := t.Fields()[sel.Name.Value]
+			if !ok {
+				continue
+			}
+			d, n = depth, 1
+			if obj, ok := namedObject(def.Type); ok && sel.SelectionSet != nil {
+				cd, cn := c.selections(obj, sel.SelectionSet, depth+1)
+				d = cd
+				n += c.multiplier(def, sel) * cn
+			}
+		case *ast.FragmentSpread:
+			if f, ok := c.fragments[sel.Name.Value]; ok {
+				d, n = c.selections(t, f.SelectionSet, depth)
+			}
+		case *ast.InlineFragment:
+			d, n = c.selections(t, sel.SelectionSet, depth)
+		}
+		if d > maxDepth {
+			maxDepth = d
+		}
+		cost += n
+	}
+	return maxDepth, cost
+}
+
+// multiplier returns how many times the selection of field counts, the
+// page size of paginated fields and 1 for the others.
+func (c costCounter) multiplier(def *graphql.FieldDefinition, field *ast.Field) int {
+	for _, arg := range def.Args {
+		if arg.Name() != "first" {
+			continue
+		}
+		n := defaultGraphQLPageSize
+		if v, ok := arg.DefaultValue.(int); ok {
+			n = v
+		}
+		for _, a := range field.Arguments {
+			if a.Name.Value == "first" {
+				if v, ok := intValue(astValue(a.Value, c.vars)); ok {
+					n = v
+				}
+			}
+		}
+		if n < 1 {
+			n = 1
+		}
+		return n
+	}
+	return 1
+}
+
+// namedObject returns the object type of t, unwrapping lists and non-null
+// types.
+func namedObject(t graphql.Type) (*graphql.Object, bool) {
+	for {
+		switch tt := t.(type) {
+		case *graphql.NonNull:
+			t = tt.OfType
+		case *graphql.List:
+			t = tt.OfType
+		case *graphql.Object:
+			return tt, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// astValue returns the value of v, looking variables up in vars.
+func astValue(v ast.Value, vars map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case *ast.Variable:
+		return vars[v.Name.Value]
+	case *ast.IntValue:
+		n, _ := strconv.Atoi(v.Value)
+		return n
+	case *ast.StringValue:
+		return v.Value
+	}
+	return nil
+}
+
+// intValue returns v as int, v being an int literal or a JSON number.
+func intValue(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,446 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+// messageConnection is a page of messages.
+type messageConnection struct {
+	nodes []message.MessageObj
+	next  string
+	total int
+}
+
+// authorRef is the author of messages.
+type authorRef struct {
+	name string
+}
+
+// analysis describes the text of a message.
+type analysis struct {
+	IsPalindrome bool `json:"isPalindrome"`
+	Length       int  `json:"length"`
+	Words        int  `json:"words"`
+}
+
+func analyze(text string) analysis {
+	return analysis{
+		IsPalindrome: *checkIfPalindrome(text),
+		Length:       len([]rune(text)),
+		Words:        len(strings.Fields(text)),
+	}
+}
+
+// newGraphQLSchema returns the GraphQL schema over the messages of the
+// default channel of every tenant. Resolvers read the stores of the tenant
+// of the request from the context, like the handlers of the REST API.
+func (r *appRouter) newGraphQLSchema() (graphql.Schema, error) {
+	pageArgs := graphql.FieldConfigArgument{
+		"first": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultGraphQLPageSize, Description: "Number of messages to return, at most 1000."},
+		"after": &graphql.ArgumentConfig{Type: graphql.String, Description: "endCursor of the previous page."},
+	}
+	withArgs := func(args graphql.FieldConfigArgument, more graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		all := graphql.FieldConfigArgument{}
+		for k, v := range args {
+			all[k] = v
+		}
+		for k, v := range more {
+			all[k] = v
+		}
+		return all
+	}
+
+	analysisType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Analysis",
+		Description: "Analysis of the text of a message.",
+		Fields: graphql.Fields{
+			"isPalindrome": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"length":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "Number of characters."},
+			"words":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(messageConnection).next != "", nil
+				},
+			},
+			"endCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Cursor to pass as after to get the next page, null on the last page.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if next := p.Source.(messageConnection).next; next != "" {
+						return next, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+
+	var messageType, authorType, connectionType *graphql.Object
+	messageType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Message",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+				"text":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"tags":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+				"queue":     &graphql.Field{Type: graphql.String},
+				"deliverAt": &graphql.Field{Type: graphql.DateTime},
+				"expiresAt": &graphql.Field{Type: graphql.DateTime},
+				"tombstone": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+				"author": &graphql.Field{
+					Type: authorType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if name := p.Source.(message.MessageObj).Author; name != "" {
+							return authorRef{name: name}, nil
+						}
+						return nil, nil
+					},
+				},
+				"parent": &graphql.Field{
+					Type:        messageType,
+					Description: "The message replied to, null if it no longer exists.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						id := p.Source.(message.MessageObj).ParentId
+						if id == 0 {
+							return nil, nil
+						}
+						return r.resolveMessage(p, id)
+					},
+				},
+				"replies": &graphql.Field{
+					Type:        graphql.NewNonNull(connectionType),
+					Description: "The direct replies, oldest first.",
+					Args:        pageArgs,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						threads, err := tenantFromContext(p.Context, r).m.Replies(p.Source.(message.MessageObj).Id, 1)
+						if err != nil {
+							return nil, graphQLError(err)
+						}
+						msgs := make([]message.MessageObj, len(threads))
+						for i, t := range threads {
+							msgs[i] = t.MessageObj
+						}
+						return paginate(p, msgs)
+					},
+				},
+				"analysis": &graphql.Field{
+					Type: graphql.NewNonNull(analysisType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return analyze(p.Source.(message.MessageObj).Text), nil
+					},
+				},
+			}
+		}),
+	})
+	connectionType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "MessageConnection",
+		Fields: graphql.Fields{
+			"nodes": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(messageType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(messageConnection).nodes, nil
+				},
+			},
+			"pageInfo": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfoType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+			"totalCount": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Number of messages on all pages.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(messageConnection).total, nil
+				},
+			},
+		},
+	})
+	authorType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Author",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(authorRef).name, nil
+				},
+			},
+			"messages": &graphql.Field{
+				Type:        graphql.NewNonNull(connectionType),
+				Description: "The delivered messages of the author.",
+				Args:        pageArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					msgs, err := r.findMessages(p, messageFilter{author: p.Source.(authorRef).name})
+					if err != nil {
+						return nil, err
+					}
+					return paginate(p, msgs)
+				},
+			},
+		},
+	})
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "MessageEvent",
+		Fields: graphql.Fields{
+			"id":      &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Description: "Resume a subscription after this event with after."},
+			"type":    &graphql.Field{Type: graphql.NewNonNull(graphql.String), Description: "created, updated, deleted, expired or undeleted."},
+			"time":    &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime)},
+			"message": &graphql.Field{Type: graphql.NewNonNull(messageType)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"message": &graphql.Field{
+				Type:        messageType,
+				Description: "A delivered message, null if there is none with the id.",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p)
+					if err != nil {
+						return nil, err
+					}
+					return r.resolveMessage(p, id)
+				},
+			},
+			"messages": &graphql.Field{
+				Type:        graphql.NewNonNull(connectionType),
+				Description: "The messages matching every filter given, in the order of their ids.",
+				Args: withArgs(pageArgs, graphql.FieldConfigArgument{
+					"tag":              &graphql.ArgumentConfig{Type: graphql.String},
+					"author":           &graphql.ArgumentConfig{Type: graphql.String},
+					"text":             &graphql.ArgumentConfig{Type: graphql.String, Description: "Case-insensitive substring of the text."},
+					"includeScheduled": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false, Description: "Also list messages not delivered yet."},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					f := messageFilter{scheduled: p.Args["includeScheduled"] == true}
+					f.tag, _ = p.Args["tag"].(string)
+					f.author, _ = p.Args["author"].(string)
+					f.text, _ = p.Args["text"].(string)
+					msgs, err := r.findMessages(p, f)
+					if err != nil {
+						return nil, err
+					}
+					return paginate(p, msgs)
+				},
+			},
+		},
+	})
+
+	createInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "CreateMessageInput",
+		Description: "A new message, see POST /v1/messages.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"text":         &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"author":       &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"tags":         &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"queue":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"deliverAt":    &graphql.InputObjectFieldConfig{Type: graphql.DateTime},
+			"delaySeconds": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"ttl":          &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"parentId":     &graphql.InputObjectFieldConfig{Type: graphql.ID},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createMessage": &graphql.Field{
+				Type: graphql.NewNonNull(messageType),
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(createInput)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					body, err := createMessageInput(p.Args["input"].(map[string]interface{}))
+					if err != nil {
+						return nil, graphQLError(err)
+					}
+					msg, err := r.defaultChannel(tenantFromContext(p.Context, r)).addMessage(p.Context, body)
+					if err != nil {
+						logFromContext(p.Context).Error("error while adding message: ", err)
+						return nil, graphQLError(err)
+					}
+					logFromContext(p.Context).Infof("Added message with id %v successfully", msg.Id)
+					return msg, nil
+				},
+			},
+			"deleteMessage": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Deletes a message, moving it to the trash unless an admin sets hard.",
+				Args: graphql.FieldConfigArgument{
+					"id":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"hard": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := idArg(p)
+					if err != nil {
+						return nil, err
+					}
+					opts, err := r.deleteOptionsOf(p.Context, p.Args["hard"] == true)
+					if err == nil {
+						err = tenantFromContext(p.Context, r).m.Delete(id, opts...)
+					}
+					if err != nil {
+						logFromContext(p.Context).Error("error while deleting message: ", err)
+						return nil, graphQLError(err)
+					}
+					logFromContext(p.Context).Infof("Deleted message %d successfully", id)
+					return true, nil
+				},
+			},
+		},
+	})
+
+	subscription := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"messageEvents": &graphql.Field{
+				Type:        graphql.NewNonNull(eventType),
+				Description: "Events of the messages matching tag and author, after the event after if given.",
+				Args: graphql.FieldConfigArgument{
+					"tag":    &graphql.ArgumentConfig{Type: graphql.String},
+					"author": &graphql.ArgumentConfig{Type: graphql.String},
+					"after":  &graphql.ArgumentConfig{Type: graphql.ID},
+				},
+				// the WebSocket connection executes the subscription once
+				// per event with the event as the root value
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Info.RootValue, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation, Subscription: subscription})
+}
+
+// resolveMessage returns message id, nil if there is none.
+func (r *appRouter) resolveMessage(p graphql.ResolveParams, id int64) (interface{}, error) {
+	msg, err := tenantFromContext(p.Context, r).m.Get(id)
+	if errors.Is(err, message.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, graphQLError(err)
+	}
+	return msg, nil
+}
+
+// messageFilter selects messages of the messages query.
+type messageFilter struct {
+	tag, author, text string
+	scheduled         bool
+}
+
+func (f messageFilter) match(msg message.MessageObj) bool {
+	if f.author != "" && msg.Author != f.author {
+		return false
+	}
+	if f.text != "" && !strings.Contains(strings.ToLower(msg.Text), strings.ToLower(f.text)) {
+		return false
+	}
+	if f.tag == "" {
+		return true
+	}
+	for _, tag := range msg.Tags {
+		if tag == f.tag {
+			return true
+		}
+	}
+	return false
+}
+
+// findMessages returns the messages of the tenant matching f.
+func (r *appRouter) findMessages(p graphql.ResolveParams, f messageFilter) ([]message.MessageObj, error) {
+	var opts []message.ReadOption
+	if f.scheduled {
+		opts = append(opts, message.IncludeScheduled())
+	}
+	all, err := tenantFromContext(p.Context, r).m.GetAll(opts...)
+	if err != nil && !errors.Is(err, message.ErrNotFound) {
+		return nil, graphQLError(err)
+	}
+	msgs := []message.MessageObj{}
+	for _, msg := range all {
+		if f.match(msg) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// paginate returns the page of msgs selected by the first and after args.
+func paginate(p graphql.ResolveParams, msgs []message.MessageObj) (interface{}, error) {
+	pg := page{size: defaultGraphQLPageSize}
+	if n, ok := p.Args["first"].(int); ok {
+		if n < 1 || n > maxPageSize {
+			return nil, graphQLError(message.Errorf(message.ErrInvalid, "Invalid first %d, should be in range 1-%d", n, maxPageSize))
+		}
+		pg.size = n
+	}
+	if after, ok := p.Args["after"].(string); ok {
+		id, err := strconv.ParseInt(after, 10, defaultBitsize)
+		if err != nil || id < 1 {
+			return nil, graphQLError(message.Errorf(message.ErrInvalid, "Invalid cursor %q", after))
+		}
+		pg.after = id
+	}
+	nodes, next := pg.apply(msgs)
+	return messageConnection{nodes: nodes, next: next, total: len(msgs)}, nil
+}
+
+// idArg returns the id argument of p as a message id.
+func idArg(p graphql.ResolveParams) (int64, error) {
+	val, _ := p.Args["id"].(string)
+	id, err := strconv.ParseInt(val, 10, defaultBitsize)
+	if err != nil || id < 1 {
+		return 0, graphQLError(message.Errorf(message.ErrInvalid, "Invalid message id value %q, should be a valid positive integer", val))
+	}
+	return id, nil
+}
+
+// createMessageInput converts a CreateMessageInput to the request body of
+// a new message.
+func createMessageInput(in map[string]interface{}) (MsgRequestBody, error) {
+	var body MsgRequestBody
+	body.Text, _ = in["text"].(string)
+	body.Author, _ = in["author"].(string)
+	body.Queue, _ = in["queue"].(string)
+	if tags, ok := in["tags"].([]interface{}); ok {
+		for _, tag := range tags {
+			s, _ := tag.(string)
+			body.Tags = append(body.Tags, s)
+		}
+	}
+	if at, ok := in["deliverAt"].(time.Time); ok {
+		body.DeliverAt = &at
+	}
+	if n, ok := in["delaySeconds"].(int); ok {
+		body.DelaySeconds = int64(n)
+	}
+	if n, ok := in["ttl"].(int); ok {
+		body.TTL = int64(n)
+	}
+	if val, ok := in["parentId"].(string); ok {
+		id, err := strconv.ParseInt(val, 10, defaultBitsize)
+		if err != nil || id < 1 {
+			return body, message.Errorf(message.ErrInvalid, "Invalid parent id %q, should be a valid positive integer", val)
+		}
+		body.ParentId = id
+	}
+	return body, nil
+}
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type graphQLResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// graphQL posts query with vars to r and returns the decoded response.
+func graphQL(t *testing.T, r *appRouter, query string, vars map[string]interface{}) graphQLResponse {
+	body, _ := json.Marshal(graphQLRequest{Query: query, Variables: vars})
+	rec := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("POST", graphQLPath, bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp graphQLResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// field returns the value at the dot separated path of data.
+func field(data interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		data = m[key]
+	}
+	return data
+}
+
+func Test_appRouter_graphQL(t *testing.T) {
+	r := newTestRouter()
+	create := `mutation($text: String!, $author: String, $parent: ID) {
+		createMessage(input: {text: $text, author: $author, tags: ["news"], parentId: $parent}) { id text }
+	}`
+	resp := graphQL(t, r, create, map[string]interface{}{"text": "abba", "author": "ann"})
+	assert.Empty(t, resp.Errors)
+	assert.Equal(t, "1", field(resp.Data, "createMessage.id"))
+	graphQL(t, r, create, map[string]interface{}{"text": "re: abba", "author": "bob", "parent": "1"})
+	graphQL(t, r, create, map[string]interface{}{"text": "hello world", "author": "ann"})
+
+	resp = graphQL(t, r, `{
+		message(id: 2) {
+			text
+			analysis { isPalindrome words }
+			author { name }
+			parent {
+				text
+				analysis { isPalindrome length }
+				author { messages { totalCount nodes { text } } }
+				replies { nodes { id } }
+			}
+		}
+	}`, nil)
+	assert.Empty(t, resp.Errors)
+	assert.Equal(t, "bob", field(resp.Data, "message.author.name"))
+	assert.Equal(t, false, field(resp.Data, "message.analysis.isPalindrome"))
+	assert.Equal(t, 2.0, field(resp.Data, "message.analysis.words"))
+	assert.Equal(t, true, field(resp.Data, "message.parent.analysis.isPalindrome"))
+	assert.Equal(t, 2.0, field(resp.Data, "message.parent.author.messages.totalCount"))
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": "2"}}, field(resp.Data, "message.parent.replies.nodes"))
+
+	resp = graphQL(t, r, `{ message(id: 42) { text } }`, nil)
+	assert.Empty(t, resp.Errors)
+	assert.Nil(t, field(resp.Data, "message"))
+
+	// filtering and pagination
+	page := `query($after: String) {
+		messages(first: 1, after: $after, tag: "news", text: "ABBA") { totalCount nodes { text } pageInfo { hasNextPage endCursor } }
+	}`
+	resp = graphQL(t, r, page, nil)
+	assert.Equal(t, 2.0, field(resp.Data, "messages.totalCount"))
+	assert.Equal(t, []interface{}{map[string]interface{}{"text": "abba"}}, field(resp.Data, "messages.nodes"))
+	assert.Equal(t, true, field(resp.Data, "messages.pageInfo.hasNextPage"))
+	resp = graphQL(t, r, page, map[string]interface{}{"after": field(resp.Data, "messages.pageInfo.endCursor")})
+	assert.Equal(t, []interface{}{map[string]interface{}{"text": "re: abba"}}, field(resp.Data, "messages.nodes"))
+	assert.Equal(t, false, field(resp.Data, "messages.pageInfo.hasNextPage"))
+	assert.Nil(t, field(resp.Data, "messages.pageInfo.endCursor"))
+
+	resp = graphQL(t, r, `mutation { deleteMessage(id: 3) }`, nil)
+	assert.Equal(t, true, field(resp.Data, "deleteMessage"))
+	resp = graphQL(t, r, `mutation { deleteMessage(id: 3) }`, nil)
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, "not_found", resp.Errors[0].Extensions["code"])
+	}
+	resp = graphQL(t, r, `mutation { createMessage(input: {text: ""}) { id } }`, nil)
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, "invalid_argument", resp.Errors[0].Extensions["code"])
+	}
+	resp = graphQL(t, r, `{ messages(first: 0) { totalCount } }`, nil)
+	assert.Len(t, resp.Errors, 1)
+	resp = graphQL(t, r, `{ nope }`, nil)
+	assert.Len(t, resp.Errors, 1)
+
+	// queries may be sent with GET, mutations not
+	rec := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", graphQLPath+"?query="+url.QueryEscape(`{ message(id: 1) { text } }`), nil))
+	assert.Contains(t, rec.Body.String(), `"text":"abba"`)
+	rec = httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", graphQLPath+"?query="+url.QueryEscape(`mutation { deleteMessage(id: 1) }`), nil))
+	assert.Contains(t, rec.Body.String(), "Mutations must be sent with POST")
+}
+
+func Test_appRouter_graphQLLimits(t *testing.T) {
+	r := newTestRouter(WithGraphQLLimits(4, 100))
+	resp := graphQL(t, r, `{ message(id: 1) { parent { parent { parent { text } } } } }`, nil)
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Contains(t, resp.Errors[0].Message, "Query depth 5 exceeds the limit of 4")
+	}
+	// fragments count too
+	resp = graphQL(t, r, `{ message(id: 1) { ...p } } fragment p on Message { parent { parent { parent { text } } } }`, nil)
+	assert.Len(t, resp.Errors, 1)
+
+	// every message of a page costs its fields
+	resp = graphQL(t, r, `query($n: Int) { messages(first: $n) { nodes { text author { name } } } }`, map[string]interface{}{"n": 50})
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Contains(t, resp.Errors[0].Message, "Query complexity 201 exceeds the limit of 100")
+	}
+	resp = graphQL(t, r, `query($n: Int) { messages(first: $n) { nodes { text author { name } } } }`, map[string]interface{}{"n": 10})
+	assert.Empty(t, resp.Errors)
+
+	// introspection is free
+	resp = graphQL(t, r, `{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`, nil)
+	assert.Empty(t, resp.Errors)
+}
+
+func Test_appRouter_graphQLAuth(t *testing.T) {
+	r := newTestRouter(
+		WithBasicAuth(map[string]string{"ann": "secret", "bob": "secret"}),
+		WithTenants(map[string]string{"bob": "globex"}),
+	)
+	defer r.tenants.close()
+	do := func(user, query string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(graphQLRequest{Query: query})
+		req := httptest.NewRequest("POST", graphQLPath, bytes.NewReader(body))
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusUnauthorized, do("", `{ messages { totalCount } }`).Code)
+	rec := do("ann", `mutation { createMessage(input: {text: "hi", author: "mallory"}) { author { name } } }`)
+	assert.Contains(t, rec.Body.String(), `"name":"ann"`)
+	rec = do("bob", `{ messages { totalCount } }`)
+	assert.Contains(t, rec.Body.String(), `"totalCount":0`)
+}
+
+func Test_appRouter_graphQLSubscription(t *testing.T) {
+	r := newTestRouter()
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+	graphQL(t, r, `mutation { createMessage(input: {text: "before", tags: ["go"]}) { id } }`, nil)
+
+	dialer := websocket.Dialer{Subprotocols: []string{graphQLWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+graphQLPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() map[string]interface{} {
+		var m map[string]interface{}
+		if err := conn.ReadJSON(&m); err != nil {
+			t.Fatal("error while reading message: ", err)
+		}
+		return m
+	}
+
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgConnectionInit}))
+	assert.Equal(t, gqlMsgConnectionAck, read()["type"])
+
+	sub := graphQLRequest{Query: `subscription($tag: String) { messageEvents(tag: $tag) { type message { text analysis { words } } } }`, Variables: map[string]interface{}{"tag": "go"}}
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgSubscribe, ID: "1", Payload: sub}))
+	// messages are handled in order, so the subscription is open once the pong arrives
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgPing}))
+	assert.Equal(t, gqlMsgPong, read()["type"])
+	graphQL(t, r, `mutation { createMessage(input: {text: "other"}) { id } }`, nil)
+	graphQL(t, r, `mutation { createMessage(input: {text: "hello gophers", tags: ["go"]}) { id } }`, nil)
+	m := read()
+	assert.Equal(t, gqlMsgNext, m["type"])
+	assert.Equal(t, "1", m["id"])
+	assert.Equal(t, "created", field(m, "payload.data.messageEvents.type"))
+	assert.Equal(t, "hello gophers", field(m, "payload.data.messageEvents.message.text"))
+	assert.Equal(t, 2.0, field(m, "payload.data.messageEvents.message.analysis.words"))
+
+	// after replays the backlog
+	replay := graphQLRequest{Query: `subscription { messageEvents(after: "1") { message { text } } }`}
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgSubscribe, ID: "4", Payload: replay}))
+	m = read()
+	assert.Equal(t, "4", m["id"])
+	assert.Equal(t, "other", field(m, "payload.data.messageEvents.message.text"))
+	assert.Equal(t, "hello gophers", field(read(), "payload.data.messageEvents.message.text"))
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgComplete, ID: "4"}))
+
+	// queries over the connection complete after their result
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgSubscribe, ID: "2", Payload: graphQLRequest{Query: `{ messages { totalCount } }`}}))
+	m = read()
+	assert.Equal(t, 3.0, field(m, "payload.data.messages.totalCount"))
+	assert.Equal(t, map[string]interface{}{"type": gqlMsgComplete, "id": "2"}, read())
+
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgSubscribe, ID: "3", Payload: graphQLRequest{Query: `subscription { nope }`}}))
+	m = read()
+	assert.Equal(t, gqlMsgError, m["type"])
+
+	// a reused id closes the connection
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgSubscribe, ID: "1", Payload: sub}))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, gqlCloseSubscriberExists), "unexpected error %v", err)
+}
+
+func Test_appRouter_graphQLSubscriptionUnauthorized(t *testing.T) {
+	r := newTestRouter()
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{graphQLWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+graphQLPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(t, conn.WriteJSON(gqlWSMessage{Type: gqlMsgSubscribe, ID: "1", Payload: graphQLRequest{Query: `{ messages { totalCount } }`}}))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, gqlCloseUnauthorized), "unexpected error %v", err)
+}
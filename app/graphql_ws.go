@@ -0,0 +1,322 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+// graphQLWSProtocol is the WebSocket subprotocol of GraphQL subscriptions,
+// see https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md.
+const graphQLWSProtocol = "graphql-transport-ws"
+
+// Message types of graphQLWSProtocol.
+const (
+	gqlMsgConnectionInit = "connection_init"
+	gqlMsgConnectionAck  = "connection_ack"
+	gqlMsgPing           = "ping"
+	gqlMsgPong           = "pong"
+	gqlMsgSubscribe      = "subscribe"
+	gqlMsgNext           = "next"
+	gqlMsgError          = "error"
+	gqlMsgComplete       = "complete"
+)
+
+// Close codes of graphQLWSProtocol.
+const (
+	gqlCloseBadRequest          = 4400
+	gqlCloseUnauthorized        = 4401
+	gqlCloseInitTimeout         = 4408
+	gqlCloseSubscriberExists    = 4409
+	gqlCloseTooManyInitRequests = 4429
+)
+
+const gqlInitTimeout = 10 * time.Second
+
+// gqlWSMessage is a message of graphQLWSProtocol.
+type gqlWSMessage struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// gqlWSConn is the server side of a GraphQL WebSocket connection. Like
+// wsConn, messages for the client are queued on send and written by a
+// single writer goroutine.
+type gqlWSConn struct {
+	r    *appRouter
+	req  *http.Request
+	conn *websocket.Conn
+	send chan gqlWSMessage
+	done chan struct{}
+
+	mu    sync.Mutex
+	acked bool
+	subs  map[string]*message.Subscription
+}
+
+// serveGraphQLWebSocket serves GraphQL operations, subscriptions in
+// particular, on a WebSocket connection speaking graphQLWSProtocol.
+func (r *appRouter) serveGraphQLWebSocket(w http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{graphQLWSProtocol},
+		Error: func(w http.ResponseWriter, req *http.Request, status int, reason error) {
+			r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "WebSocket handshake failed: %v", reason))
+		},
+	}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logOf(req).Error("Error while upgrading to WebSocket: ", err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusSwitchingProtocols, req)
+	c := &gqlWSConn{
+		r:    r,
+		req:  req,
+		conn: conn,
+		send: make(chan gqlWSMessage, wsSendQueue),
+		done: make(chan struct{}),
+		subs: make(map[string]*message.Subscription),
+	}
+	if conn.Subprotocol() != graphQLWSProtocol {
+		c.closeWith(gqlCloseBadRequest, "Subprotocol "+graphQLWSProtocol+" required")
+		return
+	}
+	logOf(req).Info("GraphQL WebSocket connection opened")
+	timer := time.AfterFunc(gqlInitTimeout, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !c.acked {
+			c.closeWith(gqlCloseInitTimeout, "Connection initialisation timeout")
+		}
+	})
+	defer timer.Stop()
+	go c.writeLoop()
+	c.readLoop()
+}
+
+// readLoop handles the messages sent by the client until the connection
+// fails or is closed.
+func (c *gqlWSConn) readLoop() {
+	defer func() {
+		close(c.done)
+		c.mu.Lock()
+		for id, sub := range c.subs {
+			c.r.tenantOf(c.req).m.Events().Unsubscribe(sub)
+			delete(c.subs, id)
+		}
+		c.mu.Unlock()
+		_ = c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxFrameSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logOf(c.req).Error("Error while reading GraphQL WebSocket message: ", err)
+			} else {
+				logOf(c.req).Info("GraphQL WebSocket connection closed")
+			}
+			return
+		}
+		var m struct {
+			Type    string          `json:"type"`
+			ID      string          `json:"id"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			c.closeWith(gqlCloseBadRequest, "Invalid message")
+			return
+		}
+		switch m.Type {
+		case gqlMsgConnectionInit:
+			c.mu.Lock()
+			acked := c.acked
+			c.acked = true
+			c.mu.Unlock()
+			if acked {
+				c.closeWith(gqlCloseTooManyInitRequests, "Too many initialisation requests")
+				return
+			}
+			c.enqueue(gqlWSMessage{Type: gqlMsgConnectionAck})
+		case gqlMsgPing:
+			c.enqueue(gqlWSMessage{Type: gqlMsgPong})
+		case gqlMsgPong:
+		case gqlMsgSubscribe:
+			c.mu.Lock()
+			acked, exists := c.acked, c.subs[m.ID] != nil
+			c.mu.Unlock()
+			switch {
+			case !acked:
+				c.closeWith(gqlCloseUnauthorized, "Unauthorized")
+				return
+			case m.ID == "":
+				c.closeWith(gqlCloseBadRequest, "Invalid message, subscribe must have an id")
+				return
+			case exists:
+				c.closeWith(gqlCloseSubscriberExists, "Subscriber for "+m.ID+" already exists")
+				return
+			}
+			var req graphQLRequest
+			if err := json.Unmarshal(m.Payload, &req); err != nil {
+				c.closeWith(gqlCloseBadRequest, "Invalid message, payload must be a GraphQL request")
+				return
+			}
+			c.subscribe(m.ID, req)
+		case gqlMsgComplete:
+			c.unsubscribe(m.ID)
+		default:
+			c.closeWith(gqlCloseBadRequest, "Invalid message type "+strconv.Quote(m.Type))
+			return
+		}
+	}
+}
+
+// writeLoop writes queued messages and keep-alive pings until the
+// connection is done or a write fails.
+func (c *gqlWSConn) writeLoop() {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case m := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := c.conn.WriteJSON(m); err != nil {
+				logOf(c.req).Error("Error while writing GraphQL WebSocket message: ", err)
+				_ = c.conn.Close()
+				return
+			}
+		case <-ping.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+			if err != nil {
+				_ = c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// enqueue queues m for the client, disconnecting the client if its queue
+// is full.
+func (c *gqlWSConn) enqueue(m gqlWSMessage) {
+	select {
+	case c.send <- m:
+	default:
+		logOf(c.req).Warn("GraphQL WebSocket connection closed, client fell behind")
+		c.closeWith(websocket.CloseTryAgainLater, "client fell behind")
+	}
+}
+
+// closeWith closes the connection with code and reason.
+func (c *gqlWSConn) closeWith(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteTimeout))
+	_ = c.conn.Close()
+}
+
+// subscribe executes operation id. Queries and mutations complete after
+// their result, subscriptions push a result per event until the client
+// completes them.
+func (c *gqlWSConn) subscribe(id string, req graphQLRequest) {
+	op, errs := c.r.parseGraphQL(req)
+	if errs != nil {
+		logOf(c.req).Error("error validating request: ", errs[0].Message)
+		c.enqueue(gqlWSMessage{Type: gqlMsgError, ID: id, Payload: errs})
+		return
+	}
+	if op.op.Operation != ast.OperationTypeSubscription {
+		c.enqueue(gqlWSMessage{Type: gqlMsgNext, ID: id, Payload: c.r.executeGraphQL(c.req.Context(), op, nil)})
+		c.enqueue(gqlWSMessage{Type: gqlMsgComplete, ID: id})
+		return
+	}
+
+	var filter message.Filter
+	var after int64
+	for _, sel := range op.op.SelectionSet.Selections {
+		f, ok := sel.(*ast.Field)
+		if !ok {
+			continue
+		}
+		for _, arg := range f.Arguments {
+			val, _ := astValue(arg.Value, op.Variables).(string)
+			switch arg.Name.Value {
+			case "tag":
+				filter.Tag = val
+			case "author":
+				filter.Author = val
+			case "after":
+				n, err := strconv.ParseInt(val, 10, defaultBitsize)
+				if err != nil || n < 0 {
+					err := message.Errorf(message.ErrInvalid, "Invalid after %q, should be a non-negative integer", val)
+					c.enqueue(gqlWSMessage{Type: gqlMsgError, ID: id, Payload: gqlerrors.FormatErrors(err)})
+					return
+				}
+				after = n
+			}
+		}
+	}
+	events := c.r.tenantOf(c.req).m.Events()
+	sub, replay, missed := events.Subscribe(after, filter)
+	if missed {
+		logOf(c.req).Warnf("GraphQL subscription resumed after %d, older events are no longer in the backlog", after)
+	}
+	c.mu.Lock()
+	c.subs[id] = sub
+	c.mu.Unlock()
+	logOf(c.req).Infof("GraphQL subscription %s opened, tag=%q author=%q", id, filter.Tag, filter.Author)
+	go c.forward(id, op, sub, replay)
+}
+
+// unsubscribe stops subscription id, which the client completed.
+func (c *gqlWSConn) unsubscribe(id string) {
+	c.mu.Lock()
+	sub := c.subs[id]
+	delete(c.subs, id)
+	c.mu.Unlock()
+	if sub != nil {
+		c.r.tenantOf(c.req).m.Events().Unsubscribe(sub)
+	}
+}
+
+// forward executes subscription id for the replayed events and then the
+// events of sub until sub is closed. Like wsConn.forward it waits for the
+// writer during the replay.
+func (c *gqlWSConn) forward(id string, op *graphQLOperation, sub *message.Subscription, replay []message.Event) {
+	ctx := c.req.Context()
+	for _, e := range replay {
+		select {
+		case c.send <- gqlWSMessage{Type: gqlMsgNext, ID: id, Payload: c.r.executeGraphQL(ctx, op, e)}:
+		case <-c.done:
+			return
+		}
+	}
+	for e := range sub.C {
+		c.enqueue(gqlWSMessage{Type: gqlMsgNext, ID: id, Payload: c.r.executeGraphQL(ctx, op, e)})
+	}
+	c.mu.Lock()
+	current := c.subs[id] == sub
+	c.mu.Unlock()
+	select {
+	case <-c.done:
+	default:
+		// closed by the broker rather than completed by the client
+		if current {
+			logOf(c.req).Warn("GraphQL WebSocket connection closed, client fell behind")
+			c.closeWith(websocket.CloseTryAgainLater, "client fell behind")
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	pb "github.com/shailendra-k-singh/example.messaging.service/pkg/messagingpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServiceName is the full name of MessagingService. Only its methods
+// are authenticated, not those of other services like the health service.
+const grpcServiceName = "messaging.v1.MessagingService"
+
+// NewGRPCServer returns a gRPC server of the MessagingService and the gRPC
+// health service. It serves the messages of the default channel of every
+// tenant from the stores of the HTTP API, resolving the tenant and the user
+// from the x-tenant-id and authorization metadata like the HTTP API does
+// from the headers. Calls are traced and counted in Prometheus metrics.
+func (r *appRouter) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(r.grpcUnaryMetrics, r.grpcUnaryTracing, r.grpcUnaryTenant),
+		grpc.ChainStreamInterceptor(r.grpcStreamMetrics, r.grpcStreamTracing, r.grpcStreamTenant),
+	)
+	s := grpc.NewServer(opts...)
+	pb.RegisterMessagingServiceServer(s, &grpcServer{r: r})
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(grpcServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+	return s
+}
+
+// grpcServer implements MessagingService on the stores of an appRouter.
+type grpcServer struct {
+	pb.UnimplementedMessagingServiceServer
+	r *appRouter
+}
+
+func (s *grpcServer) Create(ctx context.Context, req *pb.CreateRequest) (*pb.Message, error) {
+	body, err := createRequestBody(req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp, err := s.r.defaultChannel(tenantFromContext(ctx, s.r)).addMessage(ctx, body)
+	if err != nil {
+		logFromContext(ctx).Error("error while adding message: ", err)
+		return nil, grpcError(err)
+	}
+	logFromContext(ctx).Infof("Added message with id %v successfully", resp.Id)
+	return messageToProto(resp), nil
+}
+
+func (s *grpcServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.Message, error) {
+	if err := validateGRPCMsgID(req.Id); err != nil {
+		logFromContext(ctx).Error("error validating request: ", err)
+		return nil, grpcError(err)
+	}
+	resp, err := tenantFromContext(ctx, s.r).m.Get(req.Id)
+	if err != nil {
+		logFromContext(ctx).Error("error while retrieving message: ", err)
+		return nil, grpcError(err)
+	}
+	logFromContext(ctx).Infof("Retrieved message %d successfully", req.Id)
+	return messageToProto(resp), nil
+}
+
+func (s *grpcServer) List(req *pb.ListRequest, stream pb.MessagingService_ListServer) error {
+	ctx := stream.Context()
+	var opts []message.ReadOption
+	if req.IncludeScheduled {
+		opts = append(opts, message.IncludeScheduled())
+	}
+	// unlike GET /v1/messages an empty list is an empty stream, not an error
+	resp, err := tenantFromContext(ctx, s.r).m.GetAll(opts...)
+	if err != nil && !errors.Is(err, message.ErrNotFound) {
+		logFromContext(ctx).Error("error while retrieving messages: ", err)
+		return grpcError(err)
+	}
+	for _, msg := range resp {
+		if err := stream.Send(messageToProto(msg)); err != nil {
+			return err
+		}
+	}
+	logFromContext(ctx).Info("Retrieved all messages successfully")
+	return nil
+}
+
+func (s *grpcServer) Delete(ctx context.Context, req *pb.DeleteRequest) (*emptypb.Empty, error) {
+	err := validateGRPCMsgID(req.Id)
+	var opts []message.DeleteOption
+	if err == nil {
+		opts, err = s.r.deleteOptionsOf(ctx, req.Hard)
+	}
+	if err != nil {
+		logFromContext(ctx).Error("error validating request: ", err)
+		return nil, grpcError(err)
+	}
+	if err := tenantFromContext(ctx, s.r).m.Delete(req.Id, opts...); err != nil {
+		logFromContext(ctx).Error("error while deleting message: ", err)
+		return nil, grpcError(err)
+	}
+	logFromContext(ctx).Infof("Deleted message %d successfully", req.Id)
+	return &emptypb.Empty{}, nil
+}
+
+// Watch streams the events of the tenant like the HTTP event stream does.
+// A client that can not keep up gets Unavailable and is expected to watch
+// again after the last event it received.
+func (s *grpcServer) Watch(req *pb.WatchRequest, stream pb.MessagingService_WatchServer) error {
+	ctx := stream.Context()
+	if req.After < 0 {
+		err := message.Errorf(message.ErrInvalid, "Invalid after %d, should be a non-negative integer", req.After)
+		logFromContext(ctx).Error("error validating request: ", err)
+		return grpcError(err)
+	}
+	filter := message.Filter{Tag: req.Tag, Author: req.Author}
+	events := tenantFromContext(ctx, s.r).m.Events()
+	sub, replay, missed := events.Subscribe(req.After, filter)
+	defer events.Unsubscribe(sub)
+	if missed {
+		logFromContext(ctx).Warnf("Event stream resumed after %d, older events are no longer in the backlog", req.After)
+	}
+	logFromContext(ctx).Infof("Event stream opened, tag=%q author=%q", filter.Tag, filter.Author)
+
+	for _, e := range replay {
+		if err := stream.Send(eventToProto(e)); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				logFromContext(ctx).Warn("Event stream closed, subscriber fell behind")
+				return status.Error(codes.Unavailable, "subscriber fell behind, watch again after the last event received")
+			}
+			if err := stream.Send(eventToProto(e)); err != nil {
+				logFromContext(ctx).Error("Error while writing event: ", err)
+				return err
+			}
+		case <-ctx.Done():
+			logFromContext(ctx).Info("Event stream closed by client")
+			return nil
+		}
+	}
+}
+
+func validateGRPCMsgID(id int64) error {
+	if id < 1 {
+		return message.Errorf(message.ErrInvalid, "Invalid message id value %d, should be a valid positive integer", id)
+	}
+	return nil
+}
+
+// createRequestBody converts req to the request body of the REST API.
+func createRequestBody(req *pb.CreateRequest) (MsgRequestBody, error) {
+	body := MsgRequestBody{
+		Text:         req.Text,
+		Author:       req.Author,
+		Tags:         req.Tags,
+		Queue:        req.Queue,
+		DelaySeconds: req.DelaySeconds,
+		TTL:          req.TtlSeconds,
+		ParentId:     req.ParentId,
+	}
+	if req.DeliverAt != nil {
+		if err := req.DeliverAt.CheckValid(); err != nil {
+			return body, message.Errorf(message.ErrInvalid, "Invalid deliver_at: %v", err)
+		}
+		at := req.DeliverAt.AsTime()
+		body.DeliverAt = &at
+	}
+	return body, nil
+}
+
+func messageToProto(msg message.MessageObj) *pb.Message {
+	return &pb.Message{
+		Id:        msg.Id,
+		Text:      msg.Text,
+		Author:    msg.Author,
+		Tags:      msg.Tags,
+		Queue:     msg.Queue,
+		DeliverAt: timeToProto(msg.DeliverAt),
+		ExpiresAt: timeToProto(msg.ExpiresAt),
+		ParentId:  msg.ParentId,
+		Tombstone: msg.Tombstone,
+	}
+}
+
+func eventToProto(e message.Event) *pb.Event {
+	return &pb.Event{
+		Id:      e.ID,
+		Type:    e.Type,
+		Time:    timestamppb.New(e.Time),
+		Message: messageToProto(e.Message),
+	}
+}
+
+func timeToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// tenantFromContext returns the tenant stored by the tenant interceptors.
+func tenantFromContext(ctx context.Context, r *appRouter) *tenant {
+	if t, ok := ctx.Value(tenantKey{}).(*tenant); ok {
+		return t
+	}
+	return r.defaultTenant
+}
+
+// grpcAuthorize authenticates the caller of a MessagingService method from
+// the authorization metadata, resolves its tenant from the x-tenant-id
+// metadata and returns ctx with both stored as the HTTP middleware stores
+// them in a request context.
+func (r *appRouter) grpcAuthorize(ctx context.Context, method string) (context.Context, error) {
+	if !strings.HasPrefix(method, "/"+grpcServiceName+"/") {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(r.users) > 0 {
+		// metadata keys are lower case, http.Header parses the credentials
+		h := http.Request{Header: http.Header{"Authorization": md.Get("authorization")}}
+		user, pass, ok := h.BasicAuth()
+		if !ok || !r.validCredentials(user, pass) {
+			return nil, message.Errorf(errUnauthenticated, "Valid credentials are required")
+		}
+		ctx = context.WithValue(ctx, principalKey{}, user)
+	}
+	var header string
+	if v := md.Get(tenantHeader); len(v) > 0 {
+		header = v[0]
+	}
+	id, err := r.tenantID(ctx, header)
+	var t *tenant
+	if err == nil {
+		t, err = r.tenantByID(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetBaggageItem(tenantBaggageKey, t.id)
+		span.SetTag("tenant", t.id)
+	}
+	if call, ok := ctx.Value(grpcCallKey{}).(*grpcCall); ok {
+		call.tenant = t.id
+	}
+	return context.WithValue(ctx, tenantKey{}, t), nil
+}
+
+func (r *appRouter) grpcUnaryTenant(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	tctx, err := r.grpcAuthorize(ctx, info.FullMethod)
+	if err != nil {
+		logFromContext(ctx).Error("error while resolving tenant: ", err)
+		return nil, grpcError(err)
+	}
+	return handler(tctx, req)
+}
+
+func (r *appRouter) grpcStreamTenant(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := r.grpcAuthorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		logFromContext(ss.Context()).Error("error while resolving tenant: ", err)
+		return grpcError(err)
+	}
+	return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcSpan starts the span of a call of method, a child of the span
+// propagated in the metadata of ctx if there is one.
+func (r *appRouter) grpcSpan(ctx context.Context, method string) (opentracing.Span, context.Context) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tracer := r.t.get()
+	parent, err := tracer.Extract(opentracing.TextMap, metadataCarrier(md))
+	if err != nil && err != opentracing.ErrSpanContextNotFound {
+		logFromContext(ctx).Error("error while extracting span context: ", err)
+	}
+	span := tracer.StartSpan(method, ext.RPCServerOption(parent))
+	span.SetTag(string(ext.Component), "gRPC server")
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
+
+// finishGRPCSpan records the status of a call on span and finishes it.
+func finishGRPCSpan(span opentracing.Span, err error) {
+	code := status.Code(err)
+	span.SetTag("grpc.code", code.String())
+	if err != nil && code != codes.Canceled {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.Error(err))
+	}
+	span.Finish()
+}
+
+func (r *appRouter) grpcUnaryTracing(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	span, ctx := r.grpcSpan(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	finishGRPCSpan(span, err)
+	return resp, err
+}
+
+func (r *appRouter) grpcStreamTracing(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	span, ctx := r.grpcSpan(ss.Context(), info.FullMethod)
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	finishGRPCSpan(span, err)
+	return err
+}
+
+type grpcCallKey struct{}
+
+// grpcCall is what the inner interceptors learn about a call for the
+// metrics interceptor, which runs first: the tenant, once resolved.
+type grpcCall struct {
+	tenant string
+}
+
+func (r *appRouter) grpcUnaryMetrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	call := &grpcCall{}
+	resp, err := handler(context.WithValue(ctx, grpcCallKey{}, call), req)
+	observeGRPC(info.FullMethod, call, start, err)
+	return resp, err
+}
+
+func (r *appRouter) grpcStreamMetrics(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	call := &grpcCall{}
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), grpcCallKey{}, call)})
+	observeGRPC(info.FullMethod, call, start, err)
+	return err
+}
+
+func observeGRPC(method string, call *grpcCall, start time.Time, err error) {
+	grpcHandled.WithLabelValues(method, status.Code(err).String(), call.tenant).Inc()
+	grpcHandlingSeconds.WithLabelValues(method, call.tenant).Observe(time.Since(start).Seconds())
+}
+
+// serverStream overrides the context of a server stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// metadataCarrier adapts incoming metadata to the TextMap carrier of
+// opentracing. Keys of metadata are lower case, which the propagators of
+// jaeger accept.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Set(key, val string) {
+	metadata.MD(c).Set(key, val)
+}
+
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, vals := range c {
+		for _, v := range vals {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
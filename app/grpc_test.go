@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	pb "github.com/shailendra-k-singh/example.messaging.service/pkg/messagingpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-client-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialGRPC serves the gRPC server of r on an in-memory listener and returns
+// a connection to it and a func stopping both.
+func dialGRPC(t *testing.T, r *appRouter) (*grpc.ClientConn, func()) {
+	lis := bufconn.Listen(1 << 20)
+	s := r.NewGRPCServer()
+	go s.Serve(lis)
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func withBasicAuth(ctx context.Context, user, pass string) context.Context {
+	cred := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+cred)
+}
+
+func Test_grpcServer(t *testing.T) {
+	r := newTestRouter()
+	defer r.tenants.close()
+	conn, stop := dialGRPC(t, r)
+	defer stop()
+	c := pb.NewMessagingServiceClient(conn)
+	ctx := context.Background()
+
+	msg, err := c.Create(ctx, &pb.CreateRequest{Text: "abba", Tags: []string{"a"}, TtlSeconds: 60})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), msg.Id)
+	assert.NotNil(t, msg.ExpiresAt)
+
+	// the gRPC and the HTTP API serve the same store
+	rec := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/messages/1", nil))
+	assert.Contains(t, rec.Body.String(), `"abba"`)
+	r.GetRouter().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"text":"from http"}`)))
+
+	got, err := c.Get(ctx, &pb.GetRequest{Id: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "from http", got.Text)
+
+	_, err = c.Create(ctx, &pb.CreateRequest{Text: "later", DelaySeconds: 3600})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"abba", "from http"}, listTexts(t, c, &pb.ListRequest{}))
+	assert.Len(t, listTexts(t, c, &pb.ListRequest{IncludeScheduled: true}), 3)
+
+	_, err = c.Delete(ctx, &pb.DeleteRequest{Id: 1})
+	assert.NoError(t, err)
+	_, err = c.Get(ctx, &pb.GetRequest{Id: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = c.Create(ctx, &pb.CreateRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = c.Get(ctx, &pb.GetRequest{Id: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func Test_grpcServer_emptyList(t *testing.T) {
+	r := newTestRouter()
+	defer r.tenants.close()
+	conn, stop := dialGRPC(t, r)
+	defer stop()
+	c := pb.NewMessagingServiceClient(conn)
+	assert.Empty(t, listTexts(t, c, &pb.ListRequest{}))
+}
+
+func Test_grpcServer_watch(t *testing.T) {
+	r := newTestRouter()
+	defer r.tenants.close()
+	conn, stop := dialGRPC(t, r)
+	defer stop()
+	c := pb.NewMessagingServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := c.Create(ctx, &pb.CreateRequest{Text: "before", Tags: []string{"news"}})
+	assert.NoError(t, err)
+	stream, err := c.Watch(ctx, &pb.WatchRequest{Tag: "news", After: 0})
+	assert.NoError(t, err)
+	c.Create(ctx, &pb.CreateRequest{Text: "other"})
+	c.Create(ctx, &pb.CreateRequest{Text: "hello", Tags: []string{"news"}})
+	e, err := stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "created", e.Type)
+		assert.Equal(t, "hello", e.Message.Text)
+	}
+
+	// after replays the backlog
+	stream, err = c.Watch(ctx, &pb.WatchRequest{After: e.Id - 2})
+	assert.NoError(t, err)
+	e, err = stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "other", e.Message.Text)
+	}
+}
+
+func Test_grpcServer_auth(t *testing.T) {
+	r := newTestRouter(
+		WithBasicAuth(map[string]string{"ann": "secret", "bob": "secret"}),
+		WithTenants(map[string]string{"bob": "globex"}),
+		WithAdmins([]string{"ann"}),
+	)
+	defer r.tenants.close()
+	conn, stop := dialGRPC(t, r)
+	defer stop()
+	c := pb.NewMessagingServiceClient(conn)
+	ann := withBasicAuth(context.Background(), "ann", "secret")
+	bob := withBasicAuth(context.Background(), "bob", "secret")
+
+	_, err := c.Create(context.Background(), &pb.CreateRequest{Text: "hi"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = c.Create(withBasicAuth(context.Background(), "ann", "wrong"), &pb.CreateRequest{Text: "hi"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	msg, err := c.Create(ann, &pb.CreateRequest{Text: "hi", Author: "mallory"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ann", msg.Author)
+
+	// bob belongs to globex, which does not see the messages of ann
+	_, err = c.Get(bob, &pb.GetRequest{Id: msg.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = c.Get(metadata.AppendToOutgoingContext(bob, "x-tenant-id", "default"), &pb.GetRequest{Id: msg.Id})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = c.Create(bob, &pb.CreateRequest{Text: "globex"})
+	assert.NoError(t, err)
+	_, err = c.Delete(bob, &pb.DeleteRequest{Id: 1, Hard: true})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = c.Delete(ann, &pb.DeleteRequest{Id: 1, Hard: true})
+	assert.NoError(t, err)
+
+	// the health service needs no credentials
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: grpcServiceName})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func Test_grpcServer_metrics(t *testing.T) {
+	r := newTestRouter()
+	defer r.tenants.close()
+	conn, stop := dialGRPC(t, r)
+	defer stop()
+	c := pb.NewMessagingServiceClient(conn)
+	c.Get(context.Background(), &pb.GetRequest{Id: 42})
+
+	rec := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `grpc_server_handled_total{grpc_code="NotFound",grpc_method="/messaging.v1.MessagingService/Get",tenant="default"}`)
+	assert.Contains(t, rec.Body.String(), `grpc_server_handling_seconds_count{grpc_method="/messaging.v1.MessagingService/Get",tenant="default"}`)
+}
+
+func Test_grpcServer_withoutTracing(t *testing.T) {
+	// tracing is not initialized, spans go to the global tracer
+	r := NewAppRouter(200)
+	r.SetRoutes()
+	defer r.tenants.close()
+	conn, stop := dialGRPC(t, r)
+	defer stop()
+	c := pb.NewMessagingServiceClient(conn)
+	_, err := c.Create(context.Background(), &pb.CreateRequest{Text: "untraced"})
+	assert.NoError(t, err)
+}
+
+func Test_metadataCarrier(t *testing.T) {
+	span := appRouterObj.t.tracer.StartSpan("client")
+	defer span.Finish()
+	md := metadata.MD{}
+	assert.NoError(t, appRouterObj.t.tracer.Inject(span.Context(), opentracing.TextMap, metadataCarrier(md)))
+	// metadata sent over the wire has lower case keys
+	md = metadata.Pairs(flatten(md)...)
+	ctx, err := appRouterObj.t.tracer.Extract(opentracing.TextMap, metadataCarrier(md))
+	assert.NoError(t, err)
+	assert.Equal(t, span.Context().(jaeger.SpanContext).String(), ctx.(jaeger.SpanContext).String())
+}
+
+func flatten(md metadata.MD) []string {
+	var kv []string
+	for k, vals := range md {
+		for _, v := range vals {
+			kv = append(kv, k, v)
+		}
+	}
+	return kv
+}
+
+func listTexts(t *testing.T, c pb.MessagingServiceClient, req *pb.ListRequest) []string {
+	stream, err := c.List(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{}
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return texts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts = append(texts, msg.Text)
+	}
+}
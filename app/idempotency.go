@@ -0,0 +1,250 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotentReplayHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLen    = 255
+	defaultIdempotentWindow = 24 * time.Hour
+	// maxIdempotencyEntries bounds the responses remembered per tenant.
+	maxIdempotencyEntries = 10000
+)
+
+// errIdempotencyKeyReused is returned when a key is presented again with a
+// request that differs from the one it was first used with.
+var errIdempotencyKeyReused = errors.New("idempotency key reused")
+
+// idempotencyEntry is the remembered outcome of a request. done is closed
+// once the response has been recorded, until then the entry is in flight and
+// concurrent duplicates wait on it.
+type idempotencyEntry struct {
+	tenant      string
+	fingerprint string
+	done        chan struct{}
+	expires     time.Time
+
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyStore remembers responses by idempotency key for a fixed window,
+// at most max of them per tenant.
+type idempotencyStore struct {
+	sync.Mutex
+	window    time.Duration
+	max       int
+	entries   map[string]*idempotencyEntry
+	counts    map[string]int
+	nextSweep time.Time
+}
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		window:  window,
+		max:     maxIdempotencyEntries,
+		entries: make(map[string]*idempotencyEntry),
+		counts:  make(map[string]int),
+	}
+}
+
+// begin looks up key of tenant. If no live entry exists a new in-flight
+// entry is created and returned with owner set, the caller must then
+// complete it with finish or abort. Otherwise the existing entry is
+// returned. A tenant that already has max entries gets an error of kind
+// ErrQuotaExceeded instead of a new one.
+func (s *idempotencyStore) begin(tenant, key, fingerprint string) (e *idempotencyEntry, owner bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	s.sweep(now, false)
+	old, ok := s.entries[key]
+	if ok && (old.expires.IsZero() || now.Before(old.expires)) {
+		return old, false, nil
+	}
+	if !ok && s.counts[tenant] >= s.max {
+		s.sweep(now, true)
+		if s.counts[tenant] >= s.max {
+			return nil, false, message.Errorf(message.ErrQuotaExceeded, "Too many %s values in use, retry later", idempotencyKeyHeader)
+		}
+	}
+	if ok {
+		s.remove(key, old)
+	}
+	e = &idempotencyEntry{tenant: tenant, fingerprint: fingerprint, done: make(chan struct{})}
+	s.entries[key] = e
+	s.counts[tenant]++
+	return e, true, nil
+}
+
+// remove drops entry e of key. Callers hold the lock.
+func (s *idempotencyStore) remove(key string, e *idempotencyEntry) {
+	delete(s.entries, key)
+	if s.counts[e.tenant]--; s.counts[e.tenant] == 0 {
+		delete(s.counts, e.tenant)
+	}
+}
+
+// finish records the response for an in-flight entry and releases waiters.
+func (s *idempotencyStore) finish(e *idempotencyEntry, status int, header http.Header, body []byte) {
+	s.Lock()
+	e.status, e.header, e.body = status, header, body
+	e.expires = time.Now().Add(s.window)
+	s.Unlock()
+	close(e.done)
+}
+
+// abort forgets an in-flight entry so that the request may be retried, e.g.
+// after a server error.
+func (s *idempotencyStore) abort(key string, e *idempotencyEntry) {
+	s.Lock()
+	if s.entries[key] == e {
+		s.remove(key, e)
+	}
+	s.Unlock()
+	close(e.done)
+}
+
+// sweep drops expired entries, at most twice per window unless forced.
+// Callers hold the lock.
+func (s *idempotencyStore) sweep(now time.Time, force bool) {
+	if !force && now.Before(s.nextSweep) {
+		return
+	}
+	for key, e := range s.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			s.remove(key, e)
+		}
+	}
+	s.nextSweep = now.Add(s.window / 2)
+}
+
+// idempotent wraps a handler so that requests carrying an Idempotency-Key
+// header are executed at most once per key within the configured window.
+// Retries with the same key and body get the original response replayed,
+// retries with a different body are rejected with 422. The body, which is
+// read up front to fingerprint the request, may be at most max bytes, the
+// limit of the handler. Requests without the header are passed through
+// unchanged.
+func (r *appRouter) idempotent(max int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, req)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLen))
+			return
+		}
+		body, err := readLimitedBody(w, req, max)
+		if err != nil {
+			logOf(req).Error("Error while reading request body: ", err)
+			r.respondWithError(w, req, err)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(req, body)
+		// keys are scoped to the tenant and the user, nobody can replay the
+		// responses of others
+		tenant := r.tenantOf(req).id
+		scoped := tenant + "/" + strconv.Quote(principalFromContext(req.Context())) + "/" + key
+
+		for {
+			e, owner, err := r.idem.begin(tenant, scoped, fingerprint)
+			if err != nil {
+				logOf(req).Warn("Error while remembering idempotent request: ", err)
+				r.respondWithError(w, req, err)
+				return
+			}
+			if owner {
+				rec := newResponseRecorder(w)
+				next(rec, req)
+				if rec.status >= http.StatusInternalServerError {
+					r.idem.abort(scoped, e)
+				} else {
+					r.idem.finish(e, rec.status, rec.Header().Clone(), rec.body.Bytes())
+				}
+				return
+			}
+			if e.fingerprint != fingerprint {
+				r.respondWithError(w, req, message.Errorf(errIdempotencyKeyReused, "%s %q was already used with a different request", idempotencyKeyHeader, key))
+				return
+			}
+			select {
+			case <-e.done:
+			case <-req.Context().Done():
+				return
+			}
+			// An aborted entry has no response, race for ownership again.
+			if e.status == 0 {
+				continue
+			}
+			logOf(req).Infof("Replaying response for %s %q", idempotencyKeyHeader, key)
+			replayResponse(w, e)
+			return
+		}
+	}
+}
+
+func requestFingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replayResponse(w http.ResponseWriter, e *idempotencyEntry) {
+	for k, v := range e.header {
+		// The request ID belongs to the retry, not to the original request.
+		if k != requestIDHeader {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set(idempotentReplayHeader, "true")
+	w.WriteHeader(e.status)
+	if _, err := w.Write(e.body); err != nil {
+		log.Error("Error while replaying idempotent response: ", err)
+	}
+}
+
+// responseRecorder passes a response through to the underlying writer while
+// keeping a copy of the status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w}
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func postWithKey(r *appRouter, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(w, req)
+	return w
+}
+
+func Test_appRouter_idempotent(t *testing.T) {
+	r := newTestRouter()
+
+	first := postWithKey(r, "key-1", `{"text":"sample"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, "", first.Header().Get("Idempotent-Replayed"))
+
+	retry := postWithKey(r, "key-1", `{"text":"sample"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+
+	mismatch := postWithKey(r, "key-1", `{"text":"other"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+	var p Problem
+	assert.NoError(t, json.Unmarshal(mismatch.Body.Bytes(), &p))
+	assert.Equal(t, "idempotency_key_reused", p.Code)
+
+	// client errors are remembered and replayed as well
+	invalid := postWithKey(r, "key-2", `{"text":""}`)
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	invalid = postWithKey(r, "key-2", `{"text":""}`)
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	assert.Equal(t, "true", invalid.Header().Get("Idempotent-Replayed"))
+
+	msgs, err := r.m.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
+
+func Test_appRouter_idempotent_concurrent(t *testing.T) {
+	r := newTestRouter()
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 20)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := postWithKey(r, "key-concurrent", `{"text":"sample"}`)
+			assert.Equal(t, http.StatusCreated, w.Code)
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, b := range bodies {
+		assert.Equal(t, bodies[0], b)
+	}
+	msgs, err := r.m.GetAll()
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
+
+func Test_appRouter_idempotentPerUser(t *testing.T) {
+	r := newTestRouter(WithBasicAuth(map[string]string{"ann": "secret", "bob": "secret"}))
+	post := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"text":"sample"}`))
+		req.SetBasicAuth(user, "secret")
+		req.Header.Set("Idempotency-Key", "key-1")
+		w := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	ann := post("ann")
+	assert.Equal(t, http.StatusCreated, ann.Code)
+	// users of the same tenant do not get each other's responses
+	bob := post("bob")
+	assert.Equal(t, http.StatusCreated, bob.Code)
+	assert.Equal(t, "", bob.Header().Get("Idempotent-Replayed"))
+	assert.NotEqual(t, ann.Body.String(), bob.Body.String())
+	assert.Equal(t, "true", post("ann").Header().Get("Idempotent-Replayed"))
+}
+
+func Test_appRouter_idempotentLimits(t *testing.T) {
+	r := newTestRouter()
+	defer r.tenants.close()
+
+	// bodies are limited before they are buffered
+	big := `{"text":"` + strings.Repeat("x", maxMessageBytes) + `"}`
+	w := postWithKey(r, "key-big", big)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "payload_too_large")
+
+	// a tenant can not remember more than max responses at a time
+	r.idem.max = 2
+	assert.Equal(t, http.StatusCreated, postWithKey(r, "key-1", `{"text":"one"}`).Code)
+	assert.Equal(t, http.StatusCreated, postWithKey(r, "key-2", `{"text":"two"}`).Code)
+	w = postWithKey(r, "key-3", `{"text":"three"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "quota_exceeded")
+	// known keys are still replayed
+	assert.Equal(t, "true", postWithKey(r, "key-1", `{"text":"one"}`).Header().Get("Idempotent-Replayed"))
+
+	// expired responses make room
+	r.idem.Lock()
+	for _, e := range r.idem.entries {
+		e.expires = time.Now().Add(-time.Second)
+	}
+	r.idem.Unlock()
+	assert.Equal(t, http.StatusCreated, postWithKey(r, "key-3", `{"text":"three"}`).Code)
+	assert.Len(t, r.idem.entries, 1)
+}
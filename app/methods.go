@@ -0,0 +1,86 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+// errMethodNotAllowed is returned for a request to a known route with a
+// method the route does not support.
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// routeMethods are the methods probed to find what a route supports.
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// withMiddleware wraps handlers that gorilla/mux invokes without running the
+// router middleware, i.e. the not found and method not allowed handlers.
+func (r *appRouter) withMiddleware(h http.Handler) http.Handler {
+	return requestID(r.t.startTracing(discardHeadBody(h)))
+}
+
+// allowedMethods returns the methods the routes matching the path of req
+// support, or nil if no route matches.
+func (r *appRouter) allowedMethods(req *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		probe := *req
+		probe.Method = method
+		var match mux.RouteMatch
+		if r.router.Match(&probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	if len(allowed) > 0 {
+		allowed = append(allowed, "OPTIONS")
+	}
+	return allowed
+}
+
+func (r *appRouter) options(w http.ResponseWriter, req *http.Request) {
+	allowed := r.allowedMethods(req)
+	if allowed == nil {
+		r.notFound(w, req)
+		return
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	r.addSpan(req.Context(), http.StatusNoContent, req)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *appRouter) notFound(w http.ResponseWriter, req *http.Request) {
+	r.respondWithError(w, req, message.Errorf(message.ErrNotFound, "No route for %s %s", req.Method, req.URL.Path))
+}
+
+func (r *appRouter) methodNotAllowed(w http.ResponseWriter, req *http.Request) {
+	allowed := r.allowedMethods(req)
+	// The catch-all OPTIONS route makes every path look known to mux.
+	if allowed == nil {
+		r.notFound(w, req)
+		return
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	r.respondWithError(w, req, message.Errorf(errMethodNotAllowed, "Method %s is not allowed for %s", req.Method, req.URL.Path))
+}
+
+// discardHeadBody drops the body written by GET handlers serving a HEAD
+// request, leaving status and headers untouched.
+func discardHeadBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "HEAD" {
+			w = headResponseWriter{w}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
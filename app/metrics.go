@@ -1,6 +1,7 @@
 package app
 
 import (
+	"crypto/tls"
 	"io"
 	"net/http"
 	"net/http/httptrace"
@@ -9,17 +10,56 @@ import (
 
 	"github.com/opentracing/opentracing-go"
 	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/uber/jaeger-client-go"
 	jaegerprom "github.com/uber/jaeger-lib/metrics/prometheus"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// messagesExpired counts the expired messages reclaimed by the janitor of
+// every message store served, by tenant.
+var messagesExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "messages_expired_total",
+	Help: "Number of messages deleted because their ttl elapsed.",
+}, []string{"tenant"})
+
+// openAPIResponseViolations counts the responses not matching the OpenAPI
+// document, by operation.
+var openAPIResponseViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "openapi_response_violations_total",
+	Help: "Number of responses not matching the OpenAPI document, by operation.",
+}, []string{"operation"})
+
+// grpcHandled counts the calls of the gRPC API by method, status code and
+// tenant, grpcHandlingSeconds measures how long they took. The tenant is
+// empty for calls failing before it is resolved and for the health service.
+var (
+	grpcHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Number of gRPC calls completed, by method, status code and tenant.",
+	}, []string{"grpc_method", "grpc_code", "tenant"})
+	grpcHandlingSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Duration of gRPC calls until completion, by method and tenant.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"grpc_method", "tenant"})
+)
+
+// NewClientTrace returns hooks that log the DNS, connect, TLS handshake and
+// first response byte events of an outgoing request on span.
 func NewClientTrace(span opentracing.Span) *httptrace.ClientTrace {
 	trace := &clientTrace{span: span}
 	return &httptrace.ClientTrace{
-		DNSStart: trace.dnsStart,
-		DNSDone:  trace.dnsDone,
+		DNSStart:             trace.dnsStart,
+		DNSDone:              trace.dnsDone,
+		ConnectStart:         trace.connectStart,
+		ConnectDone:          trace.connectDone,
+		TLSHandshakeStart:    trace.tlsHandshakeStart,
+		TLSHandshakeDone:     trace.tlsHandshakeDone,
+		GotConn:              trace.gotConn,
+		GotFirstResponseByte: trace.gotFirstResponseByte,
 	}
 }
 
@@ -40,6 +80,45 @@ func (h *clientTrace) dnsDone(httptrace.DNSDoneInfo) {
 	h.span.LogKV(otlog.String("event", "DNS done"))
 }
 
+func (h *clientTrace) connectStart(network, addr string) {
+	h.span.LogKV(
+		otlog.String("event", "Connect start"),
+		otlog.String("network", network),
+		otlog.String("addr", addr),
+	)
+}
+
+func (h *clientTrace) connectDone(network, addr string, err error) {
+	fields := []otlog.Field{otlog.String("event", "Connect done"), otlog.String("addr", addr)}
+	if err != nil {
+		fields = append(fields, otlog.Error(err))
+	}
+	h.span.LogFields(fields...)
+}
+
+func (h *clientTrace) tlsHandshakeStart() {
+	h.span.LogKV(otlog.String("event", "TLS handshake start"))
+}
+
+func (h *clientTrace) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	fields := []otlog.Field{otlog.String("event", "TLS handshake done"), otlog.Bool("resumed", state.DidResume)}
+	if err != nil {
+		fields = append(fields, otlog.Error(err))
+	}
+	h.span.LogFields(fields...)
+}
+
+func (h *clientTrace) gotConn(info httptrace.GotConnInfo) {
+	h.span.LogKV(
+		otlog.String("event", "Got connection"),
+		otlog.Bool("reused", info.Reused),
+	)
+}
+
+func (h *clientTrace) gotFirstResponseByte() {
+	h.span.LogKV(otlog.String("event", "First response byte"))
+}
+
 type LogrusAdapter struct{}
 
 func (l LogrusAdapter) Error(msg string) {
@@ -60,6 +139,15 @@ type tracerObj struct {
 	tracer   opentracing.Tracer
 }
 
+// get returns the tracer, the global one, a no-op tracer unless set, if
+// tracing was not initialized.
+func (t *tracerObj) get() opentracing.Tracer {
+	if t.tracer == nil {
+		return opentracing.GlobalTracer()
+	}
+	return t.tracer
+}
+
 func (t *tracerObj) initTracing(lib string, host, address string) error {
 	factory := jaegerprom.New()
 	metrics := jaeger.NewMetrics(factory, map[string]string{"lib": lib})
@@ -90,7 +178,7 @@ func (t *tracerObj) initTracing(lib string, host, address string) error {
 
 func (t *tracerObj) startTracing(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		span := t.tracer.StartSpan(req.URL.Path)
+		span := t.get().StartSpan(req.URL.Path)
 		span.SetTag(string(ext.Component), "client")
 		defer span.Finish()
 
@@ -108,7 +196,11 @@ func (t *tracerObj) startTracing(next http.Handler) http.Handler {
 	})
 }
 
+// Close closes the tracer set up by initTracing, if any.
 func (t *tracerObj) Close() {
+	if t.closer == nil {
+		return
+	}
 	t.reporter.Close()
 	err := t.closer.Close()
 	if err != nil {
@@ -0,0 +1,253 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	pb "github.com/shailendra-k-singh/example.messaging.service/pkg/messagingpb"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Media types of the encodings messages are served in, besides CSV.
+const (
+	jsonContentType     = "application/json"
+	msgpackContentType  = "application/msgpack"
+	protobufContentType = "application/x-protobuf"
+	xmlContentType      = "application/xml"
+)
+
+// errNotAcceptable is the kind of errors about requests accepting none of
+// the media types a route serves, errUnsupportedMediaType of errors about
+// request bodies in a media type a route does not read.
+var (
+	errNotAcceptable        = errors.New("not acceptable")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// The media types single messages and lists of messages are served in, the
+// default first. Messages are encoded as the Message of messaging.proto in
+// protobuf, lists only in CSV.
+var (
+	messageMediaTypes = []string{jsonContentType, msgpackContentType, protobufContentType, xmlContentType}
+	listMediaTypes    = []string{jsonContentType, msgpackContentType, xmlContentType, csvContentType}
+)
+
+// encoding encodes response bodies in a media type and, if decode is set,
+// decodes request bodies.
+type encoding struct {
+	contentType string
+	encode      func(w io.Writer, v interface{}) error
+	decode      func(data []byte, v interface{}) error
+}
+
+// encodings holds the encodings by media type.
+var encodings = map[string]encoding{
+	jsonContentType:     {"application/json; charset=utf-8", encodeJSON, json.Unmarshal},
+	msgpackContentType:  {msgpackContentType, encodeMsgpack, decodeMsgpack},
+	protobufContentType: {protobufContentType, encodeProtobuf, decodeProtobuf},
+	xmlContentType:      {"application/xml; charset=utf-8", encodeXML, xml.Unmarshal},
+	csvContentType:      {"text/csv; charset=utf-8", encodeCSV, nil},
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// MessagePack follows the json tags, so that fields are named alike.
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(v)
+}
+
+func decodeMsgpack(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func encodeProtobuf(w io.Writer, v interface{}) error {
+	msg, ok := v.(message.MessageObj)
+	if !ok {
+		return fmt.Errorf("can not encode %T as protobuf", v)
+	}
+	b, err := proto.Marshal(messageToProto(msg))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// decodeProtobuf decodes a CreateRequest of messaging.proto.
+func decodeProtobuf(data []byte, v interface{}) error {
+	body, ok := v.(*MsgRequestBody)
+	if !ok {
+		return fmt.Errorf("can not decode protobuf into %T", v)
+	}
+	var req pb.CreateRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		return err
+	}
+	b, err := createRequestBody(&req)
+	if err != nil {
+		return err
+	}
+	*body = b
+	return nil
+}
+
+// xmlMessages is the XML document of a list of messages.
+type xmlMessages struct {
+	XMLName  xml.Name             `xml:"messages"`
+	Messages []message.MessageObj `xml:"message"`
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	switch v := v.(type) {
+	case message.MessageObj:
+		return enc.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: "message"}})
+	case []message.MessageObj:
+		return enc.Encode(xmlMessages{Messages: v})
+	}
+	return enc.Encode(v)
+}
+
+// encodeCSV writes a list of messages with the columns of CSV dumps.
+func encodeCSV(w io.Writer, v interface{}) error {
+	msgs, ok := v.([]message.MessageObj)
+	if !ok {
+		return fmt.Errorf("can not encode %T as CSV", v)
+	}
+	dw, err := message.NewDumpWriter(w, message.FormatCSV)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if err := dw.Write(msg); err != nil {
+			return err
+		}
+	}
+	return dw.Flush()
+}
+
+// mediaRange is a media range of an Accept header with its quality.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+	return ranges
+}
+
+// specificity returns how specifically rg matches mediaType, -1 if it does
+// not match it.
+func (rg mediaRange) specificity(mediaType string) int {
+	switch {
+	case rg.mediaType == mediaType:
+		return 2
+	case rg.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(rg.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rg.mediaType, "*")):
+		return 1
+	}
+	return -1
+}
+
+// negotiate returns the media type of offers the client prefers according
+// to the Accept header of req, the first one if the client does not say.
+// Since the response varies with the header, it is added to Vary.
+func negotiate(w http.ResponseWriter, req *http.Request, offers []string) (string, error) {
+	w.Header().Add("Vary", "Accept")
+	accept := strings.Join(req.Header["Accept"], ",")
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], nil
+	}
+	ranges := parseAccept(accept)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, rg := range ranges {
+			if s := rg.specificity(offer); s > specificity {
+				q, specificity = rg.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if best == "" {
+		return "", message.Errorf(errNotAcceptable, "Accept %q matches none of the media types served, should accept one of %s", accept, strings.Join(offers, ", "))
+	}
+	return best, nil
+}
+
+// decodeBody decodes the request body data into v according to the
+// Content-Type of req, JSON if it is not set.
+func decodeBody(req *http.Request, data []byte, v interface{}) error {
+	mediaType := jsonContentType
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ = mime.ParseMediaType(ct)
+	}
+	e, ok := encodings[mediaType]
+	if !ok || e.decode == nil {
+		return message.Errorf(errUnsupportedMediaType, "Unsupported Content-Type %q, should be one of %s", req.Header.Get("Content-Type"), strings.Join(messageMediaTypes, ", "))
+	}
+	if err := e.decode(data, v); err != nil {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		return message.Errorf(message.ErrInvalid, "Invalid request body")
+	}
+	return nil
+}
+
+// encodedResponse writes resp to w in mediaType, one of encodings. The body
+// is encoded before anything is written, so that encoding errors can still
+// be reported.
+func (r *appRouter) encodedResponse(w http.ResponseWriter, req *http.Request, mediaType string, resp interface{}, code int) {
+	if mediaType == jsonContentType {
+		jsonResponse(w, resp, code)
+		return
+	}
+	e := encodings[mediaType]
+	var buf bytes.Buffer
+	if err := e.encode(&buf, resp); err != nil {
+		logOf(req).Errorf("Error while encoding response as %s: %s", mediaType, err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	w.Header().Set("Content-Type", e.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logOf(req).Error("Error while writing response: ", err)
+	}
+}
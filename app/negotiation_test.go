@@ -0,0 +1,146 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	pb "github.com/shailendra-k-singh/example.messaging.service/pkg/messagingpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func Test_negotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+	}{
+		{accept: "", offers: messageMediaTypes, want: jsonContentType},
+		{accept: "*/*", offers: messageMediaTypes, want: jsonContentType},
+		{accept: "application/msgpack", offers: messageMediaTypes, want: msgpackContentType},
+		{accept: "application/xml;q=0.5, application/x-protobuf", offers: messageMediaTypes, want: protobufContentType},
+		{accept: "application/*;q=0.2, application/xml", offers: messageMediaTypes, want: xmlContentType},
+		{accept: "application/json;q=0, */*;q=0.1", offers: messageMediaTypes, want: msgpackContentType},
+		{accept: "text/*", offers: listMediaTypes, want: csvContentType},
+		{accept: "text/html, bogus", offers: messageMediaTypes},
+		{accept: "application/x-protobuf", offers: listMediaTypes},
+		{accept: "application/json;q=0", offers: messageMediaTypes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/messages", nil)
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			got, err := negotiate(w, req, tt.offers)
+			assert.Equal(t, tt.want, got)
+			if tt.want == "" {
+				assert.True(t, errors.Is(err, errNotAcceptable), err)
+			}
+			assert.Equal(t, "Accept", w.Header().Get("Vary"))
+		})
+	}
+}
+
+func Test_appRouter_contentNegotiation(t *testing.T) {
+	r := newTestRouter(WithOpenAPIValidation())
+	defer r.tenants.close()
+	serve := func(method, path, contentType, accept string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	// protobuf in, MessagePack out
+	body, err := proto.Marshal(&pb.CreateRequest{Text: "binary", Tags: []string{"a", "b"}})
+	assert.NoError(t, err)
+	w := serve("POST", "/v1/messages", protobufContentType, msgpackContentType, body)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, msgpackContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	var created message.MessageObj
+	assert.NoError(t, decodeMsgpack(w.Body.Bytes(), &created))
+	assert.Equal(t, message.MessageObj{Id: 1, Text: "binary", Tags: []string{"a", "b"}}, created)
+
+	// XML in, protobuf out
+	w = serve("PUT", "/v1/messages/1", xmlContentType, protobufContentType,
+		[]byte(`<message><text>edited</text><tag>c</tag></message>`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, protobufContentType, w.Header().Get("Content-Type"))
+	var updated pb.Message
+	assert.NoError(t, proto.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, "edited", updated.Text)
+	assert.Equal(t, []string{"c"}, updated.Tags)
+
+	w = serve("GET", "/v1/messages/1", "", xmlContentType, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `<message><id>1</id><text>edited</text><tag>c</tag></message>`)
+
+	w = serve("GET", "/v1/messages", "", xmlContentType, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list xmlMessages
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Messages, 1)
+	assert.Equal(t, "edited", list.Messages[0].Text)
+
+	w = serve("GET", "/v1/messages", "", "text/csv", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "id,text,author,tags\n1,edited,,c\n", w.Body.String())
+
+	w = serve("GET", "/v1/messages", "", "", nil)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header()["Vary"], "Accept")
+
+	// unsupported types are rejected by the handlers, and by the validation
+	// alike
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		accept      string
+		status      int
+		code        string
+	}{
+		{name: "list as protobuf", method: "GET", path: "/v1/messages", accept: protobufContentType, status: http.StatusNotAcceptable, code: "not_acceptable"},
+		{name: "message as html", method: "GET", path: "/v1/messages/1", accept: "text/html", status: http.StatusNotAcceptable, code: "not_acceptable"},
+		{name: "create from yaml", method: "POST", path: "/v1/messages", contentType: "application/yaml", status: http.StatusUnsupportedMediaType, code: "unsupported_media_type"},
+		{name: "update from csv", method: "PUT", path: "/v1/messages/1", contentType: csvContentType, status: http.StatusUnsupportedMediaType, code: "unsupported_media_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range []*appRouter{r, appRouterObj} {
+				req := httptest.NewRequest(tt.method, tt.path, strings.NewReader("text: x"))
+				if tt.contentType != "" {
+					req.Header.Set("Content-Type", tt.contentType)
+				}
+				if tt.accept != "" {
+					req.Header.Set("Accept", tt.accept)
+				}
+				w := httptest.NewRecorder()
+				r.GetRouter().ServeHTTP(w, req)
+				assert.Equal(t, tt.status, w.Code)
+				assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+				var p Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+				assert.Equal(t, tt.code, p.Code)
+			}
+		})
+	}
+	assert.Empty(t, openAPIViolations(t), "responses not matching the OpenAPI document")
+}
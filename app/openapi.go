@@ -0,0 +1,202 @@
+package app
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	openAPIPath = "/openapi.json"
+	apiDocsPath = "/docs"
+)
+
+// apiDocsPage renders the OpenAPI document with Swagger UI, whose assets
+// are embedded in the binary, see swaggerUI.
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Messaging Service API</title>
+  <link rel="stylesheet" href="` + apiDocsPath + `/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="` + apiDocsPath + `/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      SwaggerUIBundle({url: "` + openAPIPath + `", dom_id: "#swagger-ui", deepLinking: true});
+    };
+  </script>
+</body>
+</html>
+`
+
+// swaggerUI holds the Swagger UI assets of the docs page, served under
+// apiDocsPath so that the page works without access to a CDN.
+//
+//go:embed swaggerui/swagger-ui.css swaggerui/swagger-ui-bundle.js
+var swaggerUI embed.FS
+
+// swaggerUITypes are the content types of the Swagger UI assets by
+// extension, not left to the MIME tables of the system.
+var swaggerUITypes = map[string]string{
+	".css": "text/css; charset=utf-8",
+	".js":  "text/javascript; charset=utf-8",
+}
+
+// WithOpenAPIValidation rejects requests to the API not matching the
+// OpenAPI document with 400, or 415 for bodies of a media type not read,
+// and logs and counts the responses not matching
+// it. Streamed responses are not checked.
+func WithOpenAPIValidation() Option {
+	return func(r *appRouter) {
+		r.validateAPI = true
+	}
+}
+
+func (r *appRouter) serveOpenAPI(w http.ResponseWriter, req *http.Request) {
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, r.spec, http.StatusOK)
+}
+
+func (r *appRouter) serveAPIDocs(w http.ResponseWriter, req *http.Request) {
+	r.addSpan(req.Context(), http.StatusOK, req)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(apiDocsPage)); err != nil {
+		logOf(req).Error("Error while writing response: ", err)
+	}
+}
+
+// serveAPIDocsAsset serves a Swagger UI asset of the docs page.
+func (r *appRouter) serveAPIDocsAsset(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["asset"]
+	b, err := swaggerUI.ReadFile(path.Join("swaggerui", name))
+	if err != nil {
+		r.respondWithError(w, req, message.Errorf(message.ErrNotFound, "Asset %s not found", name))
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	w.Header().Set("Content-Type", swaggerUITypes[path.Ext(name)])
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, req, name, r.started, bytes.NewReader(b))
+}
+
+// validateOpenAPI checks requests and responses against the operation of
+// the OpenAPI document matching the route served.
+func (r *appRouter) validateOpenAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := r.openAPIRoute(req)
+		if route == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		input := &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: mux.Vars(req),
+			Route:      route,
+			Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+		}
+		if err := r.validateRequest(input); err != nil {
+			logOf(req).Error("error validating request: ", err)
+			r.respondWithError(w, req, err)
+			return
+		}
+		if req.Method == "HEAD" || websocket.IsWebSocketUpgrade(req) || route.Operation.Extensions[streamingExtension] != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, req)
+		err := openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rec.status,
+			Header:                 rec.Header(),
+			Body:                   ioutil.NopCloser(bytes.NewReader(rec.body.Bytes())),
+			Options:                input.Options,
+		})
+		if err != nil {
+			logOf(req).Errorf("Response of %s does not match the OpenAPI document: %s", route.Operation.OperationID, err)
+			openAPIResponseViolations.WithLabelValues(route.Operation.OperationID).Inc()
+		}
+	})
+}
+
+// openAPIRoute returns the operation of the document serving req, nil if
+// there is none.
+func (r *appRouter) openAPIRoute(req *http.Request) *routers.Route {
+	current := mux.CurrentRoute(req)
+	if current == nil {
+		return nil
+	}
+	path, err := current.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+	item := r.spec.Paths[path]
+	if item == nil {
+		return nil
+	}
+	method := req.Method
+	if method == "HEAD" {
+		method = "GET"
+	}
+	op := item.GetOperation(method)
+	if op == nil {
+		return nil
+	}
+	return &routers.Route{Spec: r.spec, Path: path, PathItem: item, Method: method, Operation: op}
+}
+
+// validateRequest validates the request of input. A body sent without
+// Content-Type is validated as the type the handlers assume then.
+func (r *appRouter) validateRequest(input *openapi3filter.RequestValidationInput) error {
+	req := input.Request
+	if body := input.Route.Operation.RequestBody; body != nil && req.Header.Get("Content-Type") == "" && req.ContentLength != 0 {
+		contentType := ndjsonContentType
+		if body.Value.Content.Get("application/json") != nil {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+		defer req.Header.Del("Content-Type")
+	}
+	err := openapi3filter.ValidateRequest(req.Context(), input)
+	if err == nil {
+		return nil
+	}
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		return message.Errorf(message.ErrInvalid, "Invalid request: %s", err)
+	}
+	if reqErr.RequestBody != nil && strings.HasPrefix(reqErr.Reason, "header Content-Type has unexpected value") {
+		return message.Errorf(errUnsupportedMediaType, "Unsupported Content-Type %q", req.Header.Get("Content-Type"))
+	}
+	reason := reqErr.Reason
+	var schemaErr *openapi3.SchemaError
+	if errors.As(reqErr.Err, &schemaErr) {
+		reason = schemaErr.Reason
+		if ptr := schemaErr.JSONPointer(); len(ptr) > 0 {
+			reason = fmt.Sprintf("%s at /%s", reason, strings.Join(ptr, "/"))
+		}
+	} else if reason == "" && reqErr.Err != nil {
+		reason = reqErr.Err.Error()
+	}
+	if p := reqErr.Parameter; p != nil {
+		return message.Errorf(message.ErrInvalid, "Invalid value for %s param %s: %s", p.In, p.Name, reason)
+	}
+	return message.Errorf(message.ErrInvalid, "Invalid request body: %s", reason)
+}
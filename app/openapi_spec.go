@@ -0,0 +1,613 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/graphql-go/graphql"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+// streamingExtension marks operations whose responses are streamed, which
+// the validation middleware passes through unchecked.
+const streamingExtension = "x-streaming"
+
+// schemaGen generates the schemas of Go types from their JSON encoding.
+// Named struct types become components referenced by the schemas using them.
+type schemaGen struct {
+	components openapi3.Schemas
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// ref returns the schema of the type of v.
+func (g schemaGen) ref(v interface{}) *openapi3.SchemaRef {
+	return g.schemaOf(reflect.TypeOf(v))
+}
+
+func (g schemaGen) schemaOf(t reflect.Type) *openapi3.SchemaRef {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return openapi3.NewDateTimeSchema().NewRef()
+	case rawMessageType:
+		return openapi3.NewSchema().NewRef()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return openapi3.NewBoolSchema().NewRef()
+	case reflect.Int64:
+		return openapi3.NewInt64Schema().NewRef()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openapi3.NewIntegerSchema().NewRef()
+	case reflect.Float32, reflect.Float64:
+		return openapi3.NewFloat64Schema().NewRef()
+	case reflect.String:
+		return openapi3.NewStringSchema().NewRef()
+	case reflect.Slice, reflect.Array:
+		s := openapi3.NewArraySchema()
+		s.Items = g.schemaOf(t.Elem())
+		return s.NewRef()
+	case reflect.Map:
+		s := openapi3.NewObjectSchema()
+		s.AdditionalProperties = g.schemaOf(t.Elem())
+		return s.NewRef()
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t).NewRef()
+		}
+		ref := "#/components/schemas/" + t.Name()
+		if c, ok := g.components[t.Name()]; ok {
+			return &openapi3.SchemaRef{Ref: ref, Value: c.Value}
+		}
+		// registered before it is filled in, for recursive types
+		s := &openapi3.Schema{}
+		g.components[t.Name()] = s.NewRef()
+		*s = *g.structSchema(t)
+		return &openapi3.SchemaRef{Ref: ref, Value: s}
+	}
+	// interfaces, anything goes
+	return openapi3.NewSchema().NewRef()
+}
+
+// structSchema returns the schema of struct t. Fields without omitempty are
+// always encoded, so they are required and, if they may be nil, nullable.
+// The fields of embedded structs are inlined like encoding/json does.
+func (g schemaGen) structSchema(t reflect.Type) *openapi3.Schema {
+	s := openapi3.NewObjectSchema()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts := f.Name, ""
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) > 1 {
+				opts = parts[1]
+			}
+			if f.Anonymous && parts[0] == "" {
+				name = ""
+			}
+		} else if f.Anonymous {
+			name = ""
+		}
+		if f.Anonymous && name == "" {
+			embedded := g.structSchema(f.Type)
+			for prop, ref := range embedded.Properties {
+				s.WithPropertyRef(prop, ref)
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		ref := g.schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+			switch f.Type.Kind() {
+			case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+				if ref.Ref != "" {
+					ref = (&openapi3.Schema{Nullable: true, AllOf: openapi3.SchemaRefs{ref}}).NewRef()
+				} else {
+					ref.Value.Nullable = true
+				}
+			}
+		}
+		s.WithPropertyRef(name, ref)
+	}
+	return s
+}
+
+// specBuilder builds the OpenAPI document of the API.
+type specBuilder struct {
+	doc     *openapi3.T
+	schemas schemaGen
+}
+
+// param returns a reference to the parameter component name.
+func (b *specBuilder) param(name string) *openapi3.ParameterRef {
+	return &openapi3.ParameterRef{Ref: "#/components/parameters/" + name, Value: b.doc.Components.Parameters[name].Value}
+}
+
+// operation returns a new operation tagged with tag, with the parameters
+// of the default tenant and params.
+func (b *specBuilder) operation(tag, id, summary string, params ...*openapi3.ParameterRef) *openapi3.Operation {
+	op := openapi3.NewOperation()
+	op.Tags = []string{tag}
+	op.OperationID = id
+	op.Summary = summary
+	if tag != "meta" && tag != "graphql" {
+		op.Parameters = append(op.Parameters, b.param("tenant"))
+	}
+	op.Parameters = append(op.Parameters, params...)
+	op.Responses = openapi3.Responses{"default": b.problem()}
+	return op
+}
+
+func (b *specBuilder) problem() *openapi3.ResponseRef {
+	return &openapi3.ResponseRef{Ref: "#/components/responses/Problem", Value: b.doc.Components.Responses["Problem"].Value}
+}
+
+// problems documents the error statuses of op.
+func (b *specBuilder) problems(op *openapi3.Operation, statuses ...int) {
+	for _, status := range statuses {
+		op.Responses[strconv.Itoa(status)] = b.problem()
+	}
+}
+
+// body documents the JSON request body of op, of the type of v.
+func (b *specBuilder) body(op *openapi3.Operation, description string, v interface{}) {
+	op.RequestBody = &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody().
+		WithDescription(description).
+		WithRequired(true).
+		WithJSONSchemaRef(b.schemas.ref(v))}
+}
+
+// respond documents a JSON response of op with status, of the type of v,
+// or without body if v is nil.
+func (b *specBuilder) respond(op *openapi3.Operation, status int, description string, v interface{}) *openapi3.Response {
+	resp := openapi3.NewResponse().WithDescription(description)
+	if v != nil {
+		resp.WithJSONSchemaRef(b.schemas.ref(v))
+	}
+	op.Responses[strconv.Itoa(status)] = &openapi3.ResponseRef{Value: resp}
+	return resp
+}
+
+// negotiated documents that op serves its response with status in
+// mediaTypes, the first of which is JSON, and reads its request body in the
+// ones it can decode.
+func (b *specBuilder) negotiated(op *openapi3.Operation, status int, mediaTypes []string) {
+	for _, t := range mediaTypes[1:] {
+		op.Responses[strconv.Itoa(status)].Value.Content[t] = openapi3.NewMediaType()
+		if op.RequestBody != nil && encodings[t].decode != nil {
+			op.RequestBody.Value.Content[t] = openapi3.NewMediaType()
+		}
+	}
+	b.problems(op, http.StatusNotAcceptable)
+	if op.RequestBody != nil {
+		b.problems(op, http.StatusUnsupportedMediaType)
+	}
+}
+
+// cached documents the conditional requests of op and the validators of its
+// response with status.
+func (b *specBuilder) cached(op *openapi3.Operation, status int) {
+	op.Parameters = append(op.Parameters, b.param("ifNoneMatch"), b.param("ifModifiedSince"))
+	resp := op.Responses[strconv.Itoa(status)].Value
+	if resp.Headers == nil {
+		resp.Headers = openapi3.Headers{}
+	}
+	resp.Headers["ETag"] = header("Validator of the response, for If-None-Match.", openapi3.NewStringSchema())
+	resp.Headers["Last-Modified"] = header("When the response last changed, for If-Modified-Since.", openapi3.NewStringSchema())
+	resp.Headers["Cache-Control"] = header("The cache policy of the operation, see -cache-control.", openapi3.NewStringSchema())
+	op.Responses["304"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().
+		WithDescription("Not modified since the client got the response with the ETag or date given.")}
+}
+
+// add adds op as method on path.
+func (b *specBuilder) add(method, path string, op *openapi3.Operation) {
+	b.doc.AddOperation(path, method, op)
+}
+
+// addScoped adds op as method on path, a route of the default channel,
+// and on the same route of every other channel.
+func (b *specBuilder) addScoped(method, path string, op *openapi3.Operation) {
+	b.add(method, path, op)
+	scoped := *op
+	scoped.OperationID = op.OperationID + "InChannel"
+	scoped.Parameters = append(openapi3.Parameters{b.param("channel")}, op.Parameters...)
+	b.add(method, "/v1/channels/{channel}"+strings.TrimPrefix(path, "/v1"), &scoped)
+}
+
+// streaming marks op as streaming its responses.
+func streaming(op *openapi3.Operation) {
+	op.Extensions = map[string]interface{}{streamingExtension: true}
+}
+
+func header(description string, schema *openapi3.Schema) *openapi3.HeaderRef {
+	h := &openapi3.Header{}
+	h.Description = description
+	h.Schema = schema.NewRef()
+	return &openapi3.HeaderRef{Value: h}
+}
+
+func queryParam(name, description string, schema *openapi3.Schema) *openapi3.ParameterRef {
+	return &openapi3.ParameterRef{Value: openapi3.NewQueryParameter(name).WithDescription(description).WithSchema(schema)}
+}
+
+func pathParam(name, description string, schema *openapi3.Schema) *openapi3.ParameterRef {
+	return &openapi3.ParameterRef{Value: openapi3.NewPathParameter(name).WithDescription(description).WithSchema(schema)}
+}
+
+func content(types ...string) openapi3.Content {
+	c := openapi3.Content{}
+	for _, t := range types {
+		c[t] = openapi3.NewMediaType()
+	}
+	return c
+}
+
+// newOpenAPISpec returns the OpenAPI document of the HTTP API. The drift
+// test keeps it in step with the routes of SetRoutes.
+func newOpenAPISpec() *openapi3.T {
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:       "Messaging Service",
+			Description: "Stores short text messages and serves them over REST, GraphQL and gRPC.",
+			Version:     "v1",
+		},
+		Components: openapi3.Components{
+			Schemas:    openapi3.Schemas{},
+			Parameters: openapi3.ParametersMap{},
+			Responses:  openapi3.Responses{},
+			SecuritySchemes: openapi3.SecuritySchemes{
+				"basic": &openapi3.SecuritySchemeRef{Value: openapi3.NewSecurityScheme().WithType("http").WithScheme("basic").
+					WithDescription("Required if the server was started with -auth-file.")},
+			},
+		},
+		// basic auth is optional, depending on the server configuration
+		Security: openapi3.SecurityRequirements{{"basic": []string{}}, {}},
+		Tags: openapi3.Tags{
+			{Name: "messages", Description: "Messages of the default channel, and of every other channel under /v1/channels/{channel}."},
+			{Name: "channels", Description: "Channels with their own messages and settings."},
+			{Name: "queues", Description: "Work queues with leases, retries and dead-letter queues."},
+			{Name: "webhooks", Description: "HTTP callbacks for message events."},
+			{Name: "events", Description: "Live message events."},
+			{Name: "graphql", Description: "The GraphQL API."},
+			{Name: "meta", Description: "Documentation, metrics and health."},
+		},
+	}
+	b := &specBuilder{doc: doc, schemas: schemaGen{components: doc.Components.Schemas}}
+
+	c := &doc.Components
+	c.Responses["Problem"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().
+		WithDescription("An RFC 7807 problem, code is one of the stable problem codes.").
+		WithContent(openapi3.NewContentWithSchemaRef(b.schemas.ref(Problem{}), []string{problemContentType}))}
+	c.Parameters["tenant"] = &openapi3.ParameterRef{Value: openapi3.NewHeaderParameter(tenantHeader).
+		WithDescription("The tenant served, default if not set. Users assigned to a tenant may only name theirs.").
+		WithSchema(openapi3.NewStringSchema().WithPattern(queueNamePattern.String()).WithMaxLength(maxChannelNameLen))}
+	c.Parameters["channel"] = pathParam("channel", "Name of the channel.", openapi3.NewStringSchema())
+	c.Parameters["id"] = pathParam("id", "ID of the message.", openapi3.NewInt64Schema().WithMin(1))
+	c.Parameters["include"] = queryParam("include", "Set to scheduled to include the messages not delivered yet.",
+		openapi3.NewStringSchema().WithEnum("scheduled"))
+	c.Parameters["idempotencyKey"] = &openapi3.ParameterRef{Value: openapi3.NewHeaderParameter(idempotencyKeyHeader).
+		WithDescription("Replays the response to an earlier request with the same key instead of creating messages again.").
+		WithSchema(openapi3.NewStringSchema().WithMaxLength(maxIdempotencyKeyLen))}
+	c.Parameters["ifNoneMatch"] = &openapi3.ParameterRef{Value: openapi3.NewHeaderParameter("If-None-Match").
+		WithDescription("ETags of responses the client has, answered with 304 if one is current.").
+		WithSchema(openapi3.NewStringSchema())}
+	c.Parameters["ifModifiedSince"] = &openapi3.ParameterRef{Value: openapi3.NewHeaderParameter("If-Modified-Since").
+		WithDescription("Answered with 304 if the response did not change since, ignored with If-None-Match.").
+		WithSchema(openapi3.NewStringSchema())}
+	c.Parameters["queue"] = pathParam("name", "Name of the queue.", openapi3.NewStringSchema())
+	c.Parameters["webhook"] = pathParam("id", "ID of the webhook.", openapi3.NewInt64Schema().WithMin(1))
+	boolean := openapi3.NewBoolSchema
+
+	// messages
+	op := b.operation("messages", "listMessages", "Lists the messages in ID order.", b.param("include"),
+		queryParam("page_size", "Paginates the list with pages of at most this many messages.", openapi3.NewIntegerSchema().WithMin(1).WithMax(maxPageSize)),
+		queryParam("page_token", "Token of the page, from the Link header of the previous one.", openapi3.NewInt64Schema().WithMin(1)))
+	b.respond(op, http.StatusOK, "The messages.", []message.MessageObj{}).
+		Headers = openapi3.Headers{"Link": header("Link to the next page, if any.", openapi3.NewStringSchema())}
+	b.problems(op, http.StatusBadRequest)
+	// an empty list is not found rather than an empty array
+	op.Responses["404"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().
+		WithDescription("There are no messages, or the channel does not exist.").
+		WithContent(openapi3.NewContentWithSchemaRef(b.schemas.ref(Problem{}), []string{problemContentType}))}
+	b.negotiated(op, http.StatusOK, listMediaTypes)
+	b.cached(op, http.StatusOK)
+	b.addScoped("GET", "/v1/messages", op)
+
+	op = b.operation("messages", "createMessage", "Creates a message.", b.param("idempotencyKey"))
+	b.body(op, "The message.", MsgRequestBody{})
+	b.respond(op, http.StatusCreated, "The created message.", message.MessageObj{}).
+		Headers = openapi3.Headers{"Location": header("Path of the created message.", openapi3.NewStringSchema())}
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity, http.StatusTooManyRequests)
+	b.negotiated(op, http.StatusCreated, messageMediaTypes)
+	b.addScoped("POST", "/v1/messages", op)
+
+	op = b.operation("messages", "batchCreateMessages", "Creates up to 10000 messages, reporting the outcome per message.",
+		b.param("idempotencyKey"), queryParam("atomic", "Creates none of the messages if any is invalid.", boolean()))
+	items := openapi3.NewArraySchema().WithMinItems(1).WithMaxItems(maxBatchItems)
+	items.Items = openapi3.NewSchema().NewRef() // invalid items are reported per item
+	op.RequestBody = &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody().WithRequired(true).
+		WithDescription("The messages, as a JSON array or one JSON document per line.").
+		WithContent(openapi3.Content{
+			"application/json": openapi3.NewMediaType().WithSchema(items),
+			ndjsonContentType:  openapi3.NewMediaType(),
+		})}
+	b.respond(op, http.StatusOK, "The outcome per message.", BatchResponse{})
+	b.respond(op, http.StatusUnprocessableEntity, "An atomic batch was rejected, the outcome per message.", BatchResponse{})
+	b.problems(op, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusTooManyRequests)
+	b.add("POST", "/v1/messages:batch", op)
+
+	op = b.operation("messages", "batchDeleteMessages", "Deletes messages, reporting the outcome per message.",
+		queryParam("atomic", "Deletes none of the messages if any can not be deleted.", boolean()))
+	b.body(op, "The IDs of the messages.", BatchDeleteRequestBody{})
+	b.respond(op, http.StatusOK, "The outcome per message.", BatchResponse{})
+	b.respond(op, http.StatusUnprocessableEntity, "An atomic batch was rejected, the outcome per message.", BatchResponse{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("POST", "/v1/messages:batchDelete", op)
+
+	op = b.operation("messages", "exportMessages", "Exports every message, scheduled and expired ones included.",
+		queryParam("format", "Format of the dump, from the Accept header if not set.", openapi3.NewStringSchema().WithEnum(message.FormatNDJSON, message.FormatCSV)))
+	op.Responses["200"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("The dump.").
+		WithContent(content(ndjsonContentType, csvContentType))}
+	streaming(op)
+	b.problems(op, http.StatusBadRequest)
+	b.add("GET", "/v1/messages:export", op)
+
+	op = b.operation("messages", "importMessages", "Imports a dump of messages.",
+		queryParam("format", "Format of the dump, from the Content-Type header if not set.", openapi3.NewStringSchema().WithEnum(message.FormatNDJSON, message.FormatCSV)),
+		queryParam("preserve_ids", "Keeps the IDs of the dump, failing the messages whose ID is taken.", boolean()))
+	op.RequestBody = &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody().WithRequired(true).
+		WithDescription("The dump.").WithContent(content(ndjsonContentType, csvContentType))}
+	b.respond(op, http.StatusOK, "The number of imported messages and the failures.", ImportResponse{})
+	b.problems(op, http.StatusBadRequest, http.StatusRequestEntityTooLarge)
+	b.add("POST", "/v1/messages:import", op)
+
+	op = b.operation("messages", "getMessage", "Returns a message.", b.param("id"), b.param("include"),
+		&openapi3.ParameterRef{Value: openapi3.NewQueryParameter("is-palindrome").
+			WithDescription("Set without value to check whether the text is a palindrome.").
+			WithSchema(openapi3.NewStringSchema().WithMaxLength(0))})
+	op.Parameters[len(op.Parameters)-1].Value.AllowEmptyValue = true
+	b.respond(op, http.StatusOK, "The message.", message.MessageObj{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.negotiated(op, http.StatusOK, messageMediaTypes)
+	b.cached(op, http.StatusOK)
+	b.addScoped("GET", "/v1/messages/{id}", op)
+
+	op = b.operation("messages", "updateMessage", "Replaces the text and tags of a message, keeping a revision.", b.param("id"))
+	b.body(op, "The message, fields other than text and tags are ignored.", MsgRequestBody{})
+	b.respond(op, http.StatusOK, "The updated message.", message.MessageObj{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge)
+	b.negotiated(op, http.StatusOK, messageMediaTypes)
+	b.addScoped("PUT", "/v1/messages/{id}", op)
+
+	op = b.operation("messages", "deleteMessage", "Deletes a message, moving it to the trash if the trash is enabled.", b.param("id"),
+		queryParam("hard", "Deletes the message for good, admins only.", boolean()))
+	b.respond(op, http.StatusNoContent, "The message was deleted.", nil)
+	b.problems(op, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound)
+	b.addScoped("DELETE", "/v1/messages/{id}", op)
+
+	op = b.operation("messages", "getReplies", "Returns the replies to a message.", b.param("id"), b.param("include"),
+		queryParam("depth", "Levels of replies returned.", openapi3.NewIntegerSchema().WithMin(1).WithMax(maxReplyDepth).WithDefault(defaultReplyDepth)))
+	b.respond(op, http.StatusOK, "The replies, with their replies up to depth.", []message.Thread{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.addScoped("GET", "/v1/messages/{id}/replies", op)
+
+	op = b.operation("messages", "getThread", "Returns the thread a message belongs to, from its root.", b.param("id"), b.param("include"))
+	b.respond(op, http.StatusOK, "The thread.", message.Thread{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.addScoped("GET", "/v1/messages/{id}/thread", op)
+
+	op = b.operation("messages", "getRevisions", "Returns the revisions of a message, or the difference between two of them.", b.param("id"),
+		queryParam("diff", "Two revisions like 1..2, returns their difference.", openapi3.NewStringSchema().WithPattern(`^[0-9]+\.\.[0-9]+$`)),
+		queryParam("unit", "Unit of the difference.", openapi3.NewStringSchema().WithEnum(string(message.DiffLines), string(message.DiffWords))))
+	revisions := openapi3.NewOneOfSchema(b.schemas.ref([]message.Revision{}).Value, b.schemas.ref(message.RevisionDiff{}).Value)
+	op.Responses["200"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().
+		WithDescription("The revisions, oldest first, or their difference if diff is set.").
+		WithJSONSchema(revisions)}
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.addScoped("GET", "/v1/messages/{id}/revisions", op)
+
+	op = b.operation("messages", "getRevision", "Returns a revision of a message.", b.param("id"),
+		pathParam("rev", "Version of the revision.", openapi3.NewIntegerSchema().WithMin(1)))
+	b.respond(op, http.StatusOK, "The revision.", message.Revision{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.addScoped("GET", "/v1/messages/{id}/revisions/{rev}", op)
+
+	op = b.operation("messages", "undeleteMessage", "Restores a message from the trash.", b.param("id"))
+	b.respond(op, http.StatusOK, "The restored message.", message.MessageObj{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)
+	b.addScoped("POST", "/v1/messages/{id}:undelete", op)
+
+	op = b.operation("messages", "listTrash", "Lists the deleted messages that can be restored.")
+	b.respond(op, http.StatusOK, "The deleted messages.", []message.MessageObj{})
+	b.addScoped("GET", "/v1/trash", op)
+
+	// channels
+	op = b.operation("channels", "listChannels", "Lists the channels, the default channel first.")
+	b.respond(op, http.StatusOK, "The channels.", []Channel{})
+	b.add("GET", "/v1/channels", op)
+
+	op = b.operation("channels", "createChannel", "Creates a channel.")
+	b.body(op, "The channel.", ChannelRequestBody{})
+	b.respond(op, http.StatusCreated, "The created channel.", Channel{}).
+		Headers = openapi3.Headers{"Location": header("Path of the created channel.", openapi3.NewStringSchema())}
+	b.problems(op, http.StatusBadRequest, http.StatusConflict, http.StatusTooManyRequests)
+	b.add("POST", "/v1/channels", op)
+
+	op = b.operation("channels", "getChannel", "Returns a channel.", b.param("channel"))
+	b.respond(op, http.StatusOK, "The channel.", Channel{})
+	b.problems(op, http.StatusNotFound)
+	b.add("GET", "/v1/channels/{channel}", op)
+
+	op = b.operation("channels", "updateChannel", "Changes the settings of a channel.", b.param("channel"))
+	b.body(op, "The settings, unset ones are kept.", ChannelRequestBody{})
+	b.respond(op, http.StatusOK, "The updated channel.", Channel{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)
+	b.add("PUT", "/v1/channels/{channel}", op)
+
+	op = b.operation("channels", "deleteChannel", "Deletes a channel with its messages.", b.param("channel"))
+	b.respond(op, http.StatusNoContent, "The channel was deleted.", nil)
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict)
+	b.add("DELETE", "/v1/channels/{channel}", op)
+
+	// queues
+	op = b.operation("queues", "getQueue", "Returns the policy and statistics of a queue.", b.param("queue"))
+	b.respond(op, http.StatusOK, "The queue.", QueueInfo{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("GET", "/v1/queues/{name}", op)
+
+	op = b.operation("queues", "putQueue", "Sets the policy of a queue.", b.param("queue"))
+	b.body(op, "The policy.", message.QueuePolicy{})
+	b.respond(op, http.StatusOK, "The queue.", QueueInfo{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("PUT", "/v1/queues/{name}", op)
+
+	op = b.operation("queues", "listQueueMessages", "Lists the messages of a queue.", b.param("queue"))
+	b.respond(op, http.StatusOK, "The messages.", []message.MessageObj{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("GET", "/v1/queues/{name}/messages", op)
+
+	op = b.operation("queues", "receiveMessages", "Leases visible messages of a queue.", b.param("queue"))
+	b.body(op, "How many messages to lease for how long.", ReceiveRequestBody{})
+	op.RequestBody.Value.Required = false
+	b.respond(op, http.StatusOK, "The leased messages with their receipts.", ReceiveResponse{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("POST", "/v1/queues/{name}/receive", op)
+
+	op = b.operation("queues", "ackMessages", "Deletes received messages, reporting the outcome per receipt.", b.param("queue"))
+	b.body(op, "The receipts.", ReceiptsRequestBody{})
+	b.respond(op, http.StatusOK, "The outcome per receipt.", BatchResponse{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("POST", "/v1/queues/{name}/ack", op)
+
+	op = b.operation("queues", "nackMessages", "Returns received messages to the queue, reporting the outcome per receipt.", b.param("queue"))
+	b.body(op, "The receipts, the delay before the messages are visible again and the reason of the failure.", ReceiptsRequestBody{})
+	b.respond(op, http.StatusOK, "The outcome per receipt.", BatchResponse{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("POST", "/v1/queues/{name}/nack", op)
+
+	op = b.operation("queues", "redriveMessages", "Moves dead-lettered messages back to their queue.", b.param("queue"))
+	b.body(op, "The messages to move, all if not set, and where to.", RedriveRequestBody{})
+	op.RequestBody.Value.Required = false
+	b.respond(op, http.StatusOK, "The moved messages.", RedriveResponse{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.add("POST", "/v1/queues/{name}/redrive", op)
+
+	op = b.operation("queues", "purgeQueue", "Deletes every message of a queue.", b.param("queue"))
+	b.respond(op, http.StatusOK, "The number of deleted messages.", PurgeResponse{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("POST", "/v1/queues/{name}/purge", op)
+
+	// webhooks
+	op = b.operation("webhooks", "listWebhooks", "Lists the webhooks.")
+	b.respond(op, http.StatusOK, "The webhooks.", []Webhook{})
+	b.add("GET", "/v1/webhooks", op)
+
+	op = b.operation("webhooks", "createWebhook", "Registers a webhook.")
+	b.body(op, "The webhook.", WebhookRequestBody{})
+	b.respond(op, http.StatusCreated, "The registered webhook.", Webhook{}).
+		Headers = openapi3.Headers{"Location": header("Path of the webhook.", openapi3.NewStringSchema())}
+	b.problems(op, http.StatusBadRequest)
+	b.add("POST", "/v1/webhooks", op)
+
+	op = b.operation("webhooks", "getWebhook", "Returns a webhook.", b.param("webhook"))
+	b.respond(op, http.StatusOK, "The webhook.", Webhook{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.add("GET", "/v1/webhooks/{id}", op)
+
+	op = b.operation("webhooks", "deleteWebhook", "Deletes a webhook.", b.param("webhook"))
+	b.respond(op, http.StatusNoContent, "The webhook was deleted.", nil)
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.add("DELETE", "/v1/webhooks/{id}", op)
+
+	op = b.operation("webhooks", "listDeliveries", "Lists the recent deliveries of a webhook.", b.param("webhook"))
+	b.respond(op, http.StatusOK, "The deliveries.", []Delivery{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.add("GET", "/v1/webhooks/{id}/deliveries", op)
+
+	// events
+	op = b.operation("events", "streamEvents", "Streams message events as Server-Sent Events.",
+		queryParam("tag", "Only events of messages with this tag.", openapi3.NewStringSchema()),
+		queryParam("author", "Only events of messages of this author.", openapi3.NewStringSchema()),
+		queryParam("last_event_id", "Resumes after this event, like the Last-Event-ID header.", openapi3.NewInt64Schema().WithMin(0)),
+		&openapi3.ParameterRef{Value: openapi3.NewHeaderParameter("Last-Event-ID").
+			WithDescription("Resumes after this event.").WithSchema(openapi3.NewInt64Schema().WithMin(0))})
+	op.Responses["200"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().
+		WithDescription("The event stream, every event carries a JSON encoded Event.").
+		WithContent(content(eventStreamContentType))}
+	streaming(op)
+	b.schemas.ref(message.Event{})
+	b.problems(op, http.StatusBadRequest, http.StatusNotFound)
+	b.addScoped("GET", "/v1/messages/events", op)
+
+	op = b.operation("events", "serveWebSocket", "Upgrades to a WebSocket connection publishing messages and streaming events as JSON Frames.")
+	op.Responses["101"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("Switching to the WebSocket protocol.")}
+	b.schemas.ref(Frame{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("GET", "/v1/ws", op)
+
+	// graphql
+	gqlParams := []*openapi3.ParameterRef{
+		queryParam("query", "The GraphQL document.", openapi3.NewStringSchema()),
+		queryParam("operationName", "The operation to execute.", openapi3.NewStringSchema()),
+		queryParam("variables", "The variables, a JSON object.", openapi3.NewStringSchema()),
+	}
+	op = b.operation("graphql", "queryGraphQL", "Executes a GraphQL query, or upgrades to a graphql-transport-ws WebSocket connection.", gqlParams...)
+	b.respond(op, http.StatusOK, "The GraphQL result.", graphql.Result{})
+	op.Responses["101"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("Switching to the WebSocket protocol.")}
+	b.problems(op, http.StatusBadRequest)
+	b.add("GET", graphQLPath, op)
+
+	op = b.operation("graphql", "executeGraphQL", "Executes a GraphQL query or mutation.")
+	b.body(op, "The GraphQL request.", graphQLRequest{})
+	b.respond(op, http.StatusOK, "The GraphQL result.", graphql.Result{})
+	b.problems(op, http.StatusBadRequest)
+	b.add("POST", graphQLPath, op)
+
+	// meta
+	op = b.operation("meta", "root", "Greets the client.")
+	b.respond(op, http.StatusOK, "A greeting.", "")
+	b.add("GET", "/", op)
+
+	op = b.operation("meta", "metrics", "Returns the Prometheus metrics of the server.")
+	op.Responses["200"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("The metrics.").WithContent(content("text/plain"))}
+	b.add("GET", "/metrics", op)
+
+	op = b.operation("meta", "getOpenAPI", "Returns this document.")
+	op.Responses["200"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("The OpenAPI document.").
+		WithJSONSchema(openapi3.NewObjectSchema())}
+	b.add("GET", openAPIPath, op)
+
+	op = b.operation("meta", "getAPIDocs", "Renders this document.")
+	op.Responses["200"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("The API documentation.").WithContent(content("text/html"))}
+	b.add("GET", apiDocsPath, op)
+
+	op = b.operation("meta", "getAPIDocsAsset", "Returns a Swagger UI asset of the API documentation.",
+		pathParam("asset", "File name of the asset.", openapi3.NewStringSchema()))
+	op.Responses["200"] = &openapi3.ResponseRef{Value: openapi3.NewResponse().WithDescription("The asset.").WithContent(content("text/css", "text/javascript"))}
+	b.problems(op, http.StatusNotFound)
+	b.add("GET", apiDocsPath+"/{asset}", op)
+
+	return doc
+}
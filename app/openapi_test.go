@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// openAPIViolations returns the operations whose responses did not match
+// the OpenAPI document.
+func openAPIViolations(t *testing.T) []string {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.NoError(t, err)
+	var ops []string
+	for _, f := range families {
+		if f.GetName() != "openapi_response_violations_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			ops = append(ops, m.GetLabel()[0].GetValue())
+		}
+	}
+	return ops
+}
+
+func Test_appRouter_openAPI(t *testing.T) {
+	r := newTestRouter(WithOpenAPIValidation())
+	defer r.tenants.close()
+	assert.NoError(t, r.spec.Validate(context.Background()))
+
+	w := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", openAPIPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	doc, err := openapi3.NewLoader().LoadFromData(w.Body.Bytes())
+	assert.NoError(t, err)
+	assert.NoError(t, doc.Validate(context.Background()))
+	assert.Equal(t, "listMessages", doc.Paths["/v1/messages"].Get.OperationID)
+	assert.NotNil(t, doc.Paths["/v1/messages"].Get.Responses["404"], "an empty list is not found")
+	assert.Contains(t, doc.Components.Schemas["Thread"].Value.Properties, "replies")
+
+	w = httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", apiDocsPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `url: "`+openAPIPath+`"`)
+	assert.NotContains(t, w.Body.String(), "https://", "the docs page loads no remote assets")
+
+	for _, asset := range []string{"swagger-ui.css", "swagger-ui-bundle.js"} {
+		w = httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", apiDocsPath+"/"+asset, nil))
+		assert.Equal(t, http.StatusOK, w.Code, asset)
+		assert.Equal(t, swaggerUITypes[path.Ext(asset)], w.Header().Get("Content-Type"), asset)
+		assert.NotEmpty(t, w.Body.Bytes(), asset)
+		assert.Contains(t, w.Body.String(), "swagger", asset)
+	}
+	w = httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", apiDocsPath+"/LICENSE", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, openAPIViolations(t), "getAPIDocsAsset")
+}
+
+// Test_appRouter_openAPIDrift fails when a route is added to SetRoutes
+// without documenting it, or documented without being served.
+func Test_appRouter_openAPIDrift(t *testing.T) {
+	r := newTestRouter()
+	defer r.tenants.close()
+
+	routes := map[string]bool{}
+	err := r.GetRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil // the OPTIONS catch-all
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			if m != "HEAD" {
+				routes[m+" "+path] = true
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	documented := map[string]bool{}
+	for path, item := range r.spec.Paths {
+		for m := range item.Operations() {
+			documented[m+" "+path] = true
+		}
+	}
+	assert.Equal(t, sortedKeys(routes), sortedKeys(documented))
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Test_appRouter_openAPIValidation(t *testing.T) {
+	r := newTestRouter(WithOpenAPIValidation())
+	defer r.tenants.close()
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		ctype   string
+		status  int
+		code    string
+		message string
+	}{
+		{name: "query param", method: "GET", path: "/v1/messages?page_size=0",
+			message: "Invalid value for query param page_size: number must be at least 1"},
+		{name: "path param", method: "GET", path: "/v1/messages/0",
+			message: "Invalid value for path param id: number must be at least 1"},
+		{name: "body field", method: "POST", path: "/v1/messages", body: `{"text":"sample","tags":"urgent"}`,
+			message: "Invalid request body: Field must be set to array or not be present at /tags"},
+		{name: "content type", method: "POST", path: "/v1/messages", body: `{"text":"sample"}`, ctype: "text/plain",
+			status: http.StatusUnsupportedMediaType, code: "unsupported_media_type", message: `Unsupported Content-Type "text/plain"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.ctype != "" {
+				req.Header.Set("Content-Type", tt.ctype)
+			}
+			w := httptest.NewRecorder()
+			r.GetRouter().ServeHTTP(w, req)
+
+			status, code := tt.status, tt.code
+			if status == 0 {
+				status, code = http.StatusBadRequest, "invalid_argument"
+			}
+			assert.Equal(t, status, w.Code)
+			var p Problem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, code, p.Code)
+			assert.Equal(t, tt.message, p.Detail)
+		})
+	}
+
+	// responses not matching the document are served, logged and counted
+	openAPIResponseViolations.Reset()
+	r.spec.Paths["/"].Get.Responses["200"].Value.Content["application/json"].Schema = openapi3.NewIntegerSchema().NewRef()
+	w := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"root"}, openAPIViolations(t))
+	openAPIResponseViolations.Reset()
+}
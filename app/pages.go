@@ -0,0 +1,64 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const maxPageSize = 1000
+
+// page is a window on a list of messages in ID order, read from the
+// page_size and page_token query params. Lists are not paginated unless
+// page_size is set.
+type page struct {
+	size  int
+	after int64
+}
+
+func readPage(req *http.Request) (page, error) {
+	var p page
+	q := req.URL.Query()
+	if val := q.Get("page_size"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 || n > maxPageSize {
+			return p, message.Errorf(message.ErrInvalid, "Invalid value %q for query param page_size, should be in range 1-%d", val, maxPageSize)
+		}
+		p.size = n
+	}
+	if val := q.Get("page_token"); val != "" {
+		after, err := strconv.ParseInt(val, 10, defaultBitsize)
+		if err != nil || after < 1 {
+			return p, message.Errorf(message.ErrInvalid, "Invalid value %q for query param page_token", val)
+		}
+		p.after = after
+	}
+	return p, nil
+}
+
+// apply returns the messages of msgs on the page and the token of the next
+// page, empty if this is the last one.
+func (p page) apply(msgs []message.MessageObj) ([]message.MessageObj, string) {
+	start := 0
+	for start < len(msgs) && msgs[start].Id <= p.after {
+		start++
+	}
+	msgs = msgs[start:]
+	if p.size == 0 || len(msgs) <= p.size {
+		return msgs, ""
+	}
+	msgs = msgs[:p.size]
+	return msgs, strconv.FormatInt(msgs[len(msgs)-1].Id, 10)
+}
+
+// setNextLink points the Link header of the response to req at the page
+// after token.
+func setNextLink(w http.ResponseWriter, req *http.Request, token string) {
+	q := req.URL.Query()
+	q.Set("page_token", token)
+	next := url.URL{Path: req.URL.Path, RawQuery: q.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Code is a stable machine
+// readable identifier clients can switch on; Detail is meant for humans and
+// may change between releases.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Problem codes that do not originate from the message package.
+const (
+	codeInternal = "internal"
+)
+
+// problemKind is how errors of kind are reported by the APIs.
+type problemKind struct {
+	kind   error
+	status int
+	code   string
+	grpc   codes.Code
+}
+
+// problemKinds maps the error kinds of the message package, and the few
+// kinds specific to the HTTP layer, to HTTP statuses and problem codes, and
+// to the status codes of the gRPC API. Any error not matching one of these
+// is reported as internalKind.
+var problemKinds = []problemKind{
+	{message.ErrInvalid, http.StatusBadRequest, "invalid_argument", codes.InvalidArgument},
+	{message.ErrNotFound, http.StatusNotFound, "not_found", codes.NotFound},
+	{message.ErrConflict, http.StatusConflict, "conflict", codes.Aborted},
+	{message.ErrQuotaExceeded, http.StatusTooManyRequests, "quota_exceeded", codes.ResourceExhausted},
+	{errUnauthenticated, http.StatusUnauthorized, "unauthenticated", codes.Unauthenticated},
+	{errForbidden, http.StatusForbidden, "permission_denied", codes.PermissionDenied},
+	{errMethodNotAllowed, http.StatusMethodNotAllowed, "method_not_allowed", codes.Unimplemented},
+	{errNotAcceptable, http.StatusNotAcceptable, "not_acceptable", codes.InvalidArgument},
+	{errUnsupportedMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type", codes.InvalidArgument},
+	{errPayloadTooLarge, http.StatusRequestEntityTooLarge, "payload_too_large", codes.ResourceExhausted},
+	{errIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused", codes.FailedPrecondition},
+	{message.ErrUnavailable, http.StatusServiceUnavailable, "unavailable", codes.Unavailable},
+}
+
+var internalKind = problemKind{status: http.StatusInternalServerError, code: codeInternal, grpc: codes.Internal}
+
+// kindOf returns the problem kind of err.
+func kindOf(err error) problemKind {
+	for _, k := range problemKinds {
+		if errors.Is(err, k.kind) {
+			return k
+		}
+	}
+	return internalKind
+}
+
+// newProblem builds the problem details for err as a response to req.
+func newProblem(req *http.Request, err error) Problem {
+	k := kindOf(err)
+	p := Problem{
+		Type:      "about:blank",
+		Status:    k.status,
+		Code:      k.code,
+		Instance:  req.URL.RequestURI(),
+		RequestID: requestIDFromContext(req.Context()),
+	}
+	p.Title = http.StatusText(p.Status)
+	// Internal errors may leak implementation details, keep them in the logs.
+	if p.Status != http.StatusInternalServerError {
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// respondWithError writes err to w as problem+json and records the resulting
+// status on the request span.
+func (r *appRouter) respondWithError(w http.ResponseWriter, req *http.Request, err error) {
+	p := newProblem(req, err)
+	// set by cacheControl for the success response
+	w.Header().Del("Cache-Control")
+	r.addSpan(req.Context(), p.Status, req)
+	writeResponse(w, problemContentType, p, p.Status)
+}
+
+// grpcError converts err to the gRPC status error of its kind. Like
+// problems, internal errors carry no details.
+func grpcError(err error) error {
+	k := kindOf(err)
+	if k.status == http.StatusInternalServerError {
+		return status.Error(codes.Internal, "internal error")
+	}
+	return status.Error(k.grpc, err.Error())
+}
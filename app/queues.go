@@ -0,0 +1,309 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	maxQueueNameLen          = 80
+	maxReceiveMessages       = 100
+	defaultVisibilityTimeout = 30
+	maxVisibilityTimeout     = 12 * 60 * 60
+	maxFailureReasonLen      = 1024
+	maxReceiveCount          = 1000
+)
+
+var queueNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ReceiveRequestBody is the request body of POST /v1/queues/{name}/receive.
+// MaxMessages defaults to 1 and VisibilityTimeout, in seconds, to 30.
+type ReceiveRequestBody struct {
+	MaxMessages       int `json:"max_messages,omitempty"`
+	VisibilityTimeout int `json:"visibility_timeout,omitempty"`
+}
+
+// ReceiveResponse lists the leased messages, it is empty if the queue has no
+// visible messages.
+type ReceiveResponse struct {
+	Messages []message.Lease `json:"messages"`
+}
+
+// ReceiptsRequestBody is the request body of the ack and nack endpoints.
+// Delay, in seconds, and Reason only apply to nack: the released messages
+// stay hidden for Delay and Reason is recorded as their failure.
+type ReceiptsRequestBody struct {
+	Receipts []string `json:"receipts"`
+	Delay    int      `json:"delay,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+// QueueInfo describes a queue.
+type QueueInfo struct {
+	Name   string              `json:"name"`
+	Policy message.QueuePolicy `json:"policy"`
+	message.QueueStats
+}
+
+// RedriveRequestBody is the request body of POST /v1/queues/{name}/redrive.
+// Without Ids every dead-lettered message is moved; without To messages go
+// back to the queue they were dead-lettered from.
+type RedriveRequestBody struct {
+	Ids []int64 `json:"ids,omitempty"`
+	To  string  `json:"to,omitempty"`
+}
+
+// RedriveResponse lists the moved messages.
+type RedriveResponse struct {
+	Messages []message.MessageObj `json:"messages"`
+}
+
+// PurgeResponse reports how many messages a purge deleted.
+type PurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+func validateQueueName(name string) error {
+	if len(name) > maxQueueNameLen || !queueNamePattern.MatchString(name) {
+		return message.Errorf(message.ErrInvalid, "Invalid queue name %q, must be 1-%d letters, digits, '-' or '_'", name, maxQueueNameLen)
+	}
+	return nil
+}
+
+// queueName returns the validated queue name of the request path.
+func queueName(req *http.Request) (string, error) {
+	name := mux.Vars(req)["name"]
+	return name, validateQueueName(name)
+}
+
+func (r *appRouter) receiveMessages(w http.ResponseWriter, req *http.Request) {
+	queue, err := queueName(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	body := ReceiveRequestBody{}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Invalid request body"))
+		return
+	}
+	if body.MaxMessages == 0 {
+		body.MaxMessages = 1
+	}
+	if body.VisibilityTimeout == 0 {
+		body.VisibilityTimeout = defaultVisibilityTimeout
+	}
+	if body.MaxMessages < 1 || body.MaxMessages > maxReceiveMessages {
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "max_messages must be in range 1-%d", maxReceiveMessages))
+		return
+	}
+	if body.VisibilityTimeout < 1 || body.VisibilityTimeout > maxVisibilityTimeout {
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "visibility_timeout must be in range 1-%d seconds", maxVisibilityTimeout))
+		return
+	}
+
+	leases, err := r.tenantOf(req).m.Receive(queue, body.MaxMessages, time.Duration(body.VisibilityTimeout)*time.Second)
+	if err != nil {
+		logOf(req).Error("error while receiving messages: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, ReceiveResponse{Messages: leases}, http.StatusOK)
+	logOf(req).Infof("Received %d messages from queue %s", len(leases), queue)
+}
+
+// readReceipts reads and validates the body of an ack or nack request.
+func readReceipts(req *http.Request) (string, ReceiptsRequestBody, error) {
+	body := ReceiptsRequestBody{}
+	queue, err := queueName(req)
+	if err != nil {
+		return "", body, err
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		return "", body, message.Errorf(message.ErrInvalid, "Invalid request body, must be in the format {\"receipts\": [\"...\"]}")
+	}
+	if len(body.Receipts) == 0 || len(body.Receipts) > maxReceiveMessages {
+		return "", body, message.Errorf(message.ErrInvalid, "Request must contain 1-%d receipts", maxReceiveMessages)
+	}
+	if body.Delay < 0 || body.Delay > maxVisibilityTimeout {
+		return "", body, message.Errorf(message.ErrInvalid, "delay must be in range 0-%d seconds", maxVisibilityTimeout)
+	}
+	return queue, body, nil
+}
+
+// receiptResults turns the outcome of an ack or nack into a BatchResponse.
+func receiptResults(req *http.Request, errs []error, okStatus int) BatchResponse {
+	resp := BatchResponse{Results: make([]BatchItemResult, len(errs))}
+	for i, err := range errs {
+		resp.Results[i].Index = i
+		if err != nil {
+			p := newProblem(req, err)
+			resp.Results[i].Status, resp.Results[i].Error = p.Status, &p
+			continue
+		}
+		resp.Results[i].Status = okStatus
+		resp.Applied = true
+	}
+	return resp
+}
+
+// ackMessages deletes received messages. Every receipt is acknowledged on
+// its own, the response reports the outcome per receipt.
+func (r *appRouter) ackMessages(w http.ResponseWriter, req *http.Request) {
+	queue, body, err := readReceipts(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	errs, err := r.tenantOf(req).m.Ack(queue, body.Receipts)
+	if err != nil {
+		logOf(req).Error("error while acknowledging messages: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, receiptResults(req, errs, http.StatusNoContent), http.StatusOK)
+	logOf(req).Infof("Acknowledged messages of queue %s", queue)
+}
+
+// nackMessages makes received messages visible again, optionally after a
+// delay.
+func (r *appRouter) nackMessages(w http.ResponseWriter, req *http.Request) {
+	queue, body, err := readReceipts(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	if len(body.Reason) > maxFailureReasonLen {
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "reason must be at most %d characters", maxFailureReasonLen))
+		return
+	}
+	errs, err := r.tenantOf(req).m.Nack(queue, body.Receipts, time.Duration(body.Delay)*time.Second, body.Reason)
+	if err != nil {
+		logOf(req).Error("error while releasing messages: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, receiptResults(req, errs, http.StatusNoContent), http.StatusOK)
+	logOf(req).Infof("Released messages of queue %s", queue)
+}
+
+func queueInfo(m *message.MessageServer, queue string) QueueInfo {
+	return QueueInfo{Name: queue, Policy: m.QueuePolicy(queue), QueueStats: m.QueueStats(queue)}
+}
+
+func (r *appRouter) getQueue(w http.ResponseWriter, req *http.Request) {
+	queue, err := queueName(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, queueInfo(r.tenantOf(req).m, queue), http.StatusOK)
+}
+
+// putQueue sets the policy of a queue.
+func (r *appRouter) putQueue(w http.ResponseWriter, req *http.Request) {
+	queue, err := queueName(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	policy := message.QueuePolicy{}
+	err = json.NewDecoder(req.Body).Decode(&policy)
+	if err != nil {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Invalid request body"))
+		return
+	}
+	if policy.MaxReceiveCount < 0 || policy.MaxReceiveCount > maxReceiveCount {
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "max_receive_count must be in range 0-%d", maxReceiveCount))
+		return
+	}
+	if policy.DeadLetterQueue != "" {
+		if err := validateQueueName(policy.DeadLetterQueue); err != nil {
+			r.respondWithError(w, req, err)
+			return
+		}
+	}
+	err = r.tenantOf(req).m.SetQueuePolicy(queue, policy)
+	if err != nil {
+		logOf(req).Error("error while setting queue policy: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, queueInfo(r.tenantOf(req).m, queue), http.StatusOK)
+	logOf(req).Infof("Set policy of queue %s", queue)
+}
+
+// listQueueMessages returns every message of a queue, leased or not, e.g. to
+// inspect a dead-letter queue.
+func (r *appRouter) listQueueMessages(w http.ResponseWriter, req *http.Request) {
+	queue, err := queueName(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, r.tenantOf(req).m.QueueMessages(queue), http.StatusOK)
+}
+
+func (r *appRouter) redriveMessages(w http.ResponseWriter, req *http.Request) {
+	queue, err := queueName(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	body := RedriveRequestBody{}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Invalid request body"))
+		return
+	}
+	if body.To != "" {
+		if err := validateQueueName(body.To); err != nil {
+			r.respondWithError(w, req, err)
+			return
+		}
+	}
+	moved, err := r.tenantOf(req).m.Redrive(queue, body.Ids, body.To)
+	if err != nil {
+		logOf(req).Error("error while redriving messages: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, RedriveResponse{Messages: moved}, http.StatusOK)
+	logOf(req).Infof("Redrove %d messages from queue %s", len(moved), queue)
+}
+
+func (r *appRouter) purgeQueue(w http.ResponseWriter, req *http.Request) {
+	queue, err := queueName(req)
+	if err != nil {
+		r.respondWithError(w, req, err)
+		return
+	}
+	n, err := r.tenantOf(req).m.Purge(queue)
+	if err != nil {
+		logOf(req).Error("error while purging queue: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, PurgeResponse{Purged: n}, http.StatusOK)
+	logOf(req).Infof("Purged %d messages from queue %s", n, queue)
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_appRouter_queues(t *testing.T) {
+	r := newTestRouter()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+
+	for _, text := range []string{"one", "two"} {
+		rec := serve("POST", "/v1/messages", `{"text":"`+text+`","queue":"jobs"}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	rec := serve("POST", "/v1/queues/jobs/receive", `{"max_messages":5,"visibility_timeout":60}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var received ReceiveResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &received))
+	if !assert.Len(t, received.Messages, 2) {
+		return
+	}
+	assert.Equal(t, 1, received.Messages[0].Message.ReceiveCount)
+	assert.Equal(t, "jobs", received.Messages[0].Message.Queue)
+
+	// nothing left to receive, an empty body takes the defaults
+	rec = serve("POST", "/v1/queues/jobs/receive", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"messages":[]}`, rec.Body.String())
+
+	rec = serve("POST", "/v1/queues/jobs/ack", `{"receipts":["`+received.Messages[0].Receipt+`","9-x"]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var acked BatchResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &acked))
+	assert.True(t, acked.Applied)
+	assert.Equal(t, http.StatusNoContent, acked.Results[0].Status)
+	assert.Equal(t, http.StatusNotFound, acked.Results[1].Status)
+
+	rec = serve("POST", "/v1/queues/jobs/nack", `{"receipts":["`+received.Messages[1].Receipt+`"]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve("POST", "/v1/queues/jobs/receive", `{}`)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &received))
+	if assert.Len(t, received.Messages, 1) {
+		assert.Equal(t, int64(2), received.Messages[0].Message.Id)
+		assert.Equal(t, 2, received.Messages[0].Message.ReceiveCount)
+	}
+}
+
+func Test_appRouter_deadLetterQueue(t *testing.T) {
+	r := newTestRouter()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+
+	rec := serve("PUT", "/v1/queues/jobs", `{"max_receive_count":1,"dead_letter_queue":"jobs-dlq"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"name":"jobs","policy":{"max_receive_count":1,"dead_letter_queue":"jobs-dlq"},"visible":0,"in_flight":0,"scheduled":0}`, rec.Body.String())
+
+	serve("POST", "/v1/messages", `{"text":"poison","queue":"jobs"}`)
+	rec = serve("POST", "/v1/queues/jobs/receive", `{}`)
+	var received ReceiveResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &received))
+	serve("POST", "/v1/queues/jobs/nack", `{"receipts":["`+received.Messages[0].Receipt+`"],"reason":"parse error"}`)
+
+	// the next receive dead-letters the message
+	rec = serve("POST", "/v1/queues/jobs/receive", `{}`)
+	assert.JSONEq(t, `{"messages":[]}`, rec.Body.String())
+	rec = serve("GET", "/v1/queues/jobs-dlq/messages", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"source_queue":"jobs"`)
+	assert.Contains(t, rec.Body.String(), `"reason":"parse error"`)
+
+	rec = serve("POST", "/v1/queues/jobs-dlq/redrive", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"queue":"jobs"`)
+
+	rec = serve("POST", "/v1/queues/jobs/purge", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"purged":1}`, rec.Body.String())
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const defaultRevisionLimit = 100
+
+// WithRevisionLimit keeps at most n revisions per message, in the default
+// channel and in every other channel. Zero keeps all of them. The default
+// is 100.
+func WithRevisionLimit(n int) Option {
+	return func(r *appRouter) {
+		r.revisionLimit = n
+	}
+}
+
+// diffParam returns the versions of the optional diff query param, of the
+// form from..to, and whether it was set.
+func diffParam(req *http.Request) (from, to int, ok bool, err error) {
+	val := req.URL.Query().Get("diff")
+	if val == "" {
+		return 0, 0, false, nil
+	}
+	parts := strings.Split(val, "..")
+	if len(parts) == 2 {
+		from, err = strconv.Atoi(parts[0])
+		if err == nil {
+			to, err = strconv.Atoi(parts[1])
+		}
+		if err == nil && from > 0 && to > 0 {
+			return from, to, true, nil
+		}
+	}
+	return 0, 0, false, message.Errorf(message.ErrInvalid, "Invalid value %q for query param diff, should be two revisions like 1..2", val)
+}
+
+// unitParam returns the value of the optional unit query param.
+func unitParam(req *http.Request) (message.DiffUnit, error) {
+	val := req.URL.Query().Get("unit")
+	if val == "" {
+		return message.DiffLines, nil
+	}
+	return message.ParseDiffUnit(val)
+}
+
+func (r *appRouter) getRevisions(w http.ResponseWriter, req *http.Request) {
+	id, err := r.validateMsgID(req)
+	var from, to int
+	var diff bool
+	if err == nil {
+		from, to, diff, err = diffParam(req)
+	}
+	var unit message.DiffUnit
+	if err == nil {
+		unit, err = unitParam(req)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	var resp interface{}
+	if diff {
+		resp, err = ch.m.Diff(id, from, to, unit)
+	} else {
+		resp, err = ch.m.Revisions(id)
+	}
+	if err != nil {
+		logOf(req).Error("error while retrieving revisions: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Retrieved revisions of message %d successfully", id)
+}
+
+func (r *appRouter) getRevision(w http.ResponseWriter, req *http.Request) {
+	id, err := r.validateMsgID(req)
+	var version int
+	if err == nil {
+		val := mux.Vars(req)["rev"]
+		version, err = strconv.Atoi(val)
+		if err != nil || version < 1 {
+			err = message.Errorf(message.ErrInvalid, "Invalid revision %q, should be a valid positive integer", val)
+		}
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := ch.m.Revision(id, version)
+	if err != nil {
+		logOf(req).Error("error while retrieving revision: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Retrieved revision %d of message %d successfully", version, id)
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_appRouter_revisions(t *testing.T) {
+	r := newTestRouter(WithRevisionLimit(2))
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+
+	serve("POST", "/v1/messages", `{"text":"hello world","author":"ann"}`)
+	serve("PUT", "/v1/messages/1", `{"text":"hello there","author":"bob"}`)
+
+	rec := serve("GET", "/v1/messages/1/revisions", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var revs []message.Revision
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &revs))
+	if assert.Len(t, revs, 2) {
+		assert.Equal(t, "hello world", revs[0].Text)
+		assert.Equal(t, "bob", revs[1].Editor)
+	}
+
+	rec = serve("GET", "/v1/messages/1/revisions/2", "")
+	var rev message.Revision
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rev))
+	assert.Equal(t, "hello there", rev.Text)
+	rec = serve("GET", "/v1/messages/1/revisions/0", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve("GET", "/v1/messages/1/revisions?diff=1..2&unit=word", "")
+	var d message.RevisionDiff
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &d))
+	assert.Equal(t, []message.DiffChange{
+		{Op: message.DiffEqual, Text: "hello "},
+		{Op: message.DiffDelete, Text: "world"},
+		{Op: message.DiffInsert, Text: "there"},
+	}, d.Changes)
+	rec = serve("GET", "/v1/messages/1/revisions?diff=1", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = serve("GET", "/v1/messages/1/revisions?diff=1..2&unit=char", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// the limit drops the oldest revision
+	serve("PUT", "/v1/messages/1", `{"text":"bye"}`)
+	rec = serve("GET", "/v1/messages/1/revisions/1", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// streamWriteTimeout bounds every single write of a long lived streaming
+// response, replacing the server wide write timeout for such responses.
+const streamWriteTimeout = 10 * time.Second
+
+type connKey struct{}
+
+// ConnContext stores the connection of a request in its context so that
+// streaming handlers can manage the write deadline of the connection. It is
+// meant to be used as http.Server.ConnContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// extendWriteDeadline pushes the write deadline of the connection serving
+// req to streamWriteTimeout from now. Without it the server write timeout
+// would cut streaming responses short. It is a no-op if the server was not
+// configured with ConnContext.
+func extendWriteDeadline(req *http.Request) {
+	if c, ok := req.Context().Value(connKey{}).(net.Conn); ok {
+		_ = c.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	}
+}
@@ -0,0 +1,321 @@
+package app
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTenantID  = "default"
+	tenantHeader     = "X-Tenant-ID"
+	tenantBaggageKey = "tenant"
+)
+
+// tenant holds everything a tenant owns: its messages with their ID
+// sequence, its channels and its webhooks. Nothing is shared between
+// tenants, so a request can only ever reach the stores of its own tenant.
+type tenant struct {
+	id       string
+	m        *message.MessageServer
+	channels *channelStore
+	hooks    *webhookStore
+}
+
+// tenantStore holds the tenants other than the default one, which is served
+// from the message store of the appRouter. Configured tenants are created on
+// first use, requests never create others. Without a directory they live in memory only; with one, every tenant is
+// persisted in a subdirectory named after it, laid out like the data
+// directory of the default tenant.
+type tenantStore struct {
+	sync.Mutex
+	dir     string
+	tenants map[string]*tenant
+}
+
+func newTenantStore() *tenantStore {
+	return &tenantStore{tenants: make(map[string]*tenant)}
+}
+
+type tenantKey struct{}
+
+// WithTenants assigns users of WithBasicAuth to tenants, a map of user name
+// to tenant ID. Users not in the map belong to the default tenant.
+func WithTenants(users map[string]string) Option {
+	return func(r *appRouter) {
+		r.userTenants = users
+		for _, id := range users {
+			r.tenantIDs[id] = true
+		}
+	}
+}
+
+// WithTenantIDs serves the tenants ids in addition to the ones of
+// WithTenants, e.g. to requests naming them in X-Tenant-ID without
+// authentication.
+func WithTenantIDs(ids []string) Option {
+	return func(r *appRouter) {
+		for _, id := range ids {
+			r.tenantIDs[id] = true
+		}
+	}
+}
+
+// ReadTenants parses user:tenant lines, as read by -tenants-file. Blank
+// lines and lines starting with # are ignored.
+func ReadTenants(rd io.Reader) (map[string]string, error) {
+	tenants, err := readPairs(rd, "user:tenant")
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range tenants {
+		if err := validateTenantID(id); err != nil {
+			return nil, err
+		}
+	}
+	return tenants, nil
+}
+
+// ParseTenantIDs parses the comma separated tenant IDs read by -tenants.
+func ParseTenantIDs(s string) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if err := validateTenantID(id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func validateTenantID(id string) error {
+	if len(id) > maxChannelNameLen || !queueNamePattern.MatchString(id) {
+		return message.Errorf(message.ErrInvalid, "Invalid tenant %q, must be 1-%d letters, digits, '-' or '_'", id, maxChannelNameLen)
+	}
+	return nil
+}
+
+// OpenTenants persists tenants in dir, opening the configured tenants saved
+// there by an earlier run. Tenants saved there that are no longer configured
+// are logged and left alone. It must be called before the server starts.
+func (r *appRouter) OpenTenants(dir string) error {
+	s := r.tenants
+	s.Lock()
+	defer s.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	s.dir = dir
+	for _, e := range entries {
+		if !e.IsDir() || validateTenantID(e.Name()) != nil || e.Name() == defaultTenantID {
+			continue
+		}
+		if !r.tenantIDs[e.Name()] {
+			log.WithField("tenant", e.Name()).Warn("Not serving the tenant persisted in ", filepath.Join(dir, e.Name()), ", it is not configured")
+			continue
+		}
+		t, err := r.newTenant(e.Name())
+		if err != nil {
+			s.closeAll()
+			return err
+		}
+		s.tenants[t.id] = t
+	}
+	return nil
+}
+
+// initStore sets up a message store of tenant id: the settings of the
+// appRouter and the metrics of the tenant. The store is started once it is
+// set up.
+func (r *appRouter) initStore(m *message.MessageServer, id string) {
+	r.configureStore(m)
+	expired := messagesExpired.WithLabelValues(id)
+	m.OnExpire(func(n int) { expired.Add(float64(n)) })
+	m.Start()
+}
+
+// newTenant opens the stores of tenant id. Callers hold the tenantStore lock.
+func (r *appRouter) newTenant(id string) (*tenant, error) {
+	t := &tenant{id: id, channels: newChannelStore()}
+	if r.tenants.dir == "" {
+		t.m = message.NewMessageServer()
+	} else {
+		var err error
+		t.m, err = message.Open(filepath.Join(r.tenants.dir, id))
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.setupTenant(t)
+	if r.tenants.dir != "" {
+		if err := t.channels.open(filepath.Join(r.tenants.dir, id, "channels")); err != nil {
+			t.close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// setupTenant configures the message stores of t and sets up its webhooks,
+// which deliver the events of all its channels.
+func (r *appRouter) setupTenant(t *tenant) {
+	if r.backlog > 0 {
+		t.m.Events().SetBacklogSize(r.backlog)
+	}
+	r.initStore(t.m, t.id)
+	d := newDispatcher(r.hookAttempts, r.hookBackoff, r.hookNetworks)
+	d.watch(defaultChannel, t.m.Events())
+	t.hooks = newWebhookStore(d)
+	t.channels.configure = func(name string, m *message.MessageServer) {
+		r.initStore(m, t.id)
+		d.watch(name, m.Events())
+	}
+	t.channels.removed = d.unwatch
+}
+
+// close stops the webhooks of t and closes its stores.
+func (t *tenant) close() {
+	t.hooks.d.close()
+	t.channels.close()
+	if err := t.m.Close(); err != nil {
+		log.Error("Error while closing message store of tenant ", t.id, ": ", err)
+	}
+}
+
+// get returns tenant id, creating it with r if it is configured but does
+// not exist yet.
+func (s *tenantStore) get(r *appRouter, id string) (*tenant, error) {
+	s.Lock()
+	defer s.Unlock()
+	if t, ok := s.tenants[id]; ok {
+		return t, nil
+	}
+	if !r.tenantIDs[id] {
+		return nil, message.Errorf(message.ErrNotFound, "Tenant %s not found", id)
+	}
+	t, err := r.newTenant(id)
+	if err != nil {
+		return nil, err
+	}
+	s.tenants[id] = t
+	return t, nil
+}
+
+// close closes all tenants.
+func (s *tenantStore) close() {
+	s.Lock()
+	defer s.Unlock()
+	s.closeAll()
+}
+
+func (s *tenantStore) closeAll() {
+	for _, t := range s.tenants {
+		t.close()
+	}
+}
+
+// tenantID resolves the tenant of a request with context ctx, which named
+// tenant header in its X-Tenant-ID header. An authenticated user belongs to
+// the tenant assigned by WithTenants and may only name that one in the
+// header. The header selects the tenant only if authentication is disabled;
+// with WithBasicAuth a request must be authenticated to be served at all.
+func (r *appRouter) tenantID(ctx context.Context, header string) (string, error) {
+	if header != "" {
+		if err := validateTenantID(header); err != nil {
+			return "", err
+		}
+	}
+	user := principalFromContext(ctx)
+	if user == "" && len(r.users) > 0 {
+		return "", message.Errorf(errUnauthenticated, "Valid credentials are required")
+	}
+	if user != "" {
+		id, ok := r.userTenants[user]
+		if !ok {
+			id = defaultTenantID
+		}
+		if header != "" && header != id {
+			return "", message.Errorf(errForbidden, "User %s does not belong to tenant %s", user, header)
+		}
+		return id, nil
+	}
+	if header == "" {
+		return defaultTenantID, nil
+	}
+	return header, nil
+}
+
+// resolveTenant stores the tenant of requests to the API in the request
+// context, where handlers find the stores to serve the request from, and
+// records it as baggage of the request span so that it follows the trace.
+func (r *appRouter) resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodOptions || !isAPIPath(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		id, err := r.tenantID(req.Context(), req.Header.Get(tenantHeader))
+		var t *tenant
+		if err == nil {
+			t, err = r.tenantByID(id)
+		}
+		if err != nil {
+			log.WithField("tenant", id).Error("error while resolving tenant: ", err)
+			r.respondWithError(w, req, err)
+			return
+		}
+		if span := opentracing.SpanFromContext(req.Context()); span != nil {
+			span.SetBaggageItem(tenantBaggageKey, t.id)
+			span.SetTag("tenant", t.id)
+		}
+		ctx := context.WithValue(req.Context(), tenantKey{}, t)
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// tenantByID returns tenant id, creating a configured tenant on first use.
+func (r *appRouter) tenantByID(id string) (*tenant, error) {
+	if id == defaultTenantID {
+		return r.defaultTenant, nil
+	}
+	return r.tenants.get(r, id)
+}
+
+// tenantOf returns the tenant of req, the default tenant for requests that
+// did not pass resolveTenant.
+func (r *appRouter) tenantOf(req *http.Request) *tenant {
+	if t, ok := req.Context().Value(tenantKey{}).(*tenant); ok {
+		return t
+	}
+	return r.defaultTenant
+}
+
+// logOf returns the logger for req, which records the tenant of the
+// request with every entry.
+func logOf(req *http.Request) *log.Entry {
+	return logFromContext(req.Context())
+}
+
+// logFromContext returns the logger for the request with context ctx.
+func logFromContext(ctx context.Context) *log.Entry {
+	if t, ok := ctx.Value(tenantKey{}).(*tenant); ok {
+		return log.WithField("tenant", t.id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_appRouter_tenantHeader(t *testing.T) {
+	r := newTestRouter(WithTenantIDs([]string{"acme", "globex"}))
+	defer r.tenants.close()
+	serve := func(tenant, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+		r.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve("", "POST", "/v1/messages", `{"text":"default"}`)
+	rec := serve("acme", "POST", "/v1/messages", `{"text":"acme"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	// every tenant has its own id sequence
+	assert.Contains(t, rec.Body.String(), `"id":1`)
+	serve("globex", "POST", "/v1/messages", `{"text":"globex"}`)
+
+	rec = serve("acme", "GET", "/v1/messages/1", "")
+	assert.Contains(t, rec.Body.String(), `"acme"`)
+	rec = serve("default", "GET", "/v1/messages/1", "")
+	assert.Contains(t, rec.Body.String(), `"default"`)
+	rec = serve("acme", "GET", "/v1/messages", "")
+	var msgs []message.MessageObj
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msgs))
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "acme", msgs[0].Text)
+	}
+
+	rec = serve("acme", "DELETE", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve("globex", "GET", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// channels are per tenant too
+	rec = serve("acme", "POST", "/v1/channels", `{"name":"news"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = serve("globex", "GET", "/v1/channels/news", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve("no/such", "GET", "/v1/messages", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// requests never create tenants
+	rec = serve("initech", "POST", "/v1/messages", `{"text":"initech"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serve("initech", "GET", "/v1/messages", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Len(t, r.tenants.tenants, 2)
+}
+
+func Test_appRouter_tenantOfUser(t *testing.T) {
+	r := newTestRouter(
+		WithBasicAuth(map[string]string{"ann": "secret", "bob": "secret", "eve": "secret"}),
+		WithTenants(map[string]string{"ann": "acme", "bob": "acme"}),
+	)
+	defer r.tenants.close()
+	serve := func(user, tenant, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.SetBasicAuth(user, "secret")
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+		r.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("ann", "", "POST", "/v1/messages", `{"text":"hi"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = serve("bob", "acme", "GET", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve("eve", "", "GET", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// users can not pick another tenant with the header
+	rec = serve("eve", "acme", "GET", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "permission_denied")
+	rec = serve("ann", "default", "GET", "/v1/messages", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// without credentials the header selects no tenant
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/messages/1", nil)
+	req.Header.Set(tenantHeader, "acme")
+	r.GetRouter().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	_, err := r.tenantID(context.Background(), "acme")
+	assert.True(t, errors.Is(err, errUnauthenticated), err)
+}
+
+func Test_appRouter_tenantIdempotencyKeys(t *testing.T) {
+	r := newTestRouter(WithTenantIDs([]string{"acme", "globex"}))
+	defer r.tenants.close()
+	post := func(tenant string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"text":"once"}`))
+		req.Header.Set(tenantHeader, tenant)
+		req.Header.Set(idempotencyKeyHeader, "k1")
+		r.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+	post("acme")
+	post("globex")
+	for _, tenant := range []string{"acme", "globex"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/v1/messages", nil)
+		req.Header.Set(tenantHeader, tenant)
+		r.GetRouter().ServeHTTP(rec, req)
+		assert.Contains(t, rec.Body.String(), "once", tenant)
+	}
+}
+
+func Test_appRouter_OpenTenants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serve := func(r *appRouter, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set(tenantHeader, "acme")
+		r.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	r := newTestRouter(WithTenantIDs([]string{"acme"}))
+	assert.NoError(t, r.OpenTenants(dir))
+	serve(r, "POST", "/v1/messages", `{"text":"kept"}`)
+	serve(r, "POST", "/v1/channels", `{"name":"news"}`)
+	r.tenants.close()
+
+	// tenants persisted by an earlier run are served only while configured
+	r = newTestRouter()
+	assert.NoError(t, r.OpenTenants(dir))
+	assert.Len(t, r.tenants.tenants, 0)
+	rec := serve(r, "GET", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	r.tenants.close()
+
+	r = newTestRouter(WithTenantIDs([]string{"acme"}))
+	defer r.tenants.close()
+	assert.NoError(t, r.OpenTenants(dir))
+	rec = serve(r, "GET", "/v1/messages/1", "")
+	assert.Contains(t, rec.Body.String(), "kept")
+	rec = serve(r, "GET", "/v1/channels/news", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestReadTenants(t *testing.T) {
+	tenants, err := ReadTenants(strings.NewReader("# users\nann:acme\n\nbob:globex\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"ann": "acme", "bob": "globex"}, tenants)
+	_, err = ReadTenants(strings.NewReader("ann\n"))
+	assert.EqualError(t, err, "line 1: expected user:tenant")
+	_, err = ReadTenants(strings.NewReader("ann:a/b\n"))
+	assert.Error(t, err)
+}
+
+func TestParseTenantIDs(t *testing.T) {
+	ids, err := ParseTenantIDs(" acme, globex,")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"acme", "globex"}, ids)
+	_, err = ParseTenantIDs("acme,a/b")
+	assert.Error(t, err)
+}
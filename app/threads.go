@@ -0,0 +1,97 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	defaultReplyDepth = 1
+	maxReplyDepth     = 100
+)
+
+// WithDeletePolicy sets what happens to the replies of a deleted message, in
+// the default channel and in every other channel. The default is
+// message.DeleteOrphan. With the trash enabled, the policy is applied when
+// the message is purged, except for cascade which moves the replies to the
+// trash along with it.
+func WithDeletePolicy(p message.DeletePolicy) Option {
+	return func(r *appRouter) {
+		r.deletePolicy = p
+	}
+}
+
+// depthParam returns the value of the optional depth query param.
+func depthParam(req *http.Request) (int, error) {
+	val := req.URL.Query().Get("depth")
+	if val == "" {
+		return defaultReplyDepth, nil
+	}
+	depth, err := strconv.Atoi(val)
+	if err != nil || depth < 1 || depth > maxReplyDepth {
+		return 0, message.Errorf(message.ErrInvalid, "Invalid value %q for query param depth, should be in range 1-%d", val, maxReplyDepth)
+	}
+	return depth, nil
+}
+
+func (r *appRouter) getReplies(w http.ResponseWriter, req *http.Request) {
+	id, err := r.validateMsgID(req)
+	var depth int
+	if err == nil {
+		depth, err = depthParam(req)
+	}
+	var opts []message.ReadOption
+	if err == nil {
+		opts, err = readOptions(req)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := ch.m.Replies(id, depth, opts...)
+	if err != nil {
+		logOf(req).Error("error while retrieving replies: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Retrieved replies of message %d successfully", id)
+}
+
+func (r *appRouter) getThread(w http.ResponseWriter, req *http.Request) {
+	id, err := r.validateMsgID(req)
+	var opts []message.ReadOption
+	if err == nil {
+		opts, err = readOptions(req)
+	}
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := ch.m.Thread(id, opts...)
+	if err != nil {
+		logOf(req).Error("error while retrieving thread: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Retrieved thread of message %d successfully", id)
+}
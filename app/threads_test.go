@@ -0,0 +1,71 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_appRouter_threads(t *testing.T) {
+	r := newTestRouter(WithDeletePolicy(message.DeleteTombstone))
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+
+	for _, body := range []string{
+		`{"text":"root"}`,
+		`{"text":"reply","parent_id":1}`,
+		`{"text":"nested","parent_id":2}`,
+	} {
+		rec := serve("POST", "/v1/messages", body)
+		assert.Equal(t, http.StatusCreated, rec.Code, body)
+	}
+	rec := serve("POST", "/v1/messages", `{"text":"reply","parent_id":-1}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve("GET", "/v1/messages/1/replies", "")
+	var replies []message.Thread
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &replies))
+	if assert.Len(t, replies, 1) {
+		assert.Equal(t, int64(2), replies[0].Id)
+		assert.Empty(t, replies[0].Replies, "depth defaults to 1")
+	}
+	rec = serve("GET", "/v1/messages/1/replies?depth=2", "")
+	replies = nil
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &replies))
+	if assert.Len(t, replies, 1) && assert.Len(t, replies[0].Replies, 1) {
+		assert.Equal(t, "nested", replies[0].Replies[0].Text)
+	}
+
+	// the parent of a reply is kept as a tombstone
+	rec = serve("DELETE", "/v1/messages/2", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve("GET", "/v1/messages/3/thread", "")
+	var thread message.Thread
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &thread))
+	assert.Equal(t, int64(1), thread.Id)
+	if assert.Len(t, thread.Replies, 1) {
+		assert.True(t, thread.Replies[0].Tombstone)
+		assert.Empty(t, thread.Replies[0].Text)
+		assert.Len(t, thread.Replies[0].Replies, 1)
+	}
+
+	// channels use the same policy
+	rec = serve("POST", "/v1/channels", `{"name":"ops"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	serve("POST", "/v1/channels/ops/messages", `{"text":"root"}`)
+	serve("POST", "/v1/channels/ops/messages", `{"text":"reply","parent_id":1}`)
+	serve("DELETE", "/v1/channels/ops/messages/1", "")
+	rec = serve("GET", "/v1/channels/ops/messages/2/thread", "")
+	thread = message.Thread{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &thread))
+	assert.Equal(t, int64(1), thread.Id)
+	assert.True(t, thread.Tombstone)
+}
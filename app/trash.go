@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const defaultTrashRetention = 720 * time.Hour
+
+// WithTrashRetention keeps deleted messages in the trash for d, in the
+// default channel and in every other channel, before they are purged. Zero
+// disables the trash: deletes are permanent. The default is 720 hours.
+func WithTrashRetention(d time.Duration) Option {
+	return func(r *appRouter) {
+		r.trashRetention = d
+	}
+}
+
+// WithAdmins allows the users named in admins to delete messages for good
+// with ?hard=true. Without authentication everybody may.
+func WithAdmins(admins []string) Option {
+	return func(r *appRouter) {
+		r.admins = make(map[string]bool, len(admins))
+		for _, name := range admins {
+			r.admins[name] = true
+		}
+	}
+}
+
+// isAdmin reports whether the user of the request with context ctx is an
+// admin.
+func (r *appRouter) isAdmin(ctx context.Context) bool {
+	return len(r.users) == 0 || r.admins[principalFromContext(ctx)]
+}
+
+// deleteOptions returns the options of a delete requested by req, which
+// moves messages to the trash unless an admin passes hard=true.
+func (r *appRouter) deleteOptions(req *http.Request) ([]message.DeleteOption, error) {
+	hard, err := boolParam(req, "hard")
+	if err != nil {
+		return nil, err
+	}
+	return r.deleteOptionsOf(req.Context(), hard)
+}
+
+// deleteOptionsOf returns the options of a delete by the user of the
+// request with context ctx, hard if hard is set.
+func (r *appRouter) deleteOptionsOf(ctx context.Context, hard bool) ([]message.DeleteOption, error) {
+	opts := []message.DeleteOption{message.DeletedBy(principalFromContext(ctx))}
+	if hard {
+		if !r.isAdmin(ctx) {
+			return nil, message.Errorf(errForbidden, "Only admins may delete messages for good")
+		}
+		opts = append(opts, message.HardDelete())
+	}
+	return opts, nil
+}
+
+func (r *appRouter) undeleteMessage(w http.ResponseWriter, req *http.Request) {
+	id, err := r.validateMsgID(req)
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := ch.m.Undelete(id)
+	if err != nil {
+		logOf(req).Error("error while restoring message: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+	logOf(req).Infof("Restored message %d successfully", id)
+}
+
+func (r *appRouter) listTrash(w http.ResponseWriter, req *http.Request) {
+	ch, err := r.channelOf(req)
+	if err != nil {
+		logOf(req).Error("error while retrieving channel: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, ch.m.Trash(), http.StatusOK)
+	logOf(req).Info("Retrieved trash successfully")
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_appRouter_trash(t *testing.T) {
+	r := newTestRouter(
+		WithBasicAuth(map[string]string{"ann": "secret", "bob": "secret"}),
+		WithAdmins([]string{"bob"}),
+		WithTrashRetention(time.Hour),
+	)
+	serve := func(user, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.SetBasicAuth(user, "secret")
+		r.GetRouter().ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve("ann", "POST", "/v1/messages", `{"text":"oops"}`)
+	rec := serve("ann", "DELETE", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve("ann", "GET", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve("ann", "GET", "/v1/trash", "")
+	var trash []message.MessageObj
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &trash))
+	if assert.Len(t, trash, 1) {
+		assert.Equal(t, "ann", trash[0].DeletedBy)
+		assert.NotNil(t, trash[0].DeletedAt)
+	}
+
+	rec = serve("ann", "POST", "/v1/messages/1:undelete", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "oops")
+	rec = serve("ann", "POST", "/v1/messages/1:undelete", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// only admins delete for good
+	rec = serve("ann", "DELETE", "/v1/messages/1?hard=true", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "permission_denied")
+	rec = serve("bob", "DELETE", "/v1/messages/1?hard=true", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve("bob", "GET", "/v1/trash", "")
+	assert.Equal(t, "[]\n", rec.Body.String())
+	rec = serve("bob", "POST", "/v1/messages/1:undelete", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func Test_appRouter_trashDefault(t *testing.T) {
+	serve := func(r *appRouter, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec
+	}
+
+	// deletes go to the trash by default
+	r := newTestRouter()
+	defer r.tenants.close()
+	serve(r, "POST", "/v1/messages", `{"text":"kept"}`)
+	rec := serve(r, "DELETE", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve(r, "POST", "/v1/messages/1:undelete", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "kept")
+
+	// a retention of zero makes deletes permanent
+	r = newTestRouter(WithTrashRetention(0))
+	defer r.tenants.close()
+	serve(r, "POST", "/v1/messages", `{"text":"gone"}`)
+	rec = serve(r, "DELETE", "/v1/messages/1", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = serve(r, "POST", "/v1/messages/1:undelete", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
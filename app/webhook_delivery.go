@@ -0,0 +1,427 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookEventIDHeader   = "X-Webhook-Event-Id"
+	webhookChannelHeader   = "X-Webhook-Channel"
+
+	defaultWebhookAttempts = 5
+	defaultWebhookBackoff  = time.Second
+	maxWebhookBackoff      = time.Hour
+	webhookTimeout         = 10 * time.Second
+	webhookWorkers         = 4
+	webhookQueueSize       = 256
+)
+
+// WithWebhookDelivery sets how often a webhook delivery is attempted before
+// it is given up, and the delay before the first retry. Later retries back
+// off exponentially.
+func WithWebhookDelivery(maxAttempts int, backoff time.Duration) Option {
+	return func(r *appRouter) {
+		r.hookAttempts = maxAttempts
+		r.hookBackoff = backoff
+	}
+}
+
+// internalNetworks are the networks webhooks do not deliver to unless they
+// are allowed with WithWebhookNetworks, so that API callers can not make the
+// server send requests to itself or to the services next to it: loopback,
+// private, shared, link-local, multicast and reserved addresses.
+var internalNetworks = mustParseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/3",
+	"::/128", "::1/128", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// WithWebhookNetworks allows webhooks to deliver to the internal addresses
+// in nets, e.g. to receivers in the private network of the server.
+func WithWebhookNetworks(nets []*net.IPNet) Option {
+	return func(r *appRouter) {
+		r.hookNetworks = nets
+	}
+}
+
+// ParseNetworks parses comma separated CIDR notations, as read by
+// -webhook-allow-networks.
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	nets, err := ParseNetworks(strings.Join(cidrs, ","))
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// webhookAddrAllowed reports whether webhooks may deliver to ip: if it is
+// not internal or in one of the allowed networks.
+func webhookAddrAllowed(ip net.IP, allowed []*net.IPNet) bool {
+	for _, n := range allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range internalNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookClient returns the client delivering webhooks. It checks the
+// address of every connection it opens, after name resolution and for
+// redirects too, and refuses internal addresses not in allowed. It does not
+// use a proxy, which would connect to the address on its behalf.
+func newWebhookClient(allowed []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   webhookTimeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !webhookAddrAllowed(ip, allowed) {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = nil
+	tr.DialContext = dialer.DialContext
+	return &http.Client{Timeout: webhookTimeout, Transport: tr}
+}
+
+// delivery is a Delivery in flight together with what is needed to send it.
+type delivery struct {
+	d       *Delivery
+	url     string
+	secret  string
+	event   message.Event
+	attempt int
+}
+
+// dispatcher delivers message events to the registered webhooks. It
+// subscribes to the event brokers of the message stores of all channels and
+// hands every delivery to a pool of workers; failed deliveries are requeued
+// after a backoff until they succeed or run out of attempts. Deliveries are
+// not ordered.
+type dispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	queue       chan *delivery
+
+	// mu guards store, set once the dispatcher is started, and sources
+	mu      sync.Mutex
+	store   *webhookStore
+	sources map[string]*eventSource
+
+	once     sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// eventSource is the event broker of the message store of a channel.
+type eventSource struct {
+	channel string
+	events  *message.Broker
+	stop    chan struct{}
+}
+
+func newDispatcher(maxAttempts int, backoff time.Duration, allowed []*net.IPNet) *dispatcher {
+	return &dispatcher{
+		client:      newWebhookClient(allowed),
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		queue:       make(chan *delivery, webhookQueueSize),
+		sources:     make(map[string]*eventSource),
+		stop:        make(chan struct{}),
+	}
+}
+
+// watch delivers the events published on events, the broker of the message
+// store of channel, in place of the store watched for channel before.
+func (d *dispatcher) watch(channel string, events *message.Broker) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if old, ok := d.sources[channel]; ok {
+		close(old.stop)
+	}
+	src := &eventSource{channel: channel, events: events, stop: make(chan struct{})}
+	d.sources[channel] = src
+	if d.store != nil {
+		d.follow(src)
+	}
+}
+
+// unwatch stops delivering the events of channel, whose store is removed.
+func (d *dispatcher) unwatch(channel string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if src, ok := d.sources[channel]; ok {
+		close(src.stop)
+		delete(d.sources, channel)
+	}
+}
+
+// start starts delivering events to the webhooks of s, if not started yet.
+// Events published once start returns are delivered.
+func (d *dispatcher) start(s *webhookStore) {
+	d.once.Do(func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.store = s
+		for _, src := range d.sources {
+			d.follow(src)
+		}
+		for i := 0; i < webhookWorkers; i++ {
+			go d.work(s)
+		}
+	})
+}
+
+// follow subscribes to the events of src and starts matching them against
+// the webhooks. Callers hold mu.
+func (d *dispatcher) follow(src *eventSource) {
+	sub, _, _ := src.events.Subscribe(0, message.Filter{})
+	go d.run(d.store, src, sub)
+}
+
+// close stops the dispatcher. Pending deliveries are abandoned.
+func (d *dispatcher) close() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// run matches the events of src against the webhooks of s until src is
+// unwatched or the dispatcher is stopped. If the broker drops the
+// subscription because the workers fell behind, it resubscribes after the
+// last event it handled so that no event in the backlog is lost.
+func (d *dispatcher) run(s *webhookStore, src *eventSource, sub *message.Subscription) {
+	var last int64
+	for {
+	events:
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					break events
+				}
+				if e.ID <= last {
+					// relayed again after a restart
+					continue
+				}
+				if !d.dispatch(s, src, e) {
+					src.events.Unsubscribe(sub)
+					return
+				}
+				last = e.ID
+			case <-src.stop:
+				src.events.Unsubscribe(sub)
+				return
+			case <-d.stop:
+				src.events.Unsubscribe(sub)
+				return
+			}
+		}
+
+		var replay []message.Event
+		var missed bool
+		sub, replay, missed = src.events.Subscribe(last, message.Filter{})
+		if missed {
+			log.Warnf("Webhook deliveries of channel %s resumed after event %d, older events are no longer in the backlog", src.channel, last)
+		}
+		for _, e := range replay {
+			if !d.dispatch(s, src, e) {
+				src.events.Unsubscribe(sub)
+				return
+			}
+			last = e.ID
+		}
+	}
+}
+
+// dispatch queues a delivery of e, published in the channel of src, for
+// every interested webhook. It returns false if the dispatcher was stopped
+// or src unwatched.
+func (d *dispatcher) dispatch(s *webhookStore, src *eventSource, e message.Event) bool {
+	for _, del := range s.match(src.channel, e) {
+		select {
+		case d.queue <- del:
+		case <-src.stop:
+			return false
+		case <-d.stop:
+			return false
+		}
+	}
+	return true
+}
+
+func (d *dispatcher) work(s *webhookStore) {
+	for {
+		select {
+		case del := <-d.queue:
+			d.attempt(s, del)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// attempt sends del once and records the outcome, scheduling a retry if the
+// attempt failed and attempts are left. Deliveries of a webhook removed in
+// the meantime are dropped.
+func (d *dispatcher) attempt(s *webhookStore, del *delivery) {
+	if !s.has(del.d.WebhookId) {
+		return
+	}
+	del.attempt++
+	a := d.send(del)
+	if a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300 {
+		s.record(del.d, a, deliverySucceeded, nil)
+		return
+	}
+	if del.attempt >= d.maxAttempts {
+		s.record(del.d, a, deliveryFailed, nil)
+		log.Warnf("Giving up webhook delivery %d after %d attempts", del.d.Id, del.attempt)
+		return
+	}
+	delay := backoffDelay(d.backoff, del.attempt)
+	next := time.Now().Add(delay).UTC()
+	if !s.record(del.d, a, deliveryPending, &next) {
+		// the webhook was removed
+		return
+	}
+	time.AfterFunc(delay, func() {
+		select {
+		case d.queue <- del:
+		case <-d.stop:
+		}
+	})
+}
+
+// backoffDelay returns the delay before retry n, starting at 1: base doubled
+// for every earlier retry, capped at maxWebhookBackoff, of which a random
+// half is taken off so that retries of many deliveries spread out.
+func backoffDelay(base time.Duration, n int) time.Duration {
+	// doubling stops at the cap, so delay can not overflow
+	delay := base
+	for i := 1; i < n && delay < maxWebhookBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxWebhookBackoff {
+		delay = maxWebhookBackoff
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// signature returns the value of the signature header of a request with body
+// sent at t: the unix time followed by the hex HMAC-SHA256 of
+// "<unix time>.<body>" keyed with the webhook secret. Receivers should
+// recompute it and reject requests with an old timestamp.
+func signature(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// send makes one traced request delivering the event of del.
+func (d *dispatcher) send(del *delivery) DeliveryAttempt {
+	start := time.Now()
+	a := DeliveryAttempt{Time: start.UTC()}
+	body, err := json.Marshal(del.event)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	req, err := http.NewRequest("POST", del.url, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(del.d.Id, 10))
+	req.Header.Set(webhookEventHeader, del.event.Type)
+	req.Header.Set(webhookEventIDHeader, strconv.FormatInt(del.event.ID, 10))
+	req.Header.Set(webhookChannelHeader, del.d.Channel)
+	req.Header.Set(webhookSignatureHeader, signature(del.secret, start, body))
+
+	span := opentracing.GlobalTracer().StartSpan("webhook delivery")
+	defer span.Finish()
+	ext.SpanKindRPCClient.Set(span)
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, del.url)
+	span.SetTag("webhook.id", del.d.WebhookId)
+	span.SetTag("webhook.delivery", del.d.Id)
+	span.SetTag("webhook.attempt", del.attempt)
+	err = span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+	if err != nil {
+		log.Errorf("error while injecting context is: %v", err)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), NewClientTrace(span)))
+
+	resp, err := d.client.Do(req)
+	a.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		ext.Error.Set(span, true)
+		a.Error = err.Error()
+		log.Warnf("Webhook delivery %d attempt %d failed: %v", del.d.Id, del.attempt, err)
+		return a
+	}
+	// drain a bounded part of the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	a.StatusCode = resp.StatusCode
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	if resp.StatusCode >= 300 {
+		ext.Error.Set(span, true)
+		log.Warnf("Webhook delivery %d attempt %d failed with status %d", del.d.Id, del.attempt, resp.StatusCode)
+	}
+	return a
+}
@@ -0,0 +1,356 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	maxWebhooks          = 100
+	maxWebhookSecretLen  = 256
+	maxDeliveriesPerHook = 1000
+)
+
+// Delivery states.
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+// WebhookRequestBody registers a webhook. Events defaults to every event
+// type. If Secret is empty one is generated and returned once on creation.
+type WebhookRequestBody struct {
+	URL    string         `json:"url"`
+	Events []string       `json:"events,omitempty"`
+	Filter message.Filter `json:"filter"`
+	Secret string         `json:"secret,omitempty"`
+}
+
+// Webhook is a subscription of an external URL to message events.
+type Webhook struct {
+	Id        int64          `json:"id"`
+	URL       string         `json:"url"`
+	Events    []string       `json:"events"`
+	Filter    message.Filter `json:"filter"`
+	Secret    string         `json:"secret,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// DeliveryAttempt is the outcome of one request to a webhook URL.
+type DeliveryAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// Delivery is the delivery of one event to one webhook. Every channel
+// numbers its events on its own, so an event is identified by Channel and
+// EventId together.
+type Delivery struct {
+	Id          int64             `json:"id"`
+	WebhookId   int64             `json:"webhook_id"`
+	Channel     string            `json:"channel"`
+	EventId     int64             `json:"event_id"`
+	EventType   string            `json:"event_type"`
+	Status      string            `json:"status"`
+	Attempts    []DeliveryAttempt `json:"attempts"`
+	NextAttempt *time.Time        `json:"next_attempt,omitempty"`
+}
+
+// webhook is a registered Webhook with its most recent deliveries, oldest
+// first.
+type webhook struct {
+	Webhook
+	deliveries []*Delivery
+}
+
+func (h *webhook) wants(e message.Event) bool {
+	for _, typ := range h.Events {
+		if typ == e.Type {
+			return h.Filter.Match(e)
+		}
+	}
+	return false
+}
+
+// webhookStore holds the registered webhooks and their delivery log in
+// memory. Deliveries start once the first webhook is registered.
+type webhookStore struct {
+	sync.Mutex
+	latestID   int64
+	deliveryID int64
+	hooks      map[int64]*webhook
+	d          *dispatcher
+}
+
+func newWebhookStore(d *dispatcher) *webhookStore {
+	return &webhookStore{hooks: make(map[int64]*webhook), d: d}
+}
+
+// validateWebhook validates body, rejecting URLs naming an internal address
+// not in allowed right away. Host names are checked when they are resolved
+// for a delivery.
+func validateWebhook(body WebhookRequestBody, allowed []*net.IPNet) error {
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return message.Errorf(message.ErrInvalid, "Invalid webhook url %q, must be an absolute http or https URL", body.URL)
+	}
+	ip := net.ParseIP(u.Hostname())
+	if strings.EqualFold(u.Hostname(), "localhost") {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	if ip != nil && !webhookAddrAllowed(ip, allowed) {
+		return message.Errorf(message.ErrInvalid, "Invalid webhook url %q, internal addresses are not allowed", body.URL)
+	}
+	for _, typ := range body.Events {
+		switch typ {
+		case message.EventCreated, message.EventUpdated, message.EventDeleted, message.EventExpired, message.EventUndeleted:
+		default:
+			return message.Errorf(message.ErrInvalid, "Invalid event type %q, should be one of %q, %q, %q, %q or %q", typ,
+				message.EventCreated, message.EventUpdated, message.EventDeleted, message.EventExpired, message.EventUndeleted)
+		}
+	}
+	if len(body.Secret) > maxWebhookSecretLen {
+		return message.Errorf(message.ErrInvalid, "Secret must be at most %d characters", maxWebhookSecretLen)
+	}
+	return nil
+}
+
+func (s *webhookStore) add(body WebhookRequestBody) (Webhook, error) {
+	if body.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Webhook{}, err
+		}
+		body.Secret = hex.EncodeToString(b)
+	}
+	if len(body.Events) == 0 {
+		body.Events = []string{message.EventCreated, message.EventUpdated, message.EventDeleted, message.EventExpired, message.EventUndeleted}
+	}
+	s.Lock()
+	defer s.Unlock()
+	if len(s.hooks) >= maxWebhooks {
+		return Webhook{}, message.Errorf(message.ErrQuotaExceeded, "At most %d webhooks can be registered", maxWebhooks)
+	}
+	s.latestID++
+	h := &webhook{Webhook: Webhook{
+		Id:        s.latestID,
+		URL:       body.URL,
+		Events:    append([]string(nil), body.Events...),
+		Filter:    body.Filter,
+		Secret:    body.Secret,
+		CreatedAt: time.Now().UTC(),
+	}}
+	s.hooks[h.Id] = h
+	s.d.start(s)
+	return h.Webhook, nil
+}
+
+// get returns webhook id without its secret.
+func (s *webhookStore) get(id int64) (Webhook, error) {
+	s.Lock()
+	defer s.Unlock()
+	h, ok := s.hooks[id]
+	if !ok {
+		return Webhook{}, message.Errorf(message.ErrNotFound, "webhook %d not found", id)
+	}
+	w := h.Webhook
+	w.Secret = ""
+	return w, nil
+}
+
+// list returns all webhooks without their secrets, ordered by ID.
+func (s *webhookStore) list() []Webhook {
+	s.Lock()
+	defer s.Unlock()
+	list := make([]Webhook, 0, len(s.hooks))
+	for _, h := range s.hooks {
+		w := h.Webhook
+		w.Secret = ""
+		list = append(list, w)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// remove deletes webhook id. Pending retries of its deliveries are dropped.
+func (s *webhookStore) remove(id int64) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.hooks[id]; !ok {
+		return message.Errorf(message.ErrNotFound, "webhook %d not found", id)
+	}
+	delete(s.hooks, id)
+	return nil
+}
+
+// has reports whether webhook id is registered.
+func (s *webhookStore) has(id int64) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.hooks[id]
+	return ok
+}
+
+// deliveries returns the delivery log of webhook id, newest first.
+func (s *webhookStore) deliveries(id int64) ([]Delivery, error) {
+	s.Lock()
+	defer s.Unlock()
+	h, ok := s.hooks[id]
+	if !ok {
+		return nil, message.Errorf(message.ErrNotFound, "webhook %d not found", id)
+	}
+	list := make([]Delivery, len(h.deliveries))
+	for i, d := range h.deliveries {
+		list[len(list)-1-i] = copyDelivery(d)
+	}
+	return list, nil
+}
+
+func copyDelivery(d *Delivery) Delivery {
+	c := *d
+	c.Attempts = append([]DeliveryAttempt(nil), d.Attempts...)
+	if d.NextAttempt != nil {
+		t := *d.NextAttempt
+		c.NextAttempt = &t
+	}
+	return c
+}
+
+// match creates a pending delivery of e, published in channel, for every
+// webhook interested in it.
+func (s *webhookStore) match(channel string, e message.Event) []*delivery {
+	s.Lock()
+	defer s.Unlock()
+	var list []*delivery
+	for _, h := range s.hooks {
+		if !h.wants(e) {
+			continue
+		}
+		s.deliveryID++
+		d := &Delivery{Id: s.deliveryID, WebhookId: h.Id, Channel: channel, EventId: e.ID, EventType: e.Type, Status: deliveryPending}
+		h.deliveries = append(h.deliveries, d)
+		if len(h.deliveries) > maxDeliveriesPerHook {
+			h.deliveries = h.deliveries[1:]
+		}
+		list = append(list, &delivery{d: d, url: h.URL, secret: h.Secret, event: e})
+	}
+	return list
+}
+
+// record adds an attempt to d. If the delivery is to be retried next is the
+// time of the next attempt. It returns false if the webhook was removed
+// meanwhile.
+func (s *webhookStore) record(d *Delivery, a DeliveryAttempt, status string, next *time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	d.Attempts = append(d.Attempts, a)
+	d.Status = status
+	d.NextAttempt = next
+	_, ok := s.hooks[d.WebhookId]
+	return ok
+}
+
+func (r *appRouter) webhookID(req *http.Request) (int64, error) {
+	id, err := r.validateMsgID(req)
+	if err != nil {
+		return 0, message.Errorf(message.ErrInvalid, "Invalid webhook id, should be a valid positive integer")
+	}
+	return id, nil
+}
+
+func (r *appRouter) createWebhook(w http.ResponseWriter, req *http.Request) {
+	body := WebhookRequestBody{}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		logOf(req).Error("error while unmarshalling request body: ", err)
+		r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "Invalid request body"))
+		return
+	}
+	err = validateWebhook(body, r.hookNetworks)
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := r.tenantOf(req).hooks.add(body)
+	if err != nil {
+		logOf(req).Error("error while adding webhook: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusCreated, req)
+	w.Header().Set("Location", fmt.Sprintf("/v1/webhooks/%d", resp.Id))
+	jsonResponse(w, resp, http.StatusCreated)
+	logOf(req).Infof("Added webhook %d for %s", resp.Id, resp.URL)
+}
+
+func (r *appRouter) listWebhooks(w http.ResponseWriter, req *http.Request) {
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, r.tenantOf(req).hooks.list(), http.StatusOK)
+}
+
+func (r *appRouter) getWebhook(w http.ResponseWriter, req *http.Request) {
+	id, err := r.webhookID(req)
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := r.tenantOf(req).hooks.get(id)
+	if err != nil {
+		logOf(req).Error("error while retrieving webhook: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+}
+
+func (r *appRouter) deleteWebhook(w http.ResponseWriter, req *http.Request) {
+	id, err := r.webhookID(req)
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	err = r.tenantOf(req).hooks.remove(id)
+	if err != nil {
+		logOf(req).Error("error while deleting webhook: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusNoContent, req)
+	w.WriteHeader(http.StatusNoContent)
+	logOf(req).Infof("Deleted webhook %d successfully", id)
+}
+
+func (r *appRouter) listDeliveries(w http.ResponseWriter, req *http.Request) {
+	id, err := r.webhookID(req)
+	if err != nil {
+		logOf(req).Error("error validating request: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	resp, err := r.tenantOf(req).hooks.deliveries(id)
+	if err != nil {
+		logOf(req).Error("error while retrieving deliveries: ", err)
+		r.respondWithError(w, req, err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusOK, req)
+	jsonResponse(w, resp, http.StatusOK)
+}
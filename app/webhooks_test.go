@@ -0,0 +1,272 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_backoffDelay(t *testing.T) {
+	tests := []struct {
+		base time.Duration
+		n    int
+		want time.Duration
+	}{
+		{time.Second, 1, time.Second},
+		{time.Second, 2, 2 * time.Second},
+		{time.Second, 4, 8 * time.Second},
+		{time.Second, 40, maxWebhookBackoff},
+		// shifting these would overflow
+		{10 * time.Second, 31, maxWebhookBackoff},
+		{time.Minute, 64, maxWebhookBackoff},
+		{24 * time.Hour, 1000, maxWebhookBackoff},
+		{2 * maxWebhookBackoff, 1, maxWebhookBackoff},
+	}
+	for _, tt := range tests {
+		got := backoffDelay(tt.base, tt.n)
+		assert.True(t, got >= tt.want/2 && got <= tt.want, "backoffDelay(%v, %d) = %v, want in [%v, %v]", tt.base, tt.n, got, tt.want/2, tt.want)
+	}
+}
+
+func Test_signature(t *testing.T) {
+	// echo -n '1600000000.{}' | openssl dgst -sha256 -hmac secret
+	got := signature("secret", time.Unix(1600000000, 0), []byte("{}"))
+	assert.Equal(t, "t=1600000000,v1=1e56a11da123b137c26fa37b7c222060bdf22988aa9b3248c31244f8b2ef4a28", got)
+}
+
+// waitForDeliveries polls the delivery log of webhook 1 until cond holds.
+func waitForDeliveries(t *testing.T, r *appRouter, cond func([]Delivery) bool) []Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list, err := r.hooks.deliveries(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cond(list) {
+			return list
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries did not reach the expected state: %+v", list)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func Test_appRouter_webhooks(t *testing.T) {
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies [][]byte
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, req)
+		bodies = append(bodies, body)
+		// fail the first attempt to exercise retries
+		if len(received) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	r := newTestRouter(WithWebhookDelivery(3, time.Millisecond), WithWebhookNetworks(loopback))
+	defer r.hooks.d.close()
+
+	body := `{"url":"` + target.URL + `","events":["created"],"filter":{"tag":"go"},"secret":"s3cret"}`
+	rec := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("POST", "/v1/webhooks", bytes.NewBufferString(body)))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "/v1/webhooks/1", rec.Header().Get("Location"))
+
+	_, _ = r.m.Add(message.MessageObj{Text: "skipped"})
+	_, _ = r.m.Add(message.MessageObj{Text: "hello", Tags: []string{"go"}})
+	_ = r.m.Delete(2)
+
+	list := waitForDeliveries(t, r, func(list []Delivery) bool {
+		return len(list) == 1 && list[0].Status == deliverySucceeded
+	})
+	assert.Equal(t, int64(2), list[0].EventId)
+	assert.Equal(t, message.EventCreated, list[0].EventType)
+	if assert.Len(t, list[0].Attempts, 2) {
+		assert.Equal(t, http.StatusServiceUnavailable, list[0].Attempts[0].StatusCode)
+		assert.Equal(t, http.StatusOK, list[0].Attempts[1].StatusCode)
+	}
+
+	mu.Lock()
+	req, sent := received[1], bodies[1]
+	mu.Unlock()
+	assert.Equal(t, "1", req.Header.Get(webhookDeliveryHeader))
+	assert.Equal(t, message.EventCreated, req.Header.Get(webhookEventHeader))
+	assert.Equal(t, "2", req.Header.Get(webhookEventIDHeader))
+	assert.Equal(t, defaultChannel, req.Header.Get(webhookChannelHeader))
+	sig := req.Header.Get(webhookSignatureHeader)
+	ts := strings.TrimPrefix(strings.Split(sig, ",")[0], "t=")
+	var sec int64
+	assert.NoError(t, json.Unmarshal([]byte(ts), &sec))
+	assert.Equal(t, signature("s3cret", time.Unix(sec, 0), sent), sig)
+	assert.Contains(t, string(sent), `"text":"hello"`)
+
+	// the secret is only returned on creation
+	rec = httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/webhooks/1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "s3cret")
+
+	rec = httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/webhooks/1/deliveries", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"succeeded"`)
+
+	rec = httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("DELETE", "/v1/webhooks/1", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
+
+func Test_appRouter_webhooks_giveUp(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer target.Close()
+
+	r := newTestRouter(WithWebhookDelivery(2, time.Millisecond), WithWebhookNetworks(loopback))
+	defer r.hooks.d.close()
+	_, err := r.hooks.add(WebhookRequestBody{URL: target.URL})
+	assert.NoError(t, err)
+	_, _ = r.m.Add(message.MessageObj{Text: "hello"})
+
+	list := waitForDeliveries(t, r, func(list []Delivery) bool {
+		return len(list) == 1 && list[0].Status == deliveryFailed
+	})
+	assert.Len(t, list[0].Attempts, 2)
+	assert.Nil(t, list[0].NextAttempt)
+}
+
+func Test_appRouter_webhooks_removed(t *testing.T) {
+	var received int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer target.Close()
+
+	r := newTestRouter(WithWebhookDelivery(5, 200*time.Millisecond), WithWebhookNetworks(loopback))
+	defer r.hooks.d.close()
+	h, err := r.hooks.add(WebhookRequestBody{URL: target.URL})
+	assert.NoError(t, err)
+	_, _ = r.m.Add(message.MessageObj{Text: "hello"})
+
+	waitForDeliveries(t, r, func(list []Delivery) bool {
+		return len(list) == 1 && len(list[0].Attempts) == 1
+	})
+	assert.NoError(t, r.hooks.remove(h.Id))
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&received), "retries sent after the webhook was removed")
+}
+
+func Test_appRouter_webhooks_channels(t *testing.T) {
+	channels := make(chan string, 10)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		channels <- req.Header.Get(webhookChannelHeader)
+	}))
+	defer target.Close()
+
+	r := newTestRouter(WithWebhookDelivery(1, time.Millisecond), WithWebhookNetworks(loopback))
+	defer r.tenants.close()
+	defer r.hooks.d.close()
+	serve := func(method, path, body string) int {
+		rec := httptest.NewRecorder()
+		r.GetRouter().ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		return rec.Code
+	}
+	_, err := r.hooks.add(WebhookRequestBody{URL: target.URL})
+	assert.NoError(t, err)
+
+	// messages of channels, even of one removed and created again, fire
+	// webhooks too
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusCreated, serve("POST", "/v1/channels", `{"name":"news"}`))
+		assert.Equal(t, http.StatusCreated, serve("POST", "/v1/channels/news/messages", `{"text":"hello"}`))
+		select {
+		case ch := <-channels:
+			assert.Equal(t, "news", ch)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no delivery of a message posted to a channel")
+		}
+		assert.Equal(t, http.StatusNoContent, serve("DELETE", "/v1/channels/news", ""))
+	}
+	list := waitForDeliveries(t, r, func(list []Delivery) bool { return len(list) == 2 })
+	assert.Equal(t, "news", list[0].Channel)
+	assert.Equal(t, int64(1), list[1].EventId, "the new channel numbers its events anew")
+}
+
+// loopback allows the webhooks of tests to deliver to httptest servers.
+var loopback = mustParseNetworks("127.0.0.0/8")
+
+func Test_appRouter_webhooks_internalAddress(t *testing.T) {
+	var received int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&received, 1)
+	}))
+	defer target.Close()
+
+	r := newTestRouter(WithWebhookDelivery(1, time.Millisecond))
+	defer r.hooks.d.close()
+	// registered without validation, as if a host name resolved to loopback
+	_, err := r.hooks.add(WebhookRequestBody{URL: target.URL})
+	assert.NoError(t, err)
+	_, _ = r.m.Add(message.MessageObj{Text: "hello"})
+
+	list := waitForDeliveries(t, r, func(list []Delivery) bool {
+		return len(list) == 1 && list[0].Status == deliveryFailed
+	})
+	assert.Contains(t, list[0].Attempts[0].Error, "not allowed")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&received))
+}
+
+func Test_validateWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    WebhookRequestBody
+		allowed []*net.IPNet
+		ok      bool
+	}{
+		{"valid", WebhookRequestBody{URL: "https://example.com/hook", Events: []string{"deleted"}}, nil, true},
+		{"relative url", WebhookRequestBody{URL: "/hook"}, nil, false},
+		{"other scheme", WebhookRequestBody{URL: "ftp://example.com"}, nil, false},
+		{"unknown event", WebhookRequestBody{URL: "http://example.com", Events: []string{"read"}}, nil, false},
+		{"loopback", WebhookRequestBody{URL: "http://127.0.0.1:8080/hook"}, nil, false},
+		{"localhost", WebhookRequestBody{URL: "http://localhost/hook"}, nil, false},
+		{"link-local", WebhookRequestBody{URL: "http://169.254.169.254/latest"}, nil, false},
+		{"private ipv6", WebhookRequestBody{URL: "http://[fd00::1]/hook"}, nil, false},
+		{"public address", WebhookRequestBody{URL: "https://93.184.216.34/hook"}, nil, true},
+		{"allowed network", WebhookRequestBody{URL: "http://10.1.2.3/hook"}, mustParseNetworks("10.0.0.0/8"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateWebhook(tt.body, tt.allowed)
+			assert.Equal(t, tt.ok, err == nil, "validateWebhook() = %v", err)
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	nets, err := ParseNetworks("10.0.0.0/8, fd00::/8")
+	assert.NoError(t, err)
+	assert.Len(t, nets, 2)
+	nets, err = ParseNetworks("")
+	assert.NoError(t, err)
+	assert.Empty(t, nets)
+	_, err = ParseNetworks("10.0.0.1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	log "github.com/sirupsen/logrus"
+)
+
+// Frame types of the WebSocket protocol. Clients send publish and subscribe
+// frames, each answered with an ack or error frame carrying the same id.
+// Events of the subscription are pushed to the client as event frames.
+const (
+	framePublish   = "publish"
+	frameSubscribe = "subscribe"
+	frameAck       = "ack"
+	frameError     = "error"
+	frameEvent     = "event"
+)
+
+const (
+	wsPongWait     = 60 * time.Second
+	wsPingPeriod   = wsPongWait * 9 / 10
+	wsMaxFrameSize = 64 << 10
+	wsSendQueue    = 64
+)
+
+// Frame is a single WebSocket message.
+type Frame struct {
+	Type string `json:"type"`
+	// ID is chosen by the client and echoed in the ack or error frame.
+	ID      string              `json:"id,omitempty"`
+	Message *message.MessageObj `json:"message,omitempty"`
+	// Channel names the channel a publish or subscribe frame is about, the
+	// default channel if empty.
+	Channel     string          `json:"channel,omitempty"`
+	Filter      *message.Filter `json:"filter,omitempty"`
+	LastEventID int64           `json:"last_event_id,omitempty"`
+	Event       *message.Event  `json:"event,omitempty"`
+	Error       *Problem        `json:"error,omitempty"`
+}
+
+// wsConn is the server side of a WebSocket connection. Frames for the client
+// are queued on send and written by a single writer goroutine; a client that
+// lets the queue overflow is disconnected.
+type wsConn struct {
+	r    *appRouter
+	req  *http.Request
+	t    *tenant
+	conn *websocket.Conn
+	send chan Frame
+	done chan struct{}
+
+	// mu guards sub and events, the broker sub is registered with
+	mu     sync.Mutex
+	sub    *message.Subscription
+	events *message.Broker
+}
+
+// serveWebSocket upgrades the request to a WebSocket connection on which
+// clients publish messages and subscribe to message events. The handshake
+// passes through the same middleware, and so the same authentication, as
+// every other request.
+func (r *appRouter) serveWebSocket(w http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{
+		Error: func(w http.ResponseWriter, req *http.Request, status int, reason error) {
+			r.respondWithError(w, req, message.Errorf(message.ErrInvalid, "WebSocket handshake failed: %v", reason))
+		},
+	}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logOf(req).Error("Error while upgrading to WebSocket: ", err)
+		return
+	}
+	r.addSpan(req.Context(), http.StatusSwitchingProtocols, req)
+	logOf(req).Info("WebSocket connection opened")
+
+	c := &wsConn{
+		r:    r,
+		req:  req,
+		t:    r.tenantOf(req),
+		conn: conn,
+		send: make(chan Frame, wsSendQueue),
+		done: make(chan struct{}),
+	}
+	go c.writeLoop()
+	c.readLoop()
+}
+
+// readLoop handles the frames sent by the client until the connection
+// fails or is closed.
+func (c *wsConn) readLoop() {
+	defer func() {
+		close(c.done)
+		c.mu.Lock()
+		if c.sub != nil {
+			c.events.Unsubscribe(c.sub)
+			c.sub = nil
+		}
+		c.mu.Unlock()
+		_ = c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxFrameSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Error("Error while reading WebSocket frame: ", err)
+			} else {
+				log.Info("WebSocket connection closed")
+			}
+			return
+		}
+		var f Frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			c.reply(Frame{}, message.Errorf(message.ErrInvalid, "Invalid frame, must be an object in specified format"))
+			continue
+		}
+		switch f.Type {
+		case framePublish:
+			c.publish(f, data)
+		case frameSubscribe:
+			c.subscribe(f)
+		default:
+			c.reply(f, message.Errorf(message.ErrInvalid, "Invalid frame type %q, should be %q or %q", f.Type, framePublish, frameSubscribe))
+		}
+	}
+}
+
+// writeLoop writes queued frames and keep-alive pings until the connection
+// is done or a write fails.
+func (c *wsConn) writeLoop() {
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case f := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := c.conn.WriteJSON(f); err != nil {
+				log.Error("Error while writing WebSocket frame: ", err)
+				_ = c.conn.Close()
+				return
+			}
+		case <-ping.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+			if err != nil {
+				_ = c.conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// enqueue queues f for the client, disconnecting the client if its queue is
+// full.
+func (c *wsConn) enqueue(f Frame) {
+	select {
+	case c.send <- f:
+	default:
+		c.closeSlow()
+	}
+}
+
+// closeSlow disconnects a client that does not read its frames fast enough.
+// It may reconnect and resubscribe from the last event it processed.
+func (c *wsConn) closeSlow() {
+	log.Warn("WebSocket connection closed, client fell behind")
+	msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client fell behind")
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteTimeout))
+	_ = c.conn.Close()
+}
+
+// reply acknowledges f, or rejects it if err is set.
+func (c *wsConn) reply(f Frame, err error) {
+	if err != nil {
+		p := newProblem(c.req, err)
+		c.enqueue(Frame{Type: frameError, ID: f.ID, Error: &p})
+		return
+	}
+	c.enqueue(Frame{Type: frameAck, ID: f.ID, Message: f.Message})
+}
+
+// publish creates the message of publish frame f, read from data, in the
+// channel of f. Its message takes the fields of a POST to /v1/messages.
+func (c *wsConn) publish(f Frame, data []byte) {
+	var pf struct {
+		Message *MsgRequestBody `json:"message"`
+	}
+	if err := json.Unmarshal(data, &pf); err != nil || pf.Message == nil {
+		c.reply(f, message.Errorf(message.ErrInvalid, "Publish frame must have a message"))
+		return
+	}
+	ch, err := c.r.channelNamed(c.t, f.Channel)
+	if err != nil {
+		c.reply(f, err)
+		return
+	}
+	resp, err := ch.addMessage(c.req.Context(), *pf.Message)
+	if err != nil {
+		log.Error("error while adding message: ", err)
+		c.reply(f, err)
+		return
+	}
+	log.Infof("Added message with id %v successfully", resp.Id)
+	c.reply(Frame{ID: f.ID, Message: &resp}, nil)
+}
+
+// subscribe replaces the subscription of the connection with one to the
+// events of the channel of f. Events after LastEventID that are still in the
+// backlog are replayed first.
+func (c *wsConn) subscribe(f Frame) {
+	if f.LastEventID < 0 {
+		c.reply(f, message.Errorf(message.ErrInvalid, "Invalid last event id %d, should be a non-negative integer", f.LastEventID))
+		return
+	}
+	filter := message.Filter{}
+	if f.Filter != nil {
+		filter = *f.Filter
+	}
+	ch, err := c.r.channelNamed(c.t, f.Channel)
+	if err != nil {
+		c.reply(f, err)
+		return
+	}
+	events := ch.m.Events()
+	sub, replay, missed := events.Subscribe(f.LastEventID, filter)
+	if missed {
+		log.Warnf("WebSocket subscription resumed after %d, older events are no longer in the backlog", f.LastEventID)
+	}
+	c.mu.Lock()
+	old, oldEvents := c.sub, c.events
+	c.sub, c.events = sub, events
+	c.mu.Unlock()
+	if old != nil {
+		oldEvents.Unsubscribe(old)
+	}
+
+	c.reply(Frame{ID: f.ID}, nil)
+	go c.forward(sub, replay)
+}
+
+// forward pushes the replayed events and then the events of sub to the
+// client until sub is closed. The replay may be longer than the send queue,
+// so it waits for the writer instead of treating a full queue as a slow
+// client.
+func (c *wsConn) forward(sub *message.Subscription, replay []message.Event) {
+	for i := range replay {
+		select {
+		case c.send <- Frame{Type: frameEvent, Event: &replay[i]}:
+		case <-c.done:
+			return
+		}
+	}
+	for e := range sub.C {
+		e := e
+		c.enqueue(Frame{Type: frameEvent, Event: &e})
+	}
+	c.mu.Lock()
+	current := c.sub == sub
+	c.mu.Unlock()
+	select {
+	case <-c.done:
+	default:
+		// closed by the broker rather than replaced or unsubscribed
+		if current {
+			c.closeSlow()
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialWebSocket(t *testing.T, srv *httptest.Server, header http.Header) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/ws"
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("error while dialing %s: %v (response %+v)", url, err, resp)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) Frame {
+	var f Frame
+	if err := conn.ReadJSON(&f); err != nil {
+		t.Fatal("error while reading frame: ", err)
+	}
+	return f
+}
+
+func Test_appRouter_serveWebSocket(t *testing.T) {
+	r := newTestRouter()
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+	_, _ = r.m.Add(message.MessageObj{Text: "before", Tags: []string{"go"}})
+
+	conn := dialWebSocket(t, srv, nil)
+	defer conn.Close()
+
+	assert.NoError(t, conn.WriteJSON(Frame{Type: frameSubscribe, ID: "s1", Filter: &message.Filter{Tag: "go"}, LastEventID: 0}))
+	f := readFrame(t, conn)
+	assert.Equal(t, Frame{Type: frameAck, ID: "s1"}, f)
+
+	assert.NoError(t, conn.WriteJSON(Frame{Type: framePublish, ID: "p1", Message: &message.MessageObj{Text: "hello", Tags: []string{"go"}}}))
+	f = readFrame(t, conn)
+	assert.Equal(t, frameAck, f.Type)
+	assert.Equal(t, "p1", f.ID)
+	if assert.NotNil(t, f.Message) {
+		assert.Equal(t, int64(2), f.Message.Id)
+	}
+	f = readFrame(t, conn)
+	assert.Equal(t, frameEvent, f.Type)
+	if assert.NotNil(t, f.Event) {
+		assert.Equal(t, message.EventCreated, f.Event.Type)
+		assert.Equal(t, "hello", f.Event.Message.Text)
+	}
+
+	// invalid frames are rejected without closing the connection
+	assert.NoError(t, conn.WriteJSON(Frame{Type: framePublish, ID: "p2", Message: &message.MessageObj{}}))
+	f = readFrame(t, conn)
+	assert.Equal(t, frameError, f.Type)
+	assert.Equal(t, "p2", f.ID)
+	if assert.NotNil(t, f.Error) {
+		assert.Equal(t, "invalid_argument", f.Error.Code)
+	}
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+	assert.Equal(t, frameError, readFrame(t, conn).Type)
+	assert.NoError(t, conn.WriteJSON(Frame{Type: "bogus", ID: "b1"}))
+	f = readFrame(t, conn)
+	assert.Equal(t, frameError, f.Type)
+	assert.Equal(t, "b1", f.ID)
+
+	// a second connection resumes from the backlog
+	resumed := dialWebSocket(t, srv, nil)
+	defer resumed.Close()
+	assert.NoError(t, resumed.WriteJSON(Frame{Type: frameSubscribe, ID: "s2", LastEventID: 1}))
+	assert.Equal(t, frameAck, readFrame(t, resumed).Type)
+	f = readFrame(t, resumed)
+	if assert.NotNil(t, f.Event) {
+		assert.Equal(t, int64(2), f.Event.ID)
+	}
+}
+
+func Test_appRouter_serveWebSocket_channel(t *testing.T) {
+	r := newTestRouter()
+	defer r.tenants.close()
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+	_, err := r.channels.add(Channel{Name: "news", CharLimit: 100})
+	assert.NoError(t, err)
+
+	conn := dialWebSocket(t, srv, nil)
+	defer conn.Close()
+	assert.NoError(t, conn.WriteJSON(Frame{Type: frameSubscribe, ID: "s1", Channel: "news"}))
+	assert.Equal(t, Frame{Type: frameAck, ID: "s1"}, readFrame(t, conn))
+
+	_, _ = r.m.Add(message.MessageObj{Text: "elsewhere"})
+	assert.NoError(t, conn.WriteJSON(Frame{Type: framePublish, ID: "p1", Channel: "news", Message: &message.MessageObj{Text: "hello"}}))
+	f := readFrame(t, conn)
+	assert.Equal(t, frameAck, f.Type)
+	f = readFrame(t, conn)
+	assert.Equal(t, frameEvent, f.Type)
+	if assert.NotNil(t, f.Event) {
+		assert.Equal(t, int64(1), f.Event.ID)
+		assert.Equal(t, "hello", f.Event.Message.Text)
+	}
+
+	assert.NoError(t, conn.WriteJSON(Frame{Type: frameSubscribe, ID: "s2", Channel: "gone"}))
+	f = readFrame(t, conn)
+	assert.Equal(t, frameError, f.Type)
+	if assert.NotNil(t, f.Error) {
+		assert.Equal(t, "not_found", f.Error.Code)
+	}
+}
+
+func Test_appRouter_serveWebSocket_auth(t *testing.T) {
+	r := newTestRouter(WithBasicAuth(map[string]string{"ann": "secret"}))
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/ws"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.SetBasicAuth("ann", "secret")
+	conn := dialWebSocket(t, srv, req.Header)
+	defer conn.Close()
+	assert.NoError(t, conn.WriteJSON(Frame{Type: framePublish, ID: "p1", Message: &message.MessageObj{Text: "hi", Author: "eve"}}))
+	f := readFrame(t, conn)
+	if assert.NotNil(t, f.Message) {
+		assert.Equal(t, "ann", f.Message.Author)
+	}
+}
+
+func Test_appRouter_serveWebSocket_publishTiming(t *testing.T) {
+	r := newTestRouter(WithDefaultTTL(time.Hour))
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+	conn := dialWebSocket(t, srv, nil)
+	defer conn.Close()
+
+	// the message of a publish frame takes the fields of a POST
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "publish", "id": "p1", "message": {"text": "hi", "ttl": 60}}`)))
+	f := readFrame(t, conn)
+	if assert.Equal(t, frameAck, f.Type) && assert.NotNil(t, f.Message.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Minute), *f.Message.ExpiresAt, 5*time.Second)
+	}
+
+	// expires_at is not taken from the client, the default ttl applies
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "publish", "id": "p2", "message": {"text": "hi", "expires_at": "2100-01-01T00:00:00Z"}}`)))
+	f = readFrame(t, conn)
+	if assert.Equal(t, frameAck, f.Type) && assert.NotNil(t, f.Message.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *f.Message.ExpiresAt, 5*time.Second)
+	}
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type": "publish", "id": "p3", "message": {"text": "hi", "ttl": -1}}`)))
+	f = readFrame(t, conn)
+	assert.Equal(t, frameError, f.Type)
+	assert.Equal(t, "p3", f.ID)
+}
+
+func Test_appRouter_serveWebSocket_notUpgraded(t *testing.T) {
+	r := newTestRouter()
+	rec := httptest.NewRecorder()
+	r.GetRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/ws", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, problemContentType, rec.Header().Get("Content-Type"))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultServer  = "http://localhost:8090"
+	defaultProfile = "default"
+)
+
+// profile is a server msgctl talks to and the credentials it uses there.
+type profile struct {
+	Server   string `yaml:"server"`
+	User     string `yaml:"user,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Tenant   string `yaml:"tenant,omitempty"`
+}
+
+// config is the config file of msgctl, e.g.
+//
+//	current-profile: prod
+//	profiles:
+//	  prod:
+//	    server: https://messages.example.com
+//	    user: ann
+//	    password: secret
+//	    tenant: acme
+type config struct {
+	CurrentProfile string             `yaml:"current-profile,omitempty"`
+	Profiles       map[string]profile `yaml:"profiles"`
+}
+
+// configPath returns the path of the config file: $MSGCTL_CONFIG, or else
+// msgctl/config.yaml in the user config directory.
+func configPath() string {
+	if p := os.Getenv("MSGCTL_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "msgctl", "config.yaml")
+}
+
+// loadProfile returns profile name of the config file at path. Without a
+// name it is $MSGCTL_PROFILE, the current profile of the file or "default".
+// A missing config file yields the local server, unless a profile was asked
+// for by name.
+func loadProfile(path, name string) (profile, error) {
+	if name == "" {
+		name = os.Getenv("MSGCTL_PROFILE")
+	}
+	var conf config
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err) || path == "":
+		if name != "" && name != defaultProfile {
+			return profile{}, fmt.Errorf("profile %q not found, no config file at %s", name, path)
+		}
+		return profile{Server: defaultServer}, nil
+	case err != nil:
+		return profile{}, err
+	}
+	if err := yaml.UnmarshalStrict(b, &conf); err != nil {
+		return profile{}, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if name == "" {
+		name = conf.CurrentProfile
+	}
+	if name == "" {
+		name = defaultProfile
+	}
+	p, ok := conf.Profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+	if p.Server == "" {
+		p.Server = defaultServer
+	}
+	return p, nil
+}
@@ -0,0 +1,338 @@
+// Command msgctl operates the messaging service through its HTTP API.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/shailendra-k-singh/example.messaging.service/pkg/client"
+)
+
+const usage = `usage: msgctl <command> [flags] [args]
+
+commands:
+  create [-author A] [-tags a,b] [-queue Q] [-delay D] [-ttl D] [-parent ID] [-idempotency-key K] TEXT
+  get [-palindrome] ID
+  list [-page-size N] [-scheduled]
+  delete ID...
+  watch [-tag T] [-author A] [-after EVENT]
+  export [-format ndjson|csv] [-out FILE]
+  import [-format ndjson|csv] [-preserve-ids] [-in FILE]
+
+Every command takes -o table|json|yaml, -profile NAME and -config FILE. The
+server and credentials come from the profile of the config file, by default
+msgctl/config.yaml in the user config directory; -server and -tenant
+override them.
+`
+
+// errUsage reports invalid arguments, the usage was printed already.
+var errUsage = errors.New("usage")
+
+// globals are the flags every command takes.
+type globals struct {
+	output  string
+	profile string
+	config  string
+	server  string
+	tenant  string
+}
+
+func (g *globals) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.output, "o", outputTable, "output format (table/json/yaml)")
+	fs.StringVar(&g.profile, "profile", "", "profile of the config file to use")
+	fs.StringVar(&g.config, "config", configPath(), "config file")
+	fs.StringVar(&g.server, "server", "", "URL of the server, overrides the profile")
+	fs.StringVar(&g.tenant, "tenant", "", "tenant to act for, overrides the profile")
+}
+
+// client returns the client for the selected profile.
+func (g *globals) client() (*client.Client, error) {
+	p, err := loadProfile(g.config, g.profile)
+	if err != nil {
+		return nil, err
+	}
+	if g.server != "" {
+		p.Server = g.server
+	}
+	if g.tenant != "" {
+		p.Tenant = g.tenant
+	}
+	var opts []client.Option
+	if p.User != "" {
+		opts = append(opts, client.WithBasicAuth(p.User, p.Password))
+	}
+	if p.Tenant != "" {
+		opts = append(opts, client.WithTenant(p.Tenant))
+	}
+	return client.New(p.Server, opts...)
+}
+
+// command is a subcommand of msgctl. flags registers its flags, run runs it
+// with the remaining arguments.
+type command struct {
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, c *client.Client, p *printer, args []string) error
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command line args and returns the exit status of the
+// process.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var g globals
+	fs := flag.NewFlagSet("msgctl "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	g.register(fs)
+	cmd, ok := commands(stdin)[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	cmd.flags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if err := validateOutput(g.output); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	c, err := g.client()
+	if err == nil {
+		err = cmd.run(ctx, c, &printer{w: stdout, format: g.output}, fs.Args())
+	}
+	switch {
+	case err == errUsage:
+		fs.Usage()
+		return 2
+	case err != nil:
+		fmt.Fprintf(stderr, "msgctl %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func commands(stdin io.Reader) map[string]command {
+	var (
+		author, tags, queue, key, tag, format, file string
+		delay, ttl                                  time.Duration
+		parent, after                               int64
+		pageSize                                    int
+		palindrome, scheduled, preserveIDs          bool
+	)
+	return map[string]command{
+		"create": {
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&author, "author", "", "author of the message")
+				fs.StringVar(&tags, "tags", "", "comma separated tags of the message")
+				fs.StringVar(&queue, "queue", "", "queue to put the message on")
+				fs.DurationVar(&delay, "delay", 0, "delay before the message is delivered")
+				fs.DurationVar(&ttl, "ttl", 0, "time the message lives once delivered")
+				fs.Int64Var(&parent, "parent", 0, "id of the message replied to")
+				fs.StringVar(&key, "idempotency-key", "", "idempotency key, repeating a create with the same key creates one message")
+			},
+			run: func(ctx context.Context, c *client.Client, p *printer, args []string) error {
+				if len(args) == 0 {
+					return errUsage
+				}
+				req := client.MessageRequest{
+					Text:         strings.Join(args, " "),
+					Author:       author,
+					Queue:        queue,
+					DelaySeconds: int64(delay / time.Second),
+					TTL:          int64(ttl / time.Second),
+					ParentId:     parent,
+				}
+				if tags != "" {
+					req.Tags = strings.Split(tags, ",")
+				}
+				var opts []client.CallOption
+				if key != "" {
+					opts = append(opts, client.WithIdempotencyKey(key))
+				}
+				msg, err := c.Create(ctx, req, opts...)
+				if err != nil {
+					return err
+				}
+				return p.print(msg)
+			},
+		},
+		"get": {
+			flags: func(fs *flag.FlagSet) {
+				fs.BoolVar(&palindrome, "palindrome", false, "check whether the text is a palindrome")
+			},
+			run: func(ctx context.Context, c *client.Client, p *printer, args []string) error {
+				ids, err := parseIDs(args, 1)
+				if err != nil {
+					return err
+				}
+				msg, err := c.Get(ctx, ids[0])
+				if err != nil {
+					return err
+				}
+				if palindrome {
+					ok, err := c.CheckPalindrome(ctx, ids[0])
+					if err != nil {
+						return err
+					}
+					msg.IsPalindrome = &ok
+				}
+				return p.print(msg)
+			},
+		},
+		"list": {
+			flags: func(fs *flag.FlagSet) {
+				fs.IntVar(&pageSize, "page-size", 0, "messages fetched per request")
+				fs.BoolVar(&scheduled, "scheduled", false, "also list messages not delivered yet")
+			},
+			run: func(ctx context.Context, c *client.Client, p *printer, args []string) error {
+				if len(args) > 0 {
+					return errUsage
+				}
+				msgs := []message.MessageObj{}
+				it := c.List(ctx, client.ListOptions{PageSize: pageSize, IncludeScheduled: scheduled})
+				for it.Next() {
+					msgs = append(msgs, it.Message())
+				}
+				if err := it.Err(); err != nil {
+					return err
+				}
+				return p.print(msgs)
+			},
+		},
+		"delete": {
+			flags: func(fs *flag.FlagSet) {},
+			run: func(ctx context.Context, c *client.Client, p *printer, args []string) error {
+				ids, err := parseIDs(args, -1)
+				if err != nil {
+					return err
+				}
+				for _, id := range ids {
+					if err := c.Delete(ctx, id); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		"watch": {
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&tag, "tag", "", "only follow messages with this tag")
+				fs.StringVar(&author, "author", "", "only follow messages of this author")
+				fs.Int64Var(&after, "after", 0, "replay the events after this event id")
+			},
+			run: func(ctx context.Context, c *client.Client, p *printer, args []string) error {
+				if len(args) > 0 {
+					return errUsage
+				}
+				s, err := c.Watch(ctx, client.WatchOptions{Tag: tag, Author: author, After: after})
+				if err != nil {
+					return err
+				}
+				defer s.Close()
+				for s.Next() {
+					if err := p.stream(s.Event()); err != nil {
+						return err
+					}
+				}
+				if err := s.Err(); err != nil && err != context.Canceled {
+					return err
+				}
+				return nil
+			},
+		},
+		"export": {
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&format, "format", client.FormatNDJSON, "dump format (ndjson/csv)")
+				fs.StringVar(&file, "out", "", "file to export to (default stdout)")
+			},
+			run: func(ctx context.Context, c *client.Client, p *printer, args []string) error {
+				if len(args) > 0 {
+					return errUsage
+				}
+				out := p.w
+				if file != "" {
+					f, err := os.Create(file)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					out = f
+				}
+				_, err := c.Export(ctx, format, out)
+				return err
+			},
+		},
+		"import": {
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&format, "format", client.FormatNDJSON, "dump format (ndjson/csv)")
+				fs.StringVar(&file, "in", "", "file to import from (default stdin)")
+				fs.BoolVar(&preserveIDs, "preserve-ids", false, "keep the message ids of the dump")
+			},
+			run: func(ctx context.Context, c *client.Client, p *printer, args []string) error {
+				if len(args) > 0 {
+					return errUsage
+				}
+				in := stdin
+				if file != "" {
+					f, err := os.Open(file)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					in = f
+				}
+				res, err := c.Import(ctx, format, in, preserveIDs)
+				if err != nil {
+					return err
+				}
+				if err := p.print(res); err != nil {
+					return err
+				}
+				if res.Failed > 0 {
+					return fmt.Errorf("%d records were not imported", res.Failed)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// parseIDs parses message ids, exactly n of them or at least one if n is
+// negative.
+func parseIDs(args []string, n int) ([]int64, error) {
+	if len(args) == 0 || (n >= 0 && len(args) != n) {
+		return nil, errUsage
+	}
+	ids := make([]int64, len(args))
+	for i, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid message id %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
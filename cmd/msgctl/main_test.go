@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/app"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/stretchr/testify/assert"
+)
+
+// testEnv is a server of its own for a test, with a msgctl config file
+// whose current profile acts for tenant acme on it.
+type testEnv struct {
+	dir    string
+	config string
+	srv    *httptest.Server
+	stop   func()
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	// tracing is left out, its metrics can be registered once per process
+	r := app.NewAppRouter(200, app.WithTenantIDs([]string{"acme"}))
+	r.SetRoutes()
+	e := &testEnv{srv: httptest.NewServer(r.GetRouter())}
+	e.stop = func() {
+		e.srv.Close()
+		r.Close()
+	}
+	dir, err := ioutil.TempDir("", "msgctl")
+	if err != nil {
+		e.stop()
+		t.Fatal(err)
+	}
+	e.dir = dir
+	e.config = filepath.Join(dir, "config.yaml")
+	conf := "current-profile: test\nprofiles:\n  test:\n    server: " + e.srv.URL + "\n    tenant: acme\n"
+	if err := ioutil.WriteFile(e.config, []byte(conf), 0600); err != nil {
+		e.close()
+		t.Fatal(err)
+	}
+	return e
+}
+
+func (e *testEnv) close() {
+	e.stop()
+	os.RemoveAll(e.dir)
+}
+
+// args returns args with the config of e added after the command.
+func (e *testEnv) args(args ...string) []string {
+	return append(args[:1:1], append([]string{"-config", e.config}, args[1:]...)...)
+}
+
+// msgctl runs msgctl with args against the server of e, returning the exit
+// status and the output.
+func (e *testEnv) msgctl(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), e.args(args...), strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestMsgctl(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	msgctl := e.msgctl
+	code, out, _ := msgctl("", "create", "-o", "json", "-tags", "a,b", "abba")
+	assert.Equal(t, 0, code)
+	var msg message.MessageObj
+	assert.NoError(t, json.Unmarshal([]byte(out), &msg))
+	assert.Equal(t, int64(1), msg.Id)
+	assert.Equal(t, []string{"a", "b"}, msg.Tags)
+
+	code, out, _ = msgctl("", "get", "-palindrome", "1")
+	assert.Equal(t, 0, code)
+	assert.Regexp(t, `ID\s+AUTHOR\s+TAGS\s+DELIVER AT\s+TEXT\s+PALINDROME\n1\s+-\s+a,b\s+-\s+abba\s+true\n`, out)
+
+	msgctl("", "create", "second", "message")
+	code, out, _ = msgctl("", "list", "-o", "yaml", "-page-size", "1")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "- id: 1\n  text: abba\n  tags:\n  - a\n  - b\n- id: 2\n  text: second message\n", out)
+
+	code, out, _ = msgctl("", "export", "-format", "csv")
+	assert.Equal(t, 0, code)
+	dump := out
+	assert.Contains(t, dump, "second message")
+
+	code, _, _ = msgctl("", "delete", "1", "2")
+	assert.Equal(t, 0, code)
+	code, _, errOut := msgctl("", "get", "1")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "not_found")
+
+	code, out, _ = msgctl(dump, "import", "-format", "csv")
+	assert.Equal(t, 0, code)
+	assert.Regexp(t, `IMPORTED\s+FAILED\n2\s+0\n`, out)
+
+	code, _, errOut = msgctl("", "get", "x")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, `invalid message id "x"`)
+	code, _, _ = msgctl("", "get")
+	assert.Equal(t, 2, code)
+	code, _, _ = msgctl("", "list", "-o", "xml")
+	assert.Equal(t, 2, code)
+	code, _, _ = msgctl("", "frobnicate")
+	assert.Equal(t, 2, code)
+}
+
+func TestMsgctl_watch(t *testing.T) {
+	e := newTestEnv(t)
+	defer e.close()
+	ctx, cancel := context.WithCancel(context.Background())
+	out := &syncBuffer{}
+	done := make(chan int)
+	go func() {
+		done <- run(ctx, e.args("watch", "-o", "json"), nil, out, ioutil.Discard)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "hello") && time.Now().Before(deadline) {
+		e.msgctl("", "create", "hello")
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	assert.Equal(t, 0, <-done)
+	var ev message.Event
+	line := strings.SplitN(out.String(), "\n", 2)[0]
+	assert.NoError(t, json.Unmarshal([]byte(line), &ev))
+	assert.Equal(t, message.EventCreated, ev.Type)
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msgctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	conf := `current-profile: prod
+profiles:
+  prod:
+    server: https://messages.example.com
+    user: ann
+    password: secret
+  local:
+    tenant: acme
+`
+	assert.NoError(t, ioutil.WriteFile(path, []byte(conf), 0600))
+
+	p, err := loadProfile(path, "")
+	assert.NoError(t, err)
+	assert.Equal(t, profile{Server: "https://messages.example.com", User: "ann", Password: "secret"}, p)
+	p, err = loadProfile(path, "local")
+	assert.NoError(t, err)
+	assert.Equal(t, profile{Server: defaultServer, Tenant: "acme"}, p)
+	_, err = loadProfile(path, "staging")
+	assert.EqualError(t, err, `profile "staging" not found in `+path)
+
+	p, err = loadProfile(filepath.Join(dir, "missing.yaml"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultServer, p.Server)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("profiles:\n  prod:\n    url: x\n"), 0600))
+	_, err = loadProfile(path, "prod")
+	assert.Error(t, err)
+}
+
+// syncBuffer is a bytes.Buffer safe to write and read concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+	"github.com/shailendra-k-singh/example.messaging.service/pkg/client"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+const maxTextWidth = 60
+
+func validateOutput(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("invalid output %q, should be %s, %s or %s", format, outputTable, outputJSON, outputYAML)
+}
+
+// printer writes results to w in format.
+type printer struct {
+	w      io.Writer
+	format string
+	// n counts the values printed by stream
+	n int
+}
+
+// print prints v, a single message, a list of messages or an import result.
+func (p *printer) print(v interface{}) error {
+	switch p.format {
+	case outputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	case outputYAML:
+		return p.yaml(v)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	switch v := v.(type) {
+	case message.MessageObj:
+		writeMessageHeader(tw, v.IsPalindrome != nil)
+		writeMessage(tw, v)
+	case []message.MessageObj:
+		writeMessageHeader(tw, false)
+		for _, msg := range v {
+			writeMessage(tw, msg)
+		}
+	case client.ImportResult:
+		fmt.Fprintf(tw, "IMPORTED\tFAILED\n%d\t%d\n", v.Imported, v.Failed)
+		if len(v.Errors) > 0 {
+			fmt.Fprintf(tw, "\nRECORD\tERROR\n")
+			for _, e := range v.Errors {
+				fmt.Fprintf(tw, "%d\t%s\n", e.Record, e.Error.Detail)
+			}
+		}
+	default:
+		return fmt.Errorf("can not print %T as a table", v)
+	}
+	return tw.Flush()
+}
+
+// stream prints an event of a stream. JSON streams have one event per
+// line, YAML streams one document per event.
+func (p *printer) stream(e message.Event) error {
+	defer func() { p.n++ }()
+	switch p.format {
+	case outputJSON:
+		return json.NewEncoder(p.w).Encode(e)
+	case outputYAML:
+		if p.n > 0 {
+			if _, err := fmt.Fprintln(p.w, "---"); err != nil {
+				return err
+			}
+		}
+		return p.yaml(e)
+	}
+	tw := tabwriter.NewWriter(p.w, 8, 4, 2, ' ', 0)
+	if p.n == 0 {
+		fmt.Fprintf(tw, "EVENT\tTYPE\tTIME\tID\tTEXT\n")
+	}
+	fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\n", e.ID, e.Type, e.Time.Local().Format(time.RFC3339), e.Message.Id, shorten(e.Message.Text))
+	return tw.Flush()
+}
+
+// yaml prints v with the field names and order of its JSON encoding.
+func (p *printer) yaml(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var doc interface{} = &yaml.MapSlice{}
+	if len(b) > 0 && b[0] == '[' {
+		doc = &[]yaml.MapSlice{}
+	}
+	if err := yaml.Unmarshal(b, doc); err != nil {
+		return err
+	}
+	b, err = yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = p.w.Write(b)
+	return err
+}
+
+func writeMessageHeader(w io.Writer, palindrome bool) {
+	fmt.Fprint(w, "ID\tAUTHOR\tTAGS\tDELIVER AT\tTEXT")
+	if palindrome {
+		fmt.Fprint(w, "\tPALINDROME")
+	}
+	fmt.Fprintln(w)
+}
+
+func writeMessage(w io.Writer, msg message.MessageObj) {
+	deliver := "-"
+	if msg.DeliverAt != nil {
+		deliver = msg.DeliverAt.Local().Format(time.RFC3339)
+	}
+	fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s", msg.Id, orDash(msg.Author), orDash(strings.Join(msg.Tags, ",")), deliver, shorten(msg.Text))
+	if msg.IsPalindrome != nil {
+		fmt.Fprintf(w, "\t%s", strconv.FormatBool(*msg.IsPalindrome))
+	}
+	fmt.Fprintln(w)
+}
+
+// shorten fits text on a table row.
+func shorten(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > maxTextWidth {
+		return string(r[:maxTextWidth-1]) + "…"
+	}
+	return text
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
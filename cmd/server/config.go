@@ -6,15 +6,34 @@ import (
 )
 
 type config struct {
-	logLevel     string
-	logFormat    string
-	reqPort      string
-	tracingLib   string
-	tracingAddr  string
-	tracingHost  string
-	charLimit    int
-	readTimeout  time.Duration
-	writeTimeout time.Duration
+	logLevel       string
+	logFormat      string
+	reqPort        string
+	grpcPort       string
+	tracingLib     string
+	tracingAddr    string
+	tracingHost    string
+	charLimit      int
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	idemWindow     time.Duration
+	dataDir        string
+	eventBacklog   int
+	authFile       string
+	hookAttempts   int
+	hookBackoff    time.Duration
+	hookNetworks   string
+	defaultTTL     time.Duration
+	deletePolicy   string
+	trashRetention time.Duration
+	admins         string
+	revisionLimit  int
+	tenantsFile    string
+	tenantIDs      string
+	gqlMaxDepth    int
+	gqlMaxCost     int
+	validateAPI    bool
+	cacheControl   string
 }
 
 var conf config
@@ -23,11 +42,30 @@ func loadConfig() {
 	flag.StringVar(&conf.logLevel, "log-level", "debug", "logging level for application")
 	flag.StringVar(&conf.logFormat, "log-format", "json", "logging format for application")
 	flag.StringVar(&conf.reqPort, "req-port", ":8090", "request port for incoming route queries")
+	flag.StringVar(&conf.grpcPort, "grpc-port", ":9090", "port of the gRPC API, the gRPC API is disabled if empty")
 	flag.StringVar(&conf.tracingLib, "tracing-lib", "jaeger", "tracing library")
 	flag.StringVar(&conf.tracingHost, "tracing-host", "localhost", "tracing host (localhost/service-name)")
 	flag.StringVar(&conf.tracingAddr, "tracing-addr", ":6831", "tracing reporter address")
 	flag.IntVar(&conf.charLimit, "char-limit", 280, "character limit in the input message")
 	flag.DurationVar(&conf.readTimeout, "read-timeout", 3*time.Second, "read timeout for HTTP server")
 	flag.DurationVar(&conf.writeTimeout, "write-timeout", 5*time.Second, "write timeout for HTTP server")
+	flag.DurationVar(&conf.idemWindow, "idempotency-window", 24*time.Hour, "how long responses to requests with an Idempotency-Key are remembered")
+	flag.StringVar(&conf.dataDir, "data-dir", "", "directory to persist messages in, messages are kept in memory only if empty")
+	flag.IntVar(&conf.eventBacklog, "event-backlog", 1000, "number of recent message events kept for resuming event streams")
+	flag.StringVar(&conf.authFile, "auth-file", "", "file of user:password lines required as basic auth credentials, authentication is disabled if empty")
+	flag.IntVar(&conf.hookAttempts, "webhook-max-attempts", 5, "number of attempts to deliver a webhook before giving up")
+	flag.DurationVar(&conf.hookBackoff, "webhook-backoff", time.Second, "delay before the first webhook retry, doubled for every further retry")
+	flag.StringVar(&conf.hookNetworks, "webhook-allow-networks", "", "comma separated CIDRs of internal networks webhooks may deliver to, e.g. 10.0.0.0/8; loopback, private and link-local addresses are refused otherwise")
+	flag.DurationVar(&conf.defaultTTL, "default-ttl", 0, "time after which messages created without a ttl expire, messages are kept until deleted if 0")
+	flag.StringVar(&conf.deletePolicy, "delete-policy", "orphan", "what happens to the replies of a deleted message: orphan, cascade or tombstone")
+	flag.DurationVar(&conf.trashRetention, "trash-retention", 720*time.Hour, "time deleted messages are kept in the trash before they are purged, deletes are permanent if 0")
+	flag.StringVar(&conf.admins, "admins", "", "comma separated users of -auth-file allowed to delete messages for good with ?hard=true")
+	flag.IntVar(&conf.revisionLimit, "revision-limit", 100, "number of revisions kept per message, all are kept if 0")
+	flag.StringVar(&conf.tenantsFile, "tenants-file", "", "file of user:tenant lines assigning users of -auth-file to tenants, unassigned users belong to the default tenant")
+	flag.StringVar(&conf.tenantIDs, "tenants", "", "comma separated tenants served in addition to the ones of -tenants-file, requests naming other tenants are rejected with 404")
+	flag.IntVar(&conf.gqlMaxDepth, "graphql-max-depth", 10, "maximum depth of the fields of a GraphQL operation")
+	flag.IntVar(&conf.gqlMaxCost, "graphql-max-complexity", 1000, "maximum complexity of a GraphQL operation, fields below paginated fields count once per message of the page")
+	flag.BoolVar(&conf.validateAPI, "openapi-validation", false, "reject requests not matching the OpenAPI document with 400, and log and count responses not matching it")
+	flag.StringVar(&conf.cacheControl, "cache-control", "", "Cache-Control headers of GET responses as ';' separated operationId=policy pairs overriding the defaults, e.g. \"getMessage=private, max-age=60\"")
 	flag.Parse()
 }
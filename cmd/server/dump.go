@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const dumpUsage = `usage: server export -data-dir DIR [-format ndjson|csv] [-out FILE]
+       server import -data-dir DIR [-format ndjson|csv] [-preserve-ids] [-in FILE]
+
+export and import operate offline on the data directory of a stopped server.
+`
+
+// runDumpCommand runs the export or import subcommand and returns the exit
+// status of the process.
+func runDumpCommand(cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), dumpUsage) }
+	dataDir := fs.String("data-dir", "", "data directory of the message store")
+	format := fs.String("format", message.FormatNDJSON, "dump format (ndjson/csv)")
+	file := fs.String("out", "", "file to export to (default stdout)")
+	preserveIDs := false
+	if cmd == "import" {
+		file = fs.String("in", "", "file to import from (default stdin)")
+		fs.BoolVar(&preserveIDs, "preserve-ids", false, "keep the message ids of the dump")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dataDir == "" {
+		fmt.Fprintln(os.Stderr, "-data-dir is required")
+		fs.Usage()
+		return 2
+	}
+
+	m, err := message.Open(*dataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while opening data directory: ", err)
+		return 1
+	}
+	defer m.Close()
+
+	if cmd == "export" {
+		err = exportDump(m, *file, *format)
+	} else {
+		err = importDump(m, *file, *format, preserveIDs)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
+
+func exportDump(m *message.MessageServer, file, format string) error {
+	var out io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	dw, err := message.NewDumpWriter(out, format)
+	if err != nil {
+		return err
+	}
+	n := 0
+	err = m.Export(func(msg message.MessageObj) error {
+		n++
+		return dw.Write(msg)
+	})
+	if err == nil {
+		err = dw.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d messages\n", n)
+	return nil
+}
+
+func importDump(m *message.MessageServer, file, format string, preserveIDs bool) error {
+	var in io.Reader = os.Stdin
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	dr, err := message.NewDumpReader(in, format)
+	if err != nil {
+		return err
+	}
+	res, err := message.ImportDump(m, dr, preserveIDs, nil)
+	for _, e := range res.Errors {
+		fmt.Fprintf(os.Stderr, "record %d: %v\n", e.Record, e.Err)
+	}
+	fmt.Fprintf(os.Stderr, "imported %d messages, %d failed\n", res.Imported, res.Failed)
+	return err
+}
@@ -1,27 +1,122 @@
 package main
 
 import (
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/shailendra-k-singh/example.messaging.service/app"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
 	logger "github.com/shailendra-k-singh/example.messaging.service/pkg/log"
-
-	_ "github.com/shailendra-k-singh/example.messaging.service/docs"
 )
 
+func readUsers(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return app.ReadUsers(f)
+}
+
+func readTenants(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return app.ReadTenants(f)
+}
+
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "export" || os.Args[1] == "import") {
+		os.Exit(runDumpCommand(os.Args[1], os.Args[2:]))
+	}
 	loadConfig()
 	log := logger.NewLogger(conf.logLevel, conf.logFormat)
 	log.Info("Starting Messaging Service")
 
+	policy, err := message.ParseDeletePolicy(conf.deletePolicy)
+	if err != nil {
+		log.Fatal("Error while parsing flags: ", err)
+	}
+	cachePolicies, err := app.ParseCachePolicies(conf.cacheControl)
+	if err != nil {
+		log.Fatal("Error while parsing flags: ", err)
+	}
+	tenantIDs, err := app.ParseTenantIDs(conf.tenantIDs)
+	if err != nil {
+		log.Fatal("Error while parsing flags: ", err)
+	}
+	hookNetworks, err := app.ParseNetworks(conf.hookNetworks)
+	if err != nil {
+		log.Fatal("Error while parsing flags: ", err)
+	}
+	opts := []app.Option{
+		app.WithIdempotencyWindow(conf.idemWindow),
+		app.WithEventBacklog(conf.eventBacklog),
+		app.WithWebhookDelivery(conf.hookAttempts, conf.hookBackoff),
+		app.WithWebhookNetworks(hookNetworks),
+		app.WithDefaultTTL(conf.defaultTTL),
+		app.WithDeletePolicy(policy),
+		app.WithTrashRetention(conf.trashRetention),
+		app.WithRevisionLimit(conf.revisionLimit),
+		app.WithGraphQLLimits(conf.gqlMaxDepth, conf.gqlMaxCost),
+		app.WithCacheControl(cachePolicies),
+		app.WithTenantIDs(tenantIDs),
+	}
+	if conf.validateAPI {
+		log.Info("Validating requests and responses against the OpenAPI document")
+		opts = append(opts, app.WithOpenAPIValidation())
+	}
+	if conf.dataDir != "" {
+		log.Info("Opening message store in ", conf.dataDir)
+		m, err := message.Open(conf.dataDir)
+		if err != nil {
+			log.Fatal("Error while opening message store: ", err)
+		}
+		defer m.Close()
+		opts = append(opts, app.WithMessageServer(m))
+	}
+	if conf.authFile != "" {
+		users, err := readUsers(conf.authFile)
+		if err != nil {
+			log.Fatal("Error while reading auth file: ", err)
+		}
+		log.Infof("Basic authentication enabled for %d users", len(users))
+		opts = append(opts, app.WithBasicAuth(users))
+		if conf.admins != "" {
+			opts = append(opts, app.WithAdmins(strings.Split(conf.admins, ",")))
+		}
+		if conf.tenantsFile != "" {
+			tenants, err := readTenants(conf.tenantsFile)
+			if err != nil {
+				log.Fatal("Error while reading tenants file: ", err)
+			}
+			opts = append(opts, app.WithTenants(tenants))
+		}
+	}
+
 	// Get new appRouter instance
 	log.Info("Creating new appRouter instance")
-	r := app.NewAppRouter(conf.charLimit)
+	r := app.NewAppRouter(conf.charLimit, opts...)
 	log.Info("Initializing tracing and routes")
-	err := r.InitTracing(conf.tracingLib, conf.tracingHost,conf.tracingAddr)
+	err = r.InitTracing(conf.tracingLib, conf.tracingHost,conf.tracingAddr)
 	if err != nil {
 		log.Fatal("Error while initializing tracing: ", err)
 	}
+	if conf.dataDir != "" {
+		err = r.OpenChannels(filepath.Join(conf.dataDir, "channels"))
+		if err != nil {
+			log.Fatal("Error while opening channels: ", err)
+		}
+		err = r.OpenTenants(filepath.Join(conf.dataDir, "tenants"))
+		if err != nil {
+			log.Fatal("Error while opening tenants: ", err)
+		}
+	}
 	r.SetRoutes()
 
 	srv := http.Server{
@@ -29,10 +124,28 @@ func main() {
 		Handler:      r.GetRouter(),
 		ReadTimeout:  conf.readTimeout,
 		WriteTimeout: conf.writeTimeout,
+		ConnContext:  app.ConnContext,
+	}
+
+	stopGRPC := func() {}
+	if conf.grpcPort != "" {
+		lis, err := net.Listen("tcp", conf.grpcPort)
+		if err != nil {
+			log.Fatal("Error while listening for gRPC: ", err)
+		}
+		gs := r.NewGRPCServer()
+		stopGRPC = gs.Stop
+		log.Info("Starting gRPC server on ", conf.grpcPort)
+		go func() {
+			if err := gs.Serve(lis); err != nil {
+				log.Error("gRPC server closed with error: ", err)
+			}
+		}()
 	}
 
 	log.Info("Starting HTTP server")
 	err = srv.ListenAndServe()
+	stopGRPC()
 	r.Close()
 	if err != nil {
 		log.Fatal("Server closed with error: ", err)
@@ -1,15 +1,23 @@
 module github.com/shailendra-k-singh/example.messaging.service
 
-go 1.13
+go 1.16
 
 require (
 	github.com/codahale/hdrhistogram v0.0.0-20161010025455-3a0bb77429bd // indirect
-	github.com/gorilla/mux v1.7.4
+	github.com/getkin/kin-openapi v0.80.0
+	github.com/golang/protobuf v1.4.2
+	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/graphql-go/graphql v0.8.0
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/prometheus/client_golang v1.7.1
 	github.com/sirupsen/logrus v1.6.0
-	github.com/stretchr/testify v1.4.0
+	github.com/stretchr/testify v1.6.1
 	github.com/uber/jaeger-client-go v2.25.0+incompatible
 	github.com/uber/jaeger-lib v2.2.0+incompatible
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	go.uber.org/atomic v1.6.0 // indirect
+	google.golang.org/grpc v1.33.2
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,60 @@
+package message
+
+import (
+	"strconv"
+	"time"
+)
+
+// changeLog counts the changes applied to the messages of a server and
+// records when they were made, from which HTTP caches are validated. Changes
+// replayed from the journal count as made when the server was opened, and
+// the instance keeps the versions of different servers and different runs
+// of the same server apart.
+//
+// changeLog is guarded by the MessageServer lock.
+type changeLog struct {
+	instance string
+	count    int64
+	last     time.Time
+	modified map[int64]time.Time
+}
+
+func newChangeLog() changeLog {
+	now := time.Now().UTC()
+	return changeLog{
+		instance: strconv.FormatInt(now.UnixNano(), 36),
+		last:     now,
+		modified: make(map[int64]time.Time),
+	}
+}
+
+// record records rec as applied at now.
+func (c *changeLog) record(rec journalRecord, now time.Time) {
+	if len(rec.Put) == 0 && len(rec.Delete) == 0 {
+		return
+	}
+	c.count++
+	c.last = now
+	for _, msg := range rec.Put {
+		c.modified[msg.Id] = now
+	}
+	for _, id := range rec.Delete {
+		delete(c.modified, id)
+	}
+}
+
+// Version returns an opaque version of the messages of the server, which
+// changes with every change to them, and when they last changed.
+func (m *MessageServer) Version() (string, time.Time) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.changes.instance + "." + strconv.FormatInt(m.changes.count, 10), m.changes.last
+}
+
+// Modified returns when message id last changed, the zero time if there is
+// no such message.
+func (m *MessageServer) Modified(id int64) time.Time {
+	m.RLock()
+	defer m.RUnlock()
+	return m.changes.modified[id]
+}
@@ -0,0 +1,49 @@
+package message
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageServer_Version(t *testing.T) {
+	m := NewMessageServer()
+	v0, _ := m.Version()
+	if v, _ := NewMessageServer().Version(); v == v0 {
+		t.Errorf("Version() of two servers = %q, want different versions", v)
+	}
+
+	msg, _ := m.Add(MessageObj{Text: "hello"})
+	v1, changed := m.Version()
+	if v1 == v0 {
+		t.Errorf("Version() after Add = %q, want a new version", v1)
+	}
+	modified := m.Modified(msg.Id)
+	if modified.IsZero() || !modified.Equal(changed) {
+		t.Errorf("Modified() = %v, want %v", modified, changed)
+	}
+
+	if _, err := m.Get(msg.Id); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if v, _ := m.Version(); v != v1 {
+		t.Errorf("Version() after Get = %q, want %q", v, v1)
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := m.Update(msg.Id, MessageObj{Text: "edited"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if v, _ := m.Version(); v == v1 {
+		t.Errorf("Version() after Update = %q, want a new version", v)
+	}
+	if !m.Modified(msg.Id).After(modified) {
+		t.Errorf("Modified() after Update = %v, want after %v", m.Modified(msg.Id), modified)
+	}
+
+	if err := m.Delete(msg.Id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got := m.Modified(msg.Id); !got.IsZero() {
+		t.Errorf("Modified() of deleted message = %v, want zero", got)
+	}
+}
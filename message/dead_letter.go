@@ -0,0 +1,174 @@
+package message
+
+import (
+	"sort"
+	"time"
+)
+
+// maxFailures is the number of most recent failures kept per message.
+const maxFailures = 10
+
+// Failure records why a received message was not acknowledged.
+type Failure struct {
+	Time   time.Time `json:"time" xml:"time"`
+	Reason string    `json:"reason" xml:"reason"`
+}
+
+// DeadLetter records why and from where a message was moved to a
+// dead-letter queue.
+type DeadLetter struct {
+	SourceQueue string    `json:"source_queue" xml:"source_queue"`
+	Reason      string    `json:"reason" xml:"reason"`
+	Time        time.Time `json:"time" xml:"time"`
+}
+
+// QueuePolicy configures a queue. Once a message was received
+// MaxReceiveCount times without being acknowledged, the next receive moves
+// it to DeadLetterQueue. A zero MaxReceiveCount lets messages cycle forever.
+type QueuePolicy struct {
+	MaxReceiveCount int    `json:"max_receive_count,omitempty"`
+	DeadLetterQueue string `json:"dead_letter_queue,omitempty"`
+}
+
+// QueueStats counts the messages of a queue.
+type QueueStats struct {
+	Visible   int `json:"visible"`
+	InFlight  int `json:"in_flight"`
+	Scheduled int `json:"scheduled"`
+}
+
+// addFailure returns failures with f appended, keeping the last maxFailures.
+// failures is not modified since it may be shared with a stored message.
+func addFailure(failures []Failure, f Failure) []Failure {
+	if len(failures) >= maxFailures {
+		failures = failures[len(failures)-maxFailures+1:]
+	}
+	return append(append([]Failure(nil), failures...), f)
+}
+
+// SetQueuePolicy sets the policy of queue, a zero policy removes it.
+func (m *MessageServer) SetQueuePolicy(queue string, p QueuePolicy) error {
+	if p.MaxReceiveCount > 0 && (p.DeadLetterQueue == "" || p.DeadLetterQueue == queue) {
+		return Errorf(ErrInvalid, "a max receive count requires a dead-letter queue other than %s", queue)
+	}
+	m.Lock()
+	defer m.Unlock()
+	rec := m.newRecord(0)
+	rec.Policies = map[string]QueuePolicy{queue: p}
+	return m.commit(rec)
+}
+
+// QueuePolicy returns the policy of queue.
+func (m *MessageServer) QueuePolicy(queue string) QueuePolicy {
+	m.RLock()
+	defer m.RUnlock()
+	return m.policies[queue]
+}
+
+// QueueStats counts the visible, leased and scheduled messages of queue.
+func (m *MessageServer) QueueStats(queue string) QueueStats {
+	m.RLock()
+	defer m.RUnlock()
+	now := time.Now()
+	var stats QueueStats
+	for id, msg := range m.msgStore {
+		if msg.Queue != queue || msg.expired(now) || msg.trashed() {
+			continue
+		}
+		if msg.isScheduled() {
+			stats.Scheduled++
+		} else if l, ok := m.leases[id]; ok && l.until.After(now) {
+			stats.InFlight++
+		} else {
+			stats.Visible++
+		}
+	}
+	return stats
+}
+
+// QueueMessages returns the delivered messages of queue in ID order, e.g. to
+// inspect a dead-letter queue.
+func (m *MessageServer) QueueMessages(queue string) []MessageObj {
+	m.RLock()
+	defer m.RUnlock()
+	now := time.Now()
+	msgs := []MessageObj{}
+	for _, msg := range m.msgStore {
+		if msg.Queue == queue && !msg.isScheduled() && !msg.expired(now) && !msg.trashed() {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Id < msgs[j].Id })
+	return msgs
+}
+
+// Redrive moves dead-lettered messages of queue back to the queue they came
+// from, or to queue to if set, with their receive count reset. The failures
+// of a message are kept. If ids is empty every dead-lettered message of the
+// queue is moved, otherwise nothing is moved unless all ids are
+// dead-lettered messages of the queue.
+func (m *MessageServer) Redrive(queue string, ids []int64, to string) ([]MessageObj, error) {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	if len(ids) == 0 {
+		for id, msg := range m.msgStore {
+			if msg.Queue == queue && msg.DeadLetter != nil && !msg.expired(now) && !msg.trashed() {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	rec := m.newRecord(0)
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		msg, ok := m.lookup(id, now)
+		if !ok || msg.Queue != queue || msg.DeadLetter == nil || seen[id] {
+			return nil, Errorf(ErrNotFound, "message %d is not a dead letter in queue %s", id, queue)
+		}
+		seen[id] = true
+		msg.Queue = msg.DeadLetter.SourceQueue
+		if to != "" {
+			msg.Queue = to
+		}
+		msg.ReceiveCount = 0
+		msg.DeadLetter = nil
+		rec.Put = append(rec.Put, msg)
+		rec.addEvent(EventUpdated, msg)
+	}
+	if len(rec.Put) == 0 {
+		return []MessageObj{}, nil
+	}
+	if err := m.commit(rec); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		delete(m.leases, id)
+	}
+	return rec.Put, nil
+}
+
+// Purge deletes every message of queue and returns how many were deleted.
+func (m *MessageServer) Purge(queue string) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	rec := m.newRecord(0)
+	for id, msg := range m.msgStore {
+		if msg.Queue == queue {
+			rec.Delete = append(rec.Delete, id)
+		}
+	}
+	sort.Slice(rec.Delete, func(i, j int) bool { return rec.Delete[i] < rec.Delete[j] })
+	for _, id := range rec.Delete {
+		if msg := m.msgStore[id]; !msg.trashed() {
+			rec.addEvent(EventDeleted, msg)
+		}
+	}
+	if len(rec.Delete) == 0 {
+		return 0, nil
+	}
+	if err := m.commit(rec); err != nil {
+		return 0, err
+	}
+	return len(rec.Delete), nil
+}
@@ -0,0 +1,83 @@
+package message
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMessageServer_deadLetter(t *testing.T) {
+	m := NewMessageServer()
+	if err := m.SetQueuePolicy("jobs", QueuePolicy{MaxReceiveCount: 2}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("SetQueuePolicy() without dead-letter queue error = %v, want ErrInvalid", err)
+	}
+	if err := m.SetQueuePolicy("jobs", QueuePolicy{MaxReceiveCount: 2, DeadLetterQueue: "jobs-dlq"}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = m.AddBatch([]MessageObj{{Text: "poison", Queue: "jobs"}, {Text: "fine", Queue: "jobs"}})
+
+	first, _ := m.Receive("jobs", 1, time.Hour)
+	_, _ = m.Nack("jobs", []string{first[0].Receipt}, 0, "bad input")
+	second, _ := m.Receive("jobs", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// the third receive moves message 1 on and leases message 2
+	third, _ := m.Receive("jobs", 1, time.Hour)
+	if len(third) != 1 || third[0].Message.Id != 2 || second[0].Message.Id != 1 {
+		t.Fatalf("Receive() = %+v, want message 2", third)
+	}
+	dead := m.QueueMessages("jobs-dlq")
+	if len(dead) != 1 || dead[0].DeadLetter == nil || dead[0].DeadLetter.SourceQueue != "jobs" {
+		t.Fatalf("QueueMessages(dlq) = %+v, want message 1 dead-lettered from jobs", dead)
+	}
+	if f := dead[0].Failures; len(f) != 2 || f[0].Reason != "bad input" || f[1].Reason != "visibility timeout expired" {
+		t.Errorf("dead letter failures = %+v, want the nack and the timeout", f)
+	}
+	if stats := m.QueueStats("jobs"); stats != (QueueStats{Visible: 0, InFlight: 1}) {
+		t.Errorf("QueueStats(jobs) = %+v, want 1 in flight", stats)
+	}
+
+	if _, err := m.Redrive("jobs-dlq", []int64{2}, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Redrive() of a live message error = %v, want ErrNotFound", err)
+	}
+	moved, err := m.Redrive("jobs-dlq", nil, "")
+	if err != nil || len(moved) != 1 || moved[0].Queue != "jobs" || moved[0].ReceiveCount != 0 || moved[0].DeadLetter != nil {
+		t.Errorf("Redrive() = %+v, %v, want message 1 back in jobs", moved, err)
+	}
+
+	if n, err := m.Purge("jobs"); n != 2 || err != nil {
+		t.Errorf("Purge() = %d, %v, want 2", n, err)
+	}
+}
+
+func TestMessageServer_SetQueuePolicy_persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal("Open() failed with error: ", err)
+	}
+	want := QueuePolicy{MaxReceiveCount: 3, DeadLetterQueue: "dlq"}
+	_ = m.SetQueuePolicy("jobs", want)
+	_ = m.SetQueuePolicy("other", want)
+	_ = m.SetQueuePolicy("other", QueuePolicy{})
+	_ = m.Close()
+
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal("Open() after restart failed with error: ", err)
+	}
+	defer m.Close()
+	if got := m.QueuePolicy("jobs"); got != want {
+		t.Errorf("QueuePolicy(jobs) after restart = %+v, want %+v", got, want)
+	}
+	if got := m.QueuePolicy("other"); got != (QueuePolicy{}) {
+		t.Errorf("QueuePolicy(other) after restart = %+v, want none", got)
+	}
+}
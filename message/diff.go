@@ -0,0 +1,145 @@
+package message
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DiffUnit is what texts are compared by.
+type DiffUnit string
+
+const (
+	DiffLines DiffUnit = "line"
+	DiffWords DiffUnit = "word"
+)
+
+// ParseDiffUnit returns the DiffUnit named s.
+func ParseDiffUnit(s string) (DiffUnit, error) {
+	switch u := DiffUnit(s); u {
+	case DiffLines, DiffWords:
+		return u, nil
+	}
+	return "", Errorf(ErrInvalid, "invalid diff unit %q, should be %q or %q", s, DiffLines, DiffWords)
+}
+
+// Diff ops.
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffChange is a run of text that is kept, inserted or deleted.
+type DiffChange struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// RevisionDiff is the difference between two revisions of a message.
+// Joining the texts of the changes that are not inserts yields the text of
+// revision From, joining those that are not deletes the text of revision To.
+type RevisionDiff struct {
+	MessageId int64        `json:"message_id"`
+	From      int          `json:"from"`
+	To        int          `json:"to"`
+	Unit      DiffUnit     `json:"unit"`
+	Changes   []DiffChange `json:"changes"`
+}
+
+// maxDiffCells bounds the size of the table diff fills in, 1 MB of int32s.
+// Texts whose changed middle parts are larger are reported as replaced as a
+// whole.
+const maxDiffCells = 1 << 18
+
+// split splits text into lines, each with its line break, or into words and
+// the runs of white space between them.
+func split(text string, unit DiffUnit) []string {
+	if unit == DiffLines {
+		return strings.SplitAfter(text, "\n")
+	}
+	var tokens []string
+	start, space := 0, false
+	for i, r := range text {
+		s := unicode.IsSpace(r)
+		if i > 0 && s != space {
+			tokens = append(tokens, text[start:i])
+			start = i
+		}
+		space = s
+	}
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+	return tokens
+}
+
+// diff returns the changes turning a into b, based on their longest common
+// subsequence.
+func diff(a, b []string) []DiffChange {
+	var changes []DiffChange
+	add := func(op, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(changes); n > 0 && changes[n-1].Op == op {
+			changes[n-1].Text += text
+			return
+		}
+		changes = append(changes, DiffChange{Op: op, Text: text})
+	}
+
+	// the common prefix and suffix need no table
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	add(DiffEqual, strings.Join(a[:pre], ""))
+	x, y := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	if (len(x)+1)*(len(y)+1) > maxDiffCells {
+		add(DiffDelete, strings.Join(x, ""))
+		add(DiffInsert, strings.Join(y, ""))
+	} else {
+		// lcs(i, j) is the length of the longest common subsequence of
+		// x[i:] and y[j:], kept in a single row-major table
+		w := len(y) + 1
+		table := make([]int32, (len(x)+1)*w)
+		lcs := func(i, j int) int32 { return table[i*w+j] }
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					table[i*w+j] = lcs(i+1, j+1) + 1
+				} else if lcs(i+1, j) >= lcs(i, j+1) {
+					table[i*w+j] = lcs(i+1, j)
+				} else {
+					table[i*w+j] = lcs(i, j+1)
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(x) || j < len(y) {
+			switch {
+			case i < len(x) && j < len(y) && x[i] == y[j]:
+				add(DiffEqual, x[i])
+				i++
+				j++
+			case j == len(y) || (i < len(x) && lcs(i+1, j) >= lcs(i, j+1)):
+				add(DiffDelete, x[i])
+				i++
+			default:
+				add(DiffInsert, y[j])
+				j++
+			}
+		}
+	}
+
+	add(DiffEqual, strings.Join(a[len(a)-suf:], ""))
+	if changes == nil {
+		changes = []DiffChange{}
+	}
+	return changes
+}
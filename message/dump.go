@@ -0,0 +1,279 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dump formats understood by NewDumpWriter and NewDumpReader.
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+const (
+	importChunkLen     = 500
+	maxImportErrorsLen = 100
+	// maxDumpRecordLen is the maximum length in bytes of an NDJSON record.
+	maxDumpRecordLen = 1 << 20
+)
+
+// csvHeader lists the columns of a CSV dump. Dumps with only the leading id
+// and text columns are accepted as well. Tags are joined with csvTagSep.
+var csvHeader = []string{"id", "text", "author", "tags"}
+
+const csvTagSep = ";"
+
+// DumpWriter writes messages in a dump format.
+type DumpWriter interface {
+	Write(MessageObj) error
+	// Flush writes any buffered data to the underlying writer.
+	Flush() error
+}
+
+// DumpReader reads messages in a dump format. Read returns io.EOF after the
+// last message. A malformed record is reported with an ErrInvalid error, the
+// reader may be used further to skip past it; any other error is fatal.
+type DumpReader interface {
+	Read() (MessageObj, error)
+}
+
+func NewDumpWriter(w io.Writer, format string) (DumpWriter, error) {
+	switch format {
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		return &ndjsonWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		return &csvWriter{w: cw}, nil
+	}
+	return nil, Errorf(ErrInvalid, "unsupported dump format %q, should be %s or %s", format, FormatNDJSON, FormatCSV)
+}
+
+func NewDumpReader(r io.Reader, format string) (DumpReader, error) {
+	switch format {
+	case FormatNDJSON:
+		return &ndjsonReader{r: bufio.NewReader(r)}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err == io.EOF {
+			return &csvReader{r: cr}, nil
+		}
+		if err != nil || !validCSVHeader(header) {
+			return nil, Errorf(ErrInvalid, "CSV dump must start with the header %q", strings.Join(csvHeader, ","))
+		}
+		cr.FieldsPerRecord = len(header)
+		return &csvReader{r: cr}, nil
+	}
+	return nil, Errorf(ErrInvalid, "unsupported dump format %q, should be %s or %s", format, FormatNDJSON, FormatCSV)
+}
+
+type ndjsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(msg MessageObj) error {
+	return n.enc.Encode(newMessage(msg.Id, msg))
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return n.w.Flush()
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (c *csvWriter) Write(msg MessageObj) error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{strconv.FormatInt(msg.Id, 10), msg.Text, msg.Author, strings.Join(msg.Tags, csvTagSep)})
+}
+
+func validCSVHeader(header []string) bool {
+	if len(header) != 2 && len(header) != len(csvHeader) {
+		return false
+	}
+	for i := range header {
+		if header[i] != csvHeader[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *csvWriter) Flush() error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if err := c.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonReader struct {
+	r *bufio.Reader
+}
+
+func (n *ndjsonReader) Read() (MessageObj, error) {
+	for {
+		b, err := n.readLine()
+		if err != nil && (err != io.EOF || len(b) == 0) {
+			return MessageObj{}, err
+		}
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+		var msg MessageObj
+		if err := json.Unmarshal(b, &msg); err != nil {
+			return MessageObj{}, Errorf(ErrInvalid, "invalid JSON: %v", err)
+		}
+		return msg, nil
+	}
+}
+
+// readLine reads the next line. Lines longer than maxDumpRecordLen are
+// skipped and reported with an ErrInvalid error.
+func (n *ndjsonReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		b, err := n.r.ReadSlice('\n')
+		if len(line)+len(b) > maxDumpRecordLen {
+			for err == bufio.ErrBufferFull {
+				_, err = n.r.ReadSlice('\n')
+			}
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return nil, Errorf(ErrInvalid, "record longer than %d bytes", maxDumpRecordLen)
+		}
+		line = append(line, b...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+type csvReader struct {
+	r *csv.Reader
+}
+
+func (c *csvReader) Read() (MessageObj, error) {
+	rec, err := c.r.Read()
+	var perr *csv.ParseError
+	if errors.As(err, &perr) {
+		return MessageObj{}, Errorf(ErrInvalid, "%v", perr)
+	}
+	if err != nil {
+		return MessageObj{}, err
+	}
+	id, err := strconv.ParseInt(rec[0], 10, 64)
+	if err != nil && rec[0] != "" {
+		return MessageObj{}, Errorf(ErrInvalid, "invalid id %q", rec[0])
+	}
+	msg := MessageObj{Id: id, Text: rec[1]}
+	if len(rec) == len(csvHeader) {
+		msg.Author = rec[2]
+		if rec[3] != "" {
+			msg.Tags = strings.Split(rec[3], csvTagSep)
+		}
+	}
+	return msg, nil
+}
+
+// ImportError is a record of a dump that could not be imported. Record is
+// the 1-based position of the record in the dump.
+type ImportError struct {
+	Record int
+	Err    error
+}
+
+// ImportResult summarizes an import. Errors holds at most the first 100
+// failures.
+type ImportResult struct {
+	Imported int
+	Failed   int
+	Errors   []ImportError
+}
+
+func (res *ImportResult) fail(record int, err error) {
+	res.Failed++
+	if len(res.Errors) < maxImportErrorsLen {
+		res.Errors = append(res.Errors, ImportError{Record: record, Err: err})
+	}
+}
+
+// ImportDump reads every message of r and imports it into m in chunks, see
+// Import; replies may refer to parents imported by earlier chunks. prepare,
+// if set, is called for every message, may change it and rejects it by
+// returning an error. Malformed or rejected records are counted as
+// failures, the returned error is set only if the import was aborted.
+func ImportDump(m *MessageServer, r DumpReader, preserveIDs bool, prepare func(*MessageObj) error) (ImportResult, error) {
+	var res ImportResult
+	var chunk []MessageObj
+	var records []int
+	ids := make(map[int64]int64)
+
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		_, errs, err := m.importChunk(chunk, preserveIDs, ids)
+		if err != nil {
+			return err
+		}
+		for i, err := range errs {
+			if err != nil {
+				res.fail(records[i], err)
+			} else {
+				res.Imported++
+			}
+		}
+		chunk, records = chunk[:0], records[:0]
+		return nil
+	}
+
+	for record := 1; ; record++ {
+		msg, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && prepare != nil {
+			err = prepare(&msg)
+		}
+		if errors.Is(err, ErrInvalid) {
+			res.fail(record, err)
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		chunk = append(chunk, msg)
+		records = append(records, record)
+		if len(chunk) == importChunkLen {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	err := flush()
+	sort.Slice(res.Errors, func(i, j int) bool { return res.Errors[i].Record < res.Errors[j].Record })
+	return res, err
+}
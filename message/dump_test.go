@@ -0,0 +1,118 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDump_roundTrip(t *testing.T) {
+	for _, format := range []string{FormatNDJSON, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			src := NewMessageServer()
+			_, _ = src.AddBatch(texts("one", "two, \"quoted\"", "multi\nline"))
+			_ = src.Delete(1)
+
+			var b bytes.Buffer
+			dw, err := NewDumpWriter(&b, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := src.Export(dw.Write); err != nil {
+				t.Fatal("Export() failed with error: ", err)
+			}
+			_ = dw.Flush()
+
+			dst := NewMessageServer()
+			_, _ = dst.Add(MessageObj{Text: "existing"})
+			dr, err := NewDumpReader(&b, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := ImportDump(dst, dr, true, nil)
+			if err != nil || res.Imported != 2 || res.Failed != 0 {
+				t.Fatalf("ImportDump() = %+v, %v, want 2 imported", res, err)
+			}
+			want, _ := src.GetAll()
+			got, _ := dst.GetAll()
+			if !reflect.DeepEqual(got[1:], want) {
+				t.Errorf("imported messages = %v, want %v", got[1:], want)
+			}
+			// the id sequence continues after the largest imported id
+			if msg, _ := dst.Add(MessageObj{Text: "next"}); msg.Id != 4 {
+				t.Errorf("Add() after import got id %d, want 4", msg.Id)
+			}
+		})
+	}
+}
+
+func TestImportDump_errors(t *testing.T) {
+	m := NewMessageServer()
+	_, _ = m.Add(MessageObj{Text: "existing"})
+	dump := "{\"id\":1,\"text\":\"taken\"}\nnot json\n{\"id\":5,\"text\":\"\"}\n{\"id\":6,\"text\":\"ok\"}\n"
+	dr, _ := NewDumpReader(strings.NewReader(dump), FormatNDJSON)
+	res, err := ImportDump(m, dr, true, func(msg *MessageObj) error {
+		if msg.Text == "" {
+			return Errorf(ErrInvalid, "empty text")
+		}
+		return nil
+	})
+	if err != nil || res.Imported != 1 || res.Failed != 3 {
+		t.Fatalf("ImportDump() = %+v, %v, want 1 imported and 3 failed", res, err)
+	}
+	if res.Errors[0].Record != 1 || !errors.Is(res.Errors[0].Err, ErrConflict) {
+		t.Errorf("ImportDump() first error = %+v, want conflict on record 1", res.Errors[0])
+	}
+
+	if _, err := NewDumpReader(strings.NewReader("a,b\n1,x\n"), FormatCSV); !errors.Is(err, ErrInvalid) {
+		t.Errorf("NewDumpReader() with bad CSV header error = %v, want ErrInvalid", err)
+	}
+}
+
+func TestImportDump_parents(t *testing.T) {
+	m := NewMessageServer()
+	_, _ = m.Add(MessageObj{Text: "existing"})
+	var dump strings.Builder
+	dump.WriteString("{\"id\":1,\"text\":\"root\"}\n")
+	for i := 1; i < importChunkLen; i++ {
+		dump.WriteString("{\"text\":\"filler\"}\n")
+	}
+	// the reply is imported by the second chunk
+	dump.WriteString("{\"id\":7,\"text\":\"reply\",\"parent_id\":1}\n")
+	dump.WriteString("{\"text\":\"dangling\",\"parent_id\":99}\n")
+	dr, _ := NewDumpReader(strings.NewReader(dump.String()), FormatNDJSON)
+	res, err := ImportDump(m, dr, false, nil)
+	if err != nil || res.Imported != importChunkLen+1 || res.Failed != 1 {
+		t.Fatalf("ImportDump() = %+v, %v, want %d imported and 1 failed", res, err, importChunkLen+1)
+	}
+	if e := res.Errors[0]; e.Record != importChunkLen+2 || !errors.Is(e.Err, ErrInvalid) {
+		t.Errorf("ImportDump() error = %+v, want invalid record %d", e, importChunkLen+2)
+	}
+	// new ids are given to parents and replies alike
+	reply, err := m.Get(int64(importChunkLen + 2))
+	if err != nil || reply.Text != "reply" || reply.ParentId != 2 {
+		t.Errorf("Get() of the imported reply = %+v, %v, want parent 2", reply, err)
+	}
+
+	// with preserved ids a parent may exist already
+	dr, _ = NewDumpReader(strings.NewReader("{\"id\":1000,\"text\":\"reply\",\"parent_id\":1}\n{\"id\":1001,\"text\":\"dangling\",\"parent_id\":999}\n"), FormatNDJSON)
+	res, err = ImportDump(m, dr, true, nil)
+	if err != nil || res.Imported != 1 || res.Failed != 1 {
+		t.Fatalf("ImportDump() with preserved ids = %+v, %v, want 1 imported and 1 failed", res, err)
+	}
+}
+
+func TestImportDump_longRecord(t *testing.T) {
+	m := NewMessageServer()
+	dump := "{\"text\":\"" + strings.Repeat("x", maxDumpRecordLen) + "\"}\n{\"text\":\"ok\"}\n"
+	dr, _ := NewDumpReader(strings.NewReader(dump), FormatNDJSON)
+	res, err := ImportDump(m, dr, false, nil)
+	if err != nil || res.Imported != 1 || res.Failed != 1 {
+		t.Fatalf("ImportDump() = %+v, %v, want 1 imported and 1 failed", res, err)
+	}
+	if e := res.Errors[0]; e.Record != 1 || !errors.Is(e.Err, ErrInvalid) {
+		t.Errorf("ImportDump() error = %+v, want invalid record 1", e)
+	}
+}
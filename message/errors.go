@@ -0,0 +1,38 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds returned by MessageServer. Callers should match on these with
+// errors.Is rather than on the error text.
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrConflict      = errors.New("conflict")
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrInvalid       = errors.New("invalid argument")
+	// ErrUnavailable is returned for changes to a closed MessageServer,
+	// which were not attempted.
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// Error is a typed error carrying one of the error kinds above along with a
+// human readable description of the failure.
+type Error struct {
+	Kind   error
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Errorf returns an *Error of the given kind with a formatted detail.
+func Errorf(kind error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Detail: fmt.Sprintf(format, args...)}
+}
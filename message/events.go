@@ -0,0 +1,163 @@
+package message
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by MessageServer. Expired is published when the
+// janitor deletes an expired message, undeleted when a message is restored
+// from the trash.
+const (
+	EventCreated   = "created"
+	EventUpdated   = "updated"
+	EventDeleted   = "deleted"
+	EventExpired   = "expired"
+	EventUndeleted = "undeleted"
+)
+
+const (
+	defaultBacklogSize    = 1000
+	subscriptionQueueSize = 64
+)
+
+// Event describes a change to a message. IDs increase monotonically in the
+// order the changes were applied.
+type Event struct {
+	ID      int64      `json:"id"`
+	Type    string     `json:"type"`
+	Time    time.Time  `json:"time"`
+	Message MessageObj `json:"message"`
+}
+
+// Filter restricts a subscription to the events of messages with the given
+// tag and/or author. Empty fields match everything.
+type Filter struct {
+	Tag    string `json:"tag,omitempty"`
+	Author string `json:"author,omitempty"`
+}
+
+// Match reports whether e passes the filter.
+func (f Filter) Match(e Event) bool {
+	if f.Author != "" && e.Message.Author != f.Author {
+		return false
+	}
+	if f.Tag == "" {
+		return true
+	}
+	for _, tag := range e.Message.Tags {
+		if tag == f.Tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription receives the events matching its filter on C. C is closed
+// when the subscription is cancelled or when the subscriber fell so far
+// behind that its queue overflowed; in the latter case the subscriber should
+// resubscribe from the ID of the last event it processed.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	filter Filter
+}
+
+// Broker fans events out to subscribers and keeps a bounded backlog of
+// recent events so that subscribers can resume after a disconnect. Publish
+// never blocks on subscribers.
+type Broker struct {
+	sync.Mutex
+	lastID  int64
+	backlog []Event
+	size    int
+	subs    map[*Subscription]struct{}
+}
+
+func NewBroker(backlogSize int) *Broker {
+	return &Broker{size: backlogSize, subs: make(map[*Subscription]struct{})}
+}
+
+// SetBacklogSize changes the number of recent events kept for resuming.
+func (b *Broker) SetBacklogSize(n int) {
+	b.Lock()
+	defer b.Unlock()
+	b.size = n
+	b.trim()
+}
+
+// trim drops the oldest events beyond the backlog size. Callers hold the lock.
+func (b *Broker) trim() {
+	if over := len(b.backlog) - b.size; over > 0 {
+		b.backlog = append(b.backlog[:0:0], b.backlog[over:]...)
+	}
+}
+
+// Publish assigns the next event ID to an event of the given type and
+// delivers it to every matching subscriber. Subscribers whose queue is full
+// are disconnected rather than waited for.
+func (b *Broker) Publish(typ string, msg MessageObj) Event {
+	b.Lock()
+	defer b.Unlock()
+	e := Event{ID: b.lastID + 1, Type: typ, Time: time.Now().UTC(), Message: msg}
+	b.publish(e)
+	return e
+}
+
+// deliver publishes e, which already carries its ID. IDs must increase.
+func (b *Broker) deliver(e Event) {
+	b.Lock()
+	defer b.Unlock()
+	b.publish(e)
+}
+
+// publish adds e to the backlog and delivers it to every matching
+// subscriber. Callers hold the lock.
+func (b *Broker) publish(e Event) {
+	b.lastID = e.ID
+	b.backlog = append(b.backlog, e)
+	b.trim()
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			delete(b.subs, s)
+			close(s.c)
+		}
+	}
+}
+
+// Subscribe registers a subscription for the events matching f. Events from
+// the backlog with an ID greater than lastEventID are returned for replay
+// and will not be sent on the subscription, pass 0 to only receive new
+// events. missed reports that lastEventID is older than the backlog, i.e.
+// some events are lost for this subscriber.
+func (b *Broker) Subscribe(lastEventID int64, f Filter) (s *Subscription, replay []Event, missed bool) {
+	b.Lock()
+	defer b.Unlock()
+	if lastEventID > 0 {
+		missed = len(b.backlog) > 0 && b.backlog[0].ID > lastEventID+1
+		for _, e := range b.backlog {
+			if e.ID > lastEventID && f.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+	c := make(chan Event, subscriptionQueueSize)
+	s = &Subscription{C: c, c: c, filter: f}
+	b.subs[s] = struct{}{}
+	return s, replay, missed
+}
+
+// Unsubscribe cancels s. It is safe to call more than once.
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
@@ -0,0 +1,96 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitRelayed waits until the relay of m has published event id.
+func waitRelayed(t *testing.T, m *MessageServer, id int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.RLock()
+		relayed := m.relayed
+		m.RUnlock()
+		if relayed >= id {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("relay did not reach event %d, at %d", id, relayed)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func eventIDs(events []Event) []int64 {
+	var ids []int64
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestBroker_Subscribe_replay(t *testing.T) {
+	m := NewMessageServer()
+	m.Start()
+	m.Events().SetBacklogSize(3)
+	_, _ = m.AddBatch([]MessageObj{{Text: "one", Author: "ann"}, {Text: "two", Tags: []string{"x"}}})
+	_, _ = m.Update(1, MessageObj{Text: "uno"})
+	_ = m.Delete(2)
+	waitRelayed(t, m, 4)
+
+	_, replay, missed := m.Events().Subscribe(1, Filter{})
+	if missed || !reflect.DeepEqual(eventIDs(replay), []int64{2, 3, 4}) {
+		t.Errorf("Subscribe(1) replay = %v, missed = %v, want events 2-4", eventIDs(replay), missed)
+	}
+	if replay[1].Type != EventUpdated || replay[1].Message.Author != "ann" {
+		t.Errorf("Subscribe(1) replay[1] = %+v, want update keeping the author", replay[1])
+	}
+
+	_, replay, _ = m.Events().Subscribe(1, Filter{Tag: "x"})
+	if !reflect.DeepEqual(eventIDs(replay), []int64{2, 4}) {
+		t.Errorf("Subscribe(1, tag x) replay = %v, want events 2 and 4", eventIDs(replay))
+	}
+
+	_, replay, missed = m.Events().Subscribe(0, Filter{})
+	if missed || replay != nil {
+		t.Errorf("Subscribe(0) replay = %v, missed = %v, want no replay", replay, missed)
+	}
+
+	// event 2 falls out of the backlog
+	_, _ = m.Add(MessageObj{Text: "three"})
+	waitRelayed(t, m, 5)
+	_, replay, missed = m.Events().Subscribe(1, Filter{})
+	if !missed || !reflect.DeepEqual(eventIDs(replay), []int64{3, 4, 5}) {
+		t.Errorf("Subscribe(1) replay = %v, missed = %v, want events 3-5 and missed", eventIDs(replay), missed)
+	}
+}
+
+func TestBroker_Publish_slowSubscriber(t *testing.T) {
+	b := NewBroker(10)
+	slow, _, _ := b.Subscribe(0, Filter{})
+	other, _, _ := b.Subscribe(0, Filter{Author: "bob"})
+
+	// Publish must not block although nobody reads from slow.
+	for i := 0; i < subscriptionQueueSize+1; i++ {
+		b.Publish(EventCreated, MessageObj{Id: int64(i + 1)})
+	}
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != subscriptionQueueSize {
+		t.Errorf("slow subscriber got %d events before being closed, want %d", n, subscriptionQueueSize)
+	}
+
+	b.Publish(EventCreated, MessageObj{Id: 100, Author: "bob"})
+	if e := <-other.C; e.Message.Id != 100 {
+		t.Errorf("filtered subscriber got %+v, want message 100", e)
+	}
+	b.Unsubscribe(other)
+	b.Unsubscribe(other)
+	if _, ok := <-other.C; ok {
+		t.Error("Unsubscribe() did not close the subscription")
+	}
+}
@@ -0,0 +1,136 @@
+package message
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+const (
+	janitorInterval  = time.Second
+	janitorBatchSize = 100
+)
+
+// janitor deletes expired messages and purges the trash. A message is
+// invisible from its ExpiresAt on, whether or not it was reclaimed yet, so
+// the janitor only needs to run often enough to bound the memory and journal
+// space held by expired messages. It reclaims them in batches of janitorBatchSize, each in
+// its own commit, releasing the write lock in between so that requests are
+// not held up by a large expiry.
+//
+// Messages in the trash are purged retention after they were deleted, purges
+// being ordered by deletion time.
+//
+// expiries, onExpire, purges and retention are guarded by the MessageServer
+// lock.
+type janitor struct {
+	expiries  schedule
+	onExpire  func(n int)
+	purges    schedule
+	retention time.Duration
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newJanitor() janitor {
+	return janitor{
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// expired reports whether msg has expired at now.
+func (msg MessageObj) expired(now time.Time) bool {
+	return msg.ExpiresAt != nil && !msg.ExpiresAt.After(now)
+}
+
+// OnExpire sets a function called with the number of messages reclaimed
+// whenever the janitor deleted expired messages, e.g. to count them.
+func (m *MessageServer) OnExpire(fn func(n int)) {
+	m.Lock()
+	defer m.Unlock()
+	m.jan.onExpire = fn
+}
+
+// runJanitor reclaims expired messages and purges the trash every
+// janitorInterval until stopJanitor is called. A failed commit is retried on
+// the next tick.
+func (m *MessageServer) runJanitor() {
+	defer close(m.jan.stopped)
+	tick := time.NewTicker(janitorInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case now := <-tick.C:
+			_, _ = m.reclaimExpired(now)
+			_, _ = m.purgeTrash(now)
+		case <-m.jan.stop:
+			return
+		}
+	}
+}
+
+// reclaimExpired deletes the messages expired at now, emitting an expired
+// event for each that is not in the trash, and returns how many it deleted.
+func (m *MessageServer) reclaimExpired(now time.Time) (int, error) {
+	total := 0
+	for {
+		n, more, err := m.reclaimBatch(now)
+		total += n
+		if err != nil || !more {
+			return total, err
+		}
+	}
+}
+
+// reclaimBatch deletes up to janitorBatchSize messages expired at now in a
+// single commit, applying the delete policy to their replies like Delete.
+// Tombstones are left to the deletion of their last reply. more reports
+// that further messages are due.
+func (m *MessageServer) reclaimBatch(now time.Time) (n int, more bool, err error) {
+	m.Lock()
+	defer m.Unlock()
+	var due []schedEntry
+	rec := m.newRecord(0)
+	deleted := make(map[int64]bool)
+	for len(due) < janitorBatchSize && len(m.jan.expiries) > 0 && !m.jan.expiries[0].at.After(now) {
+		e := heap.Pop(&m.jan.expiries).(schedEntry)
+		msg, ok := m.msgStore[e.id]
+		if !ok || !sameTime(msg.ExpiresAt, &e.at) || msg.Tombstone || deleted[e.id] {
+			continue
+		}
+		due = append(due, e)
+		m.remove(&rec, msg, EventExpired, deleted)
+	}
+	more = len(m.jan.expiries) > 0 && !m.jan.expiries[0].at.After(now)
+	if len(due) == 0 {
+		return 0, more, nil
+	}
+	if err := m.commit(rec); err != nil {
+		for _, e := range due {
+			heap.Push(&m.jan.expiries, e)
+		}
+		return 0, false, err
+	}
+	if m.jan.onExpire != nil {
+		m.jan.onExpire(len(due))
+	}
+	return len(due), more, nil
+}
+
+// stopJanitor stops the janitor. Messages that expire meanwhile are
+// reclaimed once the server is started again.
+func (m *MessageServer) stopJanitor() {
+	m.jan.stopOnce.Do(func() { close(m.jan.stop) })
+	<-m.jan.stopped
+}
+
+// sameTime reports whether a and b are both unset or the same instant.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,113 @@
+package message
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessageServer_expiry(t *testing.T) {
+	m := NewMessageServer()
+	m.Start()
+	reclaimed := 0
+	m.OnExpire(func(n int) { reclaimed += n })
+
+	msg, _ := m.Add(MessageObj{Text: "short-lived", ExpiresAt: after(50 * time.Millisecond)})
+	_, _ = m.Add(MessageObj{Text: "kept"})
+	if _, err := m.Get(msg.Id); err != nil {
+		t.Fatalf("Get() before expiry error = %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	// expired messages are hidden before the janitor reclaims them
+	if _, err := m.Get(msg.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of expired message error = %v, want ErrNotFound", err)
+	}
+	if got, _ := m.GetAll(); len(got) != 1 || got[0].Text != "kept" {
+		t.Errorf("GetAll() = %+v, want the unexpired message only", got)
+	}
+	if err := m.Delete(msg.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of expired message error = %v, want ErrNotFound", err)
+	}
+
+	n, err := m.reclaimExpired(time.Now())
+	if err != nil || n != 1 || reclaimed != 1 {
+		t.Fatalf("reclaimExpired() = %d, %v, reported %d, want 1", n, err, reclaimed)
+	}
+	if _, ok := m.msgStore[msg.Id]; ok {
+		t.Error("expired message still stored after reclaim")
+	}
+	waitRelayed(t, m, 3)
+	m.events.Lock()
+	last := m.events.backlog[len(m.events.backlog)-1]
+	m.events.Unlock()
+	if last.Type != EventExpired || last.Message.Id != msg.Id {
+		t.Errorf("last event = %+v, want expired event of message %d", last, msg.Id)
+	}
+}
+
+func TestMessageServer_reclaimBatch(t *testing.T) {
+	m := NewMessageServer()
+	msgs := make([]MessageObj, janitorBatchSize+10)
+	for i := range msgs {
+		msgs[i] = MessageObj{Text: "batch", ExpiresAt: after(time.Minute)}
+	}
+	_, _ = m.AddBatch(msgs)
+
+	later := time.Now().Add(2 * time.Minute)
+	n, more, err := m.reclaimBatch(later)
+	if err != nil || n != janitorBatchSize || !more {
+		t.Errorf("reclaimBatch() = %d, %v, %v, want a full batch and more", n, more, err)
+	}
+	n, err = m.reclaimExpired(later)
+	if err != nil || n != 10 || len(m.msgStore) != 0 {
+		t.Errorf("reclaimExpired() = %d, %v with %d left, want the remaining 10", n, err, len(m.msgStore))
+	}
+}
+
+func TestMessageServer_expiryDeletePolicy(t *testing.T) {
+	tests := []struct {
+		policy    DeletePolicy
+		wantIDs   []int64
+		tombstone bool
+	}{
+		{policy: DeleteOrphan, wantIDs: []int64{1, 3}},
+		{policy: DeleteCascade, wantIDs: []int64{1}},
+		{policy: DeleteTombstone, wantIDs: []int64{1, 2, 3}, tombstone: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			m := NewMessageServer()
+			m.SetDeletePolicy(tt.policy)
+			_, _ = m.Add(MessageObj{Text: "root"})
+			_, _ = m.Add(MessageObj{Text: "reply", ParentId: 1, ExpiresAt: after(time.Minute)})
+			_, _ = m.Add(MessageObj{Text: "nested", ParentId: 2})
+
+			n, err := m.reclaimExpired(time.Now().Add(2 * time.Minute))
+			if err != nil || n != 1 {
+				t.Fatalf("reclaimExpired() = %d, %v, want 1", n, err)
+			}
+			if len(m.msgStore) != len(tt.wantIDs) {
+				t.Errorf("%d messages stored, want %v", len(m.msgStore), tt.wantIDs)
+			}
+			for _, id := range tt.wantIDs {
+				if _, ok := m.msgStore[id]; !ok {
+					t.Errorf("message %d deleted, want it kept", id)
+				}
+			}
+			if got := m.msgStore[2].Tombstone; got != tt.tombstone {
+				t.Errorf("message 2 tombstone = %v, want %v", got, tt.tombstone)
+			}
+
+			// the tombstone goes with its last reply
+			if tt.tombstone {
+				if err := m.Delete(3); err != nil {
+					t.Fatalf("Delete(3) error = %v", err)
+				}
+				if _, ok := m.msgStore[2]; ok {
+					t.Error("tombstone kept after its last reply was deleted")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package message
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	journalFile     = "messages.journal"
+	lockFile        = "LOCK"
+	cursorFile      = "outbox.cursor"
+	compactChunkLen = 1000
+)
+
+// journalRecord is one line of the journal. A record is the unit of
+// atomicity: it is either replayed completely or, if it was torn by a crash,
+// not at all. Seq is the latest message ID handed out when the record was
+// written, so that IDs are never reused after a restart. EventSeq and Events
+// do the same for the events of the change, see outbox.
+type journalRecord struct {
+	Seq      int64        `json:"seq,omitempty"`
+	Put      []MessageObj `json:"put,omitempty"`
+	Delete   []int64      `json:"delete,omitempty"`
+	EventSeq int64        `json:"event_seq,omitempty"`
+	Events   []Event      `json:"events,omitempty"`
+	// Policies sets queue policies, a zero policy removes one.
+	Policies map[string]QueuePolicy `json:"policies,omitempty"`
+	// Revisions are added to the revisions of their messages.
+	Revisions []Revision `json:"revisions,omitempty"`
+}
+
+// journal is an append-only log of journalRecords in a data directory. The
+// directory is locked while the journal is open so that a server and an
+// offline command can not write to it at the same time.
+type journal struct {
+	dir  string
+	f    *os.File
+	lock *os.File
+	// size is the length of the journal up to the end of its last record.
+	size int64
+	// err is set when a failed append could not be undone, further appends
+	// fail with it.
+	err error
+}
+
+// Open returns a MessageServer persisted in dir, creating the directory if
+// needed. The existing journal is replayed and compacted before use, and
+// events that were not relayed before the server last stopped are relayed
+// again once it is started. The MessageServer must be closed to release the
+// directory.
+func Open(dir string) (*MessageServer, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMessageServer()
+	j := &journal{dir: dir, lock: lock}
+	m.Lock()
+	m.relayed, err = j.loadCursor()
+	if err == nil {
+		err = j.replay(m.apply)
+	}
+	if err == nil {
+		err = j.compact(m)
+	}
+	if err == nil {
+		j.f, err = os.OpenFile(j.path(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	}
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = j.f.Stat()
+	}
+	if err == nil {
+		j.size = fi.Size()
+		m.journal = j
+	} else if j.f != nil {
+		j.f.Close()
+	}
+	m.Unlock()
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close stops the janitor, the scheduler and the event relay once the
+// pending events are published, then closes the journal, if any, and
+// releases the data directory. Changes fail with ErrUnavailable from then on.
+func (m *MessageServer) Close() error {
+	m.Lock()
+	m.closed = true
+	started := m.started
+	m.Unlock()
+	if started {
+		m.stopJanitor()
+		m.stopScheduler()
+		m.stopRelay()
+	} else {
+		m.relayPending()
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.journal == nil {
+		return nil
+	}
+	err := m.journal.f.Close()
+	if lErr := m.journal.lock.Close(); err == nil {
+		err = lErr
+	}
+	m.journal = nil
+	return err
+}
+
+func (j *journal) path() string {
+	return filepath.Join(j.dir, journalFile)
+}
+
+// append durably writes rec to the end of the journal. If that fails, the
+// journal is cut back to the end of its last record, so that the next record
+// does not follow a torn one on the same line; if even that fails, the
+// journal refuses further appends.
+func (j *journal) append(rec journalRecord) error {
+	if j.err != nil {
+		return j.err
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	n, err := j.f.Write(append(b, '\n'))
+	if err == nil {
+		err = j.f.Sync()
+	}
+	if err != nil {
+		tErr := j.f.Truncate(j.size)
+		if tErr == nil {
+			tErr = j.f.Sync()
+		}
+		if tErr != nil {
+			j.err = fmt.Errorf("journal %s is unusable after a failed append: %v", j.path(), tErr)
+		}
+		return err
+	}
+	j.size += int64(n)
+	return nil
+}
+
+// replay calls apply for every complete record of the journal. A torn record
+// at the end of the file, with or without its line break, is the trace of a
+// crash during append and is skipped; the compaction following the replay
+// cuts it off. Anything else that fails to parse is reported as corruption.
+func (j *journal) replay(apply func(journalRecord)) error {
+	f, err := os.Open(j.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(b, &rec); err != nil {
+			if _, pErr := r.Peek(1); pErr == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("journal %s is corrupt at line %d: %v", j.path(), line, err)
+		}
+		apply(rec)
+	}
+}
+
+// compact rewrites the journal so that it holds only the current contents of
+// m, replacing the old file atomically.
+func (j *journal) compact(m *MessageServer) error {
+	tmp := j.path() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	ids := make([]int64, 0, len(m.msgStore))
+	for id := range m.msgStore {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	rec := journalRecord{Seq: m.latestID}
+	for i, id := range ids {
+		rec.Put = append(rec.Put, m.msgStore[id])
+		rec.Revisions = append(rec.Revisions, m.revisions[id]...)
+		if len(rec.Put) == compactChunkLen || i == len(ids)-1 {
+			if err := enc.Encode(rec); err != nil {
+				f.Close()
+				return err
+			}
+			rec.Put = rec.Put[:0]
+			rec.Revisions = rec.Revisions[:0]
+		}
+	}
+	if len(ids) == 0 && m.latestID > 0 {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	// keep the event sequence, the events the relay has not published and
+	// the queue policies
+	if m.eventSeq > 0 || len(m.policies) > 0 {
+		err := enc.Encode(journalRecord{EventSeq: m.eventSeq, Events: m.pending, Policies: m.policies})
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path())
+}
+
+func (j *journal) cursorPath() string {
+	return filepath.Join(j.dir, cursorFile)
+}
+
+// loadCursor returns the ID of the last relayed event, 0 if none was saved.
+func (j *journal) loadCursor() (int64, error) {
+	b, err := ioutil.ReadFile(j.cursorPath())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("outbox cursor %s is corrupt: %v", j.cursorPath(), err)
+	}
+	return id, nil
+}
+
+// saveCursor durably replaces the saved cursor with id.
+func (j *journal) saveCursor(id int64) error {
+	tmp := j.cursorPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.FormatInt(id, 10) + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, j.cursorPath())
+}
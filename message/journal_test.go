@@ -0,0 +1,171 @@
+package message
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpen_persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal("Open() failed with error: ", err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Error("Open() of a locked data directory succeeded, want error")
+	}
+	_, _ = m.AddBatch(texts("one", "two", "three"))
+	_ = m.Delete(3)
+	_ = m.Close()
+
+	// a torn record left by a crash is dropped on replay
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"seq":9,"put":[{"id":9,"te`)
+	f.Close()
+
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal("Open() after restart failed with error: ", err)
+	}
+	defer m.Close()
+	got, _ := m.GetAll()
+	want := []MessageObj{{Id: 1, Text: "one"}, {Id: 2, Text: "two"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll() after restart = %v, want %v", got, want)
+	}
+	// ids of deleted messages are not reused
+	if msg, _ := m.Add(MessageObj{Text: "four"}); msg.Id != 4 {
+		t.Errorf("Add() after restart got id %d, want 4", msg.Id)
+	}
+}
+
+func TestOpen_tornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, journalFile)
+	good := `{"seq":1,"put":[{"id":1,"text":"one"}]}` + "\n"
+
+	// a torn final record is skipped even with its line break, and cut off
+	err = ioutil.WriteFile(path, []byte(good+`{"seq":2,"put":[{"id":2,"te`+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal("Open() with a torn final record failed with error: ", err)
+	}
+	if got, _ := m.GetAll(); len(got) != 1 || got[0].Text != "one" {
+		t.Errorf("GetAll() = %v, want message one only", got)
+	}
+	if _, err := m.Add(MessageObj{Text: "two"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.Close()
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal("Open() after appending to a cut off journal failed with error: ", err)
+	}
+	_ = m.Close()
+
+	// a corrupt record followed by others is not a crash trace
+	err = ioutil.WriteFile(path, []byte(`{"seq":2,"put":[{"id":2,"te`+"\n"+good), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := Open(dir); err == nil {
+		m.Close()
+		t.Error("Open() with a corrupt record in the middle succeeded, want error")
+	}
+}
+
+func TestJournal_failedAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	_, _ = m.Add(MessageObj{Text: "one"})
+	if fi, _ := os.Stat(filepath.Join(dir, journalFile)); fi.Size() != m.journal.size {
+		t.Errorf("journal size = %d, want the file size %d", m.journal.size, fi.Size())
+	}
+
+	// appends fail for good once a failed one can not be undone
+	f := m.journal.f
+	m.journal.f, _ = os.Open(filepath.Join(dir, journalFile))
+	defer f.Close()
+	if _, err := m.Add(MessageObj{Text: "two"}); err == nil {
+		t.Fatal("Add() to a read-only journal succeeded, want error")
+	}
+	m.journal.f.Close()
+	m.journal.f = f
+	if _, err := m.Add(MessageObj{Text: "three"}); err == nil {
+		t.Error("Add() after an append that could not be undone succeeded, want error")
+	}
+}
+
+func TestMessageServer_Close(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal("Open() failed with error: ", err)
+	}
+	_, _ = m.Add(MessageObj{Text: "one"})
+	_ = m.Close()
+	// changes after Close would not be persisted, so they fail
+	if _, err := m.Add(MessageObj{Text: "two"}); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Add() after Close() error = %v, want ErrUnavailable", err)
+	}
+	if err := m.Delete(1); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Delete() after Close() error = %v, want ErrUnavailable", err)
+	}
+	if _, err := m.Get(1); err != nil {
+		t.Errorf("Get() after Close() error = %v", err)
+	}
+}
+
+func TestJournal_emptyBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal("Open() failed with error: ", err)
+	}
+	defer m.Close()
+	size := m.journal.size
+	got, err := m.AddBatch(nil)
+	if err != nil || got == nil || len(got) != 0 {
+		t.Errorf("AddBatch(nil) = %v, %v, want no messages", got, err)
+	}
+	if m.journal.size != size {
+		t.Errorf("AddBatch(nil) grew the journal from %d to %d bytes, want no record", size, m.journal.size)
+	}
+}
@@ -0,0 +1,12 @@
+package message
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockDir only creates the lock file, advisory locking is not implemented on
+// windows.
+func lockDir(dir string) (*os.File, error) {
+	return os.OpenFile(filepath.Join(dir, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+}
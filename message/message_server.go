@@ -1,69 +1,523 @@
 package message
 
 import (
-	"fmt"
+	"container/heap"
+	"sort"
 	"sync"
+	"time"
 )
 
 type MessageObj struct {
-	Id           int64  `json:"id"`
-	Text         string `json:"text"`
-	IsPalindrome *bool  `json:"is-palindrome,omitempty"`
+	Id           int64       `json:"id" xml:"id"`
+	Text         string      `json:"text" xml:"text"`
+	Author       string      `json:"author,omitempty" xml:"author,omitempty"`
+	Tags         []string    `json:"tags,omitempty" xml:"tag,omitempty"`
+	Queue        string      `json:"queue,omitempty" xml:"queue,omitempty"`
+	ReceiveCount int         `json:"receive_count,omitempty" xml:"receive_count,omitempty"`
+	Failures     []Failure   `json:"failures,omitempty" xml:"failure,omitempty"`
+	DeadLetter   *DeadLetter `json:"dead_letter,omitempty" xml:"dead_letter,omitempty"`
+	DeliverAt    *time.Time  `json:"deliver_at,omitempty" xml:"deliver_at,omitempty"`
+	ExpiresAt    *time.Time  `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+	ParentId     int64       `json:"parent_id,omitempty" xml:"parent_id,omitempty"`
+	Tombstone    bool        `json:"tombstone,omitempty" xml:"tombstone,omitempty"`
+	DeletedAt    *time.Time  `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+	DeletedBy    string      `json:"deleted_by,omitempty" xml:"deleted_by,omitempty"`
+	DeletedWith  int64       `json:"deleted_with,omitempty" xml:"deleted_with,omitempty"`
+	IsPalindrome *bool       `json:"is-palindrome,omitempty" xml:"is-palindrome,omitempty"`
 }
 
 type MessageServer struct {
 	sync.RWMutex
 	latestID int64
-	msgStore map[int64]string
+	msgStore map[int64]MessageObj
+	journal  *journal
+	events   *Broker
+	leases   map[int64]lease
+	policies map[string]QueuePolicy
+	// replies indexes the IDs of the replies of every message by its ID
+	replies      map[int64]map[int64]bool
+	deletePolicy DeletePolicy
+	// revisions holds the revisions of every message, oldest first
+	revisions     map[int64][]Revision
+	revisionLimit int
+	sched         scheduler
+	jan           janitor
+	changes       changeLog
+	// started is set by Start, closed rejects changes once Close was called
+	started bool
+	closed  bool
+	outbox
 }
 
 func NewMessageServer() *MessageServer {
 	m := MessageServer{}
-	m.msgStore = make(map[int64]string)
+	m.msgStore = make(map[int64]MessageObj)
+	m.leases = make(map[int64]lease)
+	m.policies = make(map[string]QueuePolicy)
+	m.replies = make(map[int64]map[int64]bool)
+	m.revisions = make(map[int64][]Revision)
+	m.deletePolicy = DeleteOrphan
+	m.events = NewBroker(defaultBacklogSize)
+	m.outbox = newOutbox()
+	m.sched = newScheduler()
+	m.jan = newJanitor()
+	m.changes = newChangeLog()
 	return &m
 }
 
-func (m *MessageServer) Add(msg string) MessageObj {
+// Start starts the event relay, the delivery of scheduled messages and the
+// janitor of expired messages. Until then changes are applied, but their
+// events stay pending and messages are neither delivered nor expired, so a
+// server can be configured before it does any work in the background. Start
+// does nothing once the server is started or closed.
+func (m *MessageServer) Start() {
 	m.Lock()
 	defer m.Unlock()
-	m.latestID++
-	m.msgStore[m.latestID] = msg
-	resp := MessageObj{Id: m.latestID, Text: msg}
-	return resp
+	if m.started || m.closed {
+		return
+	}
+	m.started = true
+	go m.relay()
+	go m.runScheduler()
+	go m.runJanitor()
+	m.wakeRelay()
+	m.wakeScheduler()
+}
+
+// readOptions are the options of the read methods.
+type readOptions struct {
+	includeScheduled bool
+}
+
+// ReadOption changes which messages Get and GetAll return.
+type ReadOption func(*readOptions)
+
+// IncludeScheduled makes reads return messages that are not delivered yet.
+func IncludeScheduled() ReadOption {
+	return func(o *readOptions) {
+		o.includeScheduled = true
+	}
+}
+
+func newReadOptions(opts []ReadOption) readOptions {
+	var o readOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// visible reports whether msg is returned at now by a read with options o.
+// Expired messages, tombstones and messages in the trash are never returned.
+func (o readOptions) visible(msg MessageObj, now time.Time) bool {
+	return !msg.expired(now) && !msg.Tombstone && !msg.trashed() && (o.includeScheduled || !msg.isScheduled())
+}
+
+// lookup returns message id unless it does not exist, has expired at now, is
+// a tombstone or is in the trash. Callers hold the lock.
+func (m *MessageServer) lookup(id int64, now time.Time) (MessageObj, bool) {
+	msg, ok := m.msgStore[id]
+	if !ok || msg.expired(now) || msg.Tombstone || msg.trashed() {
+		return MessageObj{}, false
+	}
+	return msg, true
+}
+
+// Events returns the broker on which the server publishes a created,
+// updated or deleted event for every change to a message. Events are
+// published by a relay shortly after the change was committed, at least
+// once: after a crash, events of the last changes may be published again
+// with the same ID.
+func (m *MessageServer) Events() *Broker {
+	return m.events
+}
+
+// newMessage returns the message to store for msg under id, copying the
+// fields that may be set by callers. A DeliverAt that is not in the future
+// is dropped, the message is delivered right away.
+func newMessage(id int64, msg MessageObj) MessageObj {
+	obj := MessageObj{Id: id, Text: msg.Text, Author: msg.Author, Queue: msg.Queue, ParentId: msg.ParentId}
+	if len(msg.Tags) > 0 {
+		obj.Tags = append([]string(nil), msg.Tags...)
+	}
+	if msg.DeliverAt != nil && msg.DeliverAt.After(time.Now()) {
+		at := msg.DeliverAt.UTC()
+		obj.DeliverAt = &at
+	}
+	if msg.ExpiresAt != nil {
+		at := msg.ExpiresAt.UTC()
+		obj.ExpiresAt = &at
+	}
+	return obj
+}
+
+// newRecord returns an empty journal record with Seq set to seq, to which
+// the events of the change are added with addEvent.
+func (m *MessageServer) newRecord(seq int64) journalRecord {
+	return journalRecord{Seq: seq, EventSeq: m.eventSeq}
+}
+
+// commit persists rec to the journal, if the server has one, and applies it
+// to the in-memory store. Nothing is applied if persisting fails, so the
+// events of rec are published if and only if the change is persisted. A
+// closed server applies nothing, a change acknowledged then would be lost.
+// Callers hold the write lock.
+func (m *MessageServer) commit(rec journalRecord) error {
+	if m.closed {
+		return Errorf(ErrUnavailable, "the message store is closed")
+	}
+	if m.journal != nil {
+		if err := m.journal.append(rec); err != nil {
+			return err
+		}
+	}
+	m.apply(rec)
+	m.wakeRelay()
+	for _, msg := range rec.Put {
+		if msg.isScheduled() {
+			m.wakeScheduler()
+			break
+		}
+	}
+	return nil
+}
+
+// apply applies rec to the in-memory store, schedules the delivery and
+// expiry of its messages and queues its events that have not been relayed
+// yet.
+func (m *MessageServer) apply(rec journalRecord) {
+	for _, msg := range rec.Put {
+		old := m.msgStore[msg.Id]
+		m.msgStore[msg.Id] = msg
+		if msg.DeliverAt != nil && !sameTime(msg.DeliverAt, old.DeliverAt) {
+			heap.Push(&m.sched.queue, schedEntry{at: *msg.DeliverAt, id: msg.Id})
+		}
+		if msg.ExpiresAt != nil && !sameTime(msg.ExpiresAt, old.ExpiresAt) {
+			heap.Push(&m.jan.expiries, schedEntry{at: *msg.ExpiresAt, id: msg.Id})
+		}
+		if msg.DeletedAt != nil && !sameTime(msg.DeletedAt, old.DeletedAt) {
+			heap.Push(&m.jan.purges, schedEntry{at: *msg.DeletedAt, id: msg.Id})
+		}
+		m.indexReply(msg)
+		if msg.Tombstone {
+			// a tombstone keeps nothing of what was said
+			delete(m.revisions, msg.Id)
+		}
+	}
+	m.compactSchedule()
+	for _, rev := range rec.Revisions {
+		m.revisions[rev.MessageId] = append(m.revisions[rev.MessageId], rev)
+		m.trimRevisions(rev.MessageId)
+	}
+	for _, id := range rec.Delete {
+		if msg, ok := m.msgStore[id]; ok {
+			m.unindexReply(msg)
+		}
+		delete(m.msgStore, id)
+		delete(m.leases, id)
+		delete(m.revisions, id)
+	}
+	if rec.Seq > m.latestID {
+		m.latestID = rec.Seq
+	}
+	for name, p := range rec.Policies {
+		if p == (QueuePolicy{}) {
+			delete(m.policies, name)
+		} else {
+			m.policies[name] = p
+		}
+	}
+	if rec.EventSeq > m.eventSeq {
+		m.eventSeq = rec.EventSeq
+	}
+	for _, e := range rec.Events {
+		if e.ID > m.relayed {
+			m.pending = append(m.pending, e)
+		}
+	}
+	m.changes.record(rec, time.Now().UTC())
+}
+
+// Add stores msg under the next message ID. Only the text, author, tags,
+// queue, parent, delivery and expiry time of msg are used. The parent must
+// be a visible message. A message with a delivery
+// time in the future is hidden until then, see scheduler; a message with an
+// expiry time is hidden from then on and reclaimed, see janitor.
+func (m *MessageServer) Add(msg MessageObj) (MessageObj, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.checkParent(msg.ParentId, time.Now()); err != nil {
+		return MessageObj{}, err
+	}
+	resp := newMessage(m.latestID+1, msg)
+	rec := m.newRecord(resp.Id)
+	rec.Put = []MessageObj{resp}
+	rec.addEvent(EventCreated, resp)
+	m.addRevision(&rec, resp, resp.Author, time.Now())
+	err := m.commit(rec)
+	if err != nil {
+		return MessageObj{}, err
+	}
+	return resp, nil
 }
 
-func (m *MessageServer) Get(id int64) (MessageObj, error) {
+// Get returns message id. Expired messages are not found, neither are
+// scheduled ones unless IncludeScheduled is passed.
+func (m *MessageServer) Get(id int64, opts ...ReadOption) (MessageObj, error) {
+	o := newReadOptions(opts)
 	m.RLock()
 	defer m.RUnlock()
 	msg, ok := m.msgStore[id]
+	if !ok || !o.visible(msg, time.Now()) {
+		return MessageObj{}, Errorf(ErrNotFound, "message %d not found", id)
+	}
+	return msg, nil
+}
+
+// Update replaces the text and tags of message id, adding a revision. The
+// author, queue, parent, delivery and expiry time of a message can not be
+// changed. A scheduled message can be updated until it is delivered; the
+// update emits no event, the created event of the delivery carries it.
+func (m *MessageServer) Update(id int64, msg MessageObj, opts ...UpdateOption) (MessageObj, error) {
+	o := newUpdateOptions(opts)
+	now := time.Now()
+	m.Lock()
+	defer m.Unlock()
+	old, ok := m.lookup(id, now)
 	if !ok {
-		return MessageObj{}, fmt.Errorf("input text ID %v not found ", id)
+		return MessageObj{}, Errorf(ErrNotFound, "message %d not found", id)
+	}
+	msg.Author = old.Author
+	msg.Queue = old.Queue
+	resp := newMessage(id, msg)
+	resp.ReceiveCount = old.ReceiveCount
+	resp.Failures = old.Failures
+	resp.DeadLetter = old.DeadLetter
+	resp.DeliverAt = old.DeliverAt
+	resp.ExpiresAt = old.ExpiresAt
+	resp.ParentId = old.ParentId
+	rec := m.newRecord(0)
+	rec.Put = []MessageObj{resp}
+	rec.addEvent(EventUpdated, resp)
+	m.addRevision(&rec, resp, o.editor, now)
+	err := m.commit(rec)
+	if err != nil {
+		return MessageObj{}, err
 	}
-	return MessageObj{Id: id, Text: msg}, nil
+	return resp, nil
 }
 
-func (m *MessageServer) GetAll() ([]MessageObj, error) {
+// GetAll returns all messages in ID order. Expired messages are left out,
+// and so are scheduled ones unless IncludeScheduled is passed.
+func (m *MessageServer) GetAll(opts ...ReadOption) ([]MessageObj, error) {
+	o := newReadOptions(opts)
+	now := time.Now()
 	m.RLock()
-	if len(m.msgStore) == 0 {
-		return nil, fmt.Errorf("no messages found")
-	}
 	defer m.RUnlock()
-	msgList := make([]MessageObj, len(m.msgStore))
-	index := 0
-	for id := range m.msgStore {
-		msgList[index] = MessageObj{Id: id, Text: m.msgStore[id]}
-		index++
+	msgList := make([]MessageObj, 0, len(m.msgStore))
+	for _, msg := range m.msgStore {
+		if o.visible(msg, now) {
+			msgList = append(msgList, msg)
+		}
+	}
+	if len(msgList) == 0 {
+		return nil, Errorf(ErrNotFound, "no messages found")
 	}
+	sort.Slice(msgList, func(i, j int) bool { return msgList[i].Id < msgList[j].Id })
 	return msgList, nil
 }
 
-func (m *MessageServer) Delete(id int64) error {
+// Delete deletes message id, moving it to the trash if the trash is enabled,
+// see SetTrashRetention, and applying the delete policy to its replies.
+func (m *MessageServer) Delete(id int64, opts ...DeleteOption) error {
+	o := newDeleteOptions(opts)
+	now := time.Now()
 	m.Lock()
 	defer m.Unlock()
-	_, ok := m.msgStore[id]
+	msg, ok := m.deletable(id, o, now)
 	if !ok {
-		return fmt.Errorf("input text ID %v not found ", id)
+		return Errorf(ErrNotFound, "message %d not found", id)
+	}
+	rec := m.newRecord(0)
+	m.delete(&rec, msg, o, now, make(map[int64]bool))
+	return m.commit(rec)
+}
+
+// AddBatch adds all msgs under a single lock so that either all or none of
+// them are visible to readers. The created messages are returned in order.
+// An empty batch changes nothing.
+func (m *MessageServer) AddBatch(msgs []MessageObj) ([]MessageObj, error) {
+	if len(msgs) == 0 {
+		return []MessageObj{}, nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	for _, msg := range msgs {
+		if err := m.checkParent(msg.ParentId, now); err != nil {
+			return nil, err
+		}
+	}
+	resp := make([]MessageObj, len(msgs))
+	rec := m.newRecord(m.latestID + int64(len(msgs)))
+	for i, msg := range msgs {
+		resp[i] = newMessage(m.latestID+int64(i)+1, msg)
+		rec.addEvent(EventCreated, resp[i])
+		m.addRevision(&rec, resp[i], resp[i].Author, now)
+	}
+	rec.Put = resp
+	err := m.commit(rec)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DeleteBatch deletes the messages with the given ids like Delete and
+// returns the outcome for each of them, nil meaning success. If atomic is
+// set nothing is deleted unless every id exists. The returned error is set
+// if the deletion could not be persisted, in which case nothing was deleted.
+func (m *MessageServer) DeleteBatch(ids []int64, atomic bool, opts ...DeleteOption) ([]error, error) {
+	o := newDeleteOptions(opts)
+	now := time.Now()
+	m.Lock()
+	defer m.Unlock()
+	errs := make([]error, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	failed := false
+	for i, id := range ids {
+		if _, ok := m.deletable(id, o, now); !ok {
+			errs[i] = Errorf(ErrNotFound, "message %d not found", id)
+			failed = true
+		} else if seen[id] {
+			errs[i] = Errorf(ErrInvalid, "message %d listed more than once", id)
+			failed = true
+		}
+		seen[id] = true
+	}
+	if atomic && failed {
+		return errs, nil
+	}
+	var valid []int64
+	for i, id := range ids {
+		if errs[i] == nil {
+			valid = append(valid, id)
+		}
+	}
+	// replies have greater IDs than their parents, so removing the newest
+	// messages first leaves no tombstone whose replies are all deleted
+	sort.Slice(valid, func(i, j int) bool { return valid[i] > valid[j] })
+	rec := m.newRecord(0)
+	deleted := make(map[int64]bool, len(valid))
+	for _, id := range valid {
+		m.delete(&rec, m.msgStore[id], o, now, deleted)
+	}
+	if len(rec.Delete) == 0 && len(rec.Put) == 0 {
+		return errs, nil
+	}
+	err := m.commit(rec)
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// Export calls fn for every message in ID order, scheduled ones included and
+// expired ones left out. Messages are read one at a
+// time so neither the whole store is copied nor is the lock held while fn
+// runs; messages added after Export started are not visited.
+func (m *MessageServer) Export(fn func(MessageObj) error) error {
+	m.RLock()
+	ids := make([]int64, 0, len(m.msgStore))
+	for id := range m.msgStore {
+		ids = append(ids, id)
+	}
+	m.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		msg, err := m.Get(id, IncludeScheduled())
+		if err != nil {
+			// deleted since the export started
+			continue
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
 	}
-	delete(m.msgStore, id)
 	return nil
 }
+
+// Import adds msgs in a single commit and returns the outcome for each of
+// them, nil meaning success. With preserveIDs the IDs of msgs are kept, a
+// message whose ID is already taken fails with ErrConflict, and the ID
+// sequence is advanced past the largest imported ID; replies must have a
+// parent that exists or comes earlier in msgs. Otherwise every message gets
+// a new ID, and replies must come after their parent in msgs, whose new ID
+// they get as ParentId. The returned messages carry the IDs they were stored
+// with.
+func (m *MessageServer) Import(msgs []MessageObj, preserveIDs bool) ([]MessageObj, []error, error) {
+	return m.importChunk(msgs, preserveIDs, make(map[int64]int64))
+}
+
+// importChunk imports msgs like Import. ids maps the IDs of the messages
+// imported so far, by earlier chunks of the same dump too, to the IDs they
+// were stored with, and is updated.
+func (m *MessageServer) importChunk(msgs []MessageObj, preserveIDs bool, ids map[int64]int64) ([]MessageObj, []error, error) {
+	now := time.Now()
+	m.Lock()
+	defer m.Unlock()
+	resp := make([]MessageObj, len(msgs))
+	errs := make([]error, len(msgs))
+	rec := m.newRecord(m.latestID)
+	seen := make(map[int64]bool, len(msgs))
+	for i, msg := range msgs {
+		obj := newMessage(msg.Id, msg)
+		if obj.ParentId != 0 {
+			parent, ok := ids[obj.ParentId]
+			if preserveIDs && !ok && m.checkParent(obj.ParentId, now) == nil {
+				parent, ok = obj.ParentId, true
+			}
+			if !ok {
+				errs[i] = Errorf(ErrInvalid, "parent message %d not found", obj.ParentId)
+				continue
+			}
+			obj.ParentId = parent
+		}
+		if preserveIDs {
+			_, exists := m.msgStore[obj.Id]
+			switch {
+			case obj.Id < 1:
+				errs[i] = Errorf(ErrInvalid, "invalid message id %d, should be a valid positive integer", obj.Id)
+			case exists || seen[obj.Id]:
+				errs[i] = Errorf(ErrConflict, "message %d already exists", obj.Id)
+			}
+			if errs[i] != nil {
+				continue
+			}
+			seen[obj.Id] = true
+			if obj.Id > rec.Seq {
+				rec.Seq = obj.Id
+			}
+		} else {
+			rec.Seq++
+			obj.Id = rec.Seq
+		}
+		if msg.Id != 0 {
+			ids[msg.Id] = obj.Id
+		}
+		resp[i] = obj
+		rec.Put = append(rec.Put, obj)
+		rec.addEvent(EventCreated, obj)
+		m.addRevision(&rec, obj, obj.Author, now)
+	}
+	if len(rec.Put) > 0 {
+		if err := m.commit(rec); err != nil {
+			for _, msg := range msgs {
+				delete(ids, msg.Id)
+			}
+			return nil, nil, err
+		}
+	}
+	return resp, errs, nil
+}
@@ -1,6 +1,7 @@
 package message
 
 import (
+	"errors"
 	"os"
 	"reflect"
 	"testing"
@@ -8,6 +9,15 @@ import (
 
 var msgServer *MessageServer
 
+// texts returns messages with the given texts, for the batch methods.
+func texts(t ...string) []MessageObj {
+	msgs := make([]MessageObj, len(t))
+	for i := range t {
+		msgs[i].Text = t[i]
+	}
+	return msgs
+}
+
 func TestMessageServer_Add(t *testing.T) {
 	type args struct {
 		msg string
@@ -19,25 +29,25 @@ func TestMessageServer_Add(t *testing.T) {
 	}{
 		{"positive flow 1",
 			args{"first"},
-			MessageObj{1, "first", nil},
+			MessageObj{Id: 1, Text: "first"},
 		},
 		{"negative flow",
 			args{"second"},
-			MessageObj{1, "second", nil}, // id should be 2
+			MessageObj{Id: 1, Text: "second"}, // id should be 2
 		},
 		{"positive flow 2",
 			args{"third"},
-			MessageObj{3, "third", nil},
+			MessageObj{Id: 3, Text: "third"},
 		},
 	}
 	for id, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if id == 1 {
-				if got := msgServer.Add(tt.args.msg); reflect.DeepEqual(got, tt.want) {
+				if got, _ := msgServer.Add(MessageObj{Text: tt.args.msg}); reflect.DeepEqual(got, tt.want) {
 					t.Errorf("Add() = %v, want %v", got, tt.want)
 				}
 			} else {
-				if got := msgServer.Add(tt.args.msg); !reflect.DeepEqual(got, tt.want) {
+				if got, err := msgServer.Add(MessageObj{Text: tt.args.msg}); err != nil || !reflect.DeepEqual(got, tt.want) {
 					t.Errorf("Add() = %v, want %v", got, tt.want)
 				}
 			}
@@ -68,7 +78,7 @@ func TestMessageServer_Get(t *testing.T) {
 	}{
 		{"positive flow 1",
 			args{1},
-			MessageObj{1, "first", nil},
+			MessageObj{Id: 1, Text: "first"},
 			false,
 		},
 		{"negative flow",
@@ -89,8 +99,8 @@ func TestMessageServer_Get(t *testing.T) {
 					t.Errorf("Get() got = %v, want %v", got, tt.want)
 				}
 			} else {
-				if err == nil {
-					t.Error("Get() failed. Expected error to be non-nil")
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get() error = %v, want ErrNotFound", err)
 					return
 				}
 			}
@@ -156,3 +166,21 @@ func TestMain(m *testing.M) {
 	exitVal := m.Run()
 	os.Exit(exitVal)
 }
+
+func TestMessageServer_DeleteBatch(t *testing.T) {
+	m := NewMessageServer()
+	got, err := m.AddBatch(texts("one", "two", "three"))
+	if err != nil || len(got) != 3 || got[2].Id != 3 {
+		t.Fatalf("AddBatch() = %v, want 3 messages with ids 1-3", got)
+	}
+
+	errs, _ := m.DeleteBatch([]int64{1, 2, 4}, true)
+	if !errors.Is(errs[2], ErrNotFound) || errs[0] != nil || len(m.msgStore) != 3 {
+		t.Errorf("DeleteBatch() atomic = %v, store size %d, want only id 4 to fail and nothing deleted", errs, len(m.msgStore))
+	}
+
+	errs, _ = m.DeleteBatch([]int64{1, 1, 4}, false)
+	if errs[0] != nil || !errors.Is(errs[1], ErrInvalid) || !errors.Is(errs[2], ErrNotFound) || len(m.msgStore) != 2 {
+		t.Errorf("DeleteBatch() = %v, store size %d, want id 1 deleted once", errs, len(m.msgStore))
+	}
+}
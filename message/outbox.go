@@ -0,0 +1,100 @@
+package message
+
+import (
+	"sync"
+	"time"
+)
+
+// outbox holds the events of committed changes until the relay has handed
+// them to the broker. Events are written to the journal in the same record
+// as the change they describe, so an event exists if and only if its change
+// was persisted. The relay cursor, the ID of the last event handed to the
+// broker, is saved in the data directory; events after it are relayed again
+// when the journal is replayed. Consumers should therefore expect duplicates
+// and dedupe by event ID.
+//
+// eventSeq, relayed and pending are guarded by the MessageServer lock.
+type outbox struct {
+	eventSeq int64
+	relayed  int64
+	pending  []Event
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newOutbox() outbox {
+	return outbox{
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// addEvent adds an event of the given type for msg to rec, under the next
+// event ID. Scheduled messages are not visible yet, so nothing is added for
+// them; their created event is added when they are delivered.
+func (rec *journalRecord) addEvent(typ string, msg MessageObj) {
+	if msg.isScheduled() {
+		return
+	}
+	rec.EventSeq++
+	rec.Events = append(rec.Events, Event{ID: rec.EventSeq, Type: typ, Time: time.Now().UTC(), Message: msg})
+}
+
+// wakeRelay makes the relay publish the pending events.
+func (m *MessageServer) wakeRelay() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// relay publishes pending events until stopRelay is called.
+func (m *MessageServer) relay() {
+	defer close(m.stopped)
+	for {
+		select {
+		case <-m.wake:
+			m.relayPending()
+		case <-m.stop:
+			m.relayPending()
+			return
+		}
+	}
+}
+
+// relayPending hands the pending events to the broker in commit order and
+// then advances the cursor.
+func (m *MessageServer) relayPending() {
+	m.Lock()
+	pending := m.pending
+	m.pending = nil
+	m.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	for _, e := range pending {
+		m.events.deliver(e)
+	}
+
+	m.Lock()
+	m.relayed = pending[len(pending)-1].ID
+	j := m.journal
+	m.Unlock()
+	if j != nil {
+		// The cursor is only saved by the relay, and Close stops the relay
+		// before it closes the journal, so it is saved without holding the
+		// lock. A cursor that could not be saved only means that these
+		// events are relayed again after a restart.
+		_ = j.saveCursor(pending[len(pending)-1].ID)
+	}
+}
+
+// stopRelay publishes the pending events and stops the relay.
+func (m *MessageServer) stopRelay() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.stopped
+}
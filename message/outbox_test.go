@@ -0,0 +1,118 @@
+package message
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func backlogIDs(m *MessageServer) []int64 {
+	m.events.Lock()
+	defer m.events.Unlock()
+	return eventIDs(m.events.backlog)
+}
+
+func TestOutbox_relayAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal("Open() failed with error: ", err)
+	}
+	m.Start()
+	_, _ = m.AddBatch(texts("one", "two"))
+	_ = m.Close()
+
+	// a crash before the cursor was saved relays the events again, with the
+	// same IDs
+	if err := os.Remove(filepath.Join(dir, cursorFile)); err != nil {
+		t.Fatal(err)
+	}
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal("Open() after restart failed with error: ", err)
+	}
+	m.Start()
+	waitRelayed(t, m, 2)
+	if got := backlogIDs(m); !reflect.DeepEqual(got, []int64{1, 2}) {
+		t.Errorf("events relayed after restart = %v, want [1 2]", got)
+	}
+	_ = m.Delete(1)
+	_ = m.Close()
+
+	// relayed events are not relayed again, and event IDs are not reused
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal("Open() after restart failed with error: ", err)
+	}
+	m.Start()
+	defer m.Close()
+	if _, err := m.Add(MessageObj{Text: "three"}); err != nil {
+		t.Fatal(err)
+	}
+	waitRelayed(t, m, 4)
+	if got := backlogIDs(m); !reflect.DeepEqual(got, []int64{4}) {
+		t.Errorf("events relayed after clean restart = %v, want [4]", got)
+	}
+}
+
+func TestOutbox_failedCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal("Open() failed with error: ", err)
+	}
+	m.Start()
+	defer m.Close()
+	_, _ = m.Add(MessageObj{Text: "one"})
+	waitRelayed(t, m, 1)
+
+	// a change that can not be persisted emits no event
+	m.journal.f.Close()
+	if _, err := m.Add(MessageObj{Text: "two"}); err == nil {
+		t.Fatal("Add() with a closed journal succeeded, want error")
+	}
+	m.RLock()
+	seq, pending := m.eventSeq, len(m.pending)
+	m.RUnlock()
+	if seq != 1 || pending != 0 {
+		t.Errorf("after failed Add() eventSeq = %d, pending = %d, want 1 and 0", seq, pending)
+	}
+}
+
+func TestMessageServer_Start(t *testing.T) {
+	m := NewMessageServer()
+	_, _ = m.Add(MessageObj{Text: "one"})
+	time.Sleep(10 * time.Millisecond)
+	if got := backlogIDs(m); len(got) != 0 {
+		t.Errorf("events relayed before Start() = %v, want none", got)
+	}
+	m.Start()
+	m.Start()
+	waitRelayed(t, m, 1)
+	_ = m.Close()
+
+	// a server closed without being started still publishes its events
+	m = NewMessageServer()
+	_, _ = m.Add(MessageObj{Text: "one"})
+	_ = m.Close()
+	if got := backlogIDs(m); !reflect.DeepEqual(got, []int64{1}) {
+		t.Errorf("events relayed by Close() = %v, want [1]", got)
+	}
+	m.Start()
+	if m.started {
+		t.Error("Start() after Close() started the server")
+	}
+}
@@ -0,0 +1,210 @@
+package message
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lease marks a message as received and invisible to other consumers until
+// the lease expires. Leases live in memory only: after a restart every
+// message is visible again.
+type lease struct {
+	receipt string
+	until   time.Time
+}
+
+// Lease is a message handed to a consumer by Receive. The receipt is needed
+// to Ack or Nack the message and becomes invalid once the message is
+// received again.
+type Lease struct {
+	Message   MessageObj `json:"message"`
+	Receipt   string     `json:"receipt"`
+	VisibleAt time.Time  `json:"visible_at"`
+}
+
+func newReceipt(id int64) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10) + "-" + hex.EncodeToString(b), nil
+}
+
+// receiptID returns the ID of the message a receipt was issued for.
+func receiptID(receipt string) (int64, error) {
+	i := strings.Index(receipt, "-")
+	if i < 1 {
+		return 0, Errorf(ErrInvalid, "invalid receipt %q", receipt)
+	}
+	id, err := strconv.ParseInt(receipt[:i], 10, 64)
+	if err != nil {
+		return 0, Errorf(ErrInvalid, "invalid receipt %q", receipt)
+	}
+	return id, nil
+}
+
+// Receive leases up to max visible messages of queue, oldest first, hiding
+// them from other consumers for visibility. The receive count of each
+// message is incremented. A message that exhausted the max receive count of
+// the queue policy is moved to the dead-letter queue instead.
+func (m *MessageServer) Receive(queue string, max int, visibility time.Duration) ([]Lease, error) {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	var ids []int64
+	for id, msg := range m.msgStore {
+		if msg.Queue != queue || msg.isScheduled() || msg.expired(now) || msg.trashed() {
+			continue
+		}
+		if l, ok := m.leases[id]; ok && l.until.After(now) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	resp := []Lease{}
+	rec := m.newRecord(0)
+	policy := m.policies[queue]
+	for _, id := range ids {
+		if len(resp) == max {
+			break
+		}
+		msg := m.msgStore[id]
+		if l, ok := m.leases[id]; ok && l.receipt != "" {
+			msg.Failures = addFailure(msg.Failures, Failure{Time: l.until.UTC(), Reason: "visibility timeout expired"})
+		}
+		if policy.MaxReceiveCount > 0 && msg.ReceiveCount >= policy.MaxReceiveCount {
+			msg.Queue = policy.DeadLetterQueue
+			msg.DeadLetter = &DeadLetter{
+				SourceQueue: queue,
+				Reason:      fmt.Sprintf("received %d times without being acknowledged", msg.ReceiveCount),
+				Time:        now.UTC(),
+			}
+			rec.Put = append(rec.Put, msg)
+			rec.addEvent(EventUpdated, msg)
+			continue
+		}
+		receipt, err := newReceipt(id)
+		if err != nil {
+			return nil, err
+		}
+		msg.ReceiveCount++
+		rec.Put = append(rec.Put, msg)
+		resp = append(resp, Lease{Message: msg, Receipt: receipt, VisibleAt: now.Add(visibility).UTC()})
+	}
+	if len(rec.Put) == 0 {
+		return resp, nil
+	}
+	// receiving changes no content, so only dead-lettering emits events
+	if err := m.commit(rec); err != nil {
+		return nil, err
+	}
+	for _, msg := range rec.Put {
+		delete(m.leases, msg.Id)
+	}
+	for _, l := range resp {
+		m.leases[l.Message.Id] = lease{receipt: l.Receipt, until: l.VisibleAt}
+	}
+	return resp, nil
+}
+
+// leased returns the ID of the message receipt was issued for, if the
+// message is still leased with that receipt. Callers hold the lock.
+func (m *MessageServer) leased(queue, receipt string) (int64, error) {
+	id, err := receiptID(receipt)
+	if err != nil {
+		return 0, err
+	}
+	msg, ok := m.lookup(id, time.Now())
+	if !ok || msg.Queue != queue {
+		return 0, Errorf(ErrNotFound, "message %d not found in queue %s", id, queue)
+	}
+	if l, ok := m.leases[id]; !ok || l.receipt != receipt {
+		return 0, Errorf(ErrConflict, "receipt %q is no longer valid, message %d was released or received again", receipt, id)
+	}
+	return id, nil
+}
+
+// Ack deletes the messages of queue received with the given receipts and
+// returns the outcome for each of them, nil meaning success. A receipt stays
+// valid after its lease expired as long as the message was not received
+// again. The returned error is set if the deletion could not be persisted.
+func (m *MessageServer) Ack(queue string, receipts []string) ([]error, error) {
+	m.Lock()
+	defer m.Unlock()
+	errs := make([]error, len(receipts))
+	rec := m.newRecord(0)
+	seen := make(map[int64]bool, len(receipts))
+	for i, receipt := range receipts {
+		id, err := m.leased(queue, receipt)
+		if err == nil && seen[id] {
+			err = Errorf(ErrInvalid, "receipt %q listed more than once", receipt)
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		seen[id] = true
+		rec.Delete = append(rec.Delete, id)
+		rec.addEvent(EventDeleted, m.msgStore[id])
+	}
+	if len(rec.Delete) == 0 {
+		return errs, nil
+	}
+	if err := m.commit(rec); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// Nack releases the messages of queue received with the given receipts so
+// that they become visible again after delay, recording reason as a failure
+// of each message. It returns the outcome for each receipt, nil meaning
+// success. The returned error is set if the failures could not be
+// persisted, in which case no message was released.
+func (m *MessageServer) Nack(queue string, receipts []string, delay time.Duration, reason string) ([]error, error) {
+	m.Lock()
+	defer m.Unlock()
+	if reason == "" {
+		reason = "released by consumer"
+	}
+	now := time.Now()
+	errs := make([]error, len(receipts))
+	rec := m.newRecord(0)
+	seen := make(map[int64]bool, len(receipts))
+	for i, receipt := range receipts {
+		id, err := m.leased(queue, receipt)
+		if err == nil && seen[id] {
+			err = Errorf(ErrInvalid, "receipt %q listed more than once", receipt)
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		seen[id] = true
+		msg := m.msgStore[id]
+		msg.Failures = addFailure(msg.Failures, Failure{Time: now.UTC(), Reason: reason})
+		rec.Put = append(rec.Put, msg)
+	}
+	if len(rec.Put) == 0 {
+		return errs, nil
+	}
+	if err := m.commit(rec); err != nil {
+		return nil, err
+	}
+	for _, msg := range rec.Put {
+		if delay > 0 {
+			// keep the message hidden, but with a receipt nobody holds
+			m.leases[msg.Id] = lease{until: now.Add(delay)}
+		} else {
+			delete(m.leases, msg.Id)
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,67 @@
+package message
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessageServer_Receive(t *testing.T) {
+	m := NewMessageServer()
+	_, _ = m.AddBatch([]MessageObj{{Text: "one", Queue: "jobs"}, {Text: "other"}, {Text: "two", Queue: "jobs"}, {Text: "three", Queue: "jobs"}})
+
+	leases, err := m.Receive("jobs", 2, time.Hour)
+	if err != nil || len(leases) != 2 || leases[0].Message.Id != 1 || leases[1].Message.Id != 3 {
+		t.Fatalf("Receive() = %+v, %v, want messages 1 and 3", leases, err)
+	}
+	if leases[0].Message.ReceiveCount != 1 {
+		t.Errorf("Receive() receive count = %d, want 1", leases[0].Message.ReceiveCount)
+	}
+	// leased messages are invisible to other consumers
+	more, _ := m.Receive("jobs", 10, time.Hour)
+	if len(more) != 1 || more[0].Message.Id != 4 {
+		t.Errorf("second Receive() = %+v, want message 4 only", more)
+	}
+
+	errs, err := m.Ack("jobs", []string{leases[0].Receipt, leases[0].Receipt, "9-x", "bogus"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantKinds := []error{nil, ErrInvalid, ErrNotFound, ErrInvalid}
+	for i, want := range wantKinds {
+		if (want == nil) != (errs[i] == nil) || (want != nil && !errors.Is(errs[i], want)) {
+			t.Errorf("Ack() error %d = %v, want %v", i, errs[i], want)
+		}
+	}
+	if _, err := m.Get(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of acknowledged message error = %v, want ErrNotFound", err)
+	}
+
+	// a nacked message is visible again and its old receipt is void
+	if errs, err := m.Nack("jobs", []string{leases[1].Receipt}, 0, ""); err != nil || errs[0] != nil {
+		t.Fatalf("Nack() error = %v, %v", err, errs)
+	}
+	again, _ := m.Receive("jobs", 10, time.Hour)
+	if len(again) != 1 || again[0].Message.Id != 3 || again[0].Message.ReceiveCount != 2 {
+		t.Errorf("Receive() after Nack() = %+v, want message 3 received twice", again)
+	}
+	if errs, _ := m.Ack("jobs", []string{leases[1].Receipt}); !errors.Is(errs[0], ErrConflict) {
+		t.Errorf("Ack() with a stale receipt error = %v, want ErrConflict", errs[0])
+	}
+}
+
+func TestMessageServer_Receive_visibilityTimeout(t *testing.T) {
+	m := NewMessageServer()
+	_, _ = m.Add(MessageObj{Text: "one", Queue: "jobs"})
+	first, _ := m.Receive("jobs", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	second, _ := m.Receive("jobs", 1, time.Hour)
+	if len(first) != 1 || len(second) != 1 || first[0].Receipt == second[0].Receipt {
+		t.Fatalf("Receive() after timeout = %+v, want the message leased again with a new receipt", second)
+	}
+	// a nack with a delay keeps the message hidden
+	_, _ = m.Nack("jobs", []string{second[0].Receipt}, time.Hour, "")
+	if leases, _ := m.Receive("jobs", 1, time.Hour); len(leases) != 0 {
+		t.Errorf("Receive() after delayed Nack() = %+v, want none", leases)
+	}
+}
@@ -0,0 +1,152 @@
+package message
+
+import "time"
+
+// Revision is a version of the text and tags of a message. Version 1 is the
+// message as created, every update adds the next one.
+type Revision struct {
+	MessageId int64     `json:"message_id"`
+	Version   int       `json:"version"`
+	Text      string    `json:"text"`
+	Tags      []string  `json:"tags,omitempty"`
+	Editor    string    `json:"editor,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// updateOptions are the options of Update.
+type updateOptions struct {
+	editor string
+}
+
+// UpdateOption changes how Update records a change.
+type UpdateOption func(*updateOptions)
+
+// EditedBy records editor as the one who made the change.
+func EditedBy(editor string) UpdateOption {
+	return func(o *updateOptions) {
+		o.editor = editor
+	}
+}
+
+func newUpdateOptions(opts []UpdateOption) updateOptions {
+	var o updateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SetRevisionLimit keeps at most n revisions per message, dropping the
+// oldest ones first. Zero, the default, keeps all of them.
+func (m *MessageServer) SetRevisionLimit(n int) {
+	m.Lock()
+	defer m.Unlock()
+	m.revisionLimit = n
+	for id := range m.revisions {
+		m.trimRevisions(id)
+	}
+}
+
+// addRevision adds the revision of msg made by editor at now to rec, unless
+// the text and tags of msg are those of its latest revision. Callers hold
+// the lock.
+func (m *MessageServer) addRevision(rec *journalRecord, msg MessageObj, editor string, now time.Time) {
+	version := 1
+	if revs := m.revisions[msg.Id]; len(revs) > 0 {
+		last := revs[len(revs)-1]
+		if last.Text == msg.Text && sameTags(last.Tags, msg.Tags) {
+			return
+		}
+		version = last.Version + 1
+	}
+	var tags []string
+	if len(msg.Tags) > 0 {
+		tags = append(tags, msg.Tags...)
+	}
+	rec.Revisions = append(rec.Revisions, Revision{
+		MessageId: msg.Id,
+		Version:   version,
+		Text:      msg.Text,
+		Tags:      tags,
+		Editor:    editor,
+		Time:      now.UTC(),
+	})
+}
+
+// sameTags reports whether a and b hold the same tags in the same order.
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// trimRevisions drops the revisions of message id beyond the revision limit.
+// Callers hold the lock.
+func (m *MessageServer) trimRevisions(id int64) {
+	revs := m.revisions[id]
+	if m.revisionLimit > 0 && len(revs) > m.revisionLimit {
+		// copy so that the dropped revisions can be collected
+		m.revisions[id] = append([]Revision(nil), revs[len(revs)-m.revisionLimit:]...)
+	}
+}
+
+// Revisions returns the revisions kept of message id, oldest first.
+func (m *MessageServer) Revisions(id int64) ([]Revision, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if _, ok := m.lookup(id, time.Now()); !ok {
+		return nil, Errorf(ErrNotFound, "message %d not found", id)
+	}
+	return append([]Revision{}, m.revisions[id]...), nil
+}
+
+// Revision returns revision version of message id.
+func (m *MessageServer) Revision(id int64, version int) (Revision, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if _, ok := m.lookup(id, time.Now()); !ok {
+		return Revision{}, Errorf(ErrNotFound, "message %d not found", id)
+	}
+	return m.revision(id, version)
+}
+
+// revision returns revision version of message id. Callers hold the lock.
+func (m *MessageServer) revision(id int64, version int) (Revision, error) {
+	for _, rev := range m.revisions[id] {
+		if rev.Version == version {
+			return rev, nil
+		}
+	}
+	return Revision{}, Errorf(ErrNotFound, "revision %d of message %d not found", version, id)
+}
+
+// Diff returns the changes from revision from to revision to of message id,
+// compared by unit.
+func (m *MessageServer) Diff(id int64, from, to int, unit DiffUnit) (RevisionDiff, error) {
+	m.RLock()
+	defer m.RUnlock()
+	if _, ok := m.lookup(id, time.Now()); !ok {
+		return RevisionDiff{}, Errorf(ErrNotFound, "message %d not found", id)
+	}
+	a, err := m.revision(id, from)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+	b, err := m.revision(id, to)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+	return RevisionDiff{
+		MessageId: id,
+		From:      from,
+		To:        to,
+		Unit:      unit,
+		Changes:   diff(split(a.Text, unit), split(b.Text, unit)),
+	}, nil
+}
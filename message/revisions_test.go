@@ -0,0 +1,175 @@
+package message
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMessageServer_revisions(t *testing.T) {
+	m := NewMessageServer()
+	m.SetRevisionLimit(3)
+	_, _ = m.Add(MessageObj{Text: "first", Author: "ann"})
+	_, _ = m.Update(1, MessageObj{Text: "second"}, EditedBy("bob"))
+
+	revs, err := m.Revisions(1)
+	if err != nil || len(revs) != 2 {
+		t.Fatalf("Revisions() = %+v, %v, want 2 revisions", revs, err)
+	}
+	if revs[0].Version != 1 || revs[0].Text != "first" || revs[0].Editor != "ann" {
+		t.Errorf("revision 1 = %+v, want the created text by ann", revs[0])
+	}
+	if revs[1].Version != 2 || revs[1].Text != "second" || revs[1].Editor != "bob" {
+		t.Errorf("revision 2 = %+v, want the update by bob", revs[1])
+	}
+
+	// the oldest revisions are dropped beyond the limit
+	_, _ = m.Update(1, MessageObj{Text: "third"})
+	_, _ = m.Update(1, MessageObj{Text: "fourth"})
+	revs, _ = m.Revisions(1)
+	if len(revs) != 3 || revs[0].Version != 2 || revs[2].Version != 4 {
+		t.Errorf("Revisions() = %+v, want versions 2 to 4", revs)
+	}
+	if _, err := m.Revision(1, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revision() of dropped version error = %v, want ErrNotFound", err)
+	}
+	if rev, err := m.Revision(1, 3); err != nil || rev.Text != "third" {
+		t.Errorf("Revision(1, 3) = %+v, %v, want third", rev, err)
+	}
+
+	// updates changing nothing add no revision
+	_, _ = m.Update(1, MessageObj{Text: "fourth"})
+	if revs, _ = m.Revisions(1); len(revs) != 3 || revs[2].Version != 4 {
+		t.Errorf("Revisions() after no-op update = %+v, want versions 2 to 4", revs)
+	}
+
+	_ = m.Delete(1)
+	if _, err := m.Revisions(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revisions() of deleted message error = %v, want ErrNotFound", err)
+	}
+	if len(m.revisions) != 0 {
+		t.Errorf("revisions = %+v after delete, want none", m.revisions)
+	}
+}
+
+func TestMessageServer_revisionTags(t *testing.T) {
+	m := NewMessageServer()
+	_, _ = m.Add(MessageObj{Text: "tagged", Tags: []string{"a", "b"}})
+	_, _ = m.Update(1, MessageObj{Text: "tagged", Tags: []string{"a", "c"}})
+
+	revs, _ := m.Revisions(1)
+	if len(revs) != 2 {
+		t.Fatalf("Revisions() = %+v, want a revision for the changed tags", revs)
+	}
+	if &revs[1].Tags[0] == &m.msgStore[1].Tags[0] {
+		t.Error("revision 2 shares its tags with the message")
+	}
+	if msg, _ := m.Get(1); !reflect.DeepEqual(msg.Tags, []string{"a", "c"}) {
+		t.Errorf("Get() tags = %v, want [a c]", msg.Tags)
+	}
+}
+
+func TestMessageServer_revisionsReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revisions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = m.Add(MessageObj{Text: "first"})
+	_, _ = m.Update(1, MessageObj{Text: "second"})
+	_ = m.Close()
+
+	for i := 0; i < 2; i++ {
+		// the second open replays the compacted journal
+		m, err = Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs, err := m.Revisions(1)
+		if err != nil || len(revs) != 2 || revs[1].Text != "second" {
+			t.Errorf("Revisions() after reopen = %+v, %v, want both revisions", revs, err)
+		}
+		_ = m.Close()
+	}
+}
+
+func TestMessageServer_Diff(t *testing.T) {
+	m := NewMessageServer()
+	_, _ = m.Add(MessageObj{Text: "the quick fox\njumps\n"})
+	_, _ = m.Update(1, MessageObj{Text: "the slow fox\njumps\n"})
+
+	d, err := m.Diff(1, 1, 2, DiffWords)
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	want := []DiffChange{
+		{Op: DiffEqual, Text: "the "},
+		{Op: DiffDelete, Text: "quick"},
+		{Op: DiffInsert, Text: "slow"},
+		{Op: DiffEqual, Text: " fox\njumps\n"},
+	}
+	if !reflect.DeepEqual(d.Changes, want) {
+		t.Errorf("Diff() by words = %+v, want %+v", d.Changes, want)
+	}
+
+	d, _ = m.Diff(1, 1, 2, DiffLines)
+	want = []DiffChange{
+		{Op: DiffDelete, Text: "the quick fox\n"},
+		{Op: DiffInsert, Text: "the slow fox\n"},
+		{Op: DiffEqual, Text: "jumps\n"},
+	}
+	if !reflect.DeepEqual(d.Changes, want) {
+		t.Errorf("Diff() by lines = %+v, want %+v", d.Changes, want)
+	}
+	if _, err := m.Diff(1, 1, 3, DiffLines); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Diff() to unknown revision error = %v, want ErrNotFound", err)
+	}
+}
+
+func Test_diff(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"", "new"},
+		{"old", ""},
+		{"a b c d", "a c d e"},
+		{"same", "same"},
+		{"x y x y", "y x y x"},
+	}
+	for _, tt := range tests {
+		changes := diff(split(tt.a, DiffWords), split(tt.b, DiffWords))
+		var a, b string
+		for _, c := range changes {
+			if c.Op != DiffInsert {
+				a += c.Text
+			}
+			if c.Op != DiffDelete {
+				b += c.Text
+			}
+		}
+		if a != tt.a || b != tt.b {
+			t.Errorf("diff(%q, %q) = %+v, does not rebuild both texts", tt.a, tt.b, changes)
+		}
+	}
+}
+
+func Test_diffTooLarge(t *testing.T) {
+	a := strings.Repeat("a ", 600) + "end"
+	b := strings.Repeat("b ", 600) + "end"
+	changes := diff(split(a, DiffWords), split(b, DiffWords))
+	want := []DiffChange{
+		{Op: DiffDelete, Text: strings.Repeat("a ", 599) + "a"},
+		{Op: DiffInsert, Text: strings.Repeat("b ", 599) + "b"},
+		{Op: DiffEqual, Text: " end"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("diff() of large texts = %+v, want them replaced as a whole", changes)
+	}
+}
@@ -0,0 +1,184 @@
+package message
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// schedulerRetry is how long the scheduler waits before retrying a delivery
+// that could not be persisted.
+const schedulerRetry = time.Second
+
+// minScheduleCompactLen is the length of the schedule below which stale
+// entries are left for the scheduler to skip.
+const minScheduleCompactLen = 1024
+
+// schedEntry is a message due for delivery at a given time.
+type schedEntry struct {
+	at time.Time
+	id int64
+}
+
+// schedule is a min-heap of scheduled deliveries ordered by time. Entries are
+// not removed when a message is deleted or rescheduled; the scheduler skips
+// an entry unless the message still exists and is still due at that time, and
+// compactSchedule drops such stale entries before they pile up.
+type schedule []schedEntry
+
+func (s schedule) Len() int            { return len(s) }
+func (s schedule) Less(i, j int) bool  { return s[i].at.Before(s[j].at) }
+func (s schedule) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *schedule) Push(x interface{}) { *s = append(*s, x.(schedEntry)) }
+func (s *schedule) Pop() interface{} {
+	old := *s
+	e := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return e
+}
+
+// scheduler delivers scheduled messages. A message posted with DeliverAt in
+// the future is stored right away but hidden from readers and consumers, and
+// changes to it emit no events, until the scheduler clears DeliverAt and
+// emits its created event. DeliverAt is persisted with the message, so the
+// schedule is rebuilt when the journal is replayed and deliveries that fell
+// due while the server was down are made on start.
+//
+// queue and compactAt are guarded by the MessageServer lock.
+type scheduler struct {
+	queue schedule
+	// compactAt is the length of queue at which it is compacted next.
+	compactAt int
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newScheduler() scheduler {
+	return scheduler{
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// isScheduled reports whether msg is waiting for its scheduled delivery.
+func (msg MessageObj) isScheduled() bool {
+	return msg.DeliverAt != nil
+}
+
+// isStale reports whether e no longer schedules the delivery of its message.
+// Callers hold the lock.
+func (m *MessageServer) isStale(e schedEntry) bool {
+	msg, ok := m.msgStore[e.id]
+	return !ok || !sameTime(msg.DeliverAt, &e.at)
+}
+
+// compactSchedule drops the stale entries of the schedule once it has
+// doubled since it was last compacted, so that they never make up more than
+// half of it. Callers hold the write lock.
+func (m *MessageServer) compactSchedule() {
+	s := &m.sched
+	if len(s.queue) < s.compactAt || len(s.queue) < minScheduleCompactLen {
+		return
+	}
+	var live schedule
+	for _, e := range s.queue {
+		if !m.isStale(e) {
+			live = append(live, e)
+		}
+	}
+	heap.Init(&live)
+	s.queue = live
+	s.compactAt = 2 * len(live)
+}
+
+// wakeScheduler makes the scheduler look at the earliest delivery again.
+func (m *MessageServer) wakeScheduler() {
+	select {
+	case m.sched.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runScheduler delivers messages as they fall due until stopScheduler is
+// called.
+func (m *MessageServer) runScheduler() {
+	s := &m.sched
+	defer close(s.stopped)
+	for {
+		m.RLock()
+		var t *time.Timer
+		var due <-chan time.Time
+		if len(s.queue) > 0 {
+			t = time.NewTimer(time.Until(s.queue[0].at))
+			due = t.C
+		}
+		m.RUnlock()
+
+		var err error
+		select {
+		case <-due:
+			err = m.deliverDue(time.Now())
+		case <-s.wake:
+		case <-s.stop:
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if err != nil {
+			// wait rather than spin on a failing journal
+			select {
+			case <-time.After(schedulerRetry):
+			case <-s.stop:
+			}
+		}
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+	}
+}
+
+// deliverDue delivers the messages due at now in a single commit. If the
+// commit fails the deliveries are kept for the next attempt.
+func (m *MessageServer) deliverDue(now time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	var due []schedEntry
+	rec := m.newRecord(0)
+	for len(m.sched.queue) > 0 && !m.sched.queue[0].at.After(now) {
+		e := heap.Pop(&m.sched.queue).(schedEntry)
+		if m.isStale(e) {
+			continue
+		}
+		msg := m.msgStore[e.id]
+		due = append(due, e)
+		msg.DeliverAt = nil
+		rec.Put = append(rec.Put, msg)
+		if !msg.trashed() {
+			// restoring it from the trash announces it instead
+			rec.addEvent(EventCreated, msg)
+		}
+	}
+	if len(rec.Put) == 0 {
+		return nil
+	}
+	if err := m.commit(rec); err != nil {
+		for _, e := range due {
+			heap.Push(&m.sched.queue, e)
+		}
+		return err
+	}
+	return nil
+}
+
+// stopScheduler stops the scheduler. Messages that fall due later are
+// delivered once the server is started again.
+func (m *MessageServer) stopScheduler() {
+	m.sched.stopOnce.Do(func() { close(m.sched.stop) })
+	<-m.sched.stopped
+}
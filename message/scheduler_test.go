@@ -0,0 +1,127 @@
+package message
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func after(d time.Duration) *time.Time {
+	t := time.Now().Add(d)
+	return &t
+}
+
+func TestMessageServer_scheduled(t *testing.T) {
+	m := NewMessageServer()
+	m.Start()
+	msg, err := m.Add(MessageObj{Text: "later", Queue: "jobs", DeliverAt: after(100 * time.Millisecond)})
+	if err != nil || msg.DeliverAt == nil {
+		t.Fatalf("Add() = %+v, %v, want a scheduled message", msg, err)
+	}
+	if _, err := m.Get(msg.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of scheduled message error = %v, want ErrNotFound", err)
+	}
+	if _, err := m.GetAll(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAll() error = %v, want ErrNotFound", err)
+	}
+	if got, err := m.GetAll(IncludeScheduled()); err != nil || len(got) != 1 {
+		t.Errorf("GetAll(IncludeScheduled()) = %+v, %v, want the scheduled message", got, err)
+	}
+	if leases, _ := m.Receive("jobs", 10, time.Hour); len(leases) != 0 {
+		t.Errorf("Receive() = %+v, want no scheduled message", leases)
+	}
+	if stats := m.QueueStats("jobs"); stats != (QueueStats{Scheduled: 1}) {
+		t.Errorf("QueueStats() = %+v, want 1 scheduled", stats)
+	}
+	// updates keep the schedule and emit no event
+	if _, err := m.Update(msg.Id, MessageObj{Text: "edited"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.Get(msg.Id, IncludeScheduled()); err != nil || got.Text != "edited" || !sameTime(got.DeliverAt, msg.DeliverAt) {
+		t.Errorf("Get() after Update() = %+v, %v, want the edited message still scheduled", got, err)
+	}
+	if _, err := m.Get(msg.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of updated scheduled message error = %v, want ErrNotFound", err)
+	}
+
+	// a message without a future delivery time is delivered right away
+	now, err := m.Add(MessageObj{Text: "now", DeliverAt: after(-time.Hour)})
+	if err != nil || now.DeliverAt != nil {
+		t.Errorf("Add() with past delivery time = %+v, %v, want an unscheduled message", now, err)
+	}
+
+	waitRelayed(t, m, 2)
+	got, err := m.Get(msg.Id)
+	if err != nil || got.DeliverAt != nil || got.Text != "edited" {
+		t.Errorf("Get() after delivery = %+v, %v, want the delivered message", got, err)
+	}
+	m.events.Lock()
+	backlog := m.events.backlog
+	m.events.Unlock()
+	if len(backlog) != 2 || backlog[1].Type != EventCreated || backlog[1].Message.Id != msg.Id {
+		t.Errorf("events = %+v, want created events of messages 2 and 1", backlog)
+	}
+}
+
+func TestScheduler_restart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal("Open() failed with error: ", err)
+	}
+	m.Start()
+	_, _ = m.Add(MessageObj{Text: "soon", DeliverAt: after(50 * time.Millisecond)})
+	_, _ = m.Add(MessageObj{Text: "later", DeliverAt: after(time.Hour)})
+	_ = m.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// deliveries that fell due while the server was down are made on start
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal("Open() after restart failed with error: ", err)
+	}
+	m.Start()
+	defer m.Close()
+	waitRelayed(t, m, 1)
+	if got := backlogIDs(m); !reflect.DeepEqual(got, []int64{1}) {
+		t.Errorf("events after restart = %v, want [1]", got)
+	}
+	if _, err := m.Get(1); err != nil {
+		t.Errorf("Get() of due message error = %v", err)
+	}
+	if got, err := m.Get(2, IncludeScheduled()); err != nil || got.DeliverAt == nil {
+		t.Errorf("Get() of scheduled message = %+v, %v, want it still scheduled", got, err)
+	}
+}
+
+func TestScheduler_compact(t *testing.T) {
+	m := NewMessageServer()
+	msgs := make([]MessageObj, minScheduleCompactLen)
+	for i := range msgs {
+		msgs[i] = MessageObj{Text: "later", DeliverAt: after(time.Hour)}
+	}
+	added, _ := m.AddBatch(msgs)
+	for _, msg := range added {
+		if err := m.Delete(msg.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the entries of deleted messages are dropped as the schedule grows
+	_, _ = m.AddBatch(msgs)
+	if got := len(m.sched.queue); got != minScheduleCompactLen {
+		t.Errorf("schedule length = %d, want %d", got, minScheduleCompactLen)
+	}
+	for _, e := range m.sched.queue {
+		if m.isStale(e) {
+			t.Fatalf("schedule entry %+v is stale", e)
+		}
+	}
+}
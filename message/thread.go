@@ -0,0 +1,235 @@
+package message
+
+import (
+	"sort"
+	"time"
+)
+
+// DeletePolicy decides what happens to the replies of a deleted message.
+type DeletePolicy string
+
+const (
+	// DeleteOrphan keeps the replies, which become the roots of their own
+	// threads. Their ParentId still names the deleted message.
+	DeleteOrphan DeletePolicy = "orphan"
+	// DeleteCascade deletes the replies, and their replies, too.
+	DeleteCascade DeletePolicy = "cascade"
+	// DeleteTombstone keeps a message that has replies as a tombstone
+	// without text or tags so that the shape of the thread is preserved. A
+	// tombstone is deleted once its last reply is. Messages in the trash
+	// that have replies are shown as tombstones, too.
+	DeleteTombstone DeletePolicy = "tombstone"
+)
+
+// ParseDeletePolicy returns the DeletePolicy named s.
+func ParseDeletePolicy(s string) (DeletePolicy, error) {
+	switch p := DeletePolicy(s); p {
+	case DeleteOrphan, DeleteCascade, DeleteTombstone:
+		return p, nil
+	}
+	return "", Errorf(ErrInvalid, "invalid delete policy %q, should be %q, %q or %q", s, DeleteOrphan, DeleteCascade, DeleteTombstone)
+}
+
+// Thread is a message with its replies, oldest first.
+type Thread struct {
+	MessageObj
+	Replies []Thread `json:"replies,omitempty"`
+}
+
+// SetDeletePolicy sets the policy applied by Delete and DeleteBatch to the
+// replies of a deleted message. The default is DeleteOrphan.
+func (m *MessageServer) SetDeletePolicy(p DeletePolicy) {
+	m.Lock()
+	defer m.Unlock()
+	m.deletePolicy = p
+}
+
+// indexReply records msg as a reply of its parent. Callers hold the lock.
+func (m *MessageServer) indexReply(msg MessageObj) {
+	if msg.ParentId == 0 {
+		return
+	}
+	set, ok := m.replies[msg.ParentId]
+	if !ok {
+		set = make(map[int64]bool)
+		m.replies[msg.ParentId] = set
+	}
+	set[msg.Id] = true
+}
+
+// unindexReply removes msg, which is being deleted, from the reply index.
+// Callers hold the lock.
+func (m *MessageServer) unindexReply(msg MessageObj) {
+	if set, ok := m.replies[msg.ParentId]; ok {
+		delete(set, msg.Id)
+		if len(set) == 0 {
+			delete(m.replies, msg.ParentId)
+		}
+	}
+	// replies of msg that are kept are orphans now
+	delete(m.replies, msg.Id)
+}
+
+// replyIDs returns the IDs of the replies of id in ID order. Callers hold the
+// lock.
+func (m *MessageServer) replyIDs(id int64) []int64 {
+	ids := make([]int64, 0, len(m.replies[id]))
+	for reply := range m.replies[id] {
+		ids = append(ids, reply)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// checkParent verifies that a new message may reply to parent. Callers hold
+// the lock.
+func (m *MessageServer) checkParent(parent int64, now time.Time) error {
+	if parent == 0 {
+		return nil
+	}
+	msg, ok := m.lookup(parent, now)
+	if !ok || msg.isScheduled() || msg.Tombstone {
+		return Errorf(ErrInvalid, "parent message %d not found", parent)
+	}
+	return nil
+}
+
+// remove adds the deletion of msg for good to rec according to the delete
+// policy, with an event of type typ for msg, EventDeleted or EventExpired,
+// and deleted events for the replies deleted along. No event is added for a
+// message in the trash, its deleted event was published when it was moved
+// there. deleted holds the IDs already deleted by rec and is updated.
+// Callers hold the lock.
+func (m *MessageServer) remove(rec *journalRecord, msg MessageObj, typ string, deleted map[int64]bool) {
+	if deleted[msg.Id] {
+		return
+	}
+	switch m.deletePolicy {
+	case DeleteCascade:
+		deleted[msg.Id] = true
+		rec.Delete = append(rec.Delete, msg.Id)
+		if !msg.trashed() {
+			rec.addEvent(typ, msg)
+		}
+		for _, id := range m.replyIDs(msg.Id) {
+			m.remove(rec, m.msgStore[id], EventDeleted, deleted)
+		}
+		return
+	case DeleteTombstone:
+		if m.liveReplies(msg.Id, deleted) > 0 {
+			if !msg.trashed() {
+				rec.addEvent(typ, msg)
+			}
+			rec.Put = append(rec.Put, tombstoneOf(msg))
+			return
+		}
+	}
+	deleted[msg.Id] = true
+	rec.Delete = append(rec.Delete, msg.Id)
+	if !msg.Tombstone && !msg.trashed() {
+		// the event of a tombstone was emitted when it was made one
+		rec.addEvent(typ, msg)
+	}
+	// a tombstone is kept only for the sake of its replies
+	if parent, ok := m.msgStore[msg.ParentId]; ok && parent.Tombstone && m.liveReplies(parent.Id, deleted) == 0 {
+		m.remove(rec, parent, EventDeleted, deleted)
+	}
+}
+
+// tombstoneOf returns the tombstone of msg.
+func tombstoneOf(msg MessageObj) MessageObj {
+	return MessageObj{
+		Id:        msg.Id,
+		Author:    msg.Author,
+		ParentId:  msg.ParentId,
+		ExpiresAt: msg.ExpiresAt,
+		Tombstone: true,
+	}
+}
+
+// shown returns message id as threads show it at now with read options o,
+// and whether they show it at all. Tombstones are shown so that the replies
+// below them are reachable, and so are messages in the trash that have
+// replies if the delete policy is tombstone. Callers hold the lock.
+func (m *MessageServer) shown(id int64, o readOptions, now time.Time) (MessageObj, bool) {
+	msg, ok := m.msgStore[id]
+	switch {
+	case !ok:
+		return MessageObj{}, false
+	case msg.Tombstone:
+		return msg, true
+	case msg.trashed():
+		if m.deletePolicy == DeleteTombstone && len(m.replies[msg.Id]) > 0 && !msg.expired(now) {
+			return tombstoneOf(msg), true
+		}
+		return MessageObj{}, false
+	}
+	return msg, o.visible(msg, now)
+}
+
+// liveReplies counts the replies of id not in deleted. Callers hold the
+// lock.
+func (m *MessageServer) liveReplies(id int64, deleted map[int64]bool) int {
+	n := 0
+	for reply := range m.replies[id] {
+		if !deleted[reply] {
+			n++
+		}
+	}
+	return n
+}
+
+// thread returns msg with its visible replies down to depth levels, or all
+// of them if depth is negative. Callers hold the lock.
+func (m *MessageServer) thread(msg MessageObj, depth int, o readOptions, now time.Time) Thread {
+	t := Thread{MessageObj: msg}
+	if depth == 0 {
+		return t
+	}
+	for _, id := range m.replyIDs(msg.Id) {
+		if reply, ok := m.shown(id, o, now); ok {
+			t.Replies = append(t.Replies, m.thread(reply, depth-1, o, now))
+		}
+	}
+	return t
+}
+
+// Replies returns the replies of message id down to depth levels, 1 being
+// the direct replies only, or all of them if depth is negative. Tombstones
+// are included so that the replies below them are reachable.
+func (m *MessageServer) Replies(id int64, depth int, opts ...ReadOption) ([]Thread, error) {
+	o := newReadOptions(opts)
+	now := time.Now()
+	m.RLock()
+	defer m.RUnlock()
+	msg, ok := m.shown(id, o, now)
+	if !ok {
+		return nil, Errorf(ErrNotFound, "message %d not found", id)
+	}
+	replies := m.thread(msg, depth, o, now).Replies
+	if replies == nil {
+		replies = []Thread{}
+	}
+	return replies, nil
+}
+
+// Thread returns the whole conversation message id belongs to, starting at
+// the oldest ancestor that still exists.
+func (m *MessageServer) Thread(id int64, opts ...ReadOption) (Thread, error) {
+	o := newReadOptions(opts)
+	now := time.Now()
+	m.RLock()
+	defer m.RUnlock()
+	msg, ok := m.shown(id, o, now)
+	if !ok {
+		return Thread{}, Errorf(ErrNotFound, "message %d not found", id)
+	}
+	for msg.ParentId != 0 {
+		parent, ok := m.shown(msg.ParentId, o, now)
+		if !ok {
+			break
+		}
+		msg = parent
+	}
+	return m.thread(msg, -1, o, now), nil
+}
@@ -0,0 +1,129 @@
+package message
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// newThread stores 1 <- 2 <- 3 and 1 <- 4, replies pointing at their parent.
+func newThread(t *testing.T, p DeletePolicy) *MessageServer {
+	t.Helper()
+	m := NewMessageServer()
+	m.SetDeletePolicy(p)
+	for _, msg := range []MessageObj{
+		{Text: "root"},
+		{Text: "reply", ParentId: 1},
+		{Text: "nested", ParentId: 2},
+		{Text: "second", ParentId: 1},
+	} {
+		if _, err := m.Add(msg); err != nil {
+			t.Fatalf("Add(%+v) error = %v", msg, err)
+		}
+	}
+	return m
+}
+
+func TestMessageServer_replies(t *testing.T) {
+	m := newThread(t, DeleteOrphan)
+	if _, err := m.Add(MessageObj{Text: "lost", ParentId: 42}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Add() with unknown parent error = %v, want ErrInvalid", err)
+	}
+
+	replies, err := m.Replies(1, 1)
+	if err != nil || len(replies) != 2 || replies[0].Id != 2 || replies[1].Id != 4 {
+		t.Fatalf("Replies(1, 1) = %+v, %v, want messages 2 and 4", replies, err)
+	}
+	if replies[0].Replies != nil {
+		t.Errorf("Replies(1, 1) went below depth 1: %+v", replies[0].Replies)
+	}
+	replies, _ = m.Replies(1, -1)
+	if len(replies[0].Replies) != 1 || replies[0].Replies[0].Id != 3 {
+		t.Errorf("Replies(1, -1) = %+v, want message 3 below message 2", replies)
+	}
+	if _, err := m.Replies(42, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Replies() of unknown message error = %v, want ErrNotFound", err)
+	}
+
+	thread, err := m.Thread(3)
+	if err != nil || thread.Id != 1 || len(thread.Replies) != 2 {
+		t.Errorf("Thread(3) = %+v, %v, want the thread rooted at message 1", thread, err)
+	}
+}
+
+func TestMessageServer_deletePolicy(t *testing.T) {
+	tests := []struct {
+		policy    DeletePolicy
+		delete    []int64
+		wantIDs   []int64
+		wantRoot  int64 // root of the thread of message 3, 0 if it is deleted
+		tombstone bool
+	}{
+		{policy: DeleteOrphan, delete: []int64{1}, wantIDs: []int64{2, 3, 4}, wantRoot: 2},
+		{policy: DeleteCascade, delete: []int64{2}, wantIDs: []int64{1, 4}},
+		{policy: DeleteTombstone, delete: []int64{2}, wantIDs: []int64{1, 2, 3, 4}, wantRoot: 1, tombstone: true},
+		{policy: DeleteTombstone, delete: []int64{2, 3}, wantIDs: []int64{1, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			m := newThread(t, tt.policy)
+			for _, id := range tt.delete {
+				if err := m.Delete(id); err != nil {
+					t.Fatalf("Delete(%d) error = %v", id, err)
+				}
+			}
+			var ids []int64
+			for id := range m.msgStore {
+				ids = append(ids, id)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Errorf("stored IDs = %v, want %v", ids, tt.wantIDs)
+			}
+			for _, id := range tt.wantIDs {
+				if _, ok := m.msgStore[id]; !ok {
+					t.Errorf("message %d deleted, want it kept", id)
+				}
+			}
+			if got := m.msgStore[2].Tombstone; got != tt.tombstone {
+				t.Errorf("message 2 tombstone = %v, want %v", got, tt.tombstone)
+			}
+			thread, err := m.Thread(3)
+			if tt.wantRoot == 0 && !errors.Is(err, ErrNotFound) {
+				t.Errorf("Thread(3) error = %v, want ErrNotFound", err)
+			}
+			if tt.wantRoot != 0 && (err != nil || thread.Id != tt.wantRoot) {
+				t.Errorf("Thread(3) = %+v, %v, want root %d", thread, err, tt.wantRoot)
+			}
+			if tt.tombstone {
+				if _, err := m.Get(2); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get() of tombstone error = %v, want ErrNotFound", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageServer_threadReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = m.Add(MessageObj{Text: "root"})
+	_, _ = m.Add(MessageObj{Text: "reply", ParentId: 1})
+	_ = m.Close()
+
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if replies, err := m.Replies(1, 1); err != nil || len(replies) != 1 {
+		t.Errorf("Replies() after reopen = %+v, %v, want the reply index rebuilt", replies, err)
+	}
+}
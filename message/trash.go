@@ -0,0 +1,198 @@
+package message
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+// deleteOptions are the options of Delete and DeleteBatch.
+type deleteOptions struct {
+	by   string
+	hard bool
+}
+
+// DeleteOption changes how Delete and DeleteBatch delete messages.
+type DeleteOption func(*deleteOptions)
+
+// DeletedBy records principal as the one who moved the messages to the
+// trash.
+func DeletedBy(principal string) DeleteOption {
+	return func(o *deleteOptions) {
+		o.by = principal
+	}
+}
+
+// HardDelete deletes messages for good instead of moving them to the trash.
+// Messages already in the trash can only be deleted this way.
+func HardDelete() DeleteOption {
+	return func(o *deleteOptions) {
+		o.hard = true
+	}
+}
+
+func newDeleteOptions(opts []DeleteOption) deleteOptions {
+	var o deleteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SetTrashRetention makes Delete and DeleteBatch move messages to the trash,
+// from which they can be restored with Undelete until the janitor purges
+// them d after their deletion. With zero, the default, the trash is disabled
+// and deletes are permanent; messages still in the trash are purged.
+func (m *MessageServer) SetTrashRetention(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.jan.retention = d
+}
+
+// trashed reports whether msg is in the trash.
+func (msg MessageObj) trashed() bool {
+	return msg.DeletedAt != nil
+}
+
+// deletable returns message id if a delete with options o may delete it.
+// Callers hold the lock.
+func (m *MessageServer) deletable(id int64, o deleteOptions, now time.Time) (MessageObj, bool) {
+	if msg, ok := m.lookup(id, now); ok {
+		return msg, true
+	}
+	msg, ok := m.msgStore[id]
+	return msg, ok && o.hard && msg.trashed() && !msg.expired(now)
+}
+
+// delete adds the deletion of msg to rec: moving it to the trash, or
+// removing it for good if the trash is disabled or o asks for a hard delete.
+// deleted holds the IDs already deleted by rec and is updated. Callers hold
+// the lock.
+func (m *MessageServer) delete(rec *journalRecord, msg MessageObj, o deleteOptions, now time.Time, deleted map[int64]bool) {
+	if o.hard || m.jan.retention == 0 {
+		m.remove(rec, msg, EventDeleted, deleted)
+		return
+	}
+	m.trash(rec, msg, 0, o, now, deleted)
+}
+
+// trash adds moving msg to the trash to rec, together with its replies if
+// the delete policy is cascade, which record the message they were deleted
+// with in DeletedWith. The other policies are applied when the message is
+// purged. with is the parent msg was deleted with, 0 if it was deleted on
+// its own. Callers hold the lock.
+func (m *MessageServer) trash(rec *journalRecord, msg MessageObj, with int64, o deleteOptions, now time.Time, deleted map[int64]bool) {
+	if deleted[msg.Id] {
+		return
+	}
+	deleted[msg.Id] = true
+	at := now.UTC()
+	msg.DeletedAt = &at
+	msg.DeletedBy = o.by
+	msg.DeletedWith = with
+	rec.Put = append(rec.Put, msg)
+	rec.addEvent(EventDeleted, msg)
+	if m.deletePolicy != DeleteCascade {
+		return
+	}
+	for _, id := range m.replyIDs(msg.Id) {
+		if reply := m.msgStore[id]; !reply.trashed() && !reply.Tombstone {
+			m.trash(rec, reply, msg.Id, o, now, deleted)
+		}
+	}
+}
+
+// Undelete restores message id from the trash together with the replies
+// that were deleted with it.
+func (m *MessageServer) Undelete(id int64) (MessageObj, error) {
+	now := time.Now()
+	m.Lock()
+	defer m.Unlock()
+	msg, ok := m.msgStore[id]
+	if !ok || !msg.trashed() || msg.expired(now) {
+		return MessageObj{}, Errorf(ErrNotFound, "message %d not found in trash", id)
+	}
+	rec := m.newRecord(0)
+	m.restore(&rec, msg, now)
+	if err := m.commit(rec); err != nil {
+		return MessageObj{}, err
+	}
+	return rec.Put[0], nil
+}
+
+// restore adds restoring msg and the replies deleted with it from the trash
+// to rec. Callers hold the lock.
+func (m *MessageServer) restore(rec *journalRecord, msg MessageObj, now time.Time) {
+	msg.DeletedAt = nil
+	msg.DeletedBy = ""
+	msg.DeletedWith = 0
+	rec.Put = append(rec.Put, msg)
+	rec.addEvent(EventUndeleted, msg)
+	for _, id := range m.replyIDs(msg.Id) {
+		if reply := m.msgStore[id]; reply.trashed() && reply.DeletedWith == msg.Id && !reply.expired(now) {
+			m.restore(rec, reply, now)
+		}
+	}
+}
+
+// Trash returns the messages in the trash in ID order.
+func (m *MessageServer) Trash() []MessageObj {
+	now := time.Now()
+	m.RLock()
+	defer m.RUnlock()
+	msgs := []MessageObj{}
+	for _, msg := range m.msgStore {
+		if msg.trashed() && !msg.expired(now) {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Id < msgs[j].Id })
+	return msgs
+}
+
+// purgeTrash deletes the messages whose retention in the trash ended at now
+// and returns how many it deleted.
+func (m *MessageServer) purgeTrash(now time.Time) (int, error) {
+	total := 0
+	for {
+		n, more, err := m.purgeBatch(now)
+		total += n
+		if err != nil || !more {
+			return total, err
+		}
+	}
+}
+
+// purgeBatch deletes up to janitorBatchSize messages from the trash in a
+// single commit, applying the delete policy. more reports that further
+// messages are due.
+func (m *MessageServer) purgeBatch(now time.Time) (n int, more bool, err error) {
+	m.Lock()
+	defer m.Unlock()
+	due := func() bool {
+		return len(m.jan.purges) > 0 && !m.jan.purges[0].at.Add(m.jan.retention).After(now)
+	}
+	var popped []schedEntry
+	rec := m.newRecord(0)
+	deleted := make(map[int64]bool)
+	for len(popped) < janitorBatchSize && due() {
+		e := heap.Pop(&m.jan.purges).(schedEntry)
+		msg, ok := m.msgStore[e.id]
+		if !ok || !sameTime(msg.DeletedAt, &e.at) {
+			continue
+		}
+		popped = append(popped, e)
+		m.remove(&rec, msg, EventDeleted, deleted)
+	}
+	more = due()
+	if len(popped) == 0 {
+		return 0, more, nil
+	}
+	if err := m.commit(rec); err != nil {
+		for _, e := range popped {
+			heap.Push(&m.jan.purges, e)
+		}
+		return 0, false, err
+	}
+	return len(popped), more, nil
+}
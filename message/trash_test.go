@@ -0,0 +1,158 @@
+package message
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMessageServer_trash(t *testing.T) {
+	m := NewMessageServer()
+	m.Start()
+	m.SetTrashRetention(time.Hour)
+	_, _ = m.Add(MessageObj{Text: "oops"})
+	_, _ = m.Add(MessageObj{Text: "kept"})
+
+	if err := m.Delete(1, DeletedBy("ann")); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := m.Get(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of trashed message error = %v, want ErrNotFound", err)
+	}
+	if err := m.Delete(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of trashed message error = %v, want ErrNotFound", err)
+	}
+	trash := m.Trash()
+	if len(trash) != 1 || trash[0].Id != 1 || trash[0].DeletedBy != "ann" || trash[0].DeletedAt == nil {
+		t.Fatalf("Trash() = %+v, want message 1 deleted by ann", trash)
+	}
+
+	msg, err := m.Undelete(1)
+	if err != nil || msg.Text != "oops" || msg.DeletedAt != nil {
+		t.Fatalf("Undelete() = %+v, %v, want the restored message", msg, err)
+	}
+	if _, err := m.Get(1); err != nil {
+		t.Errorf("Get() of restored message error = %v", err)
+	}
+	if _, err := m.Undelete(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Undelete() of message not in trash error = %v, want ErrNotFound", err)
+	}
+	waitRelayed(t, m, 4)
+	m.events.Lock()
+	last := m.events.backlog[len(m.events.backlog)-1]
+	m.events.Unlock()
+	if last.Type != EventUndeleted || last.Message.Id != 1 {
+		t.Errorf("last event = %+v, want undeleted event of message 1", last)
+	}
+
+	// a hard delete skips the trash and empties it
+	_ = m.Delete(1)
+	if err := m.Delete(1, HardDelete()); err != nil {
+		t.Fatalf("Delete() of trashed message with HardDelete error = %v", err)
+	}
+	if err := m.Delete(2, HardDelete()); err != nil {
+		t.Fatalf("Delete() with HardDelete error = %v", err)
+	}
+	if len(m.msgStore) != 0 || len(m.Trash()) != 0 {
+		t.Errorf("store = %+v after hard deletes, want it empty", m.msgStore)
+	}
+}
+
+func TestMessageServer_purgeTrash(t *testing.T) {
+	m := NewMessageServer()
+	m.SetTrashRetention(time.Hour)
+	_, _ = m.Add(MessageObj{Text: "old"})
+	_, _ = m.Add(MessageObj{Text: "new"})
+	_, _ = m.DeleteBatch([]int64{1, 2}, false)
+
+	if n, err := m.purgeTrash(time.Now()); err != nil || n != 0 {
+		t.Errorf("purgeTrash() before retention = %d, %v, want 0", n, err)
+	}
+	n, err := m.purgeTrash(time.Now().Add(time.Hour))
+	if err != nil || n != 2 {
+		t.Errorf("purgeTrash() after retention = %d, %v, want 2", n, err)
+	}
+	if len(m.msgStore) != 0 {
+		t.Errorf("store = %+v after purge, want it empty", m.msgStore)
+	}
+}
+
+func TestMessageServer_trashThreads(t *testing.T) {
+	m := newThread(t, DeleteTombstone)
+	m.SetTrashRetention(time.Hour)
+	_ = m.Delete(2)
+
+	thread, err := m.Thread(3)
+	if err != nil || thread.Id != 1 || len(thread.Replies) != 2 {
+		t.Fatalf("Thread(3) = %+v, %v, want the thread rooted at message 1", thread, err)
+	}
+	if reply := thread.Replies[0]; !reply.Tombstone || reply.Text != "" || len(reply.Replies) != 1 {
+		t.Errorf("trashed reply = %+v, want a tombstone above message 3", reply)
+	}
+
+	// the policy is applied when the trash is purged
+	_, _ = m.purgeTrash(time.Now().Add(time.Hour))
+	if msg := m.msgStore[2]; !msg.Tombstone || msg.DeletedAt != nil {
+		t.Errorf("purged message 2 = %+v, want a tombstone", msg)
+	}
+
+	m = newThread(t, DeleteCascade)
+	m.SetTrashRetention(time.Hour)
+	_ = m.Delete(1)
+	if trash := m.Trash(); len(trash) != 4 {
+		t.Errorf("Trash() after cascading delete = %+v, want the whole thread", trash)
+	}
+
+	// the replies deleted with a message are restored with it, the ones
+	// deleted on their own stay in the trash
+	m = newThread(t, DeleteCascade)
+	m.SetTrashRetention(time.Hour)
+	_ = m.Delete(4)
+	_ = m.Delete(1)
+	if msg := m.msgStore[3]; msg.DeletedWith != 2 {
+		t.Errorf("DeletedWith of message 3 = %d, want 2", msg.DeletedWith)
+	}
+	if msg, err := m.Undelete(1); err != nil || msg.Id != 1 || msg.trashed() {
+		t.Fatalf("Undelete(1) = %+v, %v, want message 1 restored", msg, err)
+	}
+	if trash := m.Trash(); len(trash) != 1 || trash[0].Id != 4 {
+		t.Errorf("Trash() after Undelete(1) = %+v, want message 4 only", trash)
+	}
+	if msg := m.msgStore[3]; msg.trashed() || msg.DeletedWith != 0 {
+		t.Errorf("message 3 after Undelete(1) = %+v, want it restored", msg)
+	}
+}
+
+func TestMessageServer_trashReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetTrashRetention(time.Hour)
+	_, _ = m.Add(MessageObj{Text: "oops", Queue: "jobs"})
+	_ = m.Delete(1, DeletedBy("ann"))
+	_ = m.Close()
+
+	m, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	m.SetTrashRetention(time.Hour)
+	if trash := m.Trash(); len(trash) != 1 || trash[0].DeletedBy != "ann" {
+		t.Errorf("Trash() after reopen = %+v, want message 1", trash)
+	}
+	if msgs, _ := m.Receive("jobs", 10, time.Minute); len(msgs) != 0 {
+		t.Errorf("Receive() = %+v, want no trashed messages", msgs)
+	}
+	if n, _ := m.purgeTrash(time.Now().Add(time.Hour)); n != 1 {
+		t.Errorf("purgeTrash() after reopen = %d, want the purge rescheduled", n)
+	}
+}
@@ -0,0 +1,349 @@
+// Package client is a Go client of the messaging API.
+//
+//	c, err := client.New("http://localhost:8090", client.WithBasicAuth("ann", "secret"))
+//	msg, err := c.Create(ctx, client.MessageRequest{Text: "hello"})
+//
+// Requests rejected with 429 are retried with exponential backoff. So are
+// requests failing with a 5xx status or not reaching the server at all, as
+// far as they are safe to repeat: reads, and changes carrying an idempotency
+// key. Messages are created with one so that retries never create a message
+// twice.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	requestIDHeader      = "X-Request-ID"
+	tenantHeader         = "X-Tenant-ID"
+
+	defaultRetries = 3
+	defaultBackoff = 100 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
+
+// MessageRequest is a message to create. A message is scheduled for
+// delivery at DeliverAt or after DelaySeconds, at most one of which may be
+// set, and expires TTL seconds after its delivery. A reply names the message
+// it replies to in ParentId.
+type MessageRequest struct {
+	Text         string     `json:"text"`
+	Author       string     `json:"author,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Queue        string     `json:"queue,omitempty"`
+	DeliverAt    *time.Time `json:"deliver_at,omitempty"`
+	DelaySeconds int64      `json:"delay_seconds,omitempty"`
+	TTL          int64      `json:"ttl,omitempty"`
+	ParentId     int64      `json:"parent_id,omitempty"`
+}
+
+// Client calls the messaging API. It is safe for concurrent use.
+type Client struct {
+	base     *url.URL
+	hc       *http.Client
+	retries  int
+	backoff  time.Duration
+	user     string
+	password string
+	tenant   string
+}
+
+// Option configures optional behaviour of the Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the Client send requests with hc instead of
+// http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.hc = hc
+	}
+}
+
+// WithRetries retries failed requests up to n times, waiting backoff before
+// the first retry and doubling the wait for every further one. A Retry-After
+// header sent by the server takes precedence, unless it asks to wait longer
+// than 10s, in which case the request fails right away. Zero disables
+// retries.
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = n
+		c.backoff = backoff
+	}
+}
+
+// WithBasicAuth authenticates every request as user.
+func WithBasicAuth(user, password string) Option {
+	return func(c *Client) {
+		c.user = user
+		c.password = password
+	}
+}
+
+// WithTenant sends every request for tenant id.
+func WithTenant(id string) Option {
+	return func(c *Client) {
+		c.tenant = id
+	}
+}
+
+// New returns a Client of the API served at baseURL, e.g.
+// http://localhost:8090.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q, must be http or https", baseURL)
+	}
+	c := &Client{
+		base:    base,
+		hc:      http.DefaultClient,
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// callOptions are the options of a single call.
+type callOptions struct {
+	idempotencyKey string
+}
+
+// CallOption changes how a single call is made.
+type CallOption func(*callOptions)
+
+// WithIdempotencyKey sends key as the Idempotency-Key of the request, so
+// that the server applies it at most once however often it is sent. Without
+// it Create uses a random key, which only protects its own retries.
+func WithIdempotencyKey(key string) CallOption {
+	return func(o *callOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+// Create creates msg and returns the created message.
+func (c *Client) Create(ctx context.Context, msg MessageRequest, opts ...CallOption) (message.MessageObj, error) {
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.idempotencyKey == "" {
+		o.idempotencyKey = newIdempotencyKey()
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return message.MessageObj{}, err
+	}
+	var resp message.MessageObj
+	_, err = c.do(ctx, http.MethodPost, "/v1/messages", body, o.idempotencyKey, &resp)
+	return resp, err
+}
+
+// Get returns message id.
+func (c *Client) Get(ctx context.Context, id int64) (message.MessageObj, error) {
+	var resp message.MessageObj
+	_, err := c.do(ctx, http.MethodGet, messagePath(id), nil, "", &resp)
+	return resp, err
+}
+
+// Delete deletes message id.
+func (c *Client) Delete(ctx context.Context, id int64) error {
+	_, err := c.do(ctx, http.MethodDelete, messagePath(id), nil, "", nil)
+	return err
+}
+
+// CheckPalindrome reports whether the text of message id is a palindrome.
+func (c *Client) CheckPalindrome(ctx context.Context, id int64) (bool, error) {
+	var resp message.MessageObj
+	_, err := c.do(ctx, http.MethodGet, messagePath(id)+"?is-palindrome", nil, "", &resp)
+	if err != nil {
+		return false, err
+	}
+	if resp.IsPalindrome == nil {
+		return false, fmt.Errorf("response for message %d lacks the palindrome check", id)
+	}
+	return *resp.IsPalindrome, nil
+}
+
+func messagePath(id int64) string {
+	return "/v1/messages/" + strconv.FormatInt(id, 10)
+}
+
+// do sends a request with body to path and decodes the response into out
+// unless it is nil.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, key string, out interface{}) (http.Header, error) {
+	h := http.Header{}
+	var rd io.Reader
+	if body != nil {
+		h.Set("Content-Type", "application/json")
+		rd = bytes.NewReader(body)
+	}
+	h.Set("Accept", "application/json")
+	if key != "" {
+		h.Set(idempotencyKeyHeader, key)
+	}
+	resp, err := c.send(ctx, method, path, rd, h)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if out != nil && method != http.MethodHead {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.Header, fmt.Errorf("invalid response body: %v", err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// send sends a request with body and header h to path, retrying it as
+// configured, and returns the response if it succeeded. Error responses are
+// returned as *Error. A body that can not be rewound is sent only once.
+// Callers close the body of the response.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader, h http.Header) (*http.Response, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	u := c.base.ResolveReference(ref).String()
+	for attempt := 0; ; attempt++ {
+		var rd io.Reader
+		if body != nil {
+			rd = ioutil.NopCloser(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, rd)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range h {
+			req.Header[k] = v
+		}
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.password)
+		}
+		if c.tenant != "" {
+			req.Header.Set(tenantHeader, c.tenant)
+		}
+		injectSpan(ctx, req)
+
+		resp, err := c.hc.Do(req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		var status int
+		if err == nil {
+			status = resp.StatusCode
+		}
+		again := retryable(method, req.Header, status)
+		if err == nil && !again {
+			defer resp.Body.Close()
+			return nil, errorOf(resp)
+		}
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		wait, ok := c.wait(attempt, resp)
+		if !again || !ok || attempt >= c.retries || !rewind(body) {
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			return nil, errorOf(resp)
+		}
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// rewind prepares body to be sent again and reports whether it can be.
+func rewind(body io.Reader) bool {
+	if body == nil {
+		return true
+	}
+	s, ok := body.(io.Seeker)
+	if !ok {
+		return false
+	}
+	_, err := s.Seek(0, io.SeekStart)
+	return err == nil
+}
+
+// retryable reports whether a request with method and header h may succeed
+// when sent again after failing with status, 0 if it got no response. A 429
+// was rejected before the server acted on it. Any other failure may have
+// come after the change was made, so only requests that are safe to repeat
+// are sent again: reads, and changes carrying an Idempotency-Key.
+func retryable(method string, h http.Header, status int) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	if status != 0 && status < http.StatusInternalServerError {
+		return false
+	}
+	return method == http.MethodGet || method == http.MethodHead || h.Get(idempotencyKeyHeader) != ""
+}
+
+// wait returns how long to wait before retrying after attempt got resp,
+// which is nil if the request failed. It returns false if the Retry-After
+// header of resp asks to wait longer than maxBackoff.
+func (c *Client) wait(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+			d := time.Duration(s) * time.Second
+			return d, d <= maxBackoff
+		}
+	}
+	d := c.backoff << uint(attempt)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d, true
+}
+
+// injectSpan propagates the span of ctx, if any, in the headers of req.
+func injectSpan(ctx context.Context, req *http.Request) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	_ = span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
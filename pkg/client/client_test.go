@@ -0,0 +1,235 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/shailendra-k-singh/example.messaging.service/app"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-client-go"
+)
+
+// testServer is a server of its own for a test. Close closes its stores as
+// well.
+type testServer struct {
+	*httptest.Server
+	stop func()
+}
+
+func (s *testServer) Close() {
+	s.Server.Close()
+	s.stop()
+}
+
+// newServer serves a new router to user, a user of the tenant of the same
+// name, passing requests through wrap. Tracing is left out, its metrics can
+// be registered once per process.
+func newServer(t *testing.T, user string, wrap func(http.Handler) http.Handler) (*testServer, *Client) {
+	r := app.NewAppRouter(200, app.WithBasicAuth(map[string]string{user: "secret"}), app.WithTenants(map[string]string{user: user}))
+	r.SetRoutes()
+	var h http.Handler = r.GetRouter()
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := &testServer{Server: httptest.NewServer(h), stop: r.Close}
+	c, err := New(srv.URL, WithBasicAuth(user, "secret"), WithRetries(3, time.Millisecond))
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return srv, c
+}
+
+func TestClient(t *testing.T) {
+	srv, c := newServer(t, "client", nil)
+	defer srv.Close()
+	ctx := context.Background()
+
+	msg, err := c.Create(ctx, MessageRequest{Text: "abba", Tags: []string{"news"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), msg.Id)
+	got, err := c.Get(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "abba", got.Text)
+	ok, err := c.CheckPalindrome(ctx, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, c.Delete(ctx, 1))
+	_, err = c.Get(ctx, 1)
+	assert.True(t, errors.Is(err, ErrNotFound), "%v", err)
+	var e *Error
+	if assert.True(t, errors.As(err, &e)) {
+		assert.Equal(t, http.StatusNotFound, e.Status)
+		assert.NotEmpty(t, e.RequestID)
+	}
+
+	_, err = c.Create(ctx, MessageRequest{Text: ""})
+	assert.True(t, errors.Is(err, ErrInvalidArgument), "%v", err)
+}
+
+func TestClient_List(t *testing.T) {
+	var requests int32
+	srv, c := newServer(t, "list", func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodGet {
+				atomic.AddInt32(&requests, 1)
+			}
+			h.ServeHTTP(w, req)
+		})
+	})
+	defer srv.Close()
+	ctx := context.Background()
+
+	it := c.List(ctx, ListOptions{})
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Err())
+
+	for _, text := range []string{"a", "b", "c", "d", "e"} {
+		_, err := c.Create(ctx, MessageRequest{Text: text})
+		assert.NoError(t, err)
+	}
+	atomic.StoreInt32(&requests, 0)
+	var texts []string
+	it = c.List(ctx, ListOptions{PageSize: 2})
+	for it.Next() {
+		texts = append(texts, it.Message().Text)
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, texts)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestClient_retries(t *testing.T) {
+	var failures int32 = 2
+	var keys []string
+	srv, c := newServer(t, "retries", func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			keys = append(keys, req.Header.Get(idempotencyKeyHeader))
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				// apply the request but lose the response
+				h.ServeHTTP(httptest.NewRecorder(), req)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			h.ServeHTTP(w, req)
+		})
+	})
+	defer srv.Close()
+	ctx := context.Background()
+
+	msg, err := c.Create(ctx, MessageRequest{Text: "once"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), msg.Id)
+	if assert.Len(t, keys, 3) {
+		assert.NotEmpty(t, keys[0])
+		assert.Equal(t, keys[0], keys[2])
+	}
+
+	atomic.StoreInt32(&failures, 10)
+	_, err = c.Get(ctx, 1)
+	assert.True(t, errors.Is(err, ErrInternal), "%v", err)
+	assert.Len(t, keys, 3+4)
+}
+
+func TestClient_retryPolicy(t *testing.T) {
+	var calls int32
+	retryAfter := ""
+	srv, c := newServer(t, "policy", func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+	})
+	defer srv.Close()
+	ctx := context.Background()
+
+	// a delete may have been applied before the response was lost
+	err := c.Delete(ctx, 1)
+	assert.True(t, errors.Is(err, ErrInternal), "%v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// the client does not wait as long as the server likes
+	atomic.StoreInt32(&calls, 0)
+	retryAfter = "3600"
+	start := time.Now()
+	_, err = c.Get(ctx, 1)
+	assert.True(t, errors.Is(err, ErrInternal), "%v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestClient_quotaRetryAfter(t *testing.T) {
+	var calls int32
+	srv, c := newServer(t, "quota", func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			h.ServeHTTP(w, req)
+		})
+	})
+	defer srv.Close()
+	_, err := c.Create(context.Background(), MessageRequest{Text: "hi"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestClient_canceled(t *testing.T) {
+	srv, _ := newServer(t, "canceled", func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+	})
+	defer srv.Close()
+	c, err := New(srv.URL, WithRetries(10, time.Hour))
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = c.Get(ctx, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestClient_options(t *testing.T) {
+	var user, tenant, traceID string
+	srv, _ := newServer(t, "options", func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			user, _, _ = req.BasicAuth()
+			tenant = req.Header.Get(tenantHeader)
+			traceID = req.Header.Get("Uber-Trace-Id")
+			h.ServeHTTP(w, req)
+		})
+	})
+	defer srv.Close()
+
+	tracer, closer := jaeger.NewTracer("caller", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	span := tracer.StartSpan("caller")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	c, err := New(srv.URL, WithBasicAuth("options", "secret"), WithTenant("options"))
+	assert.NoError(t, err)
+	_, err = c.Create(ctx, MessageRequest{Text: "hi"})
+	assert.NoError(t, err)
+	assert.Equal(t, "options", user)
+	assert.Equal(t, "options", tenant)
+	assert.NotEmpty(t, traceID)
+
+	c, err = New(srv.URL, WithBasicAuth("options", "wrong"))
+	assert.NoError(t, err)
+	_, err = c.Get(ctx, 1)
+	assert.True(t, errors.Is(err, ErrUnauthenticated), "%v", err)
+
+	_, err = New("localhost:8090")
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Dump formats of Export and Import.
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+var dumpContentTypes = map[string]string{
+	FormatNDJSON: "application/x-ndjson",
+	FormatCSV:    "text/csv",
+}
+
+// ImportResult summarizes an import. Errors lists at most the first 100
+// records that were not imported, by their 1-based position in the dump.
+type ImportResult struct {
+	Imported int                 `json:"imported"`
+	Failed   int                 `json:"failed"`
+	Errors   []ImportRecordError `json:"errors,omitempty"`
+}
+
+// ImportRecordError is a record of the dump that was not imported.
+type ImportRecordError struct {
+	Record int   `json:"record"`
+	Error  Error `json:"error"`
+}
+
+// Export writes all messages to w as a dump in format and returns the
+// number of bytes written.
+func (c *Client) Export(ctx context.Context, format string, w io.Writer) (int64, error) {
+	ct, ok := dumpContentTypes[format]
+	if !ok {
+		return 0, fmt.Errorf("invalid dump format %q, should be %s or %s", format, FormatNDJSON, FormatCSV)
+	}
+	resp, err := c.send(ctx, http.MethodGet, "/v1/messages:export?"+url.Values{"format": {format}}.Encode(), nil, http.Header{"Accept": {ct}})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return io.Copy(w, resp.Body)
+}
+
+// Import imports the dump in format read from r. With preserveIDs the
+// messages keep the IDs of the dump. Imports are not idempotent and so
+// never retried.
+func (c *Client) Import(ctx context.Context, format string, r io.Reader, preserveIDs bool) (ImportResult, error) {
+	var res ImportResult
+	ct, ok := dumpContentTypes[format]
+	if !ok {
+		return res, fmt.Errorf("invalid dump format %q, should be %s or %s", format, FormatNDJSON, FormatCSV)
+	}
+	q := url.Values{"format": {format}}
+	if preserveIDs {
+		q.Set("preserve_ids", "true")
+	}
+	// hide any Seeker of r, a body that can not be rewound is not resent
+	body := struct{ io.Reader }{r}
+	resp, err := c.send(ctx, http.MethodPost, "/v1/messages:import?"+q.Encode(), body, http.Header{"Content-Type": {ct}, "Accept": {"application/json"}})
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("invalid response body: %v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Error codes of the messaging API, the code of an Error.
+const (
+	CodeInvalidArgument      = "invalid_argument"
+	CodeUnauthenticated      = "unauthenticated"
+	CodePermissionDenied     = "permission_denied"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeQuotaExceeded        = "quota_exceeded"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal"
+)
+
+// Errors to compare errors returned by the Client with using errors.Is, for
+// example errors.Is(err, client.ErrNotFound).
+var (
+	ErrInvalidArgument      = &Error{Code: CodeInvalidArgument}
+	ErrUnauthenticated      = &Error{Code: CodeUnauthenticated}
+	ErrPermissionDenied     = &Error{Code: CodePermissionDenied}
+	ErrNotFound             = &Error{Code: CodeNotFound}
+	ErrConflict             = &Error{Code: CodeConflict}
+	ErrQuotaExceeded        = &Error{Code: CodeQuotaExceeded}
+	ErrMethodNotAllowed     = &Error{Code: CodeMethodNotAllowed}
+	ErrNotAcceptable        = &Error{Code: CodeNotAcceptable}
+	ErrUnsupportedMediaType = &Error{Code: CodeUnsupportedMediaType}
+	ErrIdempotencyKeyReused = &Error{Code: CodeIdempotencyKeyReused}
+	ErrUnavailable          = &Error{Code: CodeUnavailable}
+	ErrInternal             = &Error{Code: CodeInternal}
+)
+
+// Error is an error response of the messaging API, decoded from its problem
+// document. Code is stable, Detail is meant for humans.
+type Error struct {
+	Status    int    `json:"status"`
+	Title     string `json:"title"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s (%d %s)", e.Code, e.Status, e.Title)
+	}
+	return fmt.Sprintf("%s (%d %s): %s", e.Code, e.Status, e.Title, e.Detail)
+}
+
+// Is reports whether target is an Error with the same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// errorOf decodes the error response resp. Responses without a problem
+// document, as sent by proxies, get the code matching their status.
+func errorOf(resp *http.Response) *Error {
+	e := &Error{}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, e); err != nil || e.Code == "" {
+		e = &Error{Code: codeOf(resp.StatusCode), Detail: string(body)}
+	}
+	e.Status = resp.StatusCode
+	if e.Title == "" {
+		e.Title = http.StatusText(resp.StatusCode)
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get(requestIDHeader)
+	}
+	return e
+}
+
+func codeOf(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidArgument
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeQuotaExceeded
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusUnprocessableEntity:
+		return CodeIdempotencyKeyReused
+	}
+	return CodeInternal
+}
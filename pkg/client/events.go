@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+// WatchOptions narrow the events of Watch. Events after the event with ID
+// After are replayed from the backlog of the server.
+type WatchOptions struct {
+	Tag    string
+	Author string
+	After  int64
+}
+
+// EventStream is a stream of message events opened by Watch. When the
+// connection breaks, it reconnects and resumes after the last event
+// received.
+type EventStream struct {
+	c      *Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   WatchOptions
+	closed int32
+
+	body io.ReadCloser
+	sc   *bufio.Scanner
+	ev   message.Event
+	err  error
+}
+
+// Watch follows the events of messages.
+func (c *Client) Watch(ctx context.Context, opts WatchOptions) (*EventStream, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &EventStream{c: c, ctx: ctx, cancel: cancel, opts: opts}
+	if err := s.connect(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *EventStream) connect() error {
+	q := url.Values{}
+	if s.opts.Tag != "" {
+		q.Set("tag", s.opts.Tag)
+	}
+	if s.opts.Author != "" {
+		q.Set("author", s.opts.Author)
+	}
+	if s.opts.After > 0 {
+		q.Set("last_event_id", strconv.FormatInt(s.opts.After, 10))
+	}
+	resp, err := s.c.send(s.ctx, http.MethodGet, "/v1/messages/events?"+q.Encode(), nil, http.Header{"Accept": {"text/event-stream"}})
+	if err != nil {
+		return err
+	}
+	s.body = resp.Body
+	s.sc = bufio.NewScanner(resp.Body)
+	s.sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	return nil
+}
+
+// Next blocks until the next event arrives and reports whether it did. It
+// returns false once the stream is closed or can not be reopened.
+func (s *EventStream) Next() bool {
+	for s.err == nil {
+		if s.read() {
+			return true
+		}
+		s.body.Close()
+		if s.ctx.Err() != nil {
+			s.err = s.ctx.Err()
+			break
+		}
+		wait, _ := s.c.wait(0, nil)
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+			s.err = s.connect()
+		case <-s.ctx.Done():
+			t.Stop()
+			s.err = s.ctx.Err()
+		}
+	}
+	return false
+}
+
+// read reads the next event of the current connection.
+func (s *EventStream) read() bool {
+	var data []byte
+	for s.sc.Scan() {
+		line := s.sc.Bytes()
+		if len(line) == 0 {
+			if data == nil {
+				continue
+			}
+			var e message.Event
+			if err := json.Unmarshal(data, &e); err != nil {
+				s.err = fmt.Errorf("invalid event: %v", err)
+				return false
+			}
+			s.ev = e
+			s.opts.After = e.ID
+			return true
+		}
+		if bytes.HasPrefix(line, []byte("data:")) {
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" "))...)
+		}
+		// id, event and retry fields repeat what the data holds
+	}
+	return false
+}
+
+// Event returns the current event.
+func (s *EventStream) Event() message.Event {
+	return s.ev
+}
+
+// Err returns the error that ended the stream, nil if it was closed.
+func (s *EventStream) Err() error {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return nil
+	}
+	return s.err
+}
+
+// Close closes the stream. It may be called while Next is blocked.
+func (s *EventStream) Close() error {
+	atomic.StoreInt32(&s.closed, 1)
+	s.cancel()
+	return nil
+}
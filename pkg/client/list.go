@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/shailendra-k-singh/example.messaging.service/message"
+)
+
+const defaultPageSize = 100
+
+// ListOptions are the options of List.
+type ListOptions struct {
+	// PageSize is the number of messages fetched per request, 100 if zero.
+	PageSize int
+	// IncludeScheduled also lists messages not delivered yet.
+	IncludeScheduled bool
+}
+
+// Iterator iterates over the messages of List, fetching them page by page:
+//
+//	it := c.List(ctx, client.ListOptions{})
+//	for it.Next() {
+//	    msg := it.Message()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type Iterator struct {
+	c    *Client
+	ctx  context.Context
+	next string
+	page []message.MessageObj
+	msg  message.MessageObj
+	err  error
+}
+
+// List returns an iterator over all messages in ID order.
+func (c *Client) List(ctx context.Context, opts ListOptions) *Iterator {
+	size := opts.PageSize
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	q := url.Values{"page_size": {strconv.Itoa(size)}}
+	if opts.IncludeScheduled {
+		q.Set("include", "scheduled")
+	}
+	return &Iterator{c: c, ctx: ctx, next: "/v1/messages?" + q.Encode()}
+}
+
+// Next advances to the next message, fetching the next page when needed. It
+// returns false when there are no more messages or fetching failed.
+func (it *Iterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.next == "" || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+	it.msg, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Message returns the current message.
+func (it *Iterator) Message() message.MessageObj {
+	return it.msg
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+var nextLinkPattern = regexp.MustCompile(`<([^>]*)>\s*;\s*rel="next"`)
+
+func (it *Iterator) fetch() {
+	first := it.msg.Id == 0
+	var page []message.MessageObj
+	header, err := it.c.do(it.ctx, http.MethodGet, it.next, nil, "", &page)
+	it.next = ""
+	if err != nil {
+		// an empty list is reported as not found
+		if !(first && errors.Is(err, ErrNotFound)) {
+			it.err = err
+		}
+		return
+	}
+	it.page = page
+	if m := nextLinkPattern.FindStringSubmatch(header.Get("Link")); m != nil {
+		it.next = m[1]
+	}
+}
@@ -0,0 +1,5 @@
+// Package messagingpb holds the protobuf messages and gRPC stubs of the
+// MessagingService defined in messaging.proto.
+package messagingpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative messaging.proto
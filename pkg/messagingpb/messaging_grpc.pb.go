@@ -0,0 +1,309 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package messagingpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// MessagingServiceClient is the client API for MessagingService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MessagingServiceClient interface {
+	// Create creates a message. The author is the authenticated user when
+	// authentication is enabled.
+	Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*Message, error)
+	// Get returns a delivered message.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Message, error)
+	// List streams the delivered messages in the order of their ids.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (MessagingService_ListClient, error)
+	// Delete deletes a message, moving it to the trash unless hard is set.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Watch streams the events of messages until the call is canceled.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MessagingService_WatchClient, error)
+}
+
+type messagingServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMessagingServiceClient(cc grpc.ClientConnInterface) MessagingServiceClient {
+	return &messagingServiceClient{cc}
+}
+
+func (c *messagingServiceClient) Create(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*Message, error) {
+	out := new(Message)
+	err := c.cc.Invoke(ctx, "/messaging.v1.MessagingService/Create", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagingServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Message, error) {
+	out := new(Message)
+	err := c.cc.Invoke(ctx, "/messaging.v1.MessagingService/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagingServiceClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (MessagingService_ListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_MessagingService_serviceDesc.Streams[0], "/messaging.v1.MessagingService/List", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messagingServiceListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MessagingService_ListClient interface {
+	Recv() (*Message, error)
+	grpc.ClientStream
+}
+
+type messagingServiceListClient struct {
+	grpc.ClientStream
+}
+
+func (x *messagingServiceListClient) Recv() (*Message, error) {
+	m := new(Message)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *messagingServiceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/messaging.v1.MessagingService/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagingServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MessagingService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_MessagingService_serviceDesc.Streams[1], "/messaging.v1.MessagingService/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messagingServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MessagingService_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type messagingServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *messagingServiceWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MessagingServiceServer is the server API for MessagingService service.
+// All implementations must embed UnimplementedMessagingServiceServer
+// for forward compatibility
+type MessagingServiceServer interface {
+	// Create creates a message. The author is the authenticated user when
+	// authentication is enabled.
+	Create(context.Context, *CreateRequest) (*Message, error)
+	// Get returns a delivered message.
+	Get(context.Context, *GetRequest) (*Message, error)
+	// List streams the delivered messages in the order of their ids.
+	List(*ListRequest, MessagingService_ListServer) error
+	// Delete deletes a message, moving it to the trash unless hard is set.
+	Delete(context.Context, *DeleteRequest) (*emptypb.Empty, error)
+	// Watch streams the events of messages until the call is canceled.
+	Watch(*WatchRequest, MessagingService_WatchServer) error
+	mustEmbedUnimplementedMessagingServiceServer()
+}
+
+// UnimplementedMessagingServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMessagingServiceServer struct {
+}
+
+func (UnimplementedMessagingServiceServer) Create(context.Context, *CreateRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Create not implemented")
+}
+func (UnimplementedMessagingServiceServer) Get(context.Context, *GetRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedMessagingServiceServer) List(*ListRequest, MessagingService_ListServer) error {
+	return status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedMessagingServiceServer) Delete(context.Context, *DeleteRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedMessagingServiceServer) Watch(*WatchRequest, MessagingService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedMessagingServiceServer) mustEmbedUnimplementedMessagingServiceServer() {}
+
+// UnsafeMessagingServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MessagingServiceServer will
+// result in compilation errors.
+type UnsafeMessagingServiceServer interface {
+	mustEmbedUnimplementedMessagingServiceServer()
+}
+
+func RegisterMessagingServiceServer(s grpc.ServiceRegistrar, srv MessagingServiceServer) {
+	s.RegisterService(&_MessagingService_serviceDesc, srv)
+}
+
+func _MessagingService_Create_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagingServiceServer).Create(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messaging.v1.MessagingService/Create",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagingServiceServer).Create(ctx, req.(*CreateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessagingService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagingServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messaging.v1.MessagingService/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagingServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessagingService_List_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MessagingServiceServer).List(m, &messagingServiceListServer{stream})
+}
+
+type MessagingService_ListServer interface {
+	Send(*Message) error
+	grpc.ServerStream
+}
+
+type messagingServiceListServer struct {
+	grpc.ServerStream
+}
+
+func (x *messagingServiceListServer) Send(m *Message) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MessagingService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagingServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messaging.v1.MessagingService/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagingServiceServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessagingService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MessagingServiceServer).Watch(m, &messagingServiceWatchServer{stream})
+}
+
+type MessagingService_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type messagingServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *messagingServiceWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _MessagingService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "messaging.v1.MessagingService",
+	HandlerType: (*MessagingServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Create",
+			Handler:    _MessagingService_Create_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _MessagingService_Get_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _MessagingService_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "List",
+			Handler:       _MessagingService_List_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _MessagingService_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "messaging.proto",
+}